@@ -0,0 +1,925 @@
+// Package cryptfs encrypts the files of an afero filesystem at rest.
+package cryptfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted files start with a header holding a random file ID, followed by
+// chunks of up to cryptChunkSize bytes of plaintext, each sealed with
+// AES-GCM under its own random nonce. The file ID and the index of a chunk
+// are authenticated along with it, so chunks cannot be swapped within a file
+// or between files. Chunks are authenticated one by one though, not the file
+// as a whole: a file whose last chunks were removed, down to a chunk
+// boundary, reads as a valid shorter file.
+const (
+	cryptMagic      = "CELLSENC"
+	cryptIDSize     = 16
+	cryptHeaderSize = int64(len(cryptMagic) + cryptIDSize)
+	cryptChunkSize  = 64 << 10
+
+	// KeySize is the size of the keys used to encrypt files.
+	KeySize = 32
+)
+
+var errCorrupt = errors.New("encrypted file is corrupt")
+
+var nameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// Fs encrypts the content of the files of its source, and optionally
+// their names. Sizes and offsets seen through it are the ones of the
+// plaintext.
+//
+// Encrypted names are deterministic, so that files can be looked up by name,
+// and longer than the plaintext: names of more than about 130 bytes do not
+// fit in the 255 bytes most filesystems allow.
+type Fs struct {
+	source afero.Fs
+
+	content cipher.AEAD
+
+	// names is nil when names are not encrypted
+	names   cipher.AEAD
+	nameMAC []byte
+
+	files *openFiles
+}
+
+// New returns a filesystem encrypting the files of source with key,
+// which must be KeySize bytes long. With encryptNames, the names of files and
+// directories are encrypted as well.
+func New(source afero.Fs, key []byte, encryptNames bool) (*Fs, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes long, got %d", KeySize, len(key))
+	}
+
+	content, err := newGCM(deriveKey(key, "content"))
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &Fs{source: source, content: content, files: newOpenFiles()}
+
+	if encryptNames {
+		fs.names, err = newGCM(deriveKey(key, "names"))
+		if err != nil {
+			return nil, err
+		}
+		fs.nameMAC = deriveKey(key, "name-nonces")
+	}
+
+	return fs, nil
+}
+
+// WithSource returns a filesystem reading and writing source with the same
+// keys as fs.
+func (fs *Fs) WithSource(source afero.Fs) *Fs {
+	c := *fs
+	c.source = source
+	c.files = newOpenFiles()
+
+	return &c
+}
+
+// ReadKeyFile reads a key from a file holding either KeySize raw bytes, or
+// their hexadecimal encoding. Such a file can be made with:
+//
+//	head -c 32 /dev/urandom > export.key
+func ReadKeyFile(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == KeySize {
+		return data, nil
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("%s: expected %d raw bytes or %d hexadecimal characters", name, KeySize, 2*KeySize)
+	}
+
+	return key, nil
+}
+
+// GenerateKey returns a new random key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// WriteKeyFile writes key to a new file readable by its owner only, in
+// hexadecimal so that it can be copied around as text.
+func WriteKeyFile(name string, key []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(key)); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// KeyFromPassphrase derives a key from a passphrase with scrypt. Everyone
+// using the same passphrase and salt gets the same key.
+func KeyFromPassphrase(passphrase, salt string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), []byte(salt), 1<<15, 8, 1, KeySize)
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptName encrypts a name with a nonce derived from it, so that the
+// same name is always encrypted the same way.
+func (fs *Fs) encryptName(name string) string {
+	mac := hmac.New(sha256.New, fs.nameMAC)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:fs.names.NonceSize()]
+
+	sealed := fs.names.Seal(nonce, nonce, []byte(name), nil)
+
+	return strings.ToLower(nameEncoding.EncodeToString(sealed))
+}
+
+func (fs *Fs) decryptName(name string) (string, error) {
+	sealed, err := nameEncoding.DecodeString(strings.ToUpper(name))
+	if err != nil || len(sealed) < fs.names.NonceSize() {
+		return "", errCorrupt
+	}
+
+	n := fs.names.NonceSize()
+	plain, err := fs.names.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", errCorrupt
+	}
+
+	return string(plain), nil
+}
+
+// RealPath returns the path of name in the source, with its names
+// encrypted when names are.
+func (fs *Fs) RealPath(name string) string {
+	if fs.names == nil {
+		return name
+	}
+
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if part != "" && part != "." && part != ".." {
+			parts[i] = fs.encryptName(part)
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// PlainSize returns the size of the plaintext of an encrypted file of size
+// bytes.
+func (fs *Fs) PlainSize(size int64) int64 {
+	size -= cryptHeaderSize
+	if size <= 0 {
+		return 0
+	}
+
+	overhead := int64(fs.content.NonceSize() + fs.content.Overhead())
+	sealed := cryptChunkSize + overhead
+
+	plain := size / sealed * cryptChunkSize
+	if rest := size % sealed; rest > overhead {
+		plain += rest - overhead
+	}
+
+	return plain
+}
+
+func (fs *Fs) Name() string {
+	return "Fs"
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	// Partial chunks are read back to be rewritten, and appending is done
+	// here from the plaintext size
+	sourceFlag := flag &^ os.O_APPEND
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		sourceFlag = sourceFlag&^os.O_WRONLY | os.O_RDWR
+	}
+
+	realPath := fs.RealPath(name)
+
+	st := fs.files.acquire(realPath)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	f, err := fs.source.OpenFile(realPath, sourceFlag, perm)
+	if err != nil {
+		fs.files.release(st)
+		return nil, err
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		st.reset()
+	}
+
+	return &file{fs: fs, file: f, name: name, flag: flag, state: st}, nil
+}
+
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	return fs.source.Mkdir(fs.RealPath(name), perm)
+}
+
+func (fs *Fs) MkdirAll(name string, perm os.FileMode) error {
+	return fs.source.MkdirAll(fs.RealPath(name), perm)
+}
+
+func (fs *Fs) Remove(name string) error {
+	realPath := fs.RealPath(name)
+	if err := fs.source.Remove(realPath); err != nil {
+		return err
+	}
+	fs.files.forget(realPath)
+
+	return nil
+}
+
+func (fs *Fs) RemoveAll(name string) error {
+	realPath := fs.RealPath(name)
+	if err := fs.source.RemoveAll(realPath); err != nil {
+		return err
+	}
+	fs.files.forget(realPath)
+
+	return nil
+}
+
+func (fs *Fs) Rename(oldname, newname string) error {
+	oldPath, newPath := fs.RealPath(oldname), fs.RealPath(newname)
+	if err := fs.source.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	fs.files.rename(oldPath, newPath)
+
+	return nil
+}
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	fi, err := fs.source.Stat(fs.RealPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.info(fi, fs.baseName(name))
+}
+
+// baseName returns the plaintext name of the info of name, or an empty
+// string when names are not encrypted and the source knows it.
+func (fs *Fs) baseName(name string) string {
+	if fs.names == nil {
+		return ""
+	}
+
+	return path.Base(name)
+}
+
+func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	return fs.source.Chmod(fs.RealPath(name), mode)
+}
+
+func (fs *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return fs.source.Chtimes(fs.RealPath(name), atime, mtime)
+}
+
+// info returns fi with the plaintext size, and the plaintext name, or
+// name when it is known already.
+func (fs *Fs) info(fi os.FileInfo, name string) (os.FileInfo, error) {
+	if name == "" {
+		name = fi.Name()
+		if fs.names != nil {
+			var err error
+			if name, err = fs.decryptName(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	size := fi.Size()
+	if fi.Mode().IsRegular() {
+		size = fs.PlainSize(size)
+	}
+
+	return &fileInfo{FileInfo: fi, name: name, size: size}, nil
+}
+
+type fileInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+// openFiles holds the state of the files open, shared by all their handles.
+// Without it, a handle would keep reading a chunk another one rewrote, or
+// writing with the ID of a file another one truncated.
+type openFiles struct {
+	mu    sync.Mutex
+	files map[string]*fileState
+}
+
+func newOpenFiles() *openFiles {
+	return &openFiles{files: make(map[string]*fileState)}
+}
+
+// fileState is the state of an open file, keyed by its path in the source.
+type fileState struct {
+	// path and refs are guarded by the lock of openFiles
+	path string
+	refs int
+
+	// mu serializes the operations of all the handles of the file
+	mu sync.Mutex
+	id []byte
+
+	// cached is the index of the last chunk decrypted, kept in chunk, so
+	// that reading a chunk in small parts decrypts it once
+	cached int64
+	chunk  []byte
+}
+
+func (st *fileState) reset() {
+	st.id, st.cached, st.chunk = nil, -1, nil
+}
+
+// acquire returns the state of the file at path, shared with its other
+// handles.
+func (o *openFiles) acquire(path string) *fileState {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	st, ok := o.files[path]
+	if !ok {
+		st = &fileState{path: path, cached: -1}
+		o.files[path] = st
+	}
+	st.refs++
+
+	return st
+}
+
+// release drops the state of a file once its last handle is closed.
+func (o *openFiles) release(st *fileState) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	st.refs--
+	if st.refs == 0 && o.files[st.path] == st {
+		delete(o.files, st.path)
+	}
+}
+
+// forget detaches the states of path and the files below it, removed from the
+// source, so that a new file of the same name gets a state of its own. Their
+// handles keep the state they have.
+func (o *openFiles) forget(path string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for p := range o.files {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(o.files, p)
+		}
+	}
+}
+
+// rename moves the states of oldpath and the files below it to newpath.
+func (o *openFiles) rename(oldpath, newpath string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// A file replaced by the rename is kept by its handles only
+	delete(o.files, newpath)
+
+	var moved []*fileState
+	for p, st := range o.files {
+		if p == oldpath || strings.HasPrefix(p, oldpath+"/") {
+			delete(o.files, p)
+			moved = append(moved, st)
+		}
+	}
+
+	for _, st := range moved {
+		st.path = newpath + strings.TrimPrefix(st.path, oldpath)
+		o.files[st.path] = st
+	}
+}
+
+// file encrypts and decrypts the content of a file chunk by chunk.
+type file struct {
+	fs   *Fs
+	file afero.File
+	name string
+	flag int
+
+	// state is shared with the other handles of the file, and its lock
+	// guards off as well
+	state  *fileState
+	off    int64
+	closed bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Close() error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	if !f.closed {
+		f.closed = true
+		f.fs.files.release(f.state)
+	}
+
+	return f.file.Close()
+}
+
+func (f *file) Sync() error {
+	return f.file.Sync()
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	fi, err := f.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return f.fs.info(fi, f.fs.baseName(f.name))
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.file.Readdir(count)
+
+	var infos []os.FileInfo
+	for _, fi := range fis {
+		info, e := f.fs.info(fi, "")
+		if e != nil {
+			// Not written through the filesystem, it cannot be read
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, err
+}
+
+func (f *file) Readdirnames(n int) ([]string, error) {
+	names, err := f.file.Readdirnames(n)
+	if f.fs.names == nil {
+		return names, err
+	}
+
+	var plain []string
+	for _, name := range names {
+		if p, e := f.fs.decryptName(name); e == nil {
+			plain = append(plain, p)
+		}
+	}
+
+	return plain, err
+}
+
+// size returns the size of the plaintext.
+func (f *file) size() (int64, error) {
+	fi, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return f.fs.PlainSize(fi.Size()), nil
+}
+
+func (f *file) overhead() int64 {
+	return int64(f.fs.content.NonceSize() + f.fs.content.Overhead())
+}
+
+// header reads the file ID, or writes a new one with create when the file
+// is empty. It returns a nil ID for empty files otherwise.
+func (f *file) header(create bool) ([]byte, error) {
+	if f.state.id != nil {
+		return f.state.id, nil
+	}
+
+	b := make([]byte, cryptHeaderSize)
+	n, err := f.file.ReadAt(b, 0)
+	switch {
+	case n == len(b):
+		if !bytes.Equal(b[:len(cryptMagic)], []byte(cryptMagic)) {
+			return nil, errCorrupt
+		}
+		f.state.id = b[len(cryptMagic):]
+
+	case n > 0:
+		return nil, errCorrupt
+
+	case err != nil && err != io.EOF:
+		return nil, err
+
+	case create:
+		id := make([]byte, cryptIDSize)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		if _, err := f.file.WriteAt(append([]byte(cryptMagic), id...), 0); err != nil {
+			return nil, err
+		}
+		f.state.id = id
+	}
+
+	return f.state.id, nil
+}
+
+func (f *file) chunkOffset(i int64) int64 {
+	return cryptHeaderSize + i*(cryptChunkSize+f.overhead())
+}
+
+// additionalData authenticates the chunk i of the file id.
+func additionalData(id []byte, i int64) []byte {
+	ad := make([]byte, cryptIDSize+8)
+	copy(ad, id)
+	binary.BigEndian.PutUint64(ad[cryptIDSize:], uint64(i))
+
+	return ad
+}
+
+// readChunk returns the plaintext of the chunk i, empty past the end of the
+// file.
+func (f *file) readChunk(i int64) ([]byte, error) {
+	if i == f.state.cached {
+		return f.state.chunk, nil
+	}
+
+	id, err := f.header(false)
+	if err != nil || id == nil {
+		return nil, err
+	}
+
+	b := make([]byte, cryptChunkSize+f.overhead())
+	n, err := f.file.ReadAt(b, f.chunkOffset(i))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	nonceSize := f.fs.content.NonceSize()
+	if int64(n) <= f.overhead() {
+		return nil, errCorrupt
+	}
+
+	plain, err := f.fs.content.Open(nil, b[:nonceSize], b[nonceSize:n], additionalData(f.state.id, i))
+	if err != nil {
+		return nil, errCorrupt
+	}
+
+	f.state.cached, f.state.chunk = i, plain
+
+	return plain, nil
+}
+
+// writeChunk encrypts plain as the chunk i.
+func (f *file) writeChunk(i int64, plain []byte) error {
+	nonce := make([]byte, f.fs.content.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	sealed := f.fs.content.Seal(nonce, nonce, plain, additionalData(f.state.id, i))
+
+	f.state.cached = -1
+	if _, err := f.file.WriteAt(sealed, f.chunkOffset(i)); err != nil {
+		return err
+	}
+	f.state.cached, f.state.chunk = i, plain
+
+	return nil
+}
+
+func (f *file) readAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	var n int
+	for n < len(b) {
+		pos := off + int64(n)
+
+		chunk, err := f.readChunk(pos / cryptChunkSize)
+		if err != nil {
+			return n, err
+		}
+
+		start := int(pos % cryptChunkSize)
+		if start >= len(chunk) {
+			return n, io.EOF
+		}
+
+		n += copy(b[n:], chunk[start:])
+	}
+
+	return n, nil
+}
+
+// writeAt writes b at off, filling the gap with zeros when off is past the
+// end of the file. Every chunk touched is read back and encrypted again.
+func (f *file) writeAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	if _, err := f.header(true); err != nil {
+		return 0, err
+	}
+
+	size, err := f.size()
+	if err != nil {
+		return 0, err
+	}
+
+	end := off + int64(len(b))
+
+	first := off / cryptChunkSize
+	if size < off {
+		first = size / cryptChunkSize
+	}
+
+	for i := first; i*cryptChunkSize < end; i++ {
+		start := i * cryptChunkSize
+
+		chunk, err := f.readChunk(i)
+		if err != nil {
+			return 0, err
+		}
+
+		length := int64(len(chunk))
+		if want := end - start; want > length {
+			length = want
+		}
+		if length > cryptChunkSize {
+			length = cryptChunkSize
+		}
+
+		plain := make([]byte, length)
+		copy(plain, chunk)
+
+		if start+length > off {
+			from := off - start
+			if from < 0 {
+				from = 0
+			}
+			copy(plain[from:], b[start+from-off:])
+		}
+
+		if err := f.writeChunk(i, plain); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func (f *file) Read(b []byte) (int, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	n, err := f.readAt(b, f.off)
+	f.off += int64(n)
+
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(b []byte, off int64) (int, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	return f.readAt(b, off)
+}
+
+func (f *file) Write(b []byte) (int, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		size, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		f.off = size
+	}
+
+	n, err := f.writeAt(b, f.off)
+	f.off += int64(n)
+
+	return n, err
+}
+
+func (f *file) WriteAt(b []byte, off int64) (int, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	return f.writeAt(b, off)
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		size, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	f.off = offset
+
+	return offset, nil
+}
+
+func (f *file) Truncate(size int64) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	current, err := f.size()
+	if err != nil {
+		return err
+	}
+
+	if size == current {
+		return nil
+	}
+
+	if size > current {
+		_, err := f.writeAt(nil, size)
+		return err
+	}
+
+	if size == 0 {
+		f.state.reset()
+		return f.file.Truncate(0)
+	}
+
+	// The last chunk kept is encrypted again without its end
+	last := (size - 1) / cryptChunkSize
+
+	chunk, err := f.readChunk(last)
+	if err != nil {
+		return err
+	}
+
+	f.state.cached = -1
+	if err := f.file.Truncate(f.chunkOffset(last)); err != nil {
+		return err
+	}
+
+	return f.writeChunk(last, chunk[:size-last*cryptChunkSize])
+}
+
+// NewReader decrypts the content of an encrypted file read sequentially from
+// r, such as a file streamed over the network.
+func (fs *Fs) NewReader(r io.Reader) io.Reader {
+	return &reader{fs: fs, r: r}
+}
+
+type reader struct {
+	fs  *Fs
+	r   io.Reader
+	id  []byte
+	n   int64
+	buf []byte
+	err error
+}
+
+func (r *reader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.buf, r.err = r.next()
+	}
+
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+// next decrypts the next chunk.
+func (r *reader) next() ([]byte, error) {
+	if r.id == nil {
+		header := make([]byte, cryptHeaderSize)
+		if _, err := io.ReadFull(r.r, header); err == io.EOF {
+			return nil, io.EOF
+		} else if err != nil || !bytes.Equal(header[:len(cryptMagic)], []byte(cryptMagic)) {
+			return nil, errCorrupt
+		}
+		r.id = header[len(cryptMagic):]
+	}
+
+	nonceSize := r.fs.content.NonceSize()
+
+	b := make([]byte, cryptChunkSize+nonceSize+r.fs.content.Overhead())
+	n, err := io.ReadFull(r.r, b)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if n <= nonceSize+r.fs.content.Overhead() {
+		return nil, errCorrupt
+	}
+
+	plain, err := r.fs.content.Open(nil, b[:nonceSize], b[nonceSize:n], additionalData(r.id, r.n))
+	if err != nil {
+		return nil, errCorrupt
+	}
+	r.n++
+
+	return plain, nil
+}
@@ -0,0 +1,255 @@
+package cryptfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func newTestFs(t *testing.T, names bool) (*Fs, afero.Fs) {
+	source := afero.NewMemMapFs()
+
+	fs, err := New(source, bytes.Repeat([]byte{1}, KeySize), names)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fs, source
+}
+
+func TestRandomAccess(t *testing.T) {
+	fs, source := newTestFs(t, false)
+
+	f, err := fs.Create("/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Writes across chunks and past the end, checked against a plain copy
+	var plain []byte
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		off := r.Int63n(3 * cryptChunkSize)
+		b := make([]byte, r.Intn(cryptChunkSize))
+		r.Read(b)
+
+		if _, err := f.WriteAt(b, off); err != nil {
+			t.Fatal(err)
+		}
+
+		if end := int(off) + len(b); end > len(plain) {
+			plain = append(plain, make([]byte, end-len(plain))...)
+		}
+		copy(plain[off:], b)
+	}
+
+	fi, err := fs.Stat("/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != int64(len(plain)) {
+		t.Fatalf("expected size %d, got %d", len(plain), fi.Size())
+	}
+
+	got := make([]byte, 1000)
+	off := int64(cryptChunkSize - 300)
+	if _, err := f.ReadAt(got, off); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain[off:off+1000]) {
+		t.Fatal("unexpected content read across chunks")
+	}
+
+	if err := f.Truncate(cryptChunkSize + 10); err != nil {
+		t.Fatal(err)
+	}
+	plain = plain[:cryptChunkSize+10]
+
+	if err := f.Truncate(cryptChunkSize + 20); err != nil {
+		t.Fatal(err)
+	}
+	plain = append(plain, make([]byte, 10)...)
+
+	if pos, err := f.Seek(-5, io.SeekEnd); err != nil || pos != int64(len(plain)-5) {
+		t.Fatalf("unexpected seek to %d, %v", pos, err)
+	}
+	if _, err := f.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	plain = append(plain[:len(plain)-5], "hello world"...)
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	all, err := afero.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(all, plain) {
+		t.Fatal("unexpected content after truncating and writing")
+	}
+
+	// Nothing is stored in clear
+	raw, err := afero.ReadFile(source, "/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("hello world")) {
+		t.Fatal("expected the content to be encrypted")
+	}
+
+	// Tampering is detected
+	raw[len(raw)-1] ^= 1
+	if err := afero.WriteFile(source, "/a.bin", raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := afero.ReadFile(fs, "/a.bin"); err != errCorrupt {
+		t.Fatalf("expected the file to be corrupt, got %v", err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	fs, _ := newTestFs(t, false)
+
+	for _, s := range []string{"one ", "two"} {
+		f, err := fs.OpenFile("/log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	data, err := afero.ReadFile(fs, "/log")
+	if err != nil || string(data) != "one two" {
+		t.Fatalf("unexpected content %q, %v", data, err)
+	}
+}
+
+func TestSharedHandles(t *testing.T) {
+	fs, _ := newTestFs(t, false)
+
+	a, err := fs.Create("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	b, err := fs.OpenFile("/f", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// Reads through a see the chunk b rewrote
+	if _, err := a.WriteAt([]byte("hello"), 0); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 5)
+	if _, err := a.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.WriteAt([]byte("J"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ReadAt(got, 0); err != nil || string(got) != "Jello" {
+		t.Fatalf("unexpected content %q, %v", got, err)
+	}
+
+	// Writes through a after b truncated the file start a new one
+	if err := b.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.WriteAt([]byte("world"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := afero.ReadFile(fs, "/f"); err != nil || string(data) != "world" {
+		t.Fatalf("unexpected content %q, %v", data, err)
+	}
+
+	// Handles creating the same file together agree on its header
+	done := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			f, err := fs.OpenFile("/g", os.O_RDWR|os.O_CREATE, 0644)
+			if err != nil {
+				done <- err
+				return
+			}
+			defer f.Close()
+
+			_, err = f.WriteAt([]byte{byte('a' + i)}, int64(i))
+			done <- err
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := afero.ReadFile(fs, "/g"); err != nil || string(data) != "abcd" {
+		t.Fatalf("unexpected content %q, %v", data, err)
+	}
+}
+
+func TestNames(t *testing.T) {
+	fs, source := newTestFs(t, true)
+
+	if err := fs.MkdirAll("/dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/dir/sub/secret.txt", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/dir/sub/secret.txt", "/dir/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := source.Stat("/dir"); !os.IsNotExist(err) {
+		t.Fatalf("expected the names to be encrypted, got %v", err)
+	}
+
+	names, err := afero.ReadDir(fs, "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0].Name() != "renamed.txt" || names[0].Size() != 1 || names[1].Name() != "sub" {
+		t.Fatalf("unexpected listing %v", names)
+	}
+
+	fi, err := fs.Stat("/dir/renamed.txt")
+	if err != nil || fi.Name() != "renamed.txt" {
+		t.Fatalf("unexpected stat %v, %v", fi, err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	fs, source := newTestFs(t, false)
+
+	plain := bytes.Repeat([]byte("0123456789"), cryptChunkSize/4)
+	if err := afero.WriteFile(fs, "/a.bin", plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := source.Open("/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := ioutil.ReadAll(fs.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("unexpected content read sequentially")
+	}
+}
@@ -0,0 +1,67 @@
+package aferofs
+
+import (
+	"io"
+
+	"github.com/ghecquet/tripr/poc/cells/aferofs/cryptfs"
+)
+
+// End-to-end encryption
+//
+// With one of the options below, an IndexFs encrypts file contents, and
+// optionally names, before they are sent to the index node, which only ever
+// stores and serves ciphertext. Files are split in chunks encrypted with
+// AES-GCM, each file having its own random ID and each chunk its own random
+// nonce, so files can still be read and written at any offset.
+//
+// Every client of a team must use the same key, and the same name
+// encryption setting, to read each other's files. Either:
+//
+//   - generate a key file once, with cryptfs.GenerateKey and
+//     cryptfs.WriteKeyFile or `head -c 32 /dev/urandom | xxd -p -c 32`,
+//     hand it to the team members through a channel the node operator has no
+//     access to, such as a password manager, and use WithKeyFile;
+//   - or agree on a passphrase and use WithPassphrase. The key is derived
+//     from the passphrase and the export name, so an export keeps its key
+//     when it moves to another node but not when it is renamed.
+//
+// Losing the key loses the files. Changing it requires copying the files
+// from a filesystem using the old key to one using the new key.
+
+// WithKey encrypts the files end-to-end with key, which must be
+// cryptfs.KeySize bytes long. With encryptNames, the names of files and
+// directories below the export are encrypted as well.
+func WithKey(key []byte, encryptNames bool) IndexFsOption {
+	return withEncryption(func(string) ([]byte, error) {
+		return key, nil
+	}, encryptNames)
+}
+
+// WithKeyFile encrypts the files end-to-end with the key read from a key
+// file, see cryptfs.ReadKeyFile.
+func WithKeyFile(name string, encryptNames bool) IndexFsOption {
+	return withEncryption(func(string) ([]byte, error) {
+		return cryptfs.ReadKeyFile(name)
+	}, encryptNames)
+}
+
+// WithPassphrase encrypts the files end-to-end with a key derived from
+// passphrase and the name of the export.
+func WithPassphrase(passphrase string, encryptNames bool) IndexFsOption {
+	return withEncryption(func(export string) ([]byte, error) {
+		return cryptfs.KeyFromPassphrase(passphrase, "cells-e2e:"+export)
+	}, encryptNames)
+}
+
+func withEncryption(key func(export string) ([]byte, error), encryptNames bool) IndexFsOption {
+	return func(f *IndexFs) {
+		f.key = key
+		f.encryptNames = encryptNames
+	}
+}
+
+// readCloser decrypts the content read from a version.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -3,44 +3,143 @@ package aferofs
 import (
 	context "context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
-	"strings"
 	"syscall"
 	"time"
 
-	_ "github.com/ghecquet/tripr/poc/cells/client/resolver"
+	"github.com/ghecquet/tripr/poc/cells/aferofs/cryptfs"
+	cellsresolver "github.com/ghecquet/tripr/poc/cells/client/resolver"
 	"github.com/ghecquet/tripr/poc/cells/index"
+	"github.com/ghecquet/tripr/poc/cells/target"
 	"github.com/spf13/afero"
 	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 var _ afero.Lstater = (*IndexFs)(nil)
 
+// discoveryTimeout is how long OpenIndexFs waits for a node serving the
+// location.
+const discoveryTimeout = 10 * time.Second
+
 type IndexFs struct {
-	ctx context.Context
-	cli index.FSClient
+	ctx     context.Context
+	cli     index.FSClient
+	metrics *ClientMetrics
+
+	// creds returns the credentials of the connections to the nodes, they
+	// are in clear when it is nil
+	creds func() (credentials.TransportCredentials, error)
+
+	// key returns the end-to-end encryption key of an export, it is nil
+	// when files are sent in clear
+	key          func(export string) ([]byte, error)
+	encryptNames bool
 }
 
-func NewIndexFs(path string) afero.Fs {
-	selector := strings.Split(path, "@")
+// IndexFsOption configures an IndexFs.
+type IndexFsOption func(*IndexFs)
+
+// WithClientMetrics records the calls made by the filesystem in m.
+func WithClientMetrics(m *ClientMetrics) IndexFsOption {
+	return func(f *IndexFs) {
+		f.metrics = m
+	}
+}
+
+// NewIndexFs returns a filesystem served by an index node, at a location
+// parsed by OpenIndexFs. It exits when the location is invalid or no node
+// serves it.
+func NewIndexFs(location string, opts ...IndexFsOption) afero.Fs {
+	fs, err := OpenIndexFs(location, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return fs
+}
+
+// OpenIndexFs returns a filesystem served by the index nodes at location.
+// Locations have the form node@export/path, or node@/path for the node's
+// default export, and the snapshots of an export are read with
+// node@export@snapshot/path. Nodes may also be selected by their labels, as
+// in cells:///path?export=photos&zone=paris, see the target package.
+//
+// With end-to-end encryption, see WithKeyFile, path is encrypted too when
+// names are.
+func OpenIndexFs(location string, opts ...IndexFsOption) (afero.Fs, error) {
+	f := &IndexFs{}
+	for _, o := range opts {
+		o(f)
+	}
+
+	t, err := target.ParseLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	dialOpts := []grpc.DialOption{grpc.WithInsecure()}
+	if f.creds != nil {
+		creds, err := f.creds()
+		if err != nil {
+			return nil, err
+		}
+		// Health checks of the resolver use them as well
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	}
+	if f.metrics != nil {
+		dialOpts = append(dialOpts, f.metrics.dialOptions()...)
+	}
+	dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(f.retryInterceptor))
+
+	// Discovery runs for as long as the process, unless the program
+	// started its own
+	if err := cellsresolver.Start(context.Background(), cellsresolver.Options{}); err != nil && err != cellsresolver.ErrStarted {
+		return nil, err
+	}
 
-	// TODO - Need some type of selector
-	conn, err := grpc.Dial("cells:///"+selector[0]+"@index.FS", grpc.WithInsecure())
+	// Snapshots are healthy along with their export, the resolver checks
+	// the health service of the export. Nodes announce themselves every
+	// second, wait for them rather than failing the first calls.
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, t.String(), append(dialOpts, grpc.WithBlock())...)
 	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+		return nil, fmt.Errorf("%s: no node found: %v", location, err)
+	}
+
+	f.cli = index.NewFSClient(conn)
+	f.ctx = context.TODO()
+	if t.Export != "" {
+		f.ctx = metadata.AppendToOutgoingContext(f.ctx, index.ExportKey, t.ExportName())
 	}
-	// defer conn.Close()
 
-	c := index.NewFSClient(conn)
-	ctx := context.TODO()
+	var fs afero.Fs = f
+
+	var crypt *cryptfs.Fs
+	if f.key != nil {
+		// Snapshots use the key of their export
+		key, err := f.key(t.Export)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("encryption key: %v", err)
+		}
 
-	return afero.NewBasePathFs(&IndexFs{
-		ctx: ctx,
-		cli: c,
-	}, selector[1])
+		crypt, err = cryptfs.New(f, key, f.encryptNames)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("encryption key: %v", err)
+		}
+		fs = crypt
+	}
+
+	return &basePathFs{BasePathFs: afero.NewBasePathFs(fs, t.Path).(*afero.BasePathFs), fs: f, crypt: crypt}, nil
 }
 
 func (f *IndexFs) ReadDir(name string) ([]os.FileInfo, error) {
@@ -118,7 +217,7 @@ func (f *IndexFs) Remove(name string) error {
 }
 
 func (f *IndexFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
-	return NewIndexFile(f.ctx, name, flag, perm, f.cli)
+	return newIndexFile(f.ctx, name, flag, perm, f.cli, f.metrics)
 }
 
 func (f *IndexFs) Open(name string) (afero.File, error) {
@@ -153,23 +252,44 @@ type File struct {
 }
 
 func NewIndexFile(ctx context.Context, name string, flag int, perm os.FileMode, cli index.FSClient) (afero.File, error) {
-	stream, err := cli.Open(ctx)
-	if err != nil {
-		return nil, fromRPCError(err)
-	}
+	return newIndexFile(ctx, name, flag, perm, cli, nil)
+}
 
-	// Sending initial request to open the file descriptor
-	if err := stream.Send(&index.FileRequest{
-		Request: &index.FileRequest_Open{Open: &index.OpenRequest{
-			Name:     name,
-			Flag:     int64(flag),
-			FileMode: uint32(perm),
-		}},
-	}); err != nil {
-		return nil, fromRPCError(err)
-	}
+func newIndexFile(ctx context.Context, name string, flag int, perm os.FileMode, cli index.FSClient, m *ClientMetrics) (afero.File, error) {
+	var stream index.FS_OpenClient
+
+	// Streams of the same file go to the same node, which keeps it cached
+	ctx = cellsresolver.WithAffinity(ctx, name)
+
+	// Nodes refuse streams over their limits before the file is opened, so
+	// opening again is safe
+	err := withRetry(ctx, "/index.FS/Open", m, func(trailer *metadata.MD) error {
+		var err error
+
+		stream, err = cli.Open(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Sending initial request to open the file descriptor
+		if err := stream.Send(&index.FileRequest{
+			Request: &index.FileRequest_Open{Open: &index.OpenRequest{
+				Name:     name,
+				Flag:     int64(flag),
+				FileMode: uint32(perm),
+			}},
+		}); err != nil {
+			return err
+		}
+
+		if _, err := stream.Recv(); err != nil {
+			*trailer = stream.Trailer()
+			return err
+		}
 
-	if _, err := stream.Recv(); err != nil {
+		return nil
+	})
+	if err != nil {
 		return nil, fromRPCError(err)
 	}
 
@@ -251,23 +371,37 @@ func (f *File) Read(b []byte) (int, error) {
 	return n, nil
 }
 
+// ReadAt fills b unless the end of the file is reached, in as many requests
+// as needed.
 func (f *File) ReadAt(b []byte, off int64) (int, error) {
-	err := f.stream.Send(&index.FileRequest{
-		Request: &index.FileRequest_ReadAt{ReadAt: &index.ReadAtRequest{Offset: off}},
-	})
-	if err != nil {
-		return 0, fromRPCError(err)
-	}
+	var n int
+	for n < len(b) {
+		size := int64(len(b) - n)
+		if size > index.MaxReadSize {
+			size = index.MaxReadSize
+		}
 
-	resp, err := f.stream.Recv()
-	if err != nil {
-		return 0, fromRPCError(err)
-	}
+		err := f.stream.Send(&index.FileRequest{
+			Request: &index.FileRequest_ReadAt{ReadAt: &index.ReadAtRequest{
+				Offset: off + int64(n),
+				Size:   size,
+			}},
+		})
+		if err != nil {
+			return n, fromRPCError(err)
+		}
 
-	n := copy(resp.GetRead().GetContent(), b)
+		resp, err := f.stream.Recv()
+		if err != nil {
+			return n, fromRPCError(err)
+		}
 
-	if n == 0 {
-		return 0, io.EOF
+		content := resp.GetRead().GetContent()
+		if len(content) == 0 {
+			return n, io.EOF
+		}
+
+		n += copy(b[n:], content)
 	}
 
 	return n, nil
@@ -0,0 +1,235 @@
+package aferofs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/aferofs/cryptfs"
+	cellsresolver "github.com/ghecquet/tripr/poc/cells/client/resolver"
+	"github.com/ghecquet/tripr/poc/cells/index"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// serveIndex serves source as the export photos of the node name, announced
+// to the cells resolver.
+func serveIndex(t *testing.T, ctx context.Context, name string, source afero.Fs, opts ...grpc.ServerOption) func() {
+	mem := cellsresolver.NewMemory()
+	if err := cellsresolver.Start(ctx, cellsresolver.Options{Backend: mem}); err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := index.NewHandler(afero.NewMemMapFs())
+	h.SetExports(map[string]afero.Fs{"photos": source})
+
+	s := grpc.NewServer(opts...)
+	index.RegisterFSServer(s, h)
+	hs := health.NewServer()
+	hs.SetServingStatus(index.HealthService("photos"), healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+	go s.Serve(lis)
+
+	node := &cellsresolver.Node{
+		Name:     name,
+		Addr:     lis.Addr().String(),
+		Exports:  []*cellsresolver.Export{{Name: "photos"}},
+		Services: []string{"grpc.health.v1.Health", "index.FS"},
+	}
+	if err := mem.Announce(node); err != nil {
+		s.Stop()
+		t.Fatal(err)
+	}
+
+	return func() {
+		s.Stop()
+		h.Close()
+	}
+}
+
+func TestIndexFs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := afero.NewMemMapFs()
+	stop := serveIndex(t, ctx, "node1", source)
+	defer stop()
+
+	key, err := cryptfs.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := OpenIndexFs("node1@photos", WithKey(key, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Several chunks of the encryption, read in several calls
+	plain := bytes.Repeat([]byte("0123456789"), 20000)
+	if err := afero.WriteFile(fs, "/a.txt", plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := source.Stat("/a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected the name to be encrypted, got %v", err)
+	}
+	fis, err := afero.ReadDir(fs, "/")
+	if err != nil || len(fis) != 1 || fis[0].Name() != "a.txt" || fis[0].Size() != int64(len(plain)) {
+		t.Fatalf("unexpected listing %v, %v", fis, err)
+	}
+
+	f, err := fs.OpenFile("/a.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt([]byte("abc"), 70000); err != nil {
+		t.Fatal(err)
+	}
+	copy(plain[70000:], "abc")
+
+	got := make([]byte, len(plain))
+	if _, err := f.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("unexpected content read back")
+	}
+
+	truncated := make(chan error, 1)
+	go func() { truncated <- f.Truncate(70001) }()
+	select {
+	case err := <-truncated:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("truncate did not return")
+	}
+
+	data, err := afero.ReadFile(fs, "/a.txt")
+	if err != nil || !bytes.Equal(data, plain[:70001]) {
+		t.Fatalf("unexpected content after truncate, %d bytes, %v", len(data), err)
+	}
+}
+
+// writeCert writes a certificate and its key to dir, signed by parent, or
+// self-signed when parent is nil.
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestIndexFsTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "node2", ca, caKey)
+	writeCert(t, dir, "client", ca, caKey)
+
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "node2.crt"), filepath.Join(dir, "node2.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop := serveIndex(t, ctx, "node2", afero.NewMemMapFs(), grpc.Creds(creds))
+	defer stop()
+
+	fs, err := OpenIndexFs("node2@photos", WithTLS(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/a.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := afero.ReadFile(fs, "/a.txt"); err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected content %q, %v", data, err)
+	}
+
+	// Certificates of another CA are refused
+	writeCert(t, dir, "other", nil, nil)
+	cfg, err := clientTLS(filepath.Join(dir, "other.crt"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.VerifyPeerCertificate(serverCert.Certificate, nil); err == nil {
+		t.Error("expected the certificate of node2 to be refused")
+	}
+}
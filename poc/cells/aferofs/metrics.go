@@ -0,0 +1,48 @@
+package aferofs
+
+import (
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// ClientMetrics records the latency of the calls made by IndexFs instances
+// and how often they had to be retried. A single ClientMetrics can be shared
+// by several filesystems.
+type ClientMetrics struct {
+	grpc    *grpc_prometheus.ClientMetrics
+	retries *prometheus.CounterVec
+}
+
+// NewClientMetrics creates the client metrics and registers them on reg.
+func NewClientMetrics(reg prometheus.Registerer) (*ClientMetrics, error) {
+	m := &ClientMetrics{
+		grpc: grpc_prometheus.NewClientMetrics(),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cells_indexfs",
+			Name:      "retries_total",
+			Help:      "Calls retried by IndexFs, per method.",
+		}, []string{"method"}),
+	}
+
+	m.grpc.EnableClientHandlingTimeHistogram()
+
+	for _, c := range []prometheus.Collector{m.grpc, m.retries} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *ClientMetrics) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(m.grpc.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(m.grpc.StreamClientInterceptor()),
+	}
+}
+
+func (m *ClientMetrics) retried(method string) {
+	m.retries.WithLabelValues(method).Inc()
+}
@@ -0,0 +1,72 @@
+package aferofs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewClientMetrics(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Filesystems share the metrics rather than registering them again
+	if _, err := NewClientMetrics(reg); err == nil {
+		t.Error("expected the second registration to fail")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop := serveIndex(t, ctx, "node3", afero.NewMemMapFs())
+	defer stop()
+
+	fs, err := OpenIndexFs("node3@photos", WithClientMetrics(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/a.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	err = withRetry(ctx, "/index.FS/Stat", m, func(trailer *metadata.MD) error {
+		calls++
+		if calls == 1 {
+			return status.Error(codes.ResourceExhausted, "rate limited")
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("expected a single retry, got %d calls, %v", calls, err)
+	}
+	if n := testutil.ToFloat64(m.retries.WithLabelValues("/index.FS/Stat")); n != 1 {
+		t.Errorf("expected 1 retry, got %v", n)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, f := range families {
+		found[f.GetName()] = true
+	}
+	for _, name := range []string{"grpc_client_handled_total", "grpc_client_handling_seconds", "cells_indexfs_retries_total"} {
+		if !found[name] {
+			t.Errorf("expected %s to be gathered", name)
+		}
+	}
+}
@@ -0,0 +1,907 @@
+// Package rclonefs serves any rclone remote as an afero filesystem.
+package rclonefs
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/spf13/afero"
+)
+
+// Options tune a Fs.
+type Options struct {
+	// ListCacheTTL is how long a directory listing is reused. Listings are
+	// dropped as soon as the filesystem changes them, a remote changed by
+	// others is seen up to ListCacheTTL late.
+	ListCacheTTL time.Duration
+
+	// TempDir holds the files being written until they are uploaded,
+	// os.TempDir when empty.
+	TempDir string
+}
+
+// Fs is an afero filesystem backed by an rclone remote.
+//
+// Remotes have no random writes: a file opened for writing is buffered in
+// a local temporary file, downloaded first unless truncated, and uploaded
+// as a whole when closed or synced. Remotes have no permissions either,
+// Chmod is a no-op.
+//
+// Bucket-based remotes such as S3 have no empty directories: those created
+// through the filesystem are remembered until it is dropped, or until
+// they get files.
+type Fs struct {
+	remote fs.Fs
+	opts   Options
+	ctx    context.Context
+
+	mu       sync.Mutex
+	listings map[string]*listing
+	virtual  map[string]time.Time
+	gen      uint64 // of the listings, bumped by every change
+}
+
+type listing struct {
+	entries fs.DirEntries
+	expires time.Time
+}
+
+// New returns a filesystem serving remote.
+func New(remote fs.Fs, opts Options) *Fs {
+	return &Fs{
+		remote:   remote,
+		opts:     opts,
+		ctx:      context.Background(),
+		listings: make(map[string]*listing),
+		virtual:  make(map[string]time.Time),
+	}
+}
+
+// NewFromRemote returns a filesystem serving an rclone remote given as
+// "name:path", or as a local path.
+func NewFromRemote(remote string, opts Options) (*Fs, error) {
+	f, err := fs.NewFs(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(f, opts), nil
+}
+
+// remotePath returns the rclone path of name, relative to the root of the
+// remote.
+func remotePath(name string) string {
+	p := path.Clean("/" + name)
+	if p == "/" {
+		return ""
+	}
+
+	return p[1:]
+}
+
+func parent(remote string) string {
+	dir := path.Dir(remote)
+	if dir == "." {
+		return ""
+	}
+
+	return dir
+}
+
+// under reports whether remote is dir or inside it.
+func under(remote, dir string) bool {
+	return dir == "" || remote == dir || strings.HasPrefix(remote, dir+"/")
+}
+
+// pathError converts the errors of rclone to the ones of the os package.
+func pathError(op, name string, err error) error {
+	switch err {
+	case nil:
+		return nil
+	case fs.ErrorObjectNotFound, fs.ErrorDirNotFound:
+		err = os.ErrNotExist
+	case fs.ErrorDirectoryNotEmpty:
+		err = syscall.ENOTEMPTY
+	case fs.ErrorIsFile:
+		err = syscall.ENOTDIR
+	}
+
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// list returns the entries of the directory dir, from the cache when it is
+// recent enough.
+func (f *Fs) list(dir string) (fs.DirEntries, error) {
+	f.mu.Lock()
+	l, ok := f.listings[dir]
+	gen := f.gen
+	f.mu.Unlock()
+
+	if ok && time.Now().Before(l.expires) {
+		return l.entries, nil
+	}
+
+	entries, err := f.remote.List(f.ctx, dir)
+	if err == fs.ErrorDirNotFound && f.isVirtual(dir) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries = f.addVirtual(dir, entries)
+
+	// A change made during the fetch may be missing from entries, which
+	// are only cached when nothing changed
+	if f.opts.ListCacheTTL > 0 {
+		f.mu.Lock()
+		if f.gen == gen {
+			f.listings[dir] = &listing{entries: entries, expires: time.Now().Add(f.opts.ListCacheTTL)}
+		}
+		f.mu.Unlock()
+	}
+
+	return entries, nil
+}
+
+// changed drops the cached listings affected by a change to remote: its
+// own, the ones of the directories below, and the ones of its parents which
+// may have been created or removed along.
+func (f *Fs) changed(remote string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.gen++
+	for dir := range f.listings {
+		if under(dir, remote) || under(remote, dir) {
+			delete(f.listings, dir)
+		}
+	}
+}
+
+// isVirtual reports whether dir is the root or a directory created on a
+// remote that cannot keep it empty.
+func (f *Fs) isVirtual(dir string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.virtual[dir]
+
+	return dir == "" || ok
+}
+
+// addVirtual adds the directories remembered in dir that the remote does
+// not list.
+func (f *Fs) addVirtual(dir string, entries fs.DirEntries) fs.DirEntries {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for v, modTime := range f.virtual {
+		if v == "" || parent(v) != dir {
+			continue
+		}
+
+		found := false
+		for _, e := range entries {
+			if e.Remote() == v {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			entries = append(entries, fs.NewDir(v, modTime))
+		}
+	}
+
+	return entries
+}
+
+// mkdir creates dir and its parents.
+func (f *Fs) mkdir(dir string) error {
+	defer f.changed(dir)
+
+	// Remotes create the missing parents
+	if err := f.remote.Mkdir(f.ctx, dir); err != nil {
+		return err
+	}
+
+	if f.remote.Features().CanHaveEmptyDirectories {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for d := dir; d != ""; d = parent(d) {
+		f.virtual[d] = now
+	}
+
+	return nil
+}
+
+// forget drops the directories remembered in dir, or moves them to newDir.
+func (f *Fs) forget(dir, newDir string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for v, modTime := range f.virtual {
+		if !under(v, dir) {
+			continue
+		}
+
+		delete(f.virtual, v)
+		if newDir != "" {
+			f.virtual[newDir+v[len(dir):]] = modTime
+		}
+	}
+}
+
+// entry returns the entry of remote, found in the listing of its parent.
+func (f *Fs) entry(remote string) (fs.DirEntry, error) {
+	if remote == "" {
+		return nil, nil
+	}
+
+	entries, err := f.list(parent(remote))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.Remote() == remote {
+			return e, nil
+		}
+	}
+
+	return nil, fs.ErrorObjectNotFound
+}
+
+// object returns the object of remote, or EISDIR when it is a directory.
+func (f *Fs) object(remote string) (fs.Object, error) {
+	e, err := f.entry(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	o, ok := e.(fs.Object)
+	if !ok {
+		return nil, syscall.EISDIR
+	}
+
+	return o, nil
+}
+
+func (f *Fs) Name() string {
+	return "RcloneFs"
+}
+
+func (f *Fs) Stat(name string) (os.FileInfo, error) {
+	remote := remotePath(name)
+
+	e, err := f.entry(remote)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	return f.fileInfo(remote, e), nil
+}
+
+func (f *Fs) fileInfo(remote string, e fs.DirEntry) os.FileInfo {
+	if e == nil {
+		// The root of the remote
+		return &fileInfo{name: "/", dir: true}
+	}
+
+	_, dir := e.(fs.Directory)
+
+	return &fileInfo{
+		name:    path.Base(remote),
+		size:    e.Size(),
+		modTime: e.ModTime(f.ctx),
+		dir:     dir,
+	}
+}
+
+func (f *Fs) Mkdir(name string, perm os.FileMode) error {
+	remote := remotePath(name)
+
+	if _, err := f.entry(remote); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+
+	if e, err := f.entry(parent(remote)); err != nil {
+		return pathError("mkdir", name, err)
+	} else if _, ok := e.(fs.Object); ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	return pathError("mkdir", name, f.mkdir(remote))
+}
+
+func (f *Fs) MkdirAll(name string, perm os.FileMode) error {
+	remote := remotePath(name)
+
+	if e, err := f.entry(remote); err == nil {
+		if _, ok := e.(fs.Object); ok {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+
+	return pathError("mkdir", name, f.mkdir(remote))
+}
+
+func (f *Fs) Remove(name string) error {
+	remote := remotePath(name)
+
+	e, err := f.entry(remote)
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+
+	defer f.changed(remote)
+
+	if o, ok := e.(fs.Object); ok {
+		return pathError("remove", name, o.Remove(f.ctx))
+	}
+
+	// Bucket-based remotes remove directories whatever their content
+	if entries, err := f.list(remote); err != nil {
+		return pathError("remove", name, err)
+	} else if len(entries) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+
+	err = f.remote.Rmdir(f.ctx, remote)
+	if err == fs.ErrorDirNotFound && f.isVirtual(remote) {
+		err = nil
+	}
+	f.forget(remote, "")
+
+	return pathError("remove", name, err)
+}
+
+func (f *Fs) RemoveAll(name string) error {
+	remote := remotePath(name)
+
+	e, err := f.entry(remote)
+	if err == fs.ErrorObjectNotFound || err == fs.ErrorDirNotFound {
+		return nil
+	}
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+
+	defer f.changed(remote)
+
+	if o, ok := e.(fs.Object); ok {
+		return pathError("remove", name, o.Remove(f.ctx))
+	}
+
+	err = operations.Purge(f.ctx, f.remote, remote)
+	if err == fs.ErrorDirNotFound && f.isVirtual(remote) {
+		err = nil
+	}
+	f.forget(remote, "")
+
+	return pathError("remove", name, err)
+}
+
+func (f *Fs) Rename(oldname, newname string) error {
+	oldRemote, newRemote := remotePath(oldname), remotePath(newname)
+
+	e, err := f.entry(oldRemote)
+	if err != nil {
+		return pathError("rename", oldname, err)
+	}
+
+	defer f.changed(oldRemote)
+	defer f.changed(newRemote)
+
+	if e == nil || newRemote == "" || under(newRemote, oldRemote) {
+		return &os.PathError{Op: "rename", Path: oldname, Err: os.ErrInvalid}
+	}
+
+	if _, ok := e.(fs.Directory); ok {
+		err := operations.DirMove(f.ctx, f.remote, oldRemote, newRemote)
+		if err == fs.ErrorDirNotFound && f.isVirtual(oldRemote) {
+			err = nil
+		}
+		if err == nil {
+			f.forget(oldRemote, newRemote)
+		}
+
+		return pathError("rename", oldname, err)
+	}
+
+	// Files replace the destination, like os.Rename
+	dst, err := f.object(newRemote)
+	if err == syscall.EISDIR {
+		return &os.PathError{Op: "rename", Path: newname, Err: syscall.EISDIR}
+	}
+
+	_, err = operations.Move(f.ctx, f.remote, dst, newRemote, e.(fs.Object))
+
+	return pathError("rename", oldname, err)
+}
+
+func (f *Fs) Chmod(name string, mode os.FileMode) error {
+	_, err := f.Stat(name)
+	return err
+}
+
+func (f *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	remote := remotePath(name)
+
+	e, err := f.entry(remote)
+	if err != nil {
+		return pathError("chtimes", name, err)
+	}
+
+	o, ok := e.(fs.Object)
+	if !ok {
+		// Directories have no times of their own on most remotes
+		return nil
+	}
+
+	defer f.changed(remote)
+
+	return pathError("chtimes", name, o.SetModTime(f.ctx, mtime))
+}
+
+func (f *Fs) Create(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (f *Fs) Open(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	remote := remotePath(name)
+
+	e, err := f.entry(remote)
+	switch {
+	case err == fs.ErrorObjectNotFound && flag&os.O_CREATE != 0:
+		if p, err := f.entry(parent(remote)); err != nil {
+			return nil, pathError("open", name, err)
+		} else if _, ok := p.(fs.Object); ok {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOTDIR}
+		}
+
+	case err != nil:
+		return nil, pathError("open", name, err)
+
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+
+	file := &File{fs: f, name: name, remote: remote, flag: flag}
+
+	if _, ok := e.(fs.Directory); ok || (e == nil && err == nil) {
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+		}
+		file.dir = true
+		return file, nil
+	}
+
+	if o, ok := e.(fs.Object); ok {
+		file.obj = o
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if err := file.buffer(flag&os.O_TRUNC != 0); err != nil {
+			return nil, pathError("open", name, err)
+		}
+
+		// New files exist once uploaded, even if nothing is written
+		file.dirty = file.obj == nil || flag&os.O_TRUNC != 0
+	}
+
+	return file, nil
+}
+
+// File is a file or a directory of a remote. Files opened for reading are
+// streamed from the remote, files opened for writing are buffered locally.
+type File struct {
+	fs     *Fs
+	name   string
+	remote string
+	flag   int
+	obj    fs.Object
+
+	mu  sync.Mutex
+	off int64
+
+	// dir entries not returned yet by Readdir
+	dir     bool
+	entries []os.FileInfo
+	listed  bool
+
+	// reader streams the object from readerOff
+	reader    io.ReadCloser
+	readerOff int64
+
+	// buf holds the content of files opened for writing
+	buf   *os.File
+	dirty bool
+}
+
+// buffer copies the content of the object to a temporary file, unless
+// truncate.
+func (f *File) buffer(truncate bool) error {
+	buf, err := ioutil.TempFile(f.fs.opts.TempDir, "rclonefs-")
+	if err != nil {
+		return err
+	}
+	os.Remove(buf.Name())
+
+	if f.obj != nil && !truncate {
+		rc, err := f.obj.Open(f.fs.ctx)
+		if err != nil {
+			buf.Close()
+			return err
+		}
+		_, err = io.Copy(buf, rc)
+		rc.Close()
+		if err != nil {
+			buf.Close()
+			return err
+		}
+	}
+
+	f.buf = buf
+
+	return nil
+}
+
+// upload sends the buffered content to the remote.
+func (f *File) upload() error {
+	if f.buf == nil || !f.dirty {
+		return nil
+	}
+
+	fi, err := f.buf.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.buf.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	info := object.NewStaticObjectInfo(f.remote, time.Now(), fi.Size(), true, nil, f.fs.remote)
+
+	o, err := f.fs.remote.Put(f.fs.ctx, f.buf, info)
+	f.fs.changed(f.remote)
+	if err != nil {
+		return err
+	}
+
+	f.obj, f.dirty = o, false
+
+	return nil
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var err error
+	if f.buf != nil {
+		err = f.upload()
+		f.buf.Close()
+		f.buf = nil
+	}
+
+	if f.reader != nil {
+		f.reader.Close()
+		f.reader = nil
+	}
+
+	return pathError("close", f.name, err)
+}
+
+func (f *File) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return pathError("sync", f.name, f.upload())
+}
+
+func (f *File) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.buf != nil {
+		fi, err := f.buf.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return &fileInfo{name: path.Base(f.remote), size: fi.Size(), modTime: fi.ModTime()}, nil
+	}
+
+	return f.fs.Stat(f.name)
+}
+
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.dir {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+
+	if !f.listed {
+		entries, err := f.fs.list(f.remote)
+		if err != nil {
+			return nil, pathError("readdir", f.name, err)
+		}
+
+		for _, e := range entries {
+			f.entries = append(f.entries, f.fs.fileInfo(e.Remote(), e))
+		}
+		sort.Slice(f.entries, func(i, j int) bool {
+			return f.entries[i].Name() < f.entries[j].Name()
+		})
+		f.listed = true
+	}
+
+	if count <= 0 {
+		fis := f.entries
+		f.entries = nil
+		return fis, nil
+	}
+
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+
+	fis := f.entries[:count]
+	f.entries = f.entries[count:]
+
+	return fis, nil
+}
+
+func (f *File) Readdirnames(n int) ([]string, error) {
+	fis, err := f.Readdir(n)
+
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+
+	return names, err
+}
+
+func (f *File) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.buf != nil {
+		n, err := f.buf.ReadAt(b, f.off)
+		f.off += int64(n)
+		if n > 0 && err == io.EOF {
+			err = nil
+		}
+		return n, err
+	}
+
+	if f.obj == nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+
+	if f.reader != nil && f.readerOff != f.off {
+		f.reader.Close()
+		f.reader = nil
+	}
+
+	if f.reader == nil {
+		if f.off >= f.obj.Size() && f.obj.Size() >= 0 {
+			return 0, io.EOF
+		}
+
+		rc, err := f.obj.Open(f.fs.ctx, &fs.SeekOption{Offset: f.off})
+		if err != nil {
+			return 0, pathError("read", f.name, err)
+		}
+		f.reader, f.readerOff = rc, f.off
+	}
+
+	n, err := f.reader.Read(b)
+	f.off += int64(n)
+	f.readerOff = f.off
+
+	return n, err
+}
+
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.buf != nil {
+		return f.buf.ReadAt(b, off)
+	}
+
+	if f.obj == nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if off >= f.obj.Size() {
+		return 0, io.EOF
+	}
+
+	rc, err := f.obj.Open(f.fs.ctx, &fs.RangeOption{Start: off, End: off + int64(len(b)) - 1})
+	if err != nil {
+		return 0, pathError("read", f.name, err)
+	}
+	defer rc.Close()
+
+	n, err := io.ReadFull(rc, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		size, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	f.off = offset
+
+	return offset, nil
+}
+
+func (f *File) size() (int64, error) {
+	if f.buf != nil {
+		fi, err := f.buf.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+
+	if f.obj != nil {
+		return f.obj.Size(), nil
+	}
+
+	return 0, nil
+}
+
+// writable returns an error unless the file was opened for writing.
+func (f *File) writable(op string) error {
+	if f.buf == nil {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+
+	return nil
+}
+
+func (f *File) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.writable("write"); err != nil {
+		return 0, err
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		size, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		f.off = size
+	}
+
+	n, err := f.buf.WriteAt(b, f.off)
+	f.off += int64(n)
+	f.dirty = true
+
+	return n, err
+}
+
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.writable("write"); err != nil {
+		return 0, err
+	}
+
+	f.dirty = true
+
+	return f.buf.WriteAt(b, off)
+}
+
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *File) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.writable("truncate"); err != nil {
+		return err
+	}
+
+	f.dirty = true
+
+	return f.buf.Truncate(size)
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.dir
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,216 @@
+package rclonefs
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/spf13/afero"
+)
+
+// backends returns the remotes the tests run against, the local one in dir.
+func backends(t *testing.T, dir string) map[string]fs.Fs {
+	mem, err := memory.NewFs("memory", "", configmap.Simple{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc, err := local.NewFs("local", dir, configmap.Simple{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]fs.Fs{"memory": mem, "local": loc}
+}
+
+func TestFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclonefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, remote := range backends(t, dir) {
+		t.Run(name, func(t *testing.T) {
+			testFs(t, New(remote, Options{ListCacheTTL: time.Minute}))
+		})
+	}
+}
+
+func testFs(t *testing.T, fs *Fs) {
+	if err := fs.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/a", 0755); !os.IsExist(err) {
+		t.Fatalf("expected mkdir of an existing directory to fail, got %v", err)
+	}
+
+	if err := afero.WriteFile(fs, "/a/b/f.txt", []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Random writes are buffered until closed
+	f, err := fs.OpenFile("/a/b/f.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("W"), 6); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("!"), 11); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := afero.ReadFile(fs, "/a/b/f.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello World!" {
+		t.Fatalf("unexpected content %q", data)
+	}
+
+	f, err = fs.OpenFile("/a/b/f.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("?")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Ranged and sequential reads
+	f, err = fs.Open("/a/b/f.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 5)
+	if _, err := f.ReadAt(b, 6); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "World" {
+		t.Fatalf("unexpected range %q", b)
+	}
+	if _, err := f.Seek(11, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "!?" {
+		t.Fatalf("unexpected tail %q", rest)
+	}
+	f.Close()
+
+	if _, err := fs.OpenFile("/a/b/f.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); !os.IsExist(err) {
+		t.Fatalf("expected exclusive create to fail, got %v", err)
+	}
+
+	fi, err := fs.Stat("/a/b/f.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.IsDir() || fi.Size() != 13 {
+		t.Fatalf("unexpected stat %v %d", fi.IsDir(), fi.Size())
+	}
+
+	if err := fs.Rename("/a/b/f.txt", "/a/g.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/a/b/f.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected the old name to be gone, got %v", err)
+	}
+
+	names, err := readDirNames(fs, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "b" || names[1] != "g.txt" {
+		t.Fatalf("unexpected listing %v", names)
+	}
+
+	if err := fs.Rename("/a/b", "/c"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat("/c"); err != nil || !fi.IsDir() {
+		t.Fatalf("expected /c to be a directory, got %v", err)
+	}
+
+	if err := fs.RemoveAll("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/a/g.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected /a to be removed, got %v", err)
+	}
+}
+
+// slowList is a remote running changed in the middle of its next listing.
+type slowList struct {
+	fs.Fs
+	changed func()
+}
+
+func (r *slowList) List(ctx context.Context, dir string) (fs.DirEntries, error) {
+	entries, err := r.Fs.List(ctx, dir)
+	if changed := r.changed; changed != nil {
+		r.changed = nil
+		changed()
+	}
+
+	return entries, err
+}
+
+func TestListChanged(t *testing.T) {
+	// Memory remotes share their buckets, keep clear of the other tests
+	mem, err := memory.NewFs("memory", "list-changed", configmap.Simple{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := &slowList{Fs: mem}
+	f := New(remote, Options{ListCacheTTL: time.Minute})
+
+	remote.changed = func() {
+		if err := afero.WriteFile(f, "/f.txt", []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer f.Remove("/f.txt")
+
+	// The listing fetched before the write misses the file
+	names, err := readDirNames(f, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("unexpected listing %v", names)
+	}
+
+	names, err = readDirNames(f, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "f.txt" {
+		t.Fatalf("expected the stale listing not to be cached, got %v", names)
+	}
+}
+
+func readDirNames(fs afero.Fs, name string) ([]string, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdirnames(-1)
+}
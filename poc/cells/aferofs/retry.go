@@ -0,0 +1,74 @@
+package aferofs
+
+import (
+	context "context"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/index"
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Calls rejected by a node over its rate limits are retried up to
+// maxRetries times, waiting at least what the node asked for and backing off
+// exponentially from minBackoff up to maxBackoff otherwise.
+const (
+	maxRetries = 5
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// withRetry runs call until it succeeds, fails with another error than
+// RESOURCE_EXHAUSTED or runs out of retries. call fills trailer with the
+// trailer of the failed attempt.
+func withRetry(ctx context.Context, method string, m *ClientMetrics, call func(trailer *metadata.MD) error) error {
+	for attempt := 0; ; attempt++ {
+		var trailer metadata.MD
+
+		err := call(&trailer)
+		if status.Code(err) != codes.ResourceExhausted || attempt == maxRetries {
+			return err
+		}
+
+		if m != nil {
+			m.retried(method)
+		}
+
+		t := time.NewTimer(backoff(attempt, trailer))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// backoff returns the delay before the retry following attempt.
+func backoff(attempt int, trailer metadata.MD) time.Duration {
+	// Doubled step by step, shifting by attempt would overflow
+	d := minBackoff
+	for i := 0; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+
+	if v := trailer.Get(index.RetryAfterKey); len(v) > 0 {
+		if after, err := time.ParseDuration(v[0]); err == nil && after > d {
+			d = after
+		}
+	}
+
+	return d
+}
+
+// retryInterceptor retries the unary calls rejected by rate limits.
+func (f *IndexFs) retryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return withRetry(ctx, method, f.metrics, func(trailer *metadata.MD) error {
+		return invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(trailer))...)
+	})
+}
@@ -0,0 +1,85 @@
+package aferofs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/index"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoff(t *testing.T) {
+	for _, c := range []struct {
+		attempt    int
+		retryAfter string
+		want       time.Duration
+	}{
+		{0, "", minBackoff},
+		{1, "", 2 * minBackoff},
+		{3, "", 8 * minBackoff},
+		{20, "", maxBackoff},
+		{64, "", maxBackoff},
+
+		// The node asks for longer, or shorter than the backoff
+		{0, "2s", 2 * time.Second},
+		{3, "10ms", 8 * minBackoff},
+		{0, "soon", minBackoff},
+	} {
+		var trailer metadata.MD
+		if c.retryAfter != "" {
+			trailer = metadata.Pairs(index.RetryAfterKey, c.retryAfter)
+		}
+
+		if d := backoff(c.attempt, trailer); d != c.want {
+			t.Errorf("attempt %d, retry after %q: expected %s, got %s", c.attempt, c.retryAfter, c.want, d)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	ctx := context.Background()
+	exhausted := status.Error(codes.ResourceExhausted, "rate limited")
+
+	// Other errors are returned right away
+	calls := 0
+	err := withRetry(ctx, "/index.FS/Stat", nil, func(trailer *metadata.MD) error {
+		calls++
+		return status.Error(codes.NotFound, "not found")
+	})
+	if status.Code(err) != codes.NotFound || calls != 1 {
+		t.Errorf("expected a single call, got %d calls, %v", calls, err)
+	}
+
+	// Retries wait for what the node asked
+	calls = 0
+	start := time.Now()
+	err = withRetry(ctx, "/index.FS/Stat", nil, func(trailer *metadata.MD) error {
+		calls++
+		if calls < 3 {
+			*trailer = metadata.Pairs(index.RetryAfterKey, "150ms")
+			return exhausted
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expected 3 calls, got %d, %v", calls, err)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("expected the retries to wait 300ms, waited %s", d)
+	}
+
+	// Cancelling stops the retries with the last error
+	ctx, cancel := context.WithCancel(ctx)
+	calls = 0
+	err = withRetry(ctx, "/index.FS/Stat", nil, func(trailer *metadata.MD) error {
+		calls++
+		cancel()
+		return exhausted
+	})
+	if status.Code(err) != codes.ResourceExhausted || calls != 1 {
+		t.Errorf("expected the retries to stop, got %d calls, %v", calls, err)
+	}
+}
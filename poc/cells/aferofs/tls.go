@@ -0,0 +1,92 @@
+package aferofs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// WithTransportCredentials connects to the index nodes with creds rather
+// than in clear.
+func WithTransportCredentials(creds credentials.TransportCredentials) IndexFsOption {
+	return func(f *IndexFs) {
+		f.creds = func() (credentials.TransportCredentials, error) {
+			return creds, nil
+		}
+	}
+}
+
+// WithTLS connects to the index nodes over TLS. Their certificates must be
+// signed by the CA in caFile, or by one of the system roots when caFile is
+// empty. Host names are not checked, nodes are reached at the address they
+// announce. With certFile and keyFile, the client presents its certificate,
+// as required by the nodes setting tls.client_ca.
+func WithTLS(caFile, certFile, keyFile string) IndexFsOption {
+	return func(f *IndexFs) {
+		f.creds = func() (credentials.TransportCredentials, error) {
+			cfg, err := clientTLS(caFile, certFile, keyFile)
+			if err != nil {
+				return nil, err
+			}
+
+			return credentials.NewTLS(cfg), nil
+		}
+	}
+}
+
+func clientTLS(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading tls key pair: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	// Nil uses the system roots
+	var roots *x509.CertPool
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca: %v", err)
+		}
+
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+
+	// The chain is verified below, without the host name
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+		if len(raw) == 0 {
+			return errors.New("no certificate presented")
+		}
+
+		certs := make([]*x509.Certificate, len(raw))
+		for i, b := range raw {
+			c, err := x509.ParseCertificate(b)
+			if err != nil {
+				return err
+			}
+			certs[i] = c
+		}
+
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, c := range certs[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+
+	return cfg, nil
+}
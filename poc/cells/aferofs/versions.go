@@ -0,0 +1,151 @@
+package aferofs
+
+import (
+	context "context"
+	"io"
+
+	"github.com/ghecquet/tripr/poc/cells/aferofs/cryptfs"
+	"github.com/ghecquet/tripr/poc/cells/index"
+	"github.com/spf13/afero"
+)
+
+// Versioned is implemented by filesystems keeping the prior versions of
+// their files, such as the ones returned by NewIndexFs for exports with
+// versioning enabled.
+type Versioned interface {
+	// Versions returns the prior versions of name, most recent first.
+	Versions(name string) ([]*index.Version, error)
+
+	// OpenVersion returns the content of the version id of name.
+	OpenVersion(name, id string) (io.ReadCloser, error)
+
+	// RestoreVersion replaces the content of name with its version id.
+	RestoreVersion(name, id string) error
+}
+
+var (
+	_ Versioned = (*IndexFs)(nil)
+	_ Versioned = (*basePathFs)(nil)
+)
+
+func (f *IndexFs) Versions(name string) ([]*index.Version, error) {
+	resp, err := f.cli.ListVersions(f.ctx, &index.ListVersionsRequest{
+		Name: name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetVersions(), nil
+}
+
+func (f *IndexFs) OpenVersion(name, id string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(f.ctx)
+
+	stream, err := f.cli.OpenVersion(ctx, &index.OpenVersionRequest{
+		Name: name,
+		Id:   id,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &versionReader{stream: stream, cancel: cancel}, nil
+}
+
+func (f *IndexFs) RestoreVersion(name, id string) error {
+	_, err := f.cli.RestoreVersion(f.ctx, &index.RestoreVersionRequest{
+		Name: name,
+		Id:   id,
+	})
+
+	return err
+}
+
+// versionReader reads the content streamed by OpenVersion.
+type versionReader struct {
+	stream index.FS_OpenVersionClient
+	cancel context.CancelFunc
+	buf    []byte
+}
+
+func (r *versionReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		resp, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = resp.GetContent()
+	}
+
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+func (r *versionReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// basePathFs restricts an IndexFs to a base path, like afero.BasePathFs,
+// including for the calls on versions. With end-to-end encryption, the
+// versions are decrypted with crypt.
+type basePathFs struct {
+	*afero.BasePathFs
+	fs    *IndexFs
+	crypt *cryptfs.Fs
+}
+
+// realPath returns the path of name on the index node.
+func (b *basePathFs) realPath(name string) (string, error) {
+	name, err := b.RealPath(name)
+	if err != nil || b.crypt == nil {
+		return name, err
+	}
+
+	return b.crypt.RealPath(name), nil
+}
+
+func (b *basePathFs) Versions(name string) ([]*index.Version, error) {
+	name, err := b.realPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := b.fs.Versions(name)
+	if err != nil || b.crypt == nil {
+		return versions, err
+	}
+
+	for _, v := range versions {
+		v.Size = b.crypt.PlainSize(v.Size)
+	}
+
+	return versions, nil
+}
+
+func (b *basePathFs) OpenVersion(name, id string) (io.ReadCloser, error) {
+	name, err := b.realPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := b.fs.OpenVersion(name, id)
+	if err != nil || b.crypt == nil {
+		return rc, err
+	}
+
+	return &readCloser{Reader: b.crypt.NewReader(rc), Closer: rc}, nil
+}
+
+func (b *basePathFs) RestoreVersion(name, id string) error {
+	name, err := b.realPath(name)
+	if err != nil {
+		return err
+	}
+
+	return b.fs.RestoreVersion(name, id)
+}
@@ -0,0 +1,346 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102T150405.000000000"
+
+// Entry is a single line of the audit log.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	Addr      string    `json:"addr,omitempty"`
+	Export    string    `json:"export"`
+	Op        string    `json:"op"`
+	Path      string    `json:"path"`
+	NewPath   string    `json:"new_path,omitempty"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+
+	// Prev and Hash chain the entries together when the log is opened with
+	// HashChain. Hash must stay the last field, see marshal.
+	Prev string `json:"prev,omitempty"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// Options controls rotation and integrity of a Log.
+type Options struct {
+	// MaxSize is the size in bytes above which the log is rotated. Zero
+	// disables rotation.
+	MaxSize int64
+
+	// MaxBackups is the number of rotated files kept. Zero keeps them all.
+	MaxBackups int
+
+	// HashChain links every entry to the previous one with a SHA-256 hash,
+	// so that removing or editing a line is detected by Verify. Lines removed
+	// from the head of the oldest file are not, see Verify.
+	HashChain bool
+}
+
+// Log appends entries as JSON lines to a file.
+type Log struct {
+	path string
+	opts Options
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	last string
+}
+
+// Open opens the log at path for appending, creating it if needed. With
+// HashChain, the chain continues from the last entry already written.
+func Open(path string, opts Options) (*Log, error) {
+	l := &Log{path: path, opts: opts}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	if opts.HashChain {
+		files, err := Files(path)
+		if err != nil {
+			l.f.Close()
+			return nil, err
+		}
+
+		for i := len(files) - 1; i >= 0 && l.last == ""; i-- {
+			if l.last, err = lastHash(files[i]); err != nil {
+				l.f.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f, l.size = f, fi.Size()
+
+	return nil
+}
+
+// Write appends e to the log, rotating it first if it would grow past
+// MaxSize.
+func (l *Log) Write(e *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return errors.New("audit log is closed")
+	}
+
+	e.Prev, e.Hash = "", ""
+	if l.opts.HashChain {
+		e.Prev = l.last
+	}
+
+	line, hash, err := marshal(e, l.opts.HashChain)
+	if err != nil {
+		return err
+	}
+
+	if l.opts.MaxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.opts.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	l.last = hash
+
+	return nil
+}
+
+func (l *Log) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	backup := l.path + "." + time.Now().UTC().Format(backupTimeFormat)
+	if err := os.Rename(l.path, backup); err != nil {
+		return err
+	}
+
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	if l.opts.MaxBackups <= 0 {
+		return nil
+	}
+
+	files, err := Files(l.path)
+	if err != nil {
+		return err
+	}
+
+	// The last file is the one just created
+	backups := files[:len(files)-1]
+	for len(backups) > l.opts.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// Close closes the underlying file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+
+	err := l.f.Close()
+	l.f = nil
+
+	return err
+}
+
+// marshal encodes e as a JSON line. When chained, the hash of the line
+// without its hash field is appended as the last field.
+func marshal(e *Entry, chained bool) ([]byte, string, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !chained {
+		return append(b, '\n'), "", nil
+	}
+
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+
+	line := append(b[:len(b)-1], `,"hash":"`...)
+	line = append(line, hash...)
+	line = append(line, "\"}\n"...)
+
+	return line, hash, nil
+}
+
+// hashSuffixLen is the length of `,"hash":"<64 hex digits>"}`.
+const hashSuffixLen = len(`,"hash":""}`) + 2*sha256.Size
+
+// Files returns the rotated files of the log at path, oldest first, followed
+// by the current file if it exists.
+func Files(path string) ([]string, error) {
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	// Timestamps sort lexically
+	sort.Strings(backups)
+
+	if _, err := os.Stat(path); err == nil {
+		backups = append(backups, path)
+	}
+
+	return backups, nil
+}
+
+// lastHash returns the hash of the last entry of the file at path, or an
+// empty string if it has none.
+func lastHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Entries are far shorter than this, a tail is enough to find the last
+	const tail = 64 * 1024
+
+	offset := fi.Size() - tail
+	if offset < 0 {
+		offset = 0
+	}
+
+	b := make([]byte, fi.Size()-offset)
+	if _, err := f.ReadAt(b, offset); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	b = bytes.TrimRight(b, "\n")
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		b = b[i+1:]
+	}
+
+	if len(b) == 0 {
+		return "", nil
+	}
+
+	var e Entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return "", fmt.Errorf("%s: last entry: %v", path, err)
+	}
+
+	return e.Hash, nil
+}
+
+// Verify checks the hash chain of the entries read from r, starting from the
+// hash prev of the entry preceding them. An empty prev trusts the first entry,
+// as the files holding its predecessors may have been rotated out: removing
+// lines from the head of the log is not detected. It returns the hash of the
+// last entry so that rotated files can be verified in sequence. Entries
+// written without HashChain fail the verification.
+func Verify(r io.Reader, prev string) (string, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1024*1024)
+
+	for n := 1; s.Scan(); n++ {
+		line := s.Bytes()
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return "", fmt.Errorf("line %d: %v", n, err)
+		}
+
+		if e.Hash == "" {
+			return "", fmt.Errorf("line %d: no hash, the entry was written without a hash chain", n)
+		}
+
+		if e.Prev != prev && (n > 1 || prev != "") {
+			return "", fmt.Errorf("line %d: chain broken, previous hash is %q, expected %q", n, e.Prev, prev)
+		}
+
+		if len(line) < hashSuffixLen {
+			return "", fmt.Errorf("line %d: missing hash", n)
+		}
+
+		content := append(line[:len(line)-hashSuffixLen:len(line)-hashSuffixLen], '}')
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != e.Hash {
+			return "", fmt.Errorf("line %d: entry was modified", n)
+		}
+
+		prev = e.Hash
+	}
+
+	return prev, s.Err()
+}
+
+// VerifyFiles checks the hash chain across the given files, in order.
+func VerifyFiles(paths ...string) error {
+	var prev string
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		prev, err = Verify(f, prev)
+		f.Close()
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	return nil
+}
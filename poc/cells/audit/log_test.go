@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+
+	l, err := Open(path, Options{MaxSize: 600, HashChain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		if err := l.Write(&Entry{Op: OpMkdir, Path: p, Result: "ok"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	// Reopening continues the chain
+	l, err = Open(path, Options{MaxSize: 600, HashChain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(&Entry{Op: OpRemove, Path: "/a", Result: "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	files, err := Files(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("expected the log to be rotated, got %v", files)
+	}
+
+	if err := VerifyFiles(files...); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// Tampering with an entry breaks the chain
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte(`"/b"`), []byte(`"/x"`), 1)
+	if err := ioutil.WriteFile(files[0], data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyFiles(files...); err == nil {
+		t.Fatal("expected a modified entry to be detected")
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	s := NewServer(nil, nil, []string{"/tmp", "/*/cache"})
+
+	for p, want := range map[string]bool{
+		"/tmp":          true,
+		"/tmp/a/b":      true,
+		"tmp/a":         true,
+		"/data/tmp":     false,
+		"/home/cache":   true,
+		"/home/cache/a": true,
+		"/home/a/cache": false,
+	} {
+		if got := s.excluded(p); got != want {
+			t.Errorf("excluded(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestVerifyUnchained(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+
+	l, err := Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(&Entry{Op: OpMkdir, Path: "/a", Result: "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// A log without hashes has nothing to verify
+	if err := VerifyFiles(path); err == nil || !strings.Contains(err.Error(), "without a hash chain") {
+		t.Fatalf("expected the missing chain to be reported, got %v", err)
+	}
+}
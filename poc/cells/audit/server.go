@@ -0,0 +1,298 @@
+// Package audit records the mutating calls made to an index server.
+package audit
+
+import (
+	"context"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/index"
+)
+
+// Operations recorded in the log.
+const (
+	OpChtimes   = "chtimes"
+	OpChmod     = "chmod"
+	OpMkdir     = "mkdir"
+	OpMkdirAll  = "mkdir_all"
+	OpRename    = "rename"
+	OpRemove    = "remove"
+	OpRemoveAll = "remove_all"
+	OpWrite     = "write"
+	OpRestore   = "restore"
+	OpPurge     = "purge"
+
+	OpRestoreVersion = "restore_version"
+	OpSnapshot       = "snapshot"
+	OpDeleteSnapshot = "delete_snapshot"
+)
+
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// Server sits in front of an index.FSServer and logs every call that
+// modifies the exported filesystems. Calls are forwarded unchanged.
+type Server struct {
+	next    index.FSServer
+	log     *Log
+	exclude []string
+}
+
+// NewServer logs the mutating calls handled by next to l. Paths matching one
+// of the exclude patterns, or located under a directory matching one, are
+// not logged. Patterns use the path.Match syntax.
+func NewServer(next index.FSServer, l *Log, exclude []string) *Server {
+	return &Server{
+		next:    next,
+		log:     l,
+		exclude: exclude,
+	}
+}
+
+func (s *Server) Stat(ctx context.Context, in *index.FileRequest) (*index.FileInfo, error) {
+	return s.next.Stat(ctx, in)
+}
+
+func (s *Server) Chtimes(ctx context.Context, in *index.ChtimesRequest) (*index.ChtimesResponse, error) {
+	start := time.Now()
+	resp, err := s.next.Chtimes(ctx, in)
+	s.record(ctx, &Entry{Op: OpChtimes, Path: in.GetName()}, start, err)
+
+	return resp, err
+}
+
+func (s *Server) Chmod(ctx context.Context, in *index.ChmodRequest) (*index.ChmodResponse, error) {
+	start := time.Now()
+	resp, err := s.next.Chmod(ctx, in)
+	s.record(ctx, &Entry{Op: OpChmod, Path: in.GetName()}, start, err)
+
+	return resp, err
+}
+
+func (s *Server) Mkdir(ctx context.Context, in *index.MkdirRequest) (*index.MkdirResponse, error) {
+	start := time.Now()
+	resp, err := s.next.Mkdir(ctx, in)
+	s.record(ctx, &Entry{Op: OpMkdir, Path: in.GetName()}, start, err)
+
+	return resp, err
+}
+
+func (s *Server) MkdirAll(ctx context.Context, in *index.MkdirAllRequest) (*index.MkdirAllResponse, error) {
+	start := time.Now()
+	resp, err := s.next.MkdirAll(ctx, in)
+	s.record(ctx, &Entry{Op: OpMkdirAll, Path: in.GetPath()}, start, err)
+
+	return resp, err
+}
+
+func (s *Server) Rename(ctx context.Context, in *index.RenameRequest) (*index.RenameResponse, error) {
+	start := time.Now()
+	resp, err := s.next.Rename(ctx, in)
+	s.record(ctx, &Entry{Op: OpRename, Path: in.GetOldName(), NewPath: in.GetNewName()}, start, err)
+
+	return resp, err
+}
+
+func (s *Server) RemoveAll(ctx context.Context, in *index.RemoveAllRequest) (*index.RemoveAllResponse, error) {
+	start := time.Now()
+	resp, err := s.next.RemoveAll(ctx, in)
+	s.record(ctx, &Entry{Op: OpRemoveAll, Path: in.GetPath()}, start, err)
+
+	return resp, err
+}
+
+func (s *Server) Remove(ctx context.Context, in *index.RemoveRequest) (*index.RemoveResponse, error) {
+	start := time.Now()
+	resp, err := s.next.Remove(ctx, in)
+	s.record(ctx, &Entry{Op: OpRemove, Path: in.GetName()}, start, err)
+
+	return resp, err
+}
+
+func (s *Server) ListTrash(ctx context.Context, in *index.ListTrashRequest) (*index.ListTrashResponse, error) {
+	return s.next.ListTrash(ctx, in)
+}
+
+// RestoreTrash logs the trash entry restored and where it was restored to.
+func (s *Server) RestoreTrash(ctx context.Context, in *index.RestoreTrashRequest) (*index.RestoreTrashResponse, error) {
+	start := time.Now()
+	resp, err := s.next.RestoreTrash(ctx, in)
+	s.record(ctx, &Entry{Op: OpRestore, Path: path.Join("/", index.TrashDir, in.GetId()), NewPath: in.GetPath()}, start, err)
+
+	return resp, err
+}
+
+// PurgeTrash logs one entry per purged trash entry, or a single one for the
+// whole trash.
+func (s *Server) PurgeTrash(ctx context.Context, in *index.PurgeTrashRequest) (*index.PurgeTrashResponse, error) {
+	start := time.Now()
+	resp, err := s.next.PurgeTrash(ctx, in)
+
+	if in.GetAll() {
+		s.record(ctx, &Entry{Op: OpPurge, Path: path.Join("/", index.TrashDir)}, start, err)
+	}
+	for _, id := range in.GetIds() {
+		s.record(ctx, &Entry{Op: OpPurge, Path: path.Join("/", index.TrashDir, id)}, start, err)
+	}
+
+	return resp, err
+}
+
+func (s *Server) ListVersions(ctx context.Context, in *index.ListVersionsRequest) (*index.ListVersionsResponse, error) {
+	return s.next.ListVersions(ctx, in)
+}
+
+func (s *Server) OpenVersion(in *index.OpenVersionRequest, stream index.FS_OpenVersionServer) error {
+	return s.next.OpenVersion(in, stream)
+}
+
+// RestoreVersion logs the file restored, the version is kept in NewPath as
+// name@id.
+func (s *Server) RestoreVersion(ctx context.Context, in *index.RestoreVersionRequest) (*index.RestoreVersionResponse, error) {
+	start := time.Now()
+	resp, err := s.next.RestoreVersion(ctx, in)
+	s.record(ctx, &Entry{Op: OpRestoreVersion, Path: in.GetName(), NewPath: in.GetName() + "@" + in.GetId()}, start, err)
+
+	return resp, err
+}
+
+// CreateSnapshot logs the snapshot taken, its name is kept in Path.
+func (s *Server) CreateSnapshot(ctx context.Context, in *index.CreateSnapshotRequest) (*index.CreateSnapshotResponse, error) {
+	start := time.Now()
+	resp, err := s.next.CreateSnapshot(ctx, in)
+
+	name := in.GetName()
+	if err == nil {
+		name = resp.GetSnapshot().GetName()
+	}
+	s.record(ctx, &Entry{Op: OpSnapshot, Path: name}, start, err)
+
+	return resp, err
+}
+
+func (s *Server) ListSnapshots(ctx context.Context, in *index.ListSnapshotsRequest) (*index.ListSnapshotsResponse, error) {
+	return s.next.ListSnapshots(ctx, in)
+}
+
+func (s *Server) DeleteSnapshot(ctx context.Context, in *index.DeleteSnapshotRequest) (*index.DeleteSnapshotResponse, error) {
+	start := time.Now()
+	resp, err := s.next.DeleteSnapshot(ctx, in)
+	s.record(ctx, &Entry{Op: OpDeleteSnapshot, Path: in.GetName()}, start, err)
+
+	return resp, err
+}
+
+// Open logs one write entry per file opened for writing during the stream,
+// once the file is replaced by another one or the stream ends.
+func (s *Server) Open(stream index.FS_OpenServer) error {
+	as := &auditStream{FS_OpenServer: stream, s: s}
+
+	err := s.next.Open(as)
+	as.done(err)
+
+	return err
+}
+
+// record completes e with the caller and the outcome of the call and writes
+// it, unless every path it touches is excluded.
+func (s *Server) record(ctx context.Context, e *Entry, start time.Time, err error) {
+	if s.excluded(e.Path) && (e.NewPath == "" || s.excluded(e.NewPath)) {
+		return
+	}
+
+	e.Time = start.UTC()
+	e.Duration = float64(time.Since(start)) / float64(time.Millisecond)
+	e.Export = index.ExportFromContext(ctx)
+	e.Principal, e.Addr = index.CallerFromContext(ctx)
+
+	e.Result = "ok"
+	if err != nil {
+		e.Result = "error"
+		e.Error = err.Error()
+	}
+
+	if err := s.log.Write(e); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+func (s *Server) excluded(p string) bool {
+	for _, pattern := range s.exclude {
+		for dir := path.Clean("/" + p); ; dir = path.Dir(dir) {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+			if dir == "/" {
+				break
+			}
+		}
+	}
+
+	return false
+}
+
+// auditStream watches the requests of an Open stream to follow the file
+// being written.
+type auditStream struct {
+	index.FS_OpenServer
+	s *Server
+
+	cur *Entry
+	// start is when cur was opened
+	start time.Time
+	// flag cur was opened with
+	flag int
+	// truncated is set once cur was truncated
+	truncated bool
+}
+
+func (as *auditStream) Recv() (*index.FileRequest, error) {
+	r, err := as.FS_OpenServer.Recv()
+	if err != nil {
+		return r, err
+	}
+
+	switch req := r.Request.(type) {
+	case *index.FileRequest_Open:
+		as.done(nil)
+
+		as.cur = &Entry{Op: OpWrite, Path: req.Open.GetName()}
+		as.start = time.Now()
+		as.flag = int(req.Open.GetFlag())
+		as.truncated = false
+	case *index.FileRequest_Truncate:
+		as.truncated = true
+	}
+
+	return r, nil
+}
+
+func (as *auditStream) Send(resp *index.FileResponse) error {
+	err := as.FS_OpenServer.Send(resp)
+	if err == nil && as.cur != nil {
+		as.cur.Bytes += resp.GetWrite().GetBytesWritten()
+	}
+
+	return err
+}
+
+// done records the current file if it was opened for writing and either
+// modified or the stream failed while using it.
+func (as *auditStream) done(err error) {
+	e := as.cur
+	if e == nil {
+		return
+	}
+	as.cur = nil
+
+	if as.flag&writeFlags == 0 {
+		return
+	}
+
+	if e.Bytes == 0 && !as.truncated && as.flag&(os.O_CREATE|os.O_TRUNC) == 0 && err == nil {
+		return
+	}
+
+	as.s.record(as.Context(), e, as.start, err)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/client/resolver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// probeTimeout bounds each health check of --probe.
+const probeTimeout = 2 * time.Second
+
+// peers listens on the discovery channel and prints what the resolver sees
+// of the cluster, as a table refreshed live or as a stream of JSON lines.
+func peers(args []string) error {
+	fs := flag.NewFlagSet("peers", flag.ExitOnError)
+	discovery := fs.String("discovery", "", "discovery backend to listen on, $"+resolver.EnvDiscovery+" or multicast by default")
+	asJSON := fs.Bool("json", false, "stream the changes as JSON lines instead of printing a table")
+	probe := fs.Bool("probe", false, "check the health service of every node and report its latency")
+	interval := fs.Duration("interval", 2*time.Second, "how often nodes are probed")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	if err := resolver.Start(ctx, resolver.Options{Discovery: *discovery}); err != nil {
+		return err
+	}
+
+	v := &view{
+		out:    os.Stdout,
+		probe:  *probe,
+		probes: make(map[string]probeResult),
+		conns:  make(map[string]*grpc.ClientConn),
+	}
+
+	changed := make(chan struct{}, 1)
+	stop := resolver.WatchMembers(func(ev resolver.MemberEvent) {
+		if *asJSON {
+			v.emit(memberEvent(ev), ev.Member)
+		}
+
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer stop()
+
+	if *probe {
+		go v.probeLoop(ctx, *interval, *asJSON)
+	}
+
+	if *asJSON {
+		<-ctx.Done()
+		return nil
+	}
+
+	// Ages are only refreshed on terminals, pipes get a table per change
+	tty := false
+	if fi, err := os.Stdout.Stat(); err == nil {
+		tty = fi.Mode()&os.ModeCharDevice != 0
+	}
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	v.draw(tty)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+			v.draw(tty)
+		case <-t.C:
+			if tty {
+				v.draw(tty)
+			}
+		}
+	}
+}
+
+// view prints the members of the cluster and the results of their probes.
+type view struct {
+	out   io.Writer
+	probe bool
+
+	// mu guards the output and the probes
+	mu     sync.Mutex
+	probes map[string]probeResult
+
+	// conns are only used by the probe loop
+	conns map[string]*grpc.ClientConn
+}
+
+type probeResult struct {
+	latency time.Duration
+	err     error
+}
+
+// peer is a line of the JSON stream.
+type peer struct {
+	// Event is joined, updated, probe or the new state of the member
+	Event string `json:"event"`
+
+	Name     string            `json:"name"`
+	ID       string            `json:"id,omitempty"`
+	State    string            `json:"state"`
+	IP       string            `json:"ip,omitempty"`
+	Port     string            `json:"port,omitempty"`
+	Services []string          `json:"services,omitempty"`
+	Seen     *time.Time        `json:"last_seen,omitempty"`
+	Version  string            `json:"version,omitempty"`
+	Load     uint64            `json:"load,omitempty"`
+	Exports  []string          `json:"exports,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func newPeer(event string, m resolver.Member) peer {
+	p := peer{Event: event, Name: m.Name, State: m.State.String()}
+
+	addr := m.Addr
+	if n := m.Node; n != nil {
+		addr = n.Addr
+		p.ID, p.Services, p.Version, p.Load, p.Labels = n.ID, n.Services, n.Version, n.Load, n.Labels
+		for _, e := range n.Exports {
+			p.Exports = append(p.Exports, e.Name)
+		}
+	}
+	p.IP, p.Port, _ = net.SplitHostPort(addr)
+
+	if !m.Seen.IsZero() {
+		seen := m.Seen
+		p.Seen = &seen
+	}
+
+	return p
+}
+
+func memberEvent(ev resolver.MemberEvent) string {
+	switch {
+	case ev.Joined:
+		return "joined"
+	case ev.Previous != ev.Member.State:
+		return ev.Member.State.String()
+	default:
+		return "updated"
+	}
+}
+
+// emit writes a JSON line for m.
+func (v *view) emit(event string, m resolver.Member) {
+	p := newPeer(event, m)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if r, ok := v.probes[m.Name]; ok && v.probe {
+		p.LatencyMS = float64(r.latency) / float64(time.Millisecond)
+		if r.err != nil {
+			p.Error = r.err.Error()
+		}
+	}
+
+	json.NewEncoder(v.out).Encode(p)
+}
+
+// draw prints the table of the members, over the previous one on
+// terminals.
+func (v *view) draw(clear bool) {
+	members := resolver.Members()
+	now := time.Now()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if clear {
+		fmt.Fprint(v.out, "\033[H\033[2J")
+	} else {
+		fmt.Fprintln(v.out)
+	}
+
+	w := tabwriter.NewWriter(v.out, 0, 4, 2, ' ', 0)
+	header := "NAME\tSTATE\tIP\tPORT\tSERVICES\tLAST SEEN\t"
+	if v.probe {
+		header += "LATENCY\t"
+	}
+	fmt.Fprintln(w, header+"METADATA")
+
+	for _, m := range members {
+		p := newPeer("", m)
+
+		seen := "-"
+		if p.Seen != nil {
+			seen = now.Sub(*p.Seen).Truncate(time.Second).String() + " ago"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t", p.Name, p.State, orDash(p.IP), orDash(p.Port), orDash(strings.Join(p.Services, ",")), seen)
+		if v.probe {
+			fmt.Fprintf(w, "%s\t", v.latency(m.Name))
+		}
+		fmt.Fprintln(w, orDash(metadata(p)))
+	}
+	w.Flush()
+
+	if len(members) == 0 {
+		fmt.Fprintln(v.out, "no peers discovered yet")
+	}
+}
+
+// latency formats the last probe of the member name. v.mu must be held.
+func (v *view) latency(name string) string {
+	r, ok := v.probes[name]
+	switch {
+	case !ok:
+		return "-"
+	case r.err != nil:
+		return "error: " + r.err.Error()
+	default:
+		return r.latency.Round(100 * time.Microsecond).String()
+	}
+}
+
+// metadata formats the labels of the node, sorted, then what it announced
+// of itself.
+func metadata(p peer) string {
+	var fields []string
+	for k, v := range p.Labels {
+		fields = append(fields, k+"="+v)
+	}
+	sort.Strings(fields)
+
+	if p.Version != "" {
+		fields = append(fields, "version="+p.Version)
+	}
+	if p.Load > 0 {
+		fields = append(fields, fmt.Sprintf("load=%d", p.Load))
+	}
+	if len(p.Exports) > 0 {
+		fields = append(fields, "exports="+strings.Join(p.Exports, ","))
+	}
+	if p.ID != "" {
+		fields = append(fields, "id="+p.ID)
+	}
+
+	return strings.Join(fields, " ")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// probeLoop checks the health service of the alive and suspect members
+// every interval until ctx is done.
+func (v *view) probeLoop(ctx context.Context, interval time.Duration, emit bool) {
+	defer v.close()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		v.probeAll(ctx, emit)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (v *view) probeAll(ctx context.Context, emit bool) {
+	var probed []resolver.Member
+	keep := make(map[string]bool)
+	for _, m := range resolver.Members() {
+		if m.Node == nil || (m.State != resolver.Alive && m.State != resolver.Suspect) {
+			continue
+		}
+
+		probed = append(probed, m)
+		keep[m.Node.Addr] = true
+	}
+
+	for addr, conn := range v.conns {
+		if !keep[addr] {
+			conn.Close()
+			delete(v.conns, addr)
+		}
+	}
+
+	results := make([]probeResult, len(probed))
+
+	var wg sync.WaitGroup
+	for i, m := range probed {
+		conn, err := v.dial(m.Node)
+		if err != nil {
+			results[i].err = err
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, conn *grpc.ClientConn) {
+			defer wg.Done()
+			results[i] = check(ctx, conn)
+		}(i, conn)
+	}
+	wg.Wait()
+
+	v.mu.Lock()
+	v.probes = make(map[string]probeResult)
+	for i, m := range probed {
+		v.probes[m.Name] = results[i]
+	}
+	v.mu.Unlock()
+
+	if emit {
+		for _, m := range probed {
+			v.emit("probe", m)
+		}
+	}
+}
+
+// dial returns the connection to the services of n, kept across probes.
+func (v *view) dial(n *resolver.Node) (*grpc.ClientConn, error) {
+	if conn, ok := v.conns[n.Addr]; ok {
+		return conn, nil
+	}
+
+	conn, err := grpc.Dial(n.Addr, transportCredentials(n.TLSFingerprint))
+	if err != nil {
+		return nil, err
+	}
+	v.conns[n.Addr] = conn
+
+	return conn, nil
+}
+
+// check times a health check, which includes connecting the first time.
+func check(ctx context.Context, conn *grpc.ClientConn) probeResult {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	r := probeResult{latency: time.Since(start), err: err}
+	if err == nil && resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		r.err = fmt.Errorf("health: %s", resp.GetStatus())
+	}
+
+	return r
+}
+
+// transportCredentials returns the credentials to reach a node: plain
+// connections without a fingerprint, TLS with a certificate matching it
+// otherwise.
+func transportCredentials(fingerprint string) grpc.DialOption {
+	if fingerprint == "" {
+		return grpc.WithInsecure()
+	}
+
+	return grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		// The certificate is pinned to the fingerprint announced instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if len(raw) > 0 {
+				sum := sha256.Sum256(raw[0])
+				if hex.EncodeToString(sum[:]) == fingerprint {
+					return nil
+				}
+			}
+
+			return errors.New("certificate does not match the fingerprint announced")
+		},
+	}))
+}
+
+func (v *view) close() {
+	for _, conn := range v.conns {
+		conn.Close()
+	}
+}
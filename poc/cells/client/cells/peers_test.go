@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/client/resolver"
+)
+
+func TestNewPeer(t *testing.T) {
+	seen := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		member resolver.Member
+		peer   peer
+	}{
+		{
+			"unknown node",
+			resolver.Member{Name: "node1", Addr: "10.0.0.1:9999", State: resolver.Suspect},
+			peer{Event: "joined", Name: "node1", State: "suspect", IP: "10.0.0.1", Port: "9999"},
+		},
+		{
+			"known node",
+			resolver.Member{Name: "node2", Addr: "10.0.0.2:9999", State: resolver.Alive, Seen: seen, Node: &resolver.Node{
+				ID:       "7f3a",
+				Addr:     "10.0.0.2:4000",
+				Services: []string{"index.FS"},
+				Labels:   map[string]string{"zone": "paris"},
+				Exports:  []*resolver.Export{{Name: "photos"}, {Name: "archive"}},
+				Version:  "1.2",
+				Load:     3,
+			}},
+			peer{
+				Event:    "joined",
+				Name:     "node2",
+				ID:       "7f3a",
+				State:    "alive",
+				IP:       "10.0.0.2",
+				Port:     "4000",
+				Services: []string{"index.FS"},
+				Seen:     &seen,
+				Version:  "1.2",
+				Load:     3,
+				Exports:  []string{"photos", "archive"},
+				Labels:   map[string]string{"zone": "paris"},
+			},
+		},
+		{
+			"no address",
+			resolver.Member{Name: "node3", State: resolver.Left},
+			peer{Event: "joined", Name: "node3", State: "left"},
+		},
+	}
+
+	for _, test := range tests {
+		if p := newPeer("joined", test.member); !reflect.DeepEqual(p, test.peer) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.peer, p)
+		}
+	}
+}
+
+func TestMemberEvent(t *testing.T) {
+	tests := []struct {
+		ev    resolver.MemberEvent
+		event string
+	}{
+		{resolver.MemberEvent{Member: resolver.Member{State: resolver.Alive}, Joined: true}, "joined"},
+		{resolver.MemberEvent{Member: resolver.Member{State: resolver.Suspect}, Joined: true}, "joined"},
+		{resolver.MemberEvent{Member: resolver.Member{State: resolver.Suspect}, Previous: resolver.Alive}, "suspect"},
+		{resolver.MemberEvent{Member: resolver.Member{State: resolver.Left}, Previous: resolver.Alive}, "left"},
+		{resolver.MemberEvent{Member: resolver.Member{State: resolver.Alive}, Previous: resolver.Alive}, "updated"},
+	}
+
+	for i, test := range tests {
+		if event := memberEvent(test.ev); event != test.event {
+			t.Errorf("%d: expected %q, got %q", i, test.event, event)
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	tests := []struct {
+		peer     peer
+		metadata string
+	}{
+		{peer{}, ""},
+		{peer{Labels: map[string]string{"zone": "paris", "rack": "1"}}, "rack=1 zone=paris"},
+		{peer{Version: "1.2", Load: 3}, "version=1.2 load=3"},
+		{
+			peer{ID: "7f3a", Version: "1.2", Exports: []string{"photos", "archive"}, Labels: map[string]string{"zone": "paris"}},
+			"zone=paris version=1.2 exports=photos,archive id=7f3a",
+		},
+	}
+
+	for i, test := range tests {
+		if m := metadata(test.peer); m != test.metadata {
+			t.Errorf("%d: expected %q, got %q", i, test.metadata, m)
+		}
+	}
+}
+
+func TestEmit(t *testing.T) {
+	var out bytes.Buffer
+	v := &view{
+		out:   &out,
+		probe: true,
+		probes: map[string]probeResult{
+			"node1": {latency: 1500 * time.Microsecond},
+			"node2": {latency: time.Millisecond, err: errors.New("health: NOT_SERVING")},
+		},
+	}
+
+	v.emit("probe", resolver.Member{Name: "node1", Addr: "10.0.0.1:4000"})
+	v.emit("probe", resolver.Member{Name: "node2", Addr: "10.0.0.2:4000"})
+	v.emit("joined", resolver.Member{Name: "node3", Addr: "10.0.0.3:4000"})
+
+	dec := json.NewDecoder(&out)
+	var peers []peer
+	for dec.More() {
+		var p peer
+		if err := dec.Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, p)
+	}
+
+	if len(peers) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(peers))
+	}
+	if p := peers[0]; p.Event != "probe" || p.LatencyMS != 1.5 || p.Error != "" {
+		t.Errorf("unexpected probe %+v", p)
+	}
+	if p := peers[1]; p.Error != "health: NOT_SERVING" {
+		t.Errorf("expected the probe to fail, got %+v", p)
+	}
+	if p := peers[2]; p.Event != "joined" || p.IP != "10.0.0.3" || p.LatencyMS != 0 {
+		t.Errorf("unexpected member %+v", p)
+	}
+}
@@ -4,16 +4,23 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/coreos/etcd/etcdserver/etcdserverpb"
 	"google.golang.org/grpc"
 
-	_ "github.com/ghecquet/tripr/poc/cells/client/resolver"
+	"github.com/ghecquet/tripr/poc/cells/client/resolver"
 )
 
 func main() {
+	if err := resolver.Start(context.Background(), resolver.Options{}); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	conn, err := grpc.Dial("cells:///etcdserverpb.KV", grpc.WithInsecure())
+	conn, err := grpc.DialContext(ctx, "cells:///etcdserverpb.KV", grpc.WithInsecure(), grpc.WithBlock())
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
@@ -7,9 +7,10 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
-	"github.com/ghecquet/tripr/poc/cells/index"
+	"github.com/ghecquet/tripr/poc/cells/aferofs"
 	"github.com/pkg/errors"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/configmap"
@@ -145,10 +146,24 @@ type Object struct {
 
 var errLinksAndCopyLinks = errors.New("can't use -l/--links with -L/--copy-links")
 
-// NewFs constructs an Fs from the path
+// NewFs constructs an Fs from the path. The root is a location of the index
+// nodes as accepted by aferofs.OpenIndexFs, on the node named after the
+// remote unless it names its node or is a cells:// URL.
 func NewFs(name, root string, m configmap.Mapper) (fs.Fs, error) {
+	location := root
+	if !strings.Contains(root, "://") && !strings.Contains(root, "@") {
+		location = name + "@" + root
+	}
+
+	ifs, err := aferofs.OpenIndexFs(location)
+	if err != nil {
+		return nil, err
+	}
+
 	f := &Fs{
-		fs: index.NewFs(name + "@" + root),
+		name: name,
+		root: root,
+		fs:   ifs,
 	}
 
 	return f, nil
@@ -0,0 +1,343 @@
+package resolver
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultMaxAge is how old an announcement may be, or how far ahead the
+// clock of its sender may be, before it is rejected.
+const defaultMaxAge = 30 * time.Second
+
+// signedContext separates the signatures of announcements from anything
+// else signed with the same keys.
+const signedContext = "cells-announce-v1\x00"
+
+var (
+	errUnsigned     = errors.New("unsigned announcement")
+	errBadSignature = errors.New("invalid signature")
+	errStale        = errors.New("stale announcement")
+	errReplayed     = errors.New("replayed announcement")
+)
+
+// auth signs the announcements of the local node and authenticates those of
+// its peers, either with a secret shared by the cluster (HMAC-SHA256) or
+// with ed25519 keys of the nodes. It is configured by the query of the
+// discovery spec:
+//
+//	secret=/etc/cells/secret        file holding the cluster secret
+//	key=/etc/cells/node.key         PEM (PKCS #8) ed25519 key of the node
+//	trusted=/etc/cells/trusted.yaml public keys of the nodes, by name
+//	max_age=30s                     announcements older are rejected
+//
+// The trusted file maps node names to PEM or base64 ed25519 public keys.
+// Nodes sign with their key when they have one, and with the secret
+// otherwise.
+type auth struct {
+	secret  []byte
+	key     ed25519.PrivateKey
+	trusted map[string]ed25519.PublicKey
+	maxAge  time.Duration
+	now     func() time.Time
+
+	mu   sync.Mutex
+	seen map[nonce]time.Time
+}
+
+type nonce struct {
+	node  string
+	nonce uint64
+}
+
+// newAuth returns the auth configured by query, or nil when announcements
+// are neither signed nor verified.
+func newAuth(query url.Values) (*auth, error) {
+	a := &auth{maxAge: defaultMaxAge, now: time.Now, seen: make(map[nonce]time.Time)}
+
+	if p := query.Get("secret"); p != "" {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		a.secret = []byte(strings.TrimSpace(string(b)))
+		if len(a.secret) < 16 {
+			return nil, fmt.Errorf("%s: the secret must be at least 16 bytes long", p)
+		}
+	}
+
+	if p := query.Get("key"); p != "" {
+		key, err := loadPrivateKey(p)
+		if err != nil {
+			return nil, err
+		}
+		a.key = key
+	}
+
+	if p := query.Get("trusted"); p != "" {
+		trusted, err := loadTrusted(p)
+		if err != nil {
+			return nil, err
+		}
+		a.trusted = trusted
+	}
+
+	if s := query.Get("max_age"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("max_age: invalid duration %q", s)
+		}
+		a.maxAge = d
+	}
+
+	if a.secret == nil && a.key == nil && a.trusted == nil {
+		return nil, nil
+	}
+
+	return a, nil
+}
+
+func loadPrivateKey(p string) (ed25519.PrivateKey, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM key found", p)
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+
+	key, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", p)
+	}
+
+	return key, nil
+}
+
+func loadTrusted(p string) (map[string]ed25519.PublicKey, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys map[string]string
+	if err := yaml.UnmarshalStrict(b, &keys); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", p, err)
+	}
+
+	trusted := make(map[string]ed25519.PublicKey, len(keys))
+	for name, s := range keys {
+		key, err := parsePublicKey(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", p, name, err)
+		}
+		trusted[name] = key
+	}
+
+	return trusted, nil
+}
+
+// parsePublicKey parses a PEM (PKIX) or base64 ed25519 public key.
+func parsePublicKey(s string) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := k.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("not an ed25519 key")
+		}
+
+		return key, nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, errors.New("not an ed25519 key")
+	}
+
+	return ed25519.PublicKey(b), nil
+}
+
+// canSign reports whether a can sign the messages of node, or of a client
+// when node is empty.
+func (a *auth) canSign(node string) bool {
+	return a.secret != nil || a.key != nil && node != ""
+}
+
+// sign wraps req in a message signed for node.
+func (a *auth) sign(node string, req *Request) (*Request, error) {
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var n [8]byte
+	if _, err := rand.Read(n[:]); err != nil {
+		return nil, err
+	}
+
+	s := &Signed{
+		Payload:   payload,
+		Node:      node,
+		Timestamp: a.now().UnixNano(),
+		Nonce:     binary.BigEndian.Uint64(n[:]),
+	}
+
+	// Clients have no name to be trusted under, only the secret signs
+	// for them
+	switch {
+	case a.key != nil && node != "":
+		s.Alg = Signed_ED25519
+		s.Signature = ed25519.Sign(a.key, signedBytes(s))
+	case a.secret != nil:
+		s.Alg = Signed_HMAC_SHA256
+		s.Signature = a.mac(s)
+	default:
+		return nil, errors.New("no key to sign announcements with")
+	}
+
+	return &Request{Request: &Request_Signed{Signed: s}}, nil
+}
+
+// verify returns the request carried by req once authenticated. Requests
+// that are not signed are rejected.
+func (a *auth) verify(req *Request) (*Request, error) {
+	v, ok := req.Request.(*Request_Signed)
+	if !ok {
+		return nil, errUnsigned
+	}
+	s := v.Signed
+
+	switch s.Alg {
+	case Signed_HMAC_SHA256:
+		if a.secret == nil || !hmac.Equal(s.Signature, a.mac(s)) {
+			return nil, errBadSignature
+		}
+	case Signed_ED25519:
+		key, ok := a.trusted[s.Node]
+		if !ok {
+			return nil, fmt.Errorf("untrusted node %q", s.Node)
+		}
+		if !ed25519.Verify(key, signedBytes(s), s.Signature) {
+			return nil, errBadSignature
+		}
+	default:
+		return nil, errUnsigned
+	}
+
+	if err := a.fresh(s); err != nil {
+		return nil, err
+	}
+
+	return unwrap(s)
+}
+
+// fresh rejects the messages outside of the max age window, and those seen
+// already within it.
+func (a *auth) fresh(s *Signed) error {
+	now := a.now()
+
+	t := time.Unix(0, s.Timestamp)
+	if t.Before(now.Add(-a.maxAge)) || t.After(now.Add(a.maxAge)) {
+		return errStale
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for k, seen := range a.seen {
+		if seen.Before(now.Add(-a.maxAge)) {
+			delete(a.seen, k)
+		}
+	}
+
+	k := nonce{node: s.Node, nonce: s.Nonce}
+	if _, ok := a.seen[k]; ok {
+		return errReplayed
+	}
+	a.seen[k] = t
+
+	return nil
+}
+
+func (a *auth) mac(s *Signed) []byte {
+	m := hmac.New(sha256.New, a.secret)
+	m.Write(signedBytes(s))
+	return m.Sum(nil)
+}
+
+// signedBytes returns what the signature of s covers.
+func signedBytes(s *Signed) []byte {
+	b := make([]byte, 0, len(signedContext)+len(s.Node)+len(s.Payload)+32)
+	b = append(b, signedContext...)
+
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(s.Node)))
+	b = append(b, n[:]...)
+	b = append(b, s.Node...)
+	binary.BigEndian.PutUint64(n[:], uint64(s.Timestamp))
+	b = append(b, n[:]...)
+	binary.BigEndian.PutUint64(n[:], s.Nonce)
+	b = append(b, n[:]...)
+	binary.BigEndian.PutUint64(n[:], uint64(s.Alg))
+	b = append(b, n[:]...)
+
+	return append(b, s.Payload...)
+}
+
+// unwrap returns the request signed in s, which must be about the node that
+// signed it.
+func unwrap(s *Signed) (*Request, error) {
+	req := &Request{}
+	if err := proto.Unmarshal(s.Payload, req); err != nil {
+		return nil, err
+	}
+
+	var node string
+	switch v := req.Request.(type) {
+	case *Request_Node:
+		node = v.Node.Name
+	case *Request_Service:
+		node = v.Service.Node
+	case *Request_Dns:
+		node = v.Dns.Name
+	case *Request_Goodbye:
+		node = v.Goodbye.Name
+	case *Request_Swim:
+		node = v.Swim.From
+	}
+
+	if node != s.Node {
+		return nil, fmt.Errorf("announcement for %q signed by %q", node, s.Node)
+	}
+
+	return req, nil
+}
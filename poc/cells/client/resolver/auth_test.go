@@ -0,0 +1,83 @@
+package resolver
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func TestAuth(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+
+	tests := []struct {
+		name         string
+		sender, peer *auth
+	}{
+		{
+			"hmac",
+			&auth{secret: []byte("0123456789abcdef"), maxAge: time.Minute, now: clock},
+			&auth{secret: []byte("0123456789abcdef"), maxAge: time.Minute, now: clock, seen: make(map[nonce]time.Time)},
+		},
+		{
+			"ed25519",
+			&auth{key: key, maxAge: time.Minute, now: clock},
+			&auth{trusted: map[string]ed25519.PublicKey{"node1": pub}, maxAge: time.Minute, now: clock, seen: make(map[nonce]time.Time)},
+		},
+	}
+
+	for _, test := range tests {
+		now = time.Unix(1000, 0)
+
+		signed, err := test.sender.sign("node1", NewDNS("node1", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := test.peer.verify(signed)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if req.GetDns().GetName() != "node1" {
+			t.Fatalf("%s: unexpected request %v", test.name, req)
+		}
+
+		if _, err := test.peer.verify(signed); err != errReplayed {
+			t.Errorf("%s: expected a replay to be rejected, got %v", test.name, err)
+		}
+
+		if _, err := test.peer.verify(NewDNS("node1", nil)); err != errUnsigned {
+			t.Errorf("%s: expected an unsigned request to be rejected, got %v", test.name, err)
+		}
+
+		// Claiming to be another node
+		forged, _ := test.sender.sign("node1", NewDNS("node2", nil))
+		if _, err := test.peer.verify(forged); err == nil {
+			t.Errorf("%s: expected a request about another node to be rejected", test.name)
+		}
+
+		tampered, _ := test.sender.sign("node1", NewDNS("node1", nil))
+		tampered.GetSigned().Timestamp++
+		if _, err := test.peer.verify(tampered); err != errBadSignature {
+			t.Errorf("%s: expected a tampered request to be rejected, got %v", test.name, err)
+		}
+
+		stale, _ := test.sender.sign("node1", NewDNS("node1", nil))
+		now = now.Add(2 * time.Minute)
+		if _, err := test.peer.verify(stale); err != errStale {
+			t.Errorf("%s: expected a stale request to be rejected, got %v", test.name, err)
+		}
+	}
+
+	// Keys of other nodes do not sign for node1
+	_, other, _ := ed25519.GenerateKey(nil)
+	signed, _ := (&auth{key: other, now: clock}).sign("node1", NewDNS("node1", nil))
+	if _, err := tests[1].peer.verify(signed); err != errBadSignature {
+		t.Errorf("expected a request signed with an untrusted key to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// Balancing policies, chosen per target with balance=<policy>:
+//
+//	round_robin         calls go to each node in turn, the default
+//	least_outstanding   calls go to the node with the fewest calls in
+//	                    progress, counting the load it announces
+//	zone                calls go in turn to the nodes of the zone of the
+//	                    client, given by CELLS_ZONE, or to every node when
+//	                    none of them is in that zone
+//
+// With any policy, the calls carrying an affinity key, see WithAffinity, go
+// to the same node for as long as it is ready. Nodes suspected to have
+// failed by the membership, see Members, only get calls when all are.
+const (
+	RoundRobin       = "round_robin"
+	LeastOutstanding = "least_outstanding"
+	ZoneAffinity     = "zone"
+
+	defaultPolicy = RoundRobin
+)
+
+// EnvZone names the environment variable giving the zone of the client.
+const EnvZone = "CELLS_ZONE"
+
+// ZoneLabel is the label holding the zone of the nodes.
+const ZoneLabel = "zone"
+
+var policies = []string{RoundRobin, LeastOutstanding, ZoneAffinity}
+
+// balancerName returns the name the balancer of policy is registered with.
+func balancerName(policy string) (string, error) {
+	if policy == "" {
+		policy = defaultPolicy
+	}
+
+	for _, p := range policies {
+		if p == policy {
+			return "cells_" + policy, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown balancing policy %q", policy)
+}
+
+func registerBalancers() {
+	for _, policy := range policies {
+		name, _ := balancerName(policy)
+		balancer.Register(base.NewBalancerBuilderV2(name, &pickerBuilder{policy: policy}, base.Config{}))
+	}
+}
+
+type affinityKey struct{}
+
+// WithAffinity returns a context whose calls go to the same node as the
+// other calls made with the same key, the name of the file of Open streams
+// for instance, so that the node serves them from its caches.
+func WithAffinity(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, affinityKey{}, key)
+}
+
+func affinityFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	key, _ := ctx.Value(affinityKey{}).(string)
+	return key
+}
+
+type pickerBuilder struct {
+	policy string
+}
+
+func (b *pickerBuilder) Build(info base.PickerBuildInfo) balancer.V2Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+
+	var conns []*conn
+	for sc, sci := range info.ReadySCs {
+		conns = append(conns, &conn{sc: sc, addr: sci.Address.Addr})
+	}
+
+	return newPicker(b.policy, os.Getenv(EnvZone), conns, registry.labelsOf, registry.suspected)
+}
+
+// conn is a ready connection to a node, and the calls in progress on it.
+type conn struct {
+	sc          balancer.SubConn
+	addr        string
+	outstanding int64
+}
+
+type picker struct {
+	policy    string
+	labels    func(addr string) map[string]string
+	suspected func(addr string) bool
+
+	// conns are the connections calls are spread among, those of the zone
+	// of the client with the zone policy
+	conns []*conn
+	next  uint32
+}
+
+// newPicker returns the picker of policy. The labels of the nodes are read
+// with labels, and their state with suspected, at every pick.
+func newPicker(policy, zone string, conns []*conn, labels func(string) map[string]string, suspected func(string) bool) *picker {
+	// Maps are iterated in random order, make picks reproducible
+	sort.Slice(conns, func(i, j int) bool { return conns[i].addr < conns[j].addr })
+
+	p := &picker{policy: policy, labels: labels, suspected: suspected, conns: conns}
+
+	if policy == ZoneAffinity && zone != "" {
+		var local []*conn
+		for _, c := range conns {
+			if labels(c.addr)[ZoneLabel] == zone {
+				local = append(local, c)
+			}
+		}
+		if len(local) > 0 {
+			p.conns = local
+		}
+	}
+
+	return p
+}
+
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	conns := p.healthy()
+
+	var c *conn
+
+	switch {
+	case affinityFrom(info.Ctx) != "":
+		c = rendezvous(conns, affinityFrom(info.Ctx))
+	case p.policy == LeastOutstanding:
+		c = p.leastOutstanding(conns)
+	default:
+		c = conns[atomic.AddUint32(&p.next, 1)%uint32(len(conns))]
+	}
+
+	atomic.AddInt64(&c.outstanding, 1)
+
+	return balancer.PickResult{
+		SubConn: c.sc,
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt64(&c.outstanding, -1)
+		},
+	}, nil
+}
+
+// healthy returns the connections to the nodes that are not suspected to
+// have failed, or all of them when they all are.
+func (p *picker) healthy() []*conn {
+	if p.suspected == nil {
+		return p.conns
+	}
+
+	var conns []*conn
+	for _, c := range p.conns {
+		if !p.suspected(c.addr) {
+			conns = append(conns, c)
+		}
+	}
+
+	if len(conns) == 0 {
+		return p.conns
+	}
+
+	return conns
+}
+
+// rendezvous returns the connection with the highest hash of key and its
+// address, which only changes for the keys of the nodes that come and go.
+func rendezvous(conns []*conn, key string) *conn {
+	var (
+		best  *conn
+		score uint64
+	)
+
+	for _, c := range conns {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(c.addr))
+
+		if s := h.Sum64(); best == nil || s > score {
+			best, score = c, s
+		}
+	}
+
+	return best
+}
+
+// leastOutstanding returns the connection whose node has the fewest calls in
+// progress: those of this client, and those of every client according to the
+// load the node announced last. Ties are broken in turn.
+func (p *picker) leastOutstanding(conns []*conn) *conn {
+	start := int(atomic.AddUint32(&p.next, 1))
+
+	var (
+		best  *conn
+		score int64
+	)
+
+	for i := range conns {
+		c := conns[(start+i)%len(conns)]
+
+		s := atomic.LoadInt64(&c.outstanding)
+		if load, err := strconv.ParseInt(p.labels(c.addr)[LoadLabel], 10, 64); err == nil {
+			s += load
+		}
+
+		if best == nil || s < score {
+			best, score = c, s
+		}
+	}
+
+	return best
+}
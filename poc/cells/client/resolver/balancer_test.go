@@ -0,0 +1,126 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func testConns(addrs ...string) []*conn {
+	var conns []*conn
+	for _, addr := range addrs {
+		conns = append(conns, &conn{sc: &fakeSubConn{addr: addr}, addr: addr})
+	}
+
+	return conns
+}
+
+func pick(t *testing.T, p *picker, ctx context.Context) (string, func(balancer.DoneInfo)) {
+	res, err := p.Pick(balancer.PickInfo{FullMethodName: "/index.FS/Open", Ctx: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.SubConn.(*fakeSubConn).addr, res.Done
+}
+
+func TestBalancerPolicies(t *testing.T) {
+	labels := map[string]map[string]string{
+		"10.0.0.1:1000": {"zone": "paris", "load": "5"},
+		"10.0.0.2:1000": {"zone": "london", "load": "0"},
+		"10.0.0.3:1000": {"zone": "paris", "load": "1"},
+	}
+	labelsOf := func(addr string) map[string]string { return labels[addr] }
+	addrs := []string{"10.0.0.3:1000", "10.0.0.1:1000", "10.0.0.2:1000"}
+	ctx := context.Background()
+
+	// Round robin goes through every node
+	p := newPicker(RoundRobin, "", testConns(addrs...), labelsOf, nil)
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		addr, _ := pick(t, p, ctx)
+		seen[addr] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected round robin to use every node, got %v", seen)
+	}
+
+	// Least outstanding counts the announced load and the calls in progress
+	p = newPicker(LeastOutstanding, "", testConns(addrs...), labelsOf, nil)
+	var got []string
+	var dones []func(balancer.DoneInfo)
+	for i := 0; i < 3; i++ {
+		addr, done := pick(t, p, ctx)
+		got = append(got, addr)
+		dones = append(dones, done)
+	}
+	if got[0] != "10.0.0.2:1000" || got[1] == "10.0.0.1:1000" || got[2] == "10.0.0.1:1000" {
+		t.Errorf("unexpected least outstanding picks %v", got)
+	}
+	for _, done := range dones {
+		done(balancer.DoneInfo{})
+	}
+	if addr, _ := pick(t, p, ctx); addr != "10.0.0.2:1000" {
+		t.Errorf("expected the calls done to be forgotten, got %s", addr)
+	}
+
+	// Zone affinity keeps to the zone of the client
+	p = newPicker(ZoneAffinity, "paris", testConns(addrs...), labelsOf, nil)
+	for i := 0; i < 4; i++ {
+		if addr, _ := pick(t, p, ctx); labels[addr]["zone"] != "paris" {
+			t.Errorf("expected a node in paris, got %s", addr)
+		}
+	}
+
+	// ...unless there is no node in it
+	p = newPicker(ZoneAffinity, "tokyo", testConns(addrs...), labelsOf, nil)
+	if len(p.conns) != 3 {
+		t.Errorf("expected every node to be used, got %d", len(p.conns))
+	}
+}
+
+func TestBalancerAffinity(t *testing.T) {
+	labelsOf := func(string) map[string]string { return nil }
+	ctx := WithAffinity(context.Background(), "/photos/a.jpg")
+
+	p := newPicker(RoundRobin, "", testConns("10.0.0.1:1000", "10.0.0.2:1000", "10.0.0.3:1000"), labelsOf, nil)
+	first, _ := pick(t, p, ctx)
+	for i := 0; i < 5; i++ {
+		if addr, _ := pick(t, p, ctx); addr != first {
+			t.Fatalf("expected streams of the same file to go to %s, got %s", first, addr)
+		}
+	}
+
+	// Other nodes leaving do not move the file
+	remaining := []string{first}
+	for _, addr := range []string{"10.0.0.1:1000", "10.0.0.2:1000"} {
+		if addr != first {
+			remaining = append(remaining, addr)
+			break
+		}
+	}
+	p = newPicker(RoundRobin, "", testConns(remaining...), labelsOf, nil)
+	if addr, _ := pick(t, p, ctx); addr != first {
+		t.Errorf("expected the file to stay on %s, got %s", first, addr)
+	}
+
+	// Suspected nodes are avoided, unless they all are
+	p = newPicker(RoundRobin, "", testConns(remaining...), labelsOf, func(addr string) bool { return addr == first })
+	if addr, _ := pick(t, p, ctx); addr == first {
+		t.Errorf("expected the file to move from the suspected %s", first)
+	}
+	p = newPicker(RoundRobin, "", testConns(remaining...), labelsOf, func(string) bool { return true })
+	if addr, _ := pick(t, p, ctx); addr != first {
+		t.Errorf("expected the file to stay on %s, got %s", first, addr)
+	}
+
+	if _, err := balancerName("random"); err == nil {
+		t.Errorf("expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,306 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvDiscovery names the environment variable selecting the discovery
+// backend, see Open.
+const EnvDiscovery = "CELLS_DISCOVERY"
+
+// refreshInterval is how often the backends that are polled are read again,
+// well within the TTL of the registry.
+const refreshInterval = DefaultTTL / 3
+
+// Discovery finds the nodes of the cluster and the services they serve, and
+// makes the local node known to them.
+type Discovery interface {
+	// Watch adds what is discovered to r until ctx is done.
+	Watch(ctx context.Context, r *Registry) error
+
+	// Announce tells the peers that the node serves its services. Nodes
+	// announce themselves again at regular intervals for as long as they
+	// run.
+	Announce(n *Node) error
+
+	// Leave tells the peers that the node stops serving.
+	Leave(n *Node) error
+
+	// Close releases the resources of the backend.
+	Close() error
+}
+
+// Node is what a node announces of itself.
+type Node struct {
+	// ID identifies the node across restarts, unlike its name which may be
+	// changed
+	ID   string
+	Name string
+
+	// Addr is the host:port the services are served at. Unless
+	// Advertised is set, multicast announcements carry the port with the
+	// address of the interface they are sent on, or with no host when it
+	// has none or no interface was selected, peers then use the address
+	// the datagrams come from. Neither works through NAT.
+	Addr       string
+	Advertised bool
+
+	Services []string
+
+	// Labels describe the node to the clients selecting nodes in their
+	// targets, zone=paris for instance
+	Labels map[string]string
+
+	Exports        []*Export
+	Version        string
+	Load           uint64
+	TLSFingerprint string
+}
+
+// Open returns the discovery backend described by spec:
+//
+//	multicast://224.0.0.1:9999          UDP multicast, the default, see auth
+//	                                    for signed announcements and
+//	                                    MulticastOptions for interfaces
+//	                                    and seeds
+//	multicast://[ff02::1]:9999          UDP multicast on IPv6 links
+//	static:///etc/cells/peers.yaml      a static list of peers
+//	etcd://10.0.0.1:2379,10.0.0.2:2379/cells
+//	                                    etcd, keys under the /cells prefix
+//	srv://_cells._tcp.example.com       DNS SRV records
+//	swim://0.0.0.0:7946?join=10.0.0.5   gossip membership with failure
+//	                                    detection, see swim
+//
+// An empty spec uses multicast on the default address, and a spec without
+// scheme is a multicast address.
+func Open(spec string) (Discovery, error) {
+	u, err := parseDiscovery(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "multicast":
+		return newMulticast(u.Host, u.Query())
+	case "static":
+		return &static{path: u.Path}, nil
+	case "etcd":
+		return newEtcd(strings.Split(u.Host, ","), u.Path)
+	case "swim":
+		return openSwim(u)
+	default:
+		return newSRV(u.Host, u.Query()), nil
+	}
+}
+
+// ValidateDiscovery checks spec without opening the backend.
+func ValidateDiscovery(spec string) error {
+	_, err := parseDiscovery(spec)
+	return err
+}
+
+func parseDiscovery(spec string) (*url.URL, error) {
+	if spec == "" {
+		spec = discoveryAddr
+	}
+	if !strings.Contains(spec, "://") {
+		spec = "multicast://" + spec
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+
+	switch u.Scheme {
+	case "multicast":
+		addr, err := net.ResolveUDPAddr("udp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("discovery: %v", err)
+		}
+		if !addr.IP.IsMulticast() {
+			return nil, fmt.Errorf("discovery: %s is not a multicast address", addr.IP)
+		}
+		if _, err := newAuth(u.Query()); err != nil {
+			return nil, fmt.Errorf("discovery: %v", err)
+		}
+		if _, err := parseMulticastOptions(u.Query()); err != nil {
+			return nil, fmt.Errorf("discovery: %v", err)
+		}
+	case "swim":
+		if _, err := swimAddr(u.Host); err != nil {
+			return nil, fmt.Errorf("discovery: %v", err)
+		}
+		if _, err := newAuth(u.Query()); err != nil {
+			return nil, fmt.Errorf("discovery: %v", err)
+		}
+		for _, seed := range splitList(u.Query().Get("join")) {
+			if _, _, err := splitSeed(seed); err != nil {
+				return nil, fmt.Errorf("discovery: join: %v", err)
+			}
+		}
+	case "static":
+		if u.Path == "" {
+			return nil, fmt.Errorf("discovery: %q: missing the path of the peers file", spec)
+		}
+	case "etcd", "srv":
+		if u.Host == "" {
+			return nil, fmt.Errorf("discovery: %q: missing host", spec)
+		}
+	default:
+		return nil, fmt.Errorf("discovery: unknown backend %q", u.Scheme)
+	}
+
+	return u, nil
+}
+
+// AdvertiseAddr returns the address peers reach a server listening on lis
+// at: host when set, the listening IP unless it is unspecified, or the first
+// IP of the machine that is not a loopback.
+func AdvertiseAddr(lis net.Addr, host string) string {
+	h, port, err := net.SplitHostPort(lis.String())
+	if err != nil {
+		return lis.String()
+	}
+
+	if host == "" {
+		if ip := net.ParseIP(h); ip != nil && !ip.IsUnspecified() {
+			host = h
+		} else {
+			host = localIP()
+		}
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+func localIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+
+	var v6 string
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || !ipnet.IP.IsGlobalUnicast() {
+			continue
+		}
+
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP.String()
+		}
+		if v6 == "" {
+			v6 = ipnet.IP.String()
+		}
+	}
+
+	if v6 != "" {
+		return v6
+	}
+
+	return "127.0.0.1"
+}
+
+// ErrStarted is returned by Start while discovery runs already.
+var ErrStarted = errors.New("resolver: discovery already started")
+
+// Options configure Start.
+type Options struct {
+	// Discovery is the spec of the backend, see Open. It defaults to the
+	// CELLS_DISCOVERY environment variable, then to multicast.
+	Discovery string
+
+	// Backend is used rather than opening Discovery when set, a Memory
+	// for instance.
+	Backend Discovery
+}
+
+var (
+	discoveryMu  sync.Mutex
+	discoveryCtx context.Context
+)
+
+// Start runs the discovery the cells resolver finds nodes with until ctx is
+// done, then closes the backend. It returns once the backend is ready and
+// errors if it cannot be, without waiting for any node: until nodes are
+// found, the connections to cells targets report that they have no
+// endpoints yet.
+//
+// Discovery is started once at a time, Start returns ErrStarted until the
+// context of the running discovery is done. What a former backend
+// discovered expires with the TTL of the registry.
+func Start(ctx context.Context, opts Options) error {
+	discoveryMu.Lock()
+	defer discoveryMu.Unlock()
+
+	if discoveryCtx != nil && discoveryCtx.Err() == nil {
+		return ErrStarted
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d := opts.Backend
+	if d == nil {
+		spec := opts.Discovery
+		if spec == "" {
+			spec = os.Getenv(EnvDiscovery)
+		}
+
+		var err error
+		if d, err = Open(spec); err != nil {
+			return err
+		}
+	}
+
+	if c, ok := d.(checker); ok {
+		if err := c.check(); err != nil {
+			d.Close()
+			return fmt.Errorf("discovery: %v", err)
+		}
+	}
+
+	discoveryCtx = ctx
+
+	go registry.run(expireInterval, ctx.Done())
+	go discover(ctx, d)
+
+	return nil
+}
+
+// checker is implemented by the backends that can tell whether Watch can
+// work before it is called.
+type checker interface {
+	check() error
+}
+
+// discover runs d until ctx is done, starting it again when it fails.
+func discover(ctx context.Context, d Discovery) {
+	defer d.Close()
+
+	for {
+		err := d.Watch(ctx, registry)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("discovery: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
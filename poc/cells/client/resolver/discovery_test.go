@@ -0,0 +1,313 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+func TestValidateDiscovery(t *testing.T) {
+	valid := []string{
+		"",
+		"224.0.0.1:9999",
+		"multicast://224.0.0.1:9999",
+		"multicast://[ff02::1]:9999?interfaces=eth*,en0&exclude=eth2",
+		"multicast://224.0.0.1:9999?seeds=10.0.0.5,10.0.0.6:9998,[fd00::1]",
+		"static:///etc/cells/peers.yaml",
+		"etcd://127.0.0.1:2379,127.0.0.2:2379/cells",
+		"srv://_cells._tcp.example.com?services=index.FS",
+	}
+	for _, spec := range valid {
+		if err := ValidateDiscovery(spec); err != nil {
+			t.Errorf("%q: %v", spec, err)
+		}
+	}
+
+	invalid := []string{
+		"consul://127.0.0.1:8500",
+		"static://",
+		"etcd:///cells",
+		"multicast://nowhere",
+		"multicast://224.0.0.1:9999?secret=/nonexistent",
+		"multicast://224.0.0.1:9999?max_age=forever",
+		"multicast://10.0.0.1:9999",
+		"multicast://224.0.0.1:9999?interfaces=eth[",
+		"multicast://224.0.0.1:9999?seeds=10.0.0.5:http",
+	}
+	for _, spec := range invalid {
+		if err := ValidateDiscovery(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestStaticDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "peers.yaml")
+	peers := `
+nodes:
+  - name: node1
+    addr: 10.0.0.1:7000
+  - name: config
+    addr: 10.0.0.2:7001
+    services: [etcdserverpb.KV]
+`
+	if err := ioutil.WriteFile(path, []byte(peers), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Open("static://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	r := NewRegistry(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- d.Watch(ctx, r)
+	}()
+
+	for i := 0; len(r.Nodes()) < 2; i++ {
+		if i == 100 {
+			t.Fatal("peers not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if eps := r.Endpoints("index.FS"); len(eps) != 1 || eps[0] != "10.0.0.1:7000" {
+		t.Fatalf("unexpected index.FS endpoints %v", eps)
+	}
+	if eps := r.Endpoints("etcdserverpb.KV"); len(eps) != 1 || eps[0] != "10.0.0.2:7001" {
+		t.Fatalf("unexpected etcdserverpb.KV endpoints %v", eps)
+	}
+	if hosts := r.Hosts("config"); len(hosts) != 1 || hosts[0] != "10.0.0.2" {
+		t.Fatalf("unexpected hosts %v", hosts)
+	}
+}
+
+func TestMulticastOptions(t *testing.T) {
+	defaults := MulticastOptions{}
+	for name, use := range map[string]bool{"eth0": true, "en0": true, "docker0": false, "veth12ab": false, "wg0": false} {
+		if defaults.use(name) != use {
+			t.Errorf("%s: expected use %v by default", name, use)
+		}
+	}
+
+	o := MulticastOptions{Interfaces: []string{"eth*", "wg0"}, Exclude: []string{"eth2"}}
+	for name, use := range map[string]bool{"eth0": true, "eth2": false, "en0": false, "wg0": true} {
+		if o.use(name) != use {
+			t.Errorf("%s: expected use %v", name, use)
+		}
+	}
+
+	o.Seeds = []string{"10.0.0.5"}
+	spec, err := o.Spec("224.0.0.1:9999?max_age=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := parseDiscovery(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseMulticastOptions(u.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Interfaces) != 2 || got.Exclude[0] != "eth2" || got.Seeds[0] != "10.0.0.5" || u.Query().Get("max_age") != "1m" {
+		t.Errorf("unexpected options %+v from %s", got, spec)
+	}
+
+	if _, err := o.Spec("static:///etc/cells/peers.yaml"); err == nil {
+		t.Error("expected options of other backends to be rejected")
+	}
+	if spec, err := (MulticastOptions{}).Spec("static:///etc/cells/peers.yaml"); err != nil || spec != "static:///etc/cells/peers.yaml" {
+		t.Errorf("expected the spec unchanged, got %q, %v", spec, err)
+	}
+}
+
+func TestMulticastRelay(t *testing.T) {
+	// A client listening on the port of the group, which asked for relays
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)})
+	if err != nil {
+		t.Skipf("no second loopback address: %v", err)
+	}
+	defer client.Close()
+
+	port := client.LocalAddr().(*net.UDPAddr).Port
+	m := &multicast{addr: &net.UDPAddr{IP: net.IPv4(224, 0, 0, 1), Port: port}}
+
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &listener{conn: newGroupConn(c, false), frags: newReassembler(), peers: make(map[string]*peer)}
+	defer l.conn.Close()
+
+	r := NewRegistry(time.Minute)
+
+	hello := &Request{Request: &Request_Hello{Hello: &Hello{}}, Route: Request_UNICAST}
+	if err := m.handle(r, l, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 40000}, 0, marshal(t, hello)); err != nil {
+		t.Fatal(err)
+	}
+
+	n := &Node{Name: "node1", Addr: "10.0.0.1:1000", Services: []string{"index.FS"}}
+	req := n.record("10.0.0.1")
+	req.Route = Request_UNICAST
+	if err := m.handle(r, l, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 3), Port: port}, 0, marshal(t, req)); err != nil {
+		t.Fatal(err)
+	}
+	if eps := r.Endpoints("index.FS"); len(eps) != 1 || eps[0] != "10.0.0.1:1000" {
+		t.Fatalf("unexpected endpoints %v", eps)
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, maxDatagramSize)
+	size, _, err := client.ReadFromUDP(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relayed := &Request{}
+	if err := proto.Unmarshal(b[:size], relayed); err != nil {
+		t.Fatal(err)
+	}
+	if relayed.Route != Request_RELAYED || relayed.GetNode().GetName() != "node1" {
+		t.Fatalf("unexpected relayed request %v", relayed)
+	}
+
+	// The client adds the relayed record with the host it carries
+	rc := NewRegistry(time.Minute)
+	if err := m.handle(rc, &listener{frags: newReassembler()}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}, 0, b[:size]); err != nil {
+		t.Fatal(err)
+	}
+	if eps := rc.Endpoints("index.FS"); len(eps) != 1 || eps[0] != "10.0.0.1:1000" {
+		t.Fatalf("unexpected relayed endpoints %v", eps)
+	}
+}
+
+func TestMulticastRelayVerified(t *testing.T) {
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)})
+	if err != nil {
+		t.Skipf("no second loopback address: %v", err)
+	}
+	defer client.Close()
+
+	port := client.LocalAddr().(*net.UDPAddr).Port
+	secret := []byte("0123456789abcdef")
+	withSecret := func() *auth {
+		return &auth{secret: secret, maxAge: time.Minute, now: time.Now, seen: make(map[nonce]time.Time)}
+	}
+	m := &multicast{addr: &net.UDPAddr{IP: net.IPv4(224, 0, 0, 1), Port: port}, auth: withSecret()}
+
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &listener{conn: newGroupConn(c, false), frags: newReassembler(), peers: make(map[string]*peer)}
+	defer l.conn.Close()
+
+	r := NewRegistry(time.Minute)
+	sender := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 3), Port: port}
+
+	// Unsigned hellos and announcements are neither learnt nor relayed
+	hello := &Request{Request: &Request_Hello{Hello: &Hello{}}, Route: Request_UNICAST}
+	if err := m.handle(r, l, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 40000}, 0, marshal(t, hello)); err != errUnsigned {
+		t.Fatalf("expected the hello to be rejected, got %v", err)
+	}
+	if len(l.peers) != 0 {
+		t.Fatalf("expected no peers, got %v", l.peers)
+	}
+
+	data, err := m.helloData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.handle(r, l, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 40000}, 0, data); err != nil {
+		t.Fatal(err)
+	}
+
+	n := &Node{Name: "node1", Addr: "10.0.0.1:1000", Services: []string{"index.FS"}, Labels: map[string]string{}}
+	for i := 0; i < 20; i++ {
+		n.Labels[fmt.Sprintf("label%d", i)] = strings.Repeat("x", 500)
+	}
+
+	req := n.record("10.0.0.1")
+	req.Route = Request_UNICAST
+	if err := m.handle(r, l, sender, 0, marshal(t, req)); err != errUnsigned {
+		t.Fatalf("expected the record to be rejected, got %v", err)
+	}
+	if len(l.peers) != 1 {
+		t.Fatalf("expected the sender not to be learnt, got %v", l.peers)
+	}
+
+	// Signed records are reassembled, then relayed as they were signed
+	signed, err := withSecret().sign("node1", n.record("10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed.Route = Request_UNICAST
+	parts, err := fragment(marshal(t, signed), Request_UNICAST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) < 2 {
+		t.Fatalf("expected the record to be fragmented, got %d parts", len(parts))
+	}
+	for _, part := range parts {
+		if err := m.handle(r, l, sender, 0, part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l.peers) != 2 {
+		t.Fatalf("expected the sender to be learnt, got %v", l.peers)
+	}
+
+	rc := NewRegistry(time.Minute)
+	lc := &listener{frags: newReassembler()}
+	mc := &multicast{addr: m.addr, auth: withSecret()}
+	b := make([]byte, maxDatagramSize)
+	for range parts {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		size, _, err := client.ReadFromUDP(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mc.handle(rc, lc, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}, 0, b[:size]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if eps := rc.Endpoints("index.FS"); len(eps) != 1 || eps[0] != "10.0.0.1:1000" {
+		t.Fatalf("unexpected relayed endpoints %v", eps)
+	}
+}
+
+func marshal(t *testing.T, req *Request) []byte {
+	b, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
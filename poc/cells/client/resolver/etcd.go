@@ -0,0 +1,266 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+const (
+	defaultEtcdPrefix = "/cells"
+	etcdTimeout       = 5 * time.Second
+)
+
+// etcdDiscovery keeps the nodes in etcd, a key per service of each node and
+// a key for the record of the node, as JSON:
+//
+//	<prefix>/<node>/<service> = host:port
+//	<prefix>/<node> = {"ID": "…", "Name": "node1", "Labels": {"zone": "paris"}, …}
+//
+// Keys are attached to a lease of the node, so that they are removed by etcd
+// when the node stops announcing itself.
+type etcdDiscovery struct {
+	client *clientv3.Client
+	prefix string
+
+	mu        sync.Mutex
+	lease     clientv3.LeaseID
+	announced map[string]string
+}
+
+func newEtcd(endpoints []string, prefix string) (*etcdDiscovery, error) {
+	if prefix == "" || prefix == "/" {
+		prefix = defaultEtcdPrefix
+	}
+
+	// Without DialTimeout, the client connects in the background
+	c, err := clientv3.New(clientv3.Config{Endpoints: endpoints})
+	if err != nil {
+		return nil, err
+	}
+
+	return &etcdDiscovery{client: c, prefix: strings.TrimSuffix(prefix, "/")}, nil
+}
+
+// parseKey returns the node and the service of the key k. The service is
+// empty for the key of the record.
+func (e *etcdDiscovery) parseKey(k []byte) (node, service string, ok bool) {
+	rel := strings.TrimPrefix(string(k), e.prefix+"/")
+
+	parts := strings.SplitN(rel, "/", 2)
+	if parts[0] == "" || len(parts) == 2 && parts[1] == "" {
+		return "", "", false
+	}
+	if len(parts) == 1 {
+		return parts[0], "", true
+	}
+
+	return parts[0], parts[1], true
+}
+
+func (e *etcdDiscovery) add(r *Registry, kv *mvccpb.KeyValue) {
+	node, service, ok := e.parseKey(kv.Key)
+	if !ok {
+		return
+	}
+
+	if service == "" {
+		var n Node
+		if err := json.Unmarshal(kv.Value, &n); err == nil && n.Name == node {
+			r.AddNode(&n)
+		}
+		return
+	}
+
+	addr := string(kv.Value)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+
+	r.AddHost(node, host)
+	r.AddEndpoint(service, addr, node)
+}
+
+func (e *etcdDiscovery) remove(r *Registry, kv *mvccpb.KeyValue) {
+	node, service, ok := e.parseKey(kv.Key)
+	if !ok {
+		return
+	}
+
+	if service == "" {
+		r.SetLabels(node, nil)
+		return
+	}
+
+	addr := string(kv.Value)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+
+	r.Remove(node, host, []string{addr})
+}
+
+// list adds every node to r, and returns the revision they were read at.
+func (e *etcdDiscovery) list(ctx context.Context, r *Registry) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, etcdTimeout)
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, e.prefix+"/", clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+
+	for _, kv := range resp.Kvs {
+		e.add(r, kv)
+	}
+
+	return resp.Header.Revision, nil
+}
+
+// Watch follows the changes to the nodes, and lists them again regularly so
+// that the registry does not expire them.
+func (e *etcdDiscovery) Watch(ctx context.Context, r *Registry) error {
+	rev, err := e.list(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wc := e.client.Watch(ctx, e.prefix+"/", clientv3.WithPrefix(), clientv3.WithRev(rev+1), clientv3.WithPrevKV())
+
+	t := time.NewTicker(refreshInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-t.C:
+			if _, err := e.list(ctx, r); err != nil {
+				return err
+			}
+
+		case resp, ok := <-wc:
+			if !ok {
+				return ctx.Err()
+			}
+			if err := resp.Err(); err != nil {
+				return err
+			}
+
+			for _, ev := range resp.Events {
+				switch ev.Type {
+				case clientv3.EventTypePut:
+					e.add(r, ev.Kv)
+				case clientv3.EventTypeDelete:
+					if ev.PrevKv != nil {
+						e.remove(r, ev.PrevKv)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Announce writes the keys of the node when they changed or when its lease
+// expired, and renews the lease otherwise.
+func (e *etcdDiscovery) Announce(n *Node) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	record, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]string{path.Join(e.prefix, n.Name): string(record)}
+	for _, service := range n.Services {
+		keys[path.Join(e.prefix, n.Name, service)] = n.Addr
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lease != clientv3.NoLease {
+		if _, err := e.client.KeepAliveOnce(ctx, e.lease); err != nil {
+			e.lease, e.announced = clientv3.NoLease, nil
+		} else if equalKeys(keys, e.announced) {
+			return nil
+		}
+	}
+
+	if e.lease == clientv3.NoLease {
+		resp, err := e.client.Grant(ctx, int64(DefaultTTL/time.Second))
+		if err != nil {
+			return err
+		}
+		e.lease = resp.ID
+	}
+
+	for k, v := range keys {
+		if _, err := e.client.Put(ctx, k, v, clientv3.WithLease(e.lease)); err != nil {
+			return err
+		}
+	}
+
+	// Services no longer served
+	for k := range e.announced {
+		if _, ok := keys[k]; !ok {
+			if _, err := e.client.Delete(ctx, k); err != nil {
+				return err
+			}
+		}
+	}
+
+	e.announced = keys
+
+	return nil
+}
+
+func equalKeys(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Leave revokes the lease of the node, which removes its keys.
+func (e *etcdDiscovery) Leave(n *Node) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lease == clientv3.NoLease {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	_, err := e.client.Revoke(ctx, e.lease)
+	e.lease, e.announced = clientv3.NoLease, nil
+
+	return err
+}
+
+func (e *etcdDiscovery) Close() error {
+	return e.client.Close()
+}
@@ -0,0 +1,320 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// MulticastOptions configure the multicast backend. They are given in the
+// query of multicast specs, see Spec:
+//
+//	multicast://224.0.0.1:9999?interfaces=eth*&exclude=eth2&seeds=10.0.0.5,10.0.0.6:9999
+type MulticastOptions struct {
+	// Interfaces are the names of the network interfaces announcements
+	// are sent and received on, shell patterns such as eth* included.
+	// When empty, every interface supporting multicast is used but those
+	// matching DefaultExclude.
+	Interfaces []string
+
+	// Exclude are the interfaces never used, even when listed in
+	// Interfaces.
+	Exclude []string
+
+	// Seeds are the host[:port] of nodes announcements are also sent to by
+	// unicast, for networks where multicast is blocked. Seeds relay the
+	// announcements they receive by unicast to the nodes and clients they
+	// heard from, so every node should be given the same seeds, including
+	// the seeds themselves. The port defaults to the port of the group.
+	Seeds []string
+}
+
+// DefaultExclude are the interfaces of container bridges, virtual Ethernet
+// pairs and VPN tunnels, whose addresses peers usually cannot reach. They
+// are only used when listed in MulticastOptions.Interfaces.
+var DefaultExclude = []string{
+	"docker*", "br-*", "veth*", "virbr*", "cni*", "flannel*",
+	"tun*", "tap*", "utun*", "wg*", "zt*", "tailscale*",
+}
+
+func parseMulticastOptions(q url.Values) (MulticastOptions, error) {
+	o := MulticastOptions{
+		Interfaces: splitList(q.Get("interfaces")),
+		Exclude:    splitList(q.Get("exclude")),
+		Seeds:      splitList(q.Get("seeds")),
+	}
+
+	return o, o.validate()
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+func (o MulticastOptions) validate() error {
+	for _, p := range append(append([]string{}, o.Interfaces...), o.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid interface pattern %q", p)
+		}
+	}
+
+	for _, seed := range o.Seeds {
+		if _, _, err := splitSeed(seed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitSeed returns the host and port of seed, the port being empty when
+// not given.
+func splitSeed(seed string) (host, port string, err error) {
+	if h, p, err := net.SplitHostPort(seed); err == nil {
+		if _, err := strconv.ParseUint(p, 10, 16); err != nil {
+			return "", "", fmt.Errorf("invalid seed %q", seed)
+		}
+		return h, p, nil
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(seed, "["), "]")
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		return "", "", fmt.Errorf("invalid seed %q", seed)
+	}
+
+	return host, "", nil
+}
+
+// Spec returns spec with the options added to its query. Options only apply
+// to multicast specs.
+func (o MulticastOptions) Spec(spec string) (string, error) {
+	if len(o.Interfaces) == 0 && len(o.Exclude) == 0 && len(o.Seeds) == 0 {
+		return spec, nil
+	}
+
+	u, err := parseDiscovery(spec)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "multicast" {
+		return "", fmt.Errorf("discovery: interfaces and seeds only apply to multicast, not %s", u.Scheme)
+	}
+	if err := o.validate(); err != nil {
+		return "", fmt.Errorf("discovery: %v", err)
+	}
+
+	q := u.Query()
+	for k, list := range map[string][]string{"interfaces": o.Interfaces, "exclude": o.Exclude, "seeds": o.Seeds} {
+		if len(list) > 0 {
+			q.Set(k, strings.Join(list, ","))
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// use reports whether the interface of that name is used.
+func (o MulticastOptions) use(name string) bool {
+	if matchAny(o.Exclude, name) {
+		return false
+	}
+	if len(o.Interfaces) > 0 {
+		return matchAny(o.Interfaces, name)
+	}
+
+	return !matchAny(DefaultExclude, name)
+}
+
+// interfaces returns the interfaces that are up, support multicast, have an
+// address of the family of the group and are selected by the options.
+func (o MulticastOptions) interfaces(v6 bool) ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var ifis []net.Interface
+	for _, ifi := range all {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 || !o.use(ifi.Name) {
+			continue
+		}
+		if _, ok := interfaceHost(&ifi, v6); ok {
+			ifis = append(ifis, ifi)
+		}
+	}
+
+	return ifis, nil
+}
+
+// interfaceHost returns the host announced on ifi: its first IPv4 address,
+// or with IPv6 its first global address. It is empty for interfaces with
+// link-local IPv6 addresses only, peers use the address announcements come
+// from with their own zone. ok is false when ifi has no address of the
+// family.
+func interfaceHost(ifi *net.Interface, v6 bool) (host string, ok bool) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return "", false
+	}
+
+	for _, a := range addrs {
+		ipnet, isNet := a.(*net.IPNet)
+		if !isNet || (ipnet.IP.To4() == nil) != v6 {
+			continue
+		}
+
+		if !v6 || ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP.String(), true
+		}
+		ok = true
+	}
+
+	return "", ok
+}
+
+// udpHost returns the host of src, with the zone of link-local addresses.
+func udpHost(src *net.UDPAddr) string {
+	if src.Zone != "" {
+		return src.IP.String() + "%" + src.Zone
+	}
+
+	return src.IP.String()
+}
+
+// groupConn is a UDP socket, joined to a multicast group on a set of
+// interfaces when listening.
+type groupConn struct {
+	conn *net.UDPConn
+	v4   *ipv4.PacketConn
+	v6   *ipv6.PacketConn
+}
+
+func newGroupConn(c *net.UDPConn, v6 bool) *groupConn {
+	g := &groupConn{conn: c}
+	if v6 {
+		g.v6 = ipv6.NewPacketConn(c)
+	} else {
+		g.v4 = ipv4.NewPacketConn(c)
+	}
+
+	return g
+}
+
+// listenGroup returns a socket receiving the datagrams sent to group on
+// ifis, or on the default interface when ifis is empty.
+func listenGroup(group *net.UDPAddr, ifis []net.Interface) (*groupConn, error) {
+	v6 := group.IP.To4() == nil
+
+	network := "udp4"
+	if v6 {
+		network = "udp6"
+	}
+
+	var first *net.Interface
+	if len(ifis) > 0 {
+		first = &ifis[0]
+	}
+
+	c, err := net.ListenMulticastUDP(network, first, group)
+	if err != nil {
+		return nil, err
+	}
+	c.SetReadBuffer(maxDatagramSize)
+
+	g := newGroupConn(c, v6)
+	for i := 1; i < len(ifis); i++ {
+		if v6 {
+			err = g.v6.JoinGroup(&ifis[i], group)
+		} else {
+			err = g.v4.JoinGroup(&ifis[i], group)
+		}
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("joining %s on %s: %v", group, ifis[i].Name, err)
+		}
+	}
+
+	// Datagrams are filtered by interface where the platform tells it
+	if v6 {
+		g.v6.SetControlMessage(ipv6.FlagInterface, true)
+	} else {
+		g.v4.SetControlMessage(ipv4.FlagInterface, true)
+	}
+
+	return g, nil
+}
+
+// read reads a datagram, and returns the index of the interface it came
+// from, or 0 when it is not known.
+func (g *groupConn) read(b []byte) (n, ifindex int, src *net.UDPAddr, err error) {
+	var addr net.Addr
+	if g.v6 != nil {
+		var cm *ipv6.ControlMessage
+		n, cm, addr, err = g.v6.ReadFrom(b)
+		if cm != nil {
+			ifindex = cm.IfIndex
+		}
+	} else {
+		var cm *ipv4.ControlMessage
+		n, cm, addr, err = g.v4.ReadFrom(b)
+		if cm != nil {
+			ifindex = cm.IfIndex
+		}
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	src, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, 0, nil, fmt.Errorf("unexpected address %v", addr)
+	}
+
+	return n, ifindex, src, nil
+}
+
+// write sends b to dst, through ifi for multicast groups when not nil.
+func (g *groupConn) write(b []byte, ifi *net.Interface, dst *net.UDPAddr) error {
+	if ifi != nil {
+		var err error
+		if g.v6 != nil {
+			err = g.v6.SetMulticastInterface(ifi)
+		} else {
+			err = g.v4.SetMulticastInterface(ifi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := g.conn.WriteToUDP(b, dst)
+	return err
+}
+
+func (g *groupConn) Close() error {
+	return g.conn.Close()
+}
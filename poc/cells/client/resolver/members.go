@@ -0,0 +1,241 @@
+package resolver
+
+import (
+	"sort"
+	"time"
+)
+
+// MemberState is the state of a node in the membership of the cluster.
+type MemberState int
+
+// Backends without failure detection only have alive members, that leave
+// when they say goodbye and are dead when they expire. With swim, members
+// failing probes are suspect before they are declared dead.
+const (
+	Alive MemberState = iota
+	Suspect
+	Dead
+	Left
+)
+
+func (s MemberState) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	case Left:
+		return "left"
+	default:
+		return "unknown"
+	}
+}
+
+// Member is a node of the cluster, as seen by the local node.
+type Member struct {
+	Name string
+
+	// Addr is where the member was discovered: the address it gossips at
+	// with swim, the address of its services otherwise
+	Addr string
+
+	State MemberState
+
+	// Incarnation orders the updates of the member, with swim
+	Incarnation uint64
+
+	// Node is the last record of the member, nil until known
+	Node *Node
+
+	// Since is when the member entered its state
+	Since time.Time
+
+	// Seen is when the node was last announced or confirmed alive
+	Seen time.Time
+}
+
+// MemberEvent tells that a member joined, changed state or was updated.
+type MemberEvent struct {
+	Member Member
+
+	// Joined tells that the member was not known, Previous is its former
+	// state otherwise
+	Joined   bool
+	Previous MemberState
+}
+
+// Members returns the members discovered so far, sorted by name.
+func Members() []Member {
+	return registry.Members()
+}
+
+// WatchMembers calls fn for every change of the members discovered, see
+// Registry.WatchMembers.
+func WatchMembers(fn func(MemberEvent)) (cancel func()) {
+	return registry.WatchMembers(fn)
+}
+
+// Members returns the members of the registry, sorted by name. Members are
+// kept for as long as their node is: dead members are only returned until
+// they are removed.
+func (r *Registry) Members() []Member {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	members := make([]Member, 0, len(r.members))
+	for _, m := range r.members {
+		c := *m
+		for _, e := range r.hosts[m.Name] {
+			if e.seen.After(c.Seen) {
+				c.Seen = e.seen
+			}
+		}
+		members = append(members, c)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+
+	return members
+}
+
+// WatchMembers calls fn for every member that joins, changes state or is
+// updated, starting with the current members. fn is called from a single
+// goroutine at a time and must not call WatchMembers. The returned function
+// stops the notifications.
+func (r *Registry) WatchMembers(fn func(MemberEvent)) (cancel func()) {
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+
+	r.mu.Lock()
+	id := r.nextID
+	r.nextID++
+	r.memberWatchers[id] = fn
+	r.mu.Unlock()
+
+	for _, m := range r.Members() {
+		fn(MemberEvent{Member: m, Joined: true})
+	}
+
+	return func() {
+		r.mu.Lock()
+		delete(r.memberWatchers, id)
+		r.mu.Unlock()
+	}
+}
+
+// SetMember records the state of m, as told by a gossip backend. The
+// endpoints of the member are then added and removed with AddNode and
+// Remove, which only update its record and drop it.
+func (r *Registry) SetMember(m Member) {
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+
+	r.mu.Lock()
+	e, ok := r.members[m.Name]
+	if !ok {
+		e = &Member{}
+		r.members[m.Name] = e
+	}
+
+	var events []MemberEvent
+	if !ok || e.State != m.State || e.Incarnation != m.Incarnation || e.Addr != m.Addr {
+		since := e.Since
+		if !ok || e.State != m.State {
+			since = r.now()
+		}
+		if m.Node == nil {
+			m.Node = e.Node
+		}
+		m.Since = since
+
+		events = append(events, MemberEvent{Member: m, Joined: !ok, Previous: e.State})
+		*e = m
+	}
+	r.mu.Unlock()
+
+	r.notifyMembers(events)
+}
+
+// addMember records the node of n, alive when it was not a member. r.wmu
+// must be held.
+func (r *Registry) addMember(n *Node) {
+	r.mu.Lock()
+	e, ok := r.members[n.Name]
+	if ok {
+		e.Node = n
+		r.mu.Unlock()
+		return
+	}
+
+	now := r.now()
+	e = &Member{Name: n.Name, Addr: n.Addr, State: Alive, Node: n, Since: now, Seen: now}
+	r.members[n.Name] = e
+	r.mu.Unlock()
+
+	r.notifyMembers([]MemberEvent{{Member: *e, Joined: true}})
+}
+
+// dropMembers forgets the members of the nodes removed, which are in state
+// unless they were known to be dead already. r.wmu must be held.
+func (r *Registry) dropMembers(names []string, state MemberState) {
+	var events []MemberEvent
+
+	r.mu.Lock()
+	for _, name := range names {
+		e, ok := r.members[name]
+		if !ok {
+			continue
+		}
+		delete(r.members, name)
+
+		if e.State != Dead && e.State != Left {
+			m := *e
+			m.State, m.Since = state, r.now()
+			events = append(events, MemberEvent{Member: m, Previous: e.State})
+		}
+	}
+	r.mu.Unlock()
+
+	r.notifyMembers(events)
+}
+
+// notifyMembers calls the member watchers with events. r.wmu must be held.
+func (r *Registry) notifyMembers(events []MemberEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	r.mu.RLock()
+	var fns []func(MemberEvent)
+	for _, fn := range r.memberWatchers {
+		fns = append(fns, fn)
+	}
+	r.mu.RUnlock()
+
+	for _, ev := range events {
+		for _, fn := range fns {
+			fn(ev)
+		}
+	}
+}
+
+// suspected reports whether the node serving at addr is suspected to have
+// failed.
+func (r *Registry) suspected(addr string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, entries := range r.endpoints {
+		for _, e := range entries {
+			if e.addr != addr {
+				continue
+			}
+
+			m, ok := r.members[e.node]
+			return ok && m.State == Suspect
+		}
+	}
+
+	return false
+}
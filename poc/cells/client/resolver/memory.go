@@ -0,0 +1,89 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Memory is a discovery backend keeping the nodes announced in memory, for
+// tests: the nodes announced to a Memory are discovered by every registry
+// watching it, in the same process.
+type Memory struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+
+	// changed is closed and replaced when nodes change
+	changed chan struct{}
+}
+
+// NewMemory returns an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{nodes: make(map[string]*Node), changed: make(chan struct{})}
+}
+
+func (m *Memory) Watch(ctx context.Context, r *Registry) error {
+	added := make(map[string]*Node)
+
+	for {
+		m.mu.Lock()
+		nodes := make(map[string]*Node, len(m.nodes))
+		for name, n := range m.nodes {
+			nodes[name] = n
+		}
+		changed := m.changed
+		m.mu.Unlock()
+
+		for name, n := range added {
+			if current, ok := nodes[name]; !ok || current.Addr != n.Addr {
+				host, _, _ := net.SplitHostPort(n.Addr)
+				r.Remove(name, host, []string{n.Addr})
+			}
+		}
+		for _, n := range nodes {
+			r.AddNode(n)
+		}
+		added = nodes
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		case <-time.After(refreshInterval):
+		}
+	}
+}
+
+// Announce adds n to the nodes, or replaces the node of that name.
+func (m *Memory) Announce(n *Node) error {
+	c := *n
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nodes[n.Name] = &c
+	m.notify()
+
+	return nil
+}
+
+// Leave removes the node of the name of n.
+func (m *Memory) Leave(n *Node) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.nodes, n.Name)
+	m.notify()
+
+	return nil
+}
+
+func (m *Memory) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+func (*Memory) Close() error {
+	return nil
+}
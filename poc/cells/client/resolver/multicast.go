@@ -0,0 +1,483 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// multicast announces nodes with UDP datagrams sent to a multicast group,
+// IPv4 or IPv6 such as [ff02::1]:9999, on the interfaces selected by
+// MulticastOptions. Networks that do not carry multicast traffic between the
+// nodes, most cloud networks for instance, need seeds: announcements are sent
+// to them by unicast as well, and they relay them to the nodes and clients
+// they heard from.
+//
+// Any host of the network can send announcements, which should be signed
+// when it is not trusted, see auth.
+type multicast struct {
+	addr *net.UDPAddr
+	v6   bool
+	auth *auth
+	opts MulticastOptions
+
+	mu   sync.Mutex
+	conn *groupConn
+
+	// name is the node announced last, hellos are signed under it
+	name string
+}
+
+// maxPeers bounds the peers seeds relay announcements to.
+const maxPeers = 256
+
+func newMulticast(address string, query url.Values) (*multicast, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	a, err := newAuth(query)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+
+	opts, err := parseMulticastOptions(query)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+
+	return &multicast{addr: addr, v6: addr.IP.To4() == nil, auth: a, opts: opts}, nil
+}
+
+func (m *multicast) network() string {
+	if m.v6 {
+		return "udp6"
+	}
+
+	return "udp4"
+}
+
+// interfaces returns the interfaces announcements are sent and received on,
+// none meaning the default multicast interface of the system.
+func (m *multicast) interfaces() ([]net.Interface, error) {
+	ifis, err := m.opts.interfaces(m.v6)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ifis) == 0 {
+		if len(m.opts.Interfaces) > 0 {
+			return nil, fmt.Errorf("no multicast interface matches %s", strings.Join(m.opts.Interfaces, ","))
+		}
+		if m.v6 && m.addr.IP.IsLinkLocalMulticast() {
+			return nil, fmt.Errorf("no interface to join %s on", m.addr.IP)
+		}
+	}
+
+	return ifis, nil
+}
+
+// check joins the group on the selected interfaces, which fails on hosts
+// without multicast route.
+func (m *multicast) check() error {
+	ifis, err := m.interfaces()
+	if err != nil {
+		return err
+	}
+
+	conn, err := listenGroup(m.addr, ifis)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// seeds returns the addresses of the seeds. Seeds that do not resolve are
+// skipped, they may come later.
+func (m *multicast) seeds() []*net.UDPAddr {
+	var addrs []*net.UDPAddr
+	for _, seed := range m.opts.Seeds {
+		host, port, _ := splitSeed(seed)
+		if port == "" {
+			port = strconv.Itoa(m.addr.Port)
+		}
+
+		addr, err := net.ResolveUDPAddr(m.network(), net.JoinHostPort(host, port))
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+// listener is what Watch keeps about the datagrams it receives.
+type listener struct {
+	conn  *groupConn
+	frags *reassembler
+
+	// allowed are the indexes of the interfaces multicast datagrams are
+	// accepted from, any when nil
+	allowed map[int]bool
+
+	// peers are the nodes and clients that sent datagrams by unicast, to
+	// relay the announcements received by unicast to
+	peers map[string]*peer
+}
+
+type peer struct {
+	addr *net.UDPAddr
+	seen time.Time
+}
+
+func (m *multicast) Watch(ctx context.Context, r *Registry) error {
+	ifis, err := m.interfaces()
+	if err != nil {
+		return err
+	}
+
+	conn, err := listenGroup(m.addr, ifis)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	l := &listener{conn: conn, frags: newReassembler(), peers: make(map[string]*peer)}
+	if len(ifis) > 0 {
+		l.allowed = make(map[int]bool, len(ifis))
+		for _, ifi := range ifis {
+			l.allowed[ifi.Index] = true
+		}
+	}
+
+	if len(m.opts.Seeds) > 0 {
+		go m.hello(ctx, conn)
+	}
+
+	var failed string
+
+	b := make([]byte, maxDatagramSize)
+	for {
+		n, ifindex, src, err := conn.read(b)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		err = m.handle(r, l, src, ifindex, b[:n])
+
+		// Announcements received both by multicast and through a seed
+		// are seen twice
+		if err != nil && err != errReplayed {
+			// Rejected announcements keep coming, only log changes
+			if msg := udpHost(src) + ": " + err.Error(); msg != failed {
+				log.Printf("discovery: %s", msg)
+				failed = msg
+			}
+		}
+	}
+}
+
+// hello asks the seeds to relay announcements to conn until ctx is done.
+// Hellos are signed like announcements, so that seeds only relay to
+// authentic peers.
+func (m *multicast) hello(ctx context.Context, conn *groupConn) {
+	var failed bool
+
+	for {
+		data, err := m.helloData()
+		switch {
+		case err != nil && !failed:
+			log.Printf("discovery: hello: %v", err)
+			failed = true
+		case err == nil:
+			failed = false
+			for _, seed := range m.seeds() {
+				conn.write(data, nil, seed)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(refreshInterval):
+		}
+	}
+}
+
+func (m *multicast) helloData() ([]byte, error) {
+	req := &Request{Request: &Request_Hello{Hello: &Hello{}}}
+
+	if m.auth != nil {
+		m.mu.Lock()
+		name := m.name
+		m.mu.Unlock()
+
+		var err error
+		if req, err = m.auth.sign(name, req); err != nil {
+			return nil, err
+		}
+	}
+	req.Route = Request_UNICAST
+
+	return proto.Marshal(req)
+}
+
+// handle adds what the datagram data received from src on the interface of
+// index ifindex announces to r.
+func (m *multicast) handle(r *Registry, l *listener, src *net.UDPAddr, ifindex int, data []byte) error {
+	req := &Request{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil
+	}
+
+	route := req.Route
+	if route == Request_MULTICAST && l.allowed != nil && ifindex != 0 && !l.allowed[ifindex] {
+		return nil
+	}
+
+	if v, ok := req.Request.(*Request_Fragment); ok {
+		data, err := l.frags.add(src.String(), v.Fragment)
+		if err != nil || data == nil {
+			return err
+		}
+
+		req = &Request{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			return err
+		}
+		if _, ok := req.Request.(*Request_Fragment); ok {
+			return errors.New("nested fragments")
+		}
+	}
+
+	// Only the senders of authentic requests are learnt, and only those
+	// requests are relayed
+	sealed := req
+	req, err := m.open(req)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := req.Request.(*Request_Hello); ok {
+		if route == Request_UNICAST {
+			m.learn(l, src)
+		}
+		return nil
+	}
+
+	if route == Request_UNICAST {
+		m.learn(l, src)
+		m.relay(l, src, sealed)
+	}
+
+	host := udpHost(src)
+
+	switch v := req.Request.(type) {
+	case *Request_Node:
+		n, err := nodeFromRecord(v.Node, host)
+		if err != nil {
+			return err
+		}
+		r.AddNode(n)
+	case *Request_Service:
+		_, port, _ := net.SplitHostPort(v.Service.Addr)
+		r.AddEndpoint(v.Service.Name, net.JoinHostPort(host, port), v.Service.Node)
+	case *Request_Dns:
+		r.AddHost(v.Dns.Name, host)
+		r.SetLabels(v.Dns.Name, v.Dns.Labels)
+	case *Request_Goodbye:
+		// Nodes behind NAT leave with the address they advertised
+		var addrs []string
+		for _, a := range v.Goodbye.Addrs {
+			h, port, _ := net.SplitHostPort(a)
+			addrs = append(addrs, net.JoinHostPort(host, port))
+			if h != "" && h != host {
+				r.Remove(v.Goodbye.Name, h, []string{a})
+			}
+		}
+
+		r.Remove(v.Goodbye.Name, host, addrs)
+	}
+
+	return nil
+}
+
+// learn adds the sender of a unicast datagram to the peers. Peers listen on
+// the port of the group.
+func (m *multicast) learn(l *listener, src *net.UDPAddr) {
+	now := time.Now()
+
+	for k, p := range l.peers {
+		if now.Sub(p.seen) > DefaultTTL {
+			delete(l.peers, k)
+		}
+	}
+
+	addr := &net.UDPAddr{IP: src.IP, Port: m.addr.Port, Zone: src.Zone}
+	if p, ok := l.peers[addr.String()]; ok {
+		p.seen = now
+		return
+	}
+	if len(l.peers) < maxPeers {
+		l.peers[addr.String()] = &peer{addr: addr, seen: now}
+	}
+}
+
+// relay forwards a request received by unicast from src to the other peers,
+// as it was signed and fragmented again. Relayed requests are not relayed
+// again.
+func (m *multicast) relay(l *listener, src *net.UDPAddr, req *Request) {
+	req.Route = Request_RELAYED
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return
+	}
+
+	parts, err := fragment(data, Request_RELAYED)
+	if err != nil {
+		return
+	}
+
+	for _, p := range l.peers {
+		if !p.addr.IP.Equal(src.IP) {
+			for _, part := range parts {
+				l.conn.write(part, nil, p.addr)
+			}
+		}
+	}
+}
+
+// open returns the request carried by req. Without auth, signed requests are
+// accepted as they are.
+func (m *multicast) open(req *Request) (*Request, error) {
+	if m.auth != nil {
+		return m.auth.verify(req)
+	}
+
+	if v, ok := req.Request.(*Request_Signed); ok {
+		return unwrap(v.Signed)
+	}
+
+	return req, nil
+}
+
+// send sends the request built by req for the host announced on every
+// interface to the group, and to the seeds with the host of the node.
+func (m *multicast) send(n *Node, req func(host string) *Request) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.name = n.Name
+
+	if m.conn == nil {
+		c, err := net.ListenUDP(m.network(), nil)
+		if err != nil {
+			return err
+		}
+		m.conn = newGroupConn(c, m.v6)
+	}
+
+	ifis, err := m.interfaces()
+	if err != nil {
+		return err
+	}
+
+	if len(ifis) == 0 {
+		err = m.write(n.Name, req(""), Request_MULTICAST, nil, m.addr)
+	}
+	for i := range ifis {
+		host, _ := interfaceHost(&ifis[i], m.v6)
+		if e := m.write(n.Name, req(host), Request_MULTICAST, &ifis[i], m.addr); e != nil && err == nil {
+			err = fmt.Errorf("%s: %v", ifis[i].Name, e)
+		}
+	}
+
+	// Relayed announcements come from the seeds, they carry the host
+	host, _, _ := net.SplitHostPort(n.Addr)
+	for _, seed := range m.seeds() {
+		if e := m.write(n.Name, req(host), Request_UNICAST, nil, seed); e != nil && err == nil {
+			err = fmt.Errorf("seed %s: %v", seed, e)
+		}
+	}
+
+	return err
+}
+
+// write signs req for node, and sends it to dst along route, through ifi
+// when not nil.
+func (m *multicast) write(node string, req *Request, route Request_Route, ifi *net.Interface, dst *net.UDPAddr) error {
+	if m.auth != nil {
+		var err error
+		if req, err = m.auth.sign(node, req); err != nil {
+			return err
+		}
+	}
+	req.Route = route
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	parts, err := fragment(data, route)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		if err := m.conn.write(part, ifi, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Announce sends the record of the node, fragmented if it does not fit in a
+// datagram.
+func (m *multicast) Announce(n *Node) error {
+	return m.send(n, n.record)
+}
+
+func (m *multicast) Leave(n *Node) error {
+	return m.send(n, func(host string) *Request {
+		return NewGoodbye(n.Name, n.announcedAddr(host))
+	})
+}
+
+func (m *multicast) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn == nil {
+		return nil
+	}
+
+	err := m.conn.Close()
+	m.conn = nil
+
+	return err
+}
@@ -0,0 +1,245 @@
+package resolver
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/target"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Labels derived from the node records, unless the nodes set them.
+const (
+	VersionLabel = "version"
+	LoadLabel    = "load"
+)
+
+// labels returns the labels clients select n by: its own, the names of its
+// exports, its version and its load.
+func (n *Node) labels() map[string]string {
+	labels := make(map[string]string, len(n.Labels)+3)
+
+	if len(n.Exports) > 0 {
+		var names []string
+		for _, e := range n.Exports {
+			names = append(names, e.Name)
+		}
+		sort.Strings(names)
+		labels[target.ExportKey] = strings.Join(names, ",")
+	}
+	if n.Version != "" {
+		labels[VersionLabel] = n.Version
+	}
+	if n.ID != "" || n.Version != "" || len(n.Exports) > 0 {
+		// Only nodes sending records report their load
+		labels[LoadLabel] = strconv.FormatUint(n.Load, 10)
+	}
+
+	for k, v := range n.Labels {
+		labels[k] = v
+	}
+
+	return labels
+}
+
+// announcedAddr is the address announcements sent with host carry: Addr
+// when advertised, its port on host otherwise. An empty host tells peers to
+// use the address the datagrams come from, see Node.
+func (n *Node) announcedAddr(host string) string {
+	if n.Advertised {
+		return n.Addr
+	}
+
+	_, port, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return n.Addr
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// record returns the announcement of n, with the services at host.
+func (n *Node) record(host string) *Request {
+	addr := n.announcedAddr(host)
+
+	rec := &NodeRecord{
+		ID:             n.ID,
+		Name:           n.Name,
+		Exports:        n.Exports,
+		Version:        n.Version,
+		Load:           n.Load,
+		Labels:         n.Labels,
+		TLSFingerprint: n.TLSFingerprint,
+	}
+	for _, service := range n.Services {
+		rec.Services = append(rec.Services, &Service{Name: service, Addr: addr, Node: n.Name})
+	}
+
+	return &Request{Request: &Request_Node{Node: rec}}
+}
+
+// nodeFromRecord returns the node described by rec, received from host.
+// Services are expected to share an address, the first one is used.
+func nodeFromRecord(rec *NodeRecord, host string) (*Node, error) {
+	if rec.Name == "" || len(rec.Services) == 0 {
+		return nil, errors.New("node record without name or services")
+	}
+
+	h, port, err := net.SplitHostPort(rec.Services[0].Addr)
+	if err != nil {
+		return nil, err
+	}
+	if h == "" {
+		h = host
+	}
+
+	n := &Node{
+		ID:             rec.ID,
+		Name:           rec.Name,
+		Addr:           net.JoinHostPort(h, port),
+		Advertised:     h != host,
+		Labels:         rec.Labels,
+		Exports:        rec.Exports,
+		Version:        rec.Version,
+		Load:           rec.Load,
+		TLSFingerprint: rec.TLSFingerprint,
+	}
+	for _, s := range rec.Services {
+		n.Services = append(n.Services, s.Name)
+	}
+
+	return n, nil
+}
+
+// Fragments are sent for the requests larger than maxFragmentSize, leaving
+// room for the fields of Fragment in the datagram. A request is made of at
+// most maxFragments.
+const (
+	maxFragmentSize = maxDatagramSize - 64
+	maxFragments    = 16
+
+	// fragmentTimeout is how long the fragments of a request are kept
+	// waiting for the others
+	fragmentTimeout = 2 * time.Second
+
+	// maxPending bounds the requests being reassembled
+	maxPending = 64
+)
+
+// fragment splits data, a marshalled request, into requests that fit in a
+// datagram, sent along route. Small requests are returned as they are.
+func fragment(data []byte, route Request_Route) ([][]byte, error) {
+	if len(data) <= maxFragmentSize {
+		return [][]byte{data}, nil
+	}
+
+	count := (len(data) + maxFragmentSize - 1) / maxFragmentSize
+	if count > maxFragments {
+		return nil, errors.New("announcement too large")
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	parts := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * maxFragmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		b, err := proto.Marshal(&Request{Request: &Request_Fragment{Fragment: &Fragment{
+			ID:    binary.BigEndian.Uint64(id[:]),
+			Index: uint32(i),
+			Count: uint32(count),
+			Data:  data[i*maxFragmentSize : end],
+		}}, Route: route})
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, b)
+	}
+
+	return parts, nil
+}
+
+// reassembler puts fragmented requests back together.
+type reassembler struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	pending map[pendingKey]*pending
+}
+
+type pendingKey struct {
+	src string
+	id  uint64
+}
+
+type pending struct {
+	parts    [][]byte
+	received int
+	started  time.Time
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{now: time.Now, pending: make(map[pendingKey]*pending)}
+}
+
+// add adds a fragment received from src, and returns the marshalled request
+// once all its fragments were received.
+func (r *reassembler) add(src string, f *Fragment) ([]byte, error) {
+	if f.Count < 2 || f.Count > maxFragments || f.Index >= f.Count {
+		return nil, errors.New("invalid fragment")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for k, p := range r.pending {
+		if now.Sub(p.started) > fragmentTimeout {
+			delete(r.pending, k)
+		}
+	}
+
+	k := pendingKey{src: src, id: f.ID}
+	p, ok := r.pending[k]
+	if !ok {
+		if len(r.pending) >= maxPending {
+			return nil, errors.New("too many fragmented announcements")
+		}
+
+		p = &pending{parts: make([][]byte, f.Count), started: now}
+		r.pending[k] = p
+	}
+
+	if int(f.Count) != len(p.parts) {
+		delete(r.pending, k)
+		return nil, errors.New("invalid fragment")
+	}
+	if p.parts[f.Index] == nil {
+		p.parts[f.Index] = f.Data
+		p.received++
+	}
+	if p.received < len(p.parts) {
+		return nil, nil
+	}
+
+	delete(r.pending, k)
+
+	var data []byte
+	for _, part := range p.parts {
+		data = append(data, part...)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,87 @@
+package resolver
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+func TestFragments(t *testing.T) {
+	n := &Node{
+		ID:       "id1",
+		Name:     "node1",
+		Addr:     "10.0.0.1:1000",
+		Services: []string{"index.FS"},
+		Labels:   map[string]string{"description": strings.Repeat("x", 3*maxDatagramSize)},
+	}
+
+	data, err := proto.Marshal(n.record(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts, err := fragment(data, Request_UNICAST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 4 {
+		t.Fatalf("expected 4 fragments, got %d", len(parts))
+	}
+
+	r := newReassembler()
+
+	// Fragments arrive in any order, and may be repeated
+	var got []byte
+	for _, i := range []int{3, 1, 1, 0, 2} {
+		if len(parts[i]) > maxDatagramSize {
+			t.Fatalf("fragment %d is %d bytes long", i, len(parts[i]))
+		}
+
+		req := &Request{}
+		if err := proto.Unmarshal(parts[i], req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Route != Request_UNICAST {
+			t.Fatalf("fragment %d sent along %v", i, req.Route)
+		}
+
+		b, err := r.add("10.0.0.1:9999", req.GetFragment())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b != nil && got != nil {
+			t.Fatal("request reassembled twice")
+		}
+		if b != nil {
+			got = b
+		}
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatal("reassembled request differs")
+	}
+	if len(r.pending) != 0 {
+		t.Errorf("expected no pending request, got %d", len(r.pending))
+	}
+
+	req := &Request{}
+	if err := proto.Unmarshal(got, req); err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := nodeFromRecord(req.GetNode(), "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Addr != "10.0.0.1:1000" || node.Advertised || node.ID != "id1" {
+		t.Errorf("unexpected node %+v", node)
+	}
+
+	// Small requests are not fragmented
+	parts, _ = fragment([]byte("small"), Request_MULTICAST)
+	if len(parts) != 1 || string(parts[0]) != "small" {
+		t.Errorf("unexpected fragments %q", parts)
+	}
+}
@@ -0,0 +1,464 @@
+package resolver
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long endpoints and hosts are kept after they were last
+// announced. Nodes announce themselves every second by default.
+const DefaultTTL = 10 * time.Second
+
+// Registry holds the endpoints of the services, and the addresses and labels
+// of the nodes discovered so far. Entries that are not announced again
+// within the TTL are evicted. It is safe for concurrent use.
+type Registry struct {
+	ttl time.Duration
+	now func() time.Time
+
+	// wmu serializes changes with the notifications they trigger, so that
+	// watchers see the changes in order
+	wmu sync.Mutex
+
+	mu        sync.RWMutex
+	endpoints map[string][]entry
+	hosts     map[string][]entry
+	labels    map[string]map[string]string
+	records   map[string]*Node
+	watchers  map[int]*watcher
+	nextID    int
+
+	members        map[string]*Member
+	memberWatchers map[int]func(MemberEvent)
+}
+
+// entry is an address, the last time it was announced, and the node it
+// belongs to when known.
+type entry struct {
+	addr string
+	seen time.Time
+	node string
+}
+
+// Endpoint is an address a service is served at, with the node serving it
+// and the labels of the node. Node is empty when unknown.
+type Endpoint struct {
+	Addr   string
+	Node   string
+	Labels map[string]string
+}
+
+type watcher struct {
+	service string
+	fn      func([]Endpoint)
+}
+
+// NewRegistry returns an empty registry evicting entries after ttl.
+func NewRegistry(ttl time.Duration) *Registry {
+	return &Registry{
+		ttl:       ttl,
+		now:       time.Now,
+		endpoints: make(map[string][]entry),
+		hosts:     make(map[string][]entry),
+		labels:    make(map[string]map[string]string),
+		records:   make(map[string]*Node),
+		watchers:  make(map[int]*watcher),
+
+		members:        make(map[string]*Member),
+		memberWatchers: make(map[int]func(MemberEvent)),
+	}
+}
+
+// AddEndpoint records that service is served at addr by node, which may be
+// empty for nodes that do not tell. Watchers of service are notified if addr
+// is new.
+func (r *Registry) AddEndpoint(service, addr, node string) {
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+
+	r.mu.Lock()
+	entries, e, added := seen(r.endpoints[service], addr, r.now())
+	moved := !added && e.node != node
+	e.node = node
+	r.endpoints[service] = entries
+	r.mu.Unlock()
+
+	if added || moved {
+		r.notify(service)
+	}
+}
+
+// AddHost records that the node name is reachable at ip.
+func (r *Registry) AddHost(name, ip string) {
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+
+	r.mu.Lock()
+	r.hosts[name], _, _ = seen(r.hosts[name], ip, r.now())
+	r.mu.Unlock()
+}
+
+// SetLabels records the labels of the node name. Watchers are notified if
+// they changed.
+func (r *Registry) SetLabels(name string, labels map[string]string) {
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+
+	r.mu.Lock()
+	changed := !equalLabels(r.labels[name], labels)
+	if changed {
+		r.labels[name] = labels
+	}
+
+	var services []string
+	if changed {
+		for service := range r.endpoints {
+			services = append(services, service)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, service := range services {
+		r.notify(service)
+	}
+}
+
+// AddNode records everything n announces: its host, its labels and the
+// endpoints of its services, all at n.Addr.
+func (r *Registry) AddNode(n *Node) {
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil || n.Name == "" {
+		return
+	}
+
+	r.AddHost(n.Name, host)
+	r.SetLabels(n.Name, n.labels())
+	for _, service := range n.Services {
+		r.AddEndpoint(service, n.Addr, n.Name)
+	}
+
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+
+	r.mu.Lock()
+	r.records[n.Name] = n
+	r.mu.Unlock()
+
+	r.addMember(n)
+}
+
+// Node returns the last record announced by the node of that name, or nil
+// for nodes that only announce their services. The record must not be
+// modified.
+func (r *Registry) Node(name string) *Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.records[name]
+}
+
+func equalLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+
+	return true
+}
+
+// seen marks addr as announced at now, appending it to entries if needed,
+// and returns its entry.
+func seen(entries []entry, addr string, now time.Time) ([]entry, *entry, bool) {
+	for i := range entries {
+		if entries[i].addr == addr {
+			entries[i].seen = now
+			return entries, &entries[i], false
+		}
+	}
+
+	entries = append(entries, entry{addr: addr, seen: now})
+
+	return entries, &entries[len(entries)-1], true
+}
+
+// Remove forgets the node name at ip, and the endpoints at addrs. Watchers
+// of the services losing endpoints are notified.
+func (r *Registry) Remove(name, ip string, addrs []string) {
+	leaving := make(map[string]bool)
+	for _, a := range addrs {
+		leaving[a] = true
+	}
+
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+
+	r.mu.Lock()
+	filter(r.hosts, name, func(e entry) bool { return e.addr != ip })
+	dropped := r.dropNodes()
+
+	var changed []string
+	for service := range r.endpoints {
+		if filter(r.endpoints, service, func(e entry) bool { return !leaving[e.addr] }) {
+			changed = append(changed, service)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, service := range changed {
+		r.notify(service)
+	}
+	r.dropMembers(dropped, Left)
+}
+
+// Expire evicts the entries not announced within the TTL, and notifies the
+// watchers of the services losing endpoints.
+func (r *Registry) Expire() {
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+
+	deadline := r.now().Add(-r.ttl)
+	fresh := func(e entry) bool { return e.seen.After(deadline) }
+
+	r.mu.Lock()
+	for name := range r.hosts {
+		filter(r.hosts, name, fresh)
+	}
+	dropped := r.dropNodes()
+
+	var changed []string
+	for service := range r.endpoints {
+		if filter(r.endpoints, service, fresh) {
+			changed = append(changed, service)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, service := range changed {
+		r.notify(service)
+	}
+	r.dropMembers(dropped, Dead)
+}
+
+// dropNodes forgets the labels and records of the nodes without hosts left,
+// and returns the names of the members among them. r.mu must be held.
+func (r *Registry) dropNodes() []string {
+	for name := range r.labels {
+		if _, ok := r.hosts[name]; !ok {
+			delete(r.labels, name)
+		}
+	}
+	for name := range r.records {
+		if _, ok := r.hosts[name]; !ok {
+			delete(r.records, name)
+		}
+	}
+
+	var dropped []string
+	for name := range r.members {
+		if _, ok := r.hosts[name]; !ok {
+			dropped = append(dropped, name)
+		}
+	}
+
+	return dropped
+}
+
+// filter keeps the entries of m[key] for which keep returns true, deleting
+// the key when none is left. It reports whether any entry was removed.
+func filter(m map[string][]entry, key string, keep func(entry) bool) bool {
+	entries := m[key]
+
+	var kept []entry
+	for _, e := range entries {
+		if keep(e) {
+			kept = append(kept, e)
+		}
+	}
+
+	if len(kept) == len(entries) {
+		return false
+	}
+
+	if len(kept) > 0 {
+		m[key] = kept
+	} else {
+		delete(m, key)
+	}
+
+	return true
+}
+
+// run expires entries every interval until done is closed.
+func (r *Registry) run(interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			r.Expire()
+		}
+	}
+}
+
+// Endpoints returns the addresses of service, in the order they were
+// discovered.
+func (r *Registry) Endpoints(service string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return addrs(r.endpoints[service])
+}
+
+// Hosts returns the IPs the node name announced itself from.
+func (r *Registry) Hosts(name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return addrs(r.hosts[name])
+}
+
+// Nodes returns the names of the nodes known to the registry, sorted.
+func (r *Registry) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var names []string
+	for name := range r.hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func addrs(entries []entry) []string {
+	var a []string
+	for _, e := range entries {
+		a = append(a, e.addr)
+	}
+
+	return a
+}
+
+// Watch calls fn with the endpoints of service every time they are added or
+// removed, or the labels of the nodes change, starting with the current ones
+// if there are any. fn is called from a single goroutine at a time and must
+// not call Watch. The returned function stops the notifications: fn is not
+// called anymore once it returns, so it must not be called from fn.
+func (r *Registry) Watch(service string, fn func([]Endpoint)) (cancel func()) {
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+
+	r.mu.Lock()
+	id := r.nextID
+	r.nextID++
+	r.watchers[id] = &watcher{service: service, fn: fn}
+	current := r.resolve(service)
+	r.mu.Unlock()
+
+	if len(current) > 0 {
+		fn(current)
+	}
+
+	return func() {
+		r.wmu.Lock()
+		defer r.wmu.Unlock()
+
+		r.mu.Lock()
+		delete(r.watchers, id)
+		r.mu.Unlock()
+	}
+}
+
+// resolve returns the endpoints of service with their nodes. The node of
+// the endpoints that were announced without one is found from their host.
+// r.mu must be held.
+func (r *Registry) resolve(service string) []Endpoint {
+	var eps []Endpoint
+	for _, e := range r.endpoints[service] {
+		node := e.node
+		if node == "" {
+			node = r.nodeAt(e.addr)
+		}
+
+		eps = append(eps, Endpoint{Addr: e.addr, Node: node, Labels: r.labels[node]})
+	}
+
+	return eps
+}
+
+// labelsOf returns the labels of the node serving at addr, if known.
+func (r *Registry) labelsOf(addr string) map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, entries := range r.endpoints {
+		for _, e := range entries {
+			if e.addr != addr {
+				continue
+			}
+
+			node := e.node
+			if node == "" {
+				node = r.nodeAt(addr)
+			}
+
+			return r.labels[node]
+		}
+	}
+
+	return nil
+}
+
+// nodeAt returns the node at the host of addr, or an empty name when there
+// is none or when several nodes share the host.
+func (r *Registry) nodeAt(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+
+	var node string
+	for name, entries := range r.hosts {
+		for _, e := range entries {
+			if e.addr != host {
+				continue
+			}
+			if node != "" {
+				return ""
+			}
+			node = name
+		}
+	}
+
+	return node
+}
+
+// notify calls the watchers of service with its endpoints. r.wmu must be
+// held.
+func (r *Registry) notify(service string) {
+	r.mu.RLock()
+	current := r.resolve(service)
+
+	var fns []func([]Endpoint)
+	for _, w := range r.watchers {
+		if w.service == service {
+			fns = append(fns, w.fn)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(current)
+	}
+}
@@ -0,0 +1,188 @@
+package resolver
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistryExpire(t *testing.T) {
+	now := time.Unix(0, 0)
+
+	r := NewRegistry(10 * time.Second)
+	r.now = func() time.Time { return now }
+
+	var got [][]string
+	cancel := r.Watch("index.FS", func(eps []Endpoint) {
+		got = append(got, endpointAddrs(eps))
+	})
+
+	r.AddHost("node1", "10.0.0.1")
+	r.AddEndpoint("index.FS", "10.0.0.1:1000", "")
+	now = now.Add(5 * time.Second)
+	r.AddEndpoint("index.FS", "10.0.0.2:1000", "")
+
+	// Announcing again refreshes without notifying
+	now = now.Add(5 * time.Second)
+	r.AddEndpoint("index.FS", "10.0.0.2:1000", "")
+
+	now = now.Add(time.Second)
+	r.Expire()
+
+	if eps := r.Endpoints("index.FS"); len(eps) != 1 || eps[0] != "10.0.0.2:1000" {
+		t.Fatalf("expected the first endpoint to expire, got %v", eps)
+	}
+	if nodes := r.Nodes(); len(nodes) != 0 {
+		t.Fatalf("expected node1 to expire, got %v", nodes)
+	}
+
+	expected := [][]string{
+		{"10.0.0.1:1000"},
+		{"10.0.0.1:1000", "10.0.0.2:1000"},
+		{"10.0.0.2:1000"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected notifications %v, got %v", expected, got)
+	}
+
+	// Watchers are not notified once cancelled
+	cancel()
+	r.Remove("node2", "10.0.0.2", []string{"10.0.0.2:1000"})
+
+	if len(got) != len(expected) {
+		t.Fatalf("unexpected notification after cancel: %v", got[len(got)-1])
+	}
+	if eps := r.Endpoints("index.FS"); len(eps) != 0 {
+		t.Fatalf("expected no endpoint left, got %v", eps)
+	}
+}
+
+func TestRegistryLabels(t *testing.T) {
+	r := NewRegistry(time.Minute)
+
+	var got []Endpoint
+	r.Watch("index.FS", func(eps []Endpoint) {
+		got = eps
+	})
+
+	// node2 shares the IP of node1 but names itself in its announcements
+	r.AddHost("node1", "10.0.0.1")
+	r.AddHost("node2", "10.0.0.1")
+	r.AddEndpoint("index.FS", "10.0.0.1:1000", "")
+	r.AddEndpoint("index.FS", "10.0.0.1:2000", "node2")
+	r.SetLabels("node2", map[string]string{"zone": "paris"})
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 endpoints, got %v", got)
+	}
+	// Without a node in its announcement, the endpoint could be either's
+	if got[0].Node != "" || got[0].Labels != nil {
+		t.Errorf("unexpected first endpoint %+v", got[0])
+	}
+	if got[1].Node != "node2" || got[1].Labels["zone"] != "paris" {
+		t.Errorf("unexpected second endpoint %+v", got[1])
+	}
+
+	// The labels of nodes that left are forgotten
+	r.Remove("node2", "10.0.0.1", []string{"10.0.0.1:2000"})
+	if _, ok := r.labels["node2"]; ok {
+		t.Errorf("expected the labels of node2 to be removed")
+	}
+}
+
+func endpointAddrs(eps []Endpoint) []string {
+	var s []string
+	for _, ep := range eps {
+		s = append(s, ep.Addr)
+	}
+
+	return s
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	r := NewRegistry(time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				addr := fmt.Sprintf("10.0.0.%d:%d", i, j)
+				r.AddHost(fmt.Sprint("node", i), "10.0.0.1")
+				r.AddEndpoint("index.FS", addr, "")
+				r.SetLabels(fmt.Sprint("node", i), map[string]string{"j": fmt.Sprint(j)})
+				cancel := r.Watch("index.FS", func([]Endpoint) {})
+				r.Endpoints("index.FS")
+				r.Nodes()
+				r.Expire()
+				r.Remove("node", "10.0.0.1", []string{addr})
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRegistryWatchCancel(t *testing.T) {
+	r := NewRegistry(time.Minute)
+
+	calls, release := make(chan struct{}, 2), make(chan struct{})
+	cancel := r.Watch("index.FS", func([]Endpoint) {
+		calls <- struct{}{}
+		<-release
+	})
+
+	go r.AddEndpoint("index.FS", "10.0.0.1:1234", "")
+	<-calls
+
+	// Canceling waits for the notification being delivered
+	canceled := make(chan struct{})
+	go func() {
+		cancel()
+		close(canceled)
+	}()
+	select {
+	case <-canceled:
+		t.Fatal("expected cancel to wait for the watcher")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-canceled
+
+	r.AddEndpoint("index.FS", "10.0.0.2:1234", "")
+	if len(calls) != 0 {
+		t.Error("expected no notification after cancel")
+	}
+}
+
+func TestRegistryAddNode(t *testing.T) {
+	r := NewRegistry(time.Minute)
+
+	r.AddNode(&Node{
+		ID:       "id1",
+		Name:     "node1",
+		Addr:     "10.0.0.1:1000",
+		Services: []string{"index.FS"},
+		Labels:   map[string]string{"zone": "paris"},
+		Exports:  []*Export{{Name: "photos"}, {Name: "archive"}},
+		Version:  "2.1",
+		Load:     3,
+	})
+
+	eps := r.Endpoints("index.FS")
+	if len(eps) != 1 || eps[0] != "10.0.0.1:1000" {
+		t.Fatalf("unexpected endpoints %v", eps)
+	}
+
+	expected := map[string]string{"zone": "paris", "export": "archive,photos", "version": "2.1", "load": "3"}
+	if labels := r.labels["node1"]; fmt.Sprint(labels) != fmt.Sprint(expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+	if n := r.Node("node1"); n == nil || n.ID != "id1" {
+		t.Errorf("unexpected record %+v", n)
+	}
+}
@@ -1,180 +1,351 @@
 package resolver
 
 import (
-	"bytes"
+	"context"
+	"fmt"
 	"log"
-	"net"
-	"strings"
+	"sync"
+	"time"
 
-	"github.com/gogo/protobuf/proto"
+	"github.com/ghecquet/tripr/poc/cells/target"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
 )
 
-var (
-	watchers  []func(string, []string)
-	dns       = make(map[string][]string)
-	endpoints = make(map[string][]string)
-)
+// registry holds what the discovery loop learnt from the announcements of
+// the nodes.
+var registry = NewRegistry(DefaultTTL)
+
+// Nodes returns the names of the nodes discovered so far.
+func Nodes() []string {
+	return registry.Nodes()
+}
 
+// The resolver and its balancers are registered with gRPC on import, nodes
+// are only discovered once Start is called.
 func init() {
 	resolver.Register(&cellsBuilder{})
-	watch()
+	registerBalancers()
 }
 
-func watch() {
-	addr, err := net.ResolveUDPAddr("udp", discoveryAddr)
+const (
+	discoveryAddr   = "224.0.0.1:9999"
+	maxDatagramSize = 8192
+
+	// expireInterval is how often entries past their TTL are evicted
+	expireInterval = time.Second
+)
+
+// Endpoints are only published once they answer health checks, which are
+// repeated every healthInterval.
+const (
+	healthInterval = 5 * time.Second
+	healthTimeout  = time.Second
+)
+
+type cellsBuilder struct{}
+
+// Build resolves targets such as cells:///index.FS?export=photos&zone=paris,
+// see the target package for their syntax. It does not wait for the
+// endpoints, the ClientConn is told when there are none yet.
+func (*cellsBuilder) Build(rt resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	t, err := target.Parse(rt.Endpoint)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	l, err := net.ListenMulticastUDP("udp", nil, addr)
-	l.SetReadBuffer(maxDatagramSize)
 
-	go func() {
-		for {
-			b := make([]byte, maxDatagramSize)
-			n, src, err := l.ReadFromUDP(b)
-			if err != nil {
-				log.Fatal("ReadFromUDP failed:", err)
-			}
+	lb, err := balancerName(t.Balance)
+	if err != nil {
+		return nil, &target.Error{Target: rt.Endpoint, Reason: err.Error()}
+	}
 
-			if n == 0 {
-				continue
-			}
+	sc := cc.ParseServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{%q: {}}]}`, lb))
+	if sc.Err != nil {
+		return nil, sc.Err
+	}
 
-			host, _, _ := net.SplitHostPort(src.String())
+	r := &cellsResolver{
+		target: t,
+		config: sc,
+		cc:     cc,
+		rn:     make(chan struct{}, 1),
+		dialOpts: []grpc.DialOption{
+			grpc.WithInsecure(),
+		},
+		probes:  make(map[string]*probe),
+		updated: make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
 
-			req := &Request{}
-			proto.Unmarshal(bytes.Trim(b, "\x00"), req)
+	if opts.DialCreds != nil {
+		r.dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(opts.DialCreds)}
+	}
 
-			switch v := req.Request.(type) {
-			case *Request_Service:
-				_, port, _ := net.SplitHostPort(v.Service.Addr)
-				addr := net.JoinHostPort(host, port)
-				service := v.Service.Name
+	r.cancel = registry.Watch(t.Service, r.update)
+	go r.run()
 
-				current, ok := endpoints[service]
-				if !ok {
-					endpoints[service] = []string{addr}
-					continue
-				}
+	return r, nil
+}
 
-				found := false
-				for _, c := range current {
-					if c == addr {
-						found = true
-						break
-					}
-				}
+func (*cellsBuilder) Scheme() string {
+	return target.Scheme
+}
 
-				if !found {
-					endpoints[service] = append(endpoints[service], addr)
-				}
+type cellsResolver struct {
+	target *target.Target
+	config *serviceconfig.ParseResult
+	cc     resolver.ClientConn
 
-				for _, watcher := range watchers {
-					watcher(service, endpoints[service])
-				}
-			case *Request_Dns:
-				name := v.Dns.Name
+	// rn asks for the endpoints to be checked again
+	rn chan struct{}
 
-				current, ok := dns[name]
-				if !ok {
-					dns[name] = []string{host}
-					continue
-				}
+	dialOpts []grpc.DialOption
+
+	mu         sync.Mutex
+	candidates []string
+
+	probes  map[string]*probe
+	updated chan struct{}
+	closed  chan struct{}
+	cancel  func()
+}
+
+// probe holds the connection used to health check an endpoint.
+type probe struct {
+	conn   *grpc.ClientConn
+	client healthpb.HealthClient
+}
+
+// update makes the endpoints of the service on the nodes selected by the
+// target the candidates to health check.
+func (r *cellsResolver) update(eps []Endpoint) {
+	var candidates []string
+	for _, ep := range eps {
+		if r.target.Node != "" && ep.Node != r.target.Node {
+			continue
+		}
+		if r.target.Match(ep.Labels) {
+			candidates = append(candidates, ep.Addr)
+		}
+	}
+
+	r.mu.Lock()
+	r.candidates = candidates
+	r.mu.Unlock()
+
+	select {
+	case r.updated <- struct{}{}:
+	default:
+	}
+}
+
+// run health checks the candidate endpoints when it starts, whenever they
+// change and at regular intervals, and publishes those that are serving.
+func (r *cellsResolver) run() {
+	t := time.NewTicker(healthInterval)
+	defer t.Stop()
+
+	var checked, published []string
 
-				found := false
-				for _, c := range current {
-					if c == host {
-						found = true
-						break
-					}
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-r.closed:
+				for _, p := range r.probes {
+					p.conn.Close()
 				}
+				return
+			case <-r.updated:
+				// Nodes announce themselves every second, only check
+				// again when the set of endpoints changes
+				r.mu.Lock()
+				same := checked != nil && equal(r.candidates, checked)
+				r.mu.Unlock()
 
-				if !found {
-					dns[name] = append(dns[name], host)
+				if same {
+					continue
 				}
+			case <-r.rn:
+			case <-t.C:
 			}
 		}
-	}()
-}
 
-const (
-	scheme          = "cells"
-	discoveryAddr   = "224.0.0.1:9999"
-	maxDatagramSize = 8192
-)
+		r.mu.Lock()
+		candidates := r.candidates
+		r.mu.Unlock()
 
-type cellsBuilder struct{}
+		checked = candidates
+		healthy := r.healthy(candidates)
 
-func (*cellsBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	r := &cellsResolver{
-		target: target,
-		cc:     cc,
-		rn:     make(chan struct{}, 1),
+		switch {
+		case len(healthy) == 0:
+			if published != nil {
+				// Connections to the former endpoints are closed
+				r.cc.UpdateState(resolver.State{Addresses: []resolver.Address{}, ServiceConfig: r.config})
+				published = nil
+			}
+
+			// Calls fail, or wait with WaitForReady, and gRPC asks
+			// again with ResolveNow
+			r.cc.ReportError(&NoEndpointsError{Target: r.target.String()})
+		case !equal(healthy, published):
+			addresses := []resolver.Address{}
+			for _, ep := range healthy {
+				addresses = append(addresses, resolver.Address{Addr: ep})
+			}
+
+			r.cc.UpdateState(resolver.State{Addresses: addresses, ServiceConfig: r.config})
+			published = healthy
+		}
 	}
-	go r.watch()
-	r.ResolveNow(resolver.ResolveNowOptions{})
-	return r, nil
 }
 
-func (*cellsBuilder) Scheme() string {
-	return scheme
+// NoEndpointsError is reported to the connections to targets without
+// serving endpoints, because no node was discovered yet or none of those
+// selected is healthy.
+type NoEndpointsError struct {
+	Target string
 }
 
-type cellsResolver struct {
-	target resolver.Target
-	cc     resolver.ClientConn
-	rn     chan struct{}
+func (e *NoEndpointsError) Error() string {
+	return fmt.Sprintf("%s: no endpoints yet", e.Target)
 }
 
-func (r *cellsResolver) watch() {
-	watchers = append(watchers, func(s string, eps []string) {
-		desc := strings.Split(r.target.Endpoint, "@")
-		all := (len(desc) == 1)
+// healthy returns the endpoints reporting SERVING for the checked service,
+// in the order they were given.
+func (r *cellsResolver) healthy(eps []string) []string {
+	keep := make(map[string]bool)
+	for _, ep := range eps {
+		keep[ep] = true
 
-		if (all && s == desc[0]) || s == desc[1] {
-			addresses := []resolver.Address{}
-			for _, ep := range eps {
-				if all {
-					addresses = append(addresses, resolver.Address{Addr: ep})
-				} else {
-					host, _, _ := net.SplitHostPort(ep)
-					for _, ip := range dns[desc[0]] {
-						if ip == host {
-							addresses = append(addresses, resolver.Address{Addr: ep})
-						}
-					}
-				}
-			}
+		if _, ok := r.probes[ep]; ok {
+			continue
+		}
+
+		conn, err := grpc.Dial(ep, r.dialOpts...)
+		if err != nil {
+			log.Printf("health check %s: %v", ep, err)
+			continue
+		}
+
+		r.probes[ep] = &probe{conn: conn, client: healthpb.NewHealthClient(conn)}
+	}
+
+	for ep, p := range r.probes {
+		if !keep[ep] {
+			p.conn.Close()
+			delete(r.probes, ep)
+		}
+	}
+
+	serving := make([]bool, len(eps))
 
-			r.cc.UpdateState(resolver.State{Addresses: addresses})
-			r.rn <- struct{}{}
+	var wg sync.WaitGroup
+	for i, ep := range eps {
+		p, ok := r.probes[ep]
+		if !ok {
+			continue
 		}
-	})
+
+		wg.Add(1)
+		go func(i int, p *probe) {
+			defer wg.Done()
+			serving[i] = r.serving(p)
+		}(i, p)
+	}
+	wg.Wait()
+
+	var healthy []string
+	for i, ep := range eps {
+		if serving[i] {
+			healthy = append(healthy, ep)
+		}
+	}
+
+	return healthy
+}
+
+func (r *cellsResolver) serving(p *probe) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+
+	resp, err := p.client.Check(ctx, &healthpb.HealthCheckRequest{Service: r.target.HealthService()})
+	if status.Code(err) == codes.Unimplemented {
+		// Nodes predating health checks are trusted as before
+		return true
+	}
+	if err != nil {
+		return false
+	}
+
+	return resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
 }
 
+// ResolveNow checks the endpoints again, without waiting for it.
 func (r *cellsResolver) ResolveNow(o resolver.ResolveNowOptions) {
-	<-r.rn
+	select {
+	case r.rn <- struct{}{}:
+	default:
+	}
 }
 
-func (*cellsResolver) Close() {}
+func (r *cellsResolver) Close() {
+	r.cancel()
+	close(r.closed)
+}
 
-func NewService(addr string, service string) *Request {
+// NewService returns the message announcing that the node serves service at
+// addr.
+func NewService(node, addr, service string) *Request {
 	return &Request{
 		Request: &Request_Service{
 			Service: &Service{
 				Addr: addr,
 				Name: service,
+				Node: node,
 			},
 		},
 	}
 }
 
-func NewDNS(name string) *Request {
+// NewDNS returns the message announcing the node and its labels.
+func NewDNS(name string, labels map[string]string) *Request {
 	return &Request{
 		Request: &Request_Dns{
 			Dns: &DNS{
-				Name: name,
+				Name:   name,
+				Labels: labels,
+			},
+		},
+	}
+}
+
+// NewGoodbye returns the message a node sends when it stops serving addrs.
+func NewGoodbye(name string, addrs ...string) *Request {
+	return &Request{
+		Request: &Request_Goodbye{
+			Goodbye: &Goodbye{
+				Name:  name,
+				Addrs: addrs,
 			},
 		},
 	}
@@ -20,11 +20,146 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+// Route tells how the datagram reached the node, see Hello
+type Request_Route int32
+
+const (
+	Request_MULTICAST Request_Route = 0
+	Request_UNICAST   Request_Route = 1
+	Request_RELAYED   Request_Route = 2
+)
+
+var Request_Route_name = map[int32]string{
+	0: "MULTICAST",
+	1: "UNICAST",
+	2: "RELAYED",
+}
+
+var Request_Route_value = map[string]int32{
+	"MULTICAST": 0,
+	"UNICAST":   1,
+	"RELAYED":   2,
+}
+
+func (x Request_Route) String() string {
+	return proto.EnumName(Request_Route_name, int32(x))
+}
+
+func (Request_Route) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{0, 0}
+}
+
+type Signed_Algorithm int32
+
+const (
+	Signed_NONE        Signed_Algorithm = 0
+	Signed_HMAC_SHA256 Signed_Algorithm = 1
+	Signed_ED25519     Signed_Algorithm = 2
+)
+
+var Signed_Algorithm_name = map[int32]string{
+	0: "NONE",
+	1: "HMAC_SHA256",
+	2: "ED25519",
+}
+
+var Signed_Algorithm_value = map[string]int32{
+	"NONE":        0,
+	"HMAC_SHA256": 1,
+	"ED25519":     2,
+}
+
+func (x Signed_Algorithm) String() string {
+	return proto.EnumName(Signed_Algorithm_name, int32(x))
+}
+
+func (Signed_Algorithm) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{5, 0}
+}
+
+type Swim_Type int32
+
+const (
+	// PING asks the member for an ACK with the same Seq
+	Swim_PING Swim_Type = 0
+	Swim_ACK  Swim_Type = 1
+	// PING_REQ asks the member to ping Target, the address of another
+	// member, and to forward its ACK
+	Swim_PING_REQ Swim_Type = 2
+	// SYNC carries the whole membership of the sender, which is
+	// answered with a SYNC_REPLY carrying the membership of the
+	// receiver. Nodes join the cluster with a SYNC to a seed.
+	Swim_SYNC       Swim_Type = 3
+	Swim_SYNC_REPLY Swim_Type = 4
+)
+
+var Swim_Type_name = map[int32]string{
+	0: "PING",
+	1: "ACK",
+	2: "PING_REQ",
+	3: "SYNC",
+	4: "SYNC_REPLY",
+}
+
+var Swim_Type_value = map[string]int32{
+	"PING":       0,
+	"ACK":        1,
+	"PING_REQ":   2,
+	"SYNC":       3,
+	"SYNC_REPLY": 4,
+}
+
+func (x Swim_Type) String() string {
+	return proto.EnumName(Swim_Type_name, int32(x))
+}
+
+func (Swim_Type) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{9, 0}
+}
+
+type MemberRecord_Status int32
+
+const (
+	MemberRecord_ALIVE   MemberRecord_Status = 0
+	MemberRecord_SUSPECT MemberRecord_Status = 1
+	MemberRecord_DEAD    MemberRecord_Status = 2
+	MemberRecord_LEFT    MemberRecord_Status = 3
+)
+
+var MemberRecord_Status_name = map[int32]string{
+	0: "ALIVE",
+	1: "SUSPECT",
+	2: "DEAD",
+	3: "LEFT",
+}
+
+var MemberRecord_Status_value = map[string]int32{
+	"ALIVE":   0,
+	"SUSPECT": 1,
+	"DEAD":    2,
+	"LEFT":    3,
+}
+
+func (x MemberRecord_Status) String() string {
+	return proto.EnumName(MemberRecord_Status_name, int32(x))
+}
+
+func (MemberRecord_Status) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{10, 0}
+}
+
 type Request struct {
 	// Types that are valid to be assigned to Request:
 	//	*Request_Service
 	//	*Request_Dns
+	//	*Request_Goodbye
+	//	*Request_Signed
+	//	*Request_Node
+	//	*Request_Fragment
+	//	*Request_Hello
+	//	*Request_Swim
 	Request              isRequest_Request `protobuf_oneof:"request"`
+	Route                Request_Route     `protobuf:"varint,8,opt,name=route,proto3,enum=resolver.Request_Route" json:"route,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
 	XXX_unrecognized     []byte            `json:"-"`
 	XXX_sizecache        int32             `json:"-"`
@@ -67,10 +202,46 @@ type Request_Dns struct {
 	Dns *DNS `protobuf:"bytes,2,opt,name=dns,proto3,oneof"`
 }
 
+type Request_Goodbye struct {
+	Goodbye *Goodbye `protobuf:"bytes,3,opt,name=goodbye,proto3,oneof"`
+}
+
+type Request_Signed struct {
+	Signed *Signed `protobuf:"bytes,4,opt,name=signed,proto3,oneof"`
+}
+
+type Request_Node struct {
+	Node *NodeRecord `protobuf:"bytes,5,opt,name=node,proto3,oneof"`
+}
+
+type Request_Fragment struct {
+	Fragment *Fragment `protobuf:"bytes,6,opt,name=fragment,proto3,oneof"`
+}
+
+type Request_Hello struct {
+	Hello *Hello `protobuf:"bytes,7,opt,name=hello,proto3,oneof"`
+}
+
+type Request_Swim struct {
+	Swim *Swim `protobuf:"bytes,9,opt,name=swim,proto3,oneof"`
+}
+
 func (*Request_Service) isRequest_Request() {}
 
 func (*Request_Dns) isRequest_Request() {}
 
+func (*Request_Goodbye) isRequest_Request() {}
+
+func (*Request_Signed) isRequest_Request() {}
+
+func (*Request_Node) isRequest_Request() {}
+
+func (*Request_Fragment) isRequest_Request() {}
+
+func (*Request_Hello) isRequest_Request() {}
+
+func (*Request_Swim) isRequest_Request() {}
+
 func (m *Request) GetRequest() isRequest_Request {
 	if m != nil {
 		return m.Request
@@ -92,17 +263,110 @@ func (m *Request) GetDns() *DNS {
 	return nil
 }
 
+func (m *Request) GetGoodbye() *Goodbye {
+	if x, ok := m.GetRequest().(*Request_Goodbye); ok {
+		return x.Goodbye
+	}
+	return nil
+}
+
+func (m *Request) GetSigned() *Signed {
+	if x, ok := m.GetRequest().(*Request_Signed); ok {
+		return x.Signed
+	}
+	return nil
+}
+
+func (m *Request) GetNode() *NodeRecord {
+	if x, ok := m.GetRequest().(*Request_Node); ok {
+		return x.Node
+	}
+	return nil
+}
+
+func (m *Request) GetFragment() *Fragment {
+	if x, ok := m.GetRequest().(*Request_Fragment); ok {
+		return x.Fragment
+	}
+	return nil
+}
+
+func (m *Request) GetHello() *Hello {
+	if x, ok := m.GetRequest().(*Request_Hello); ok {
+		return x.Hello
+	}
+	return nil
+}
+
+func (m *Request) GetSwim() *Swim {
+	if x, ok := m.GetRequest().(*Request_Swim); ok {
+		return x.Swim
+	}
+	return nil
+}
+
+func (m *Request) GetRoute() Request_Route {
+	if m != nil {
+		return m.Route
+	}
+	return Request_MULTICAST
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*Request) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
 		(*Request_Service)(nil),
 		(*Request_Dns)(nil),
+		(*Request_Goodbye)(nil),
+		(*Request_Signed)(nil),
+		(*Request_Node)(nil),
+		(*Request_Fragment)(nil),
+		(*Request_Hello)(nil),
+		(*Request_Swim)(nil),
 	}
 }
 
+// Hello is sent by unicast to the seeds by the nodes that do not announce
+// themselves, so that the seeds relay the announcements they receive to
+// them. Nodes announcing themselves to the seeds receive them as well.
+type Hello struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Hello) Reset()         { *m = Hello{} }
+func (m *Hello) String() string { return proto.CompactTextString(m) }
+func (*Hello) ProtoMessage()    {}
+func (*Hello) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{1}
+}
+
+func (m *Hello) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Hello.Unmarshal(m, b)
+}
+func (m *Hello) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Hello.Marshal(b, m, deterministic)
+}
+func (m *Hello) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Hello.Merge(m, src)
+}
+func (m *Hello) XXX_Size() int {
+	return xxx_messageInfo_Hello.Size(m)
+}
+func (m *Hello) XXX_DiscardUnknown() {
+	xxx_messageInfo_Hello.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Hello proto.InternalMessageInfo
+
+// Service announces that the node Node serves the service Name at Addr. In
+// a NodeRecord, the host of Addr is empty when peers should use the address
+// the announcement comes from.
 type Service struct {
 	Addr                 string   `protobuf:"bytes,1,opt,name=Addr,proto3" json:"Addr,omitempty"`
 	Name                 string   `protobuf:"bytes,3,opt,name=Name,proto3" json:"Name,omitempty"`
+	Node                 string   `protobuf:"bytes,4,opt,name=Node,proto3" json:"Node,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -112,7 +376,7 @@ func (m *Service) Reset()         { *m = Service{} }
 func (m *Service) String() string { return proto.CompactTextString(m) }
 func (*Service) ProtoMessage()    {}
 func (*Service) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f5838971722c666f, []int{1}
+	return fileDescriptor_f5838971722c666f, []int{2}
 }
 
 func (m *Service) XXX_Unmarshal(b []byte) error {
@@ -147,18 +411,29 @@ func (m *Service) GetName() string {
 	return ""
 }
 
+func (m *Service) GetNode() string {
+	if m != nil {
+		return m.Node
+	}
+	return ""
+}
+
+// DNS announces a node and the labels clients select it by. Nodes now send
+// a NodeRecord instead of DNS and Service messages, which are still read
+// from older nodes.
 type DNS struct {
-	Name                 string   `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Name                 string            `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	Labels               map[string]string `protobuf:"bytes,2,rep,name=Labels,proto3" json:"Labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *DNS) Reset()         { *m = DNS{} }
 func (m *DNS) String() string { return proto.CompactTextString(m) }
 func (*DNS) ProtoMessage()    {}
 func (*DNS) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f5838971722c666f, []int{2}
+	return fileDescriptor_f5838971722c666f, []int{3}
 }
 
 func (m *DNS) XXX_Unmarshal(b []byte) error {
@@ -186,10 +461,530 @@ func (m *DNS) GetName() string {
 	return ""
 }
 
+func (m *DNS) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+// Goodbye is sent by a node leaving the cluster so that its endpoints are
+// dropped right away.
+type Goodbye struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	Addrs                []string `protobuf:"bytes,2,rep,name=Addrs,proto3" json:"Addrs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Goodbye) Reset()         { *m = Goodbye{} }
+func (m *Goodbye) String() string { return proto.CompactTextString(m) }
+func (*Goodbye) ProtoMessage()    {}
+func (*Goodbye) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{4}
+}
+
+func (m *Goodbye) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Goodbye.Unmarshal(m, b)
+}
+func (m *Goodbye) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Goodbye.Marshal(b, m, deterministic)
+}
+func (m *Goodbye) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Goodbye.Merge(m, src)
+}
+func (m *Goodbye) XXX_Size() int {
+	return xxx_messageInfo_Goodbye.Size(m)
+}
+func (m *Goodbye) XXX_DiscardUnknown() {
+	xxx_messageInfo_Goodbye.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Goodbye proto.InternalMessageInfo
+
+func (m *Goodbye) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Goodbye) GetAddrs() []string {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+// Signed carries a Request authenticated by the node sending it. The
+// signature covers every other field, the timestamp and the nonce stop
+// messages from being replayed.
+type Signed struct {
+	// Payload is the marshalled Request
+	Payload []byte `protobuf:"bytes,1,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	Node    string `protobuf:"bytes,2,opt,name=Node,proto3" json:"Node,omitempty"`
+	// Timestamp is in nanoseconds since the epoch
+	Timestamp            int64            `protobuf:"varint,3,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+	Nonce                uint64           `protobuf:"varint,4,opt,name=Nonce,proto3" json:"Nonce,omitempty"`
+	Alg                  Signed_Algorithm `protobuf:"varint,5,opt,name=Alg,proto3,enum=resolver.Signed_Algorithm" json:"Alg,omitempty"`
+	Signature            []byte           `protobuf:"bytes,6,opt,name=Signature,proto3" json:"Signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *Signed) Reset()         { *m = Signed{} }
+func (m *Signed) String() string { return proto.CompactTextString(m) }
+func (*Signed) ProtoMessage()    {}
+func (*Signed) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{5}
+}
+
+func (m *Signed) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Signed.Unmarshal(m, b)
+}
+func (m *Signed) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Signed.Marshal(b, m, deterministic)
+}
+func (m *Signed) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Signed.Merge(m, src)
+}
+func (m *Signed) XXX_Size() int {
+	return xxx_messageInfo_Signed.Size(m)
+}
+func (m *Signed) XXX_DiscardUnknown() {
+	xxx_messageInfo_Signed.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Signed proto.InternalMessageInfo
+
+func (m *Signed) GetPayload() []byte {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (m *Signed) GetNode() string {
+	if m != nil {
+		return m.Node
+	}
+	return ""
+}
+
+func (m *Signed) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *Signed) GetNonce() uint64 {
+	if m != nil {
+		return m.Nonce
+	}
+	return 0
+}
+
+func (m *Signed) GetAlg() Signed_Algorithm {
+	if m != nil {
+		return m.Alg
+	}
+	return Signed_NONE
+}
+
+func (m *Signed) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+// NodeRecord describes a node and everything it serves, in a single
+// announcement.
+type NodeRecord struct {
+	// ID identifies the node across restarts and renames
+	ID       string     `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Name     string     `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	Services []*Service `protobuf:"bytes,3,rep,name=Services,proto3" json:"Services,omitempty"`
+	Exports  []*Export  `protobuf:"bytes,4,rep,name=Exports,proto3" json:"Exports,omitempty"`
+	Version  string     `protobuf:"bytes,5,opt,name=Version,proto3" json:"Version,omitempty"`
+	// Load is the number of files the node holds open for clients
+	Load uint64 `protobuf:"varint,6,opt,name=Load,proto3" json:"Load,omitempty"`
+	// Labels are what clients select nodes by, zone=paris for instance
+	Labels map[string]string `protobuf:"bytes,7,rep,name=Labels,proto3" json:"Labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// TLSFingerprint is the hex SHA-256 of the node's certificate, if any
+	TLSFingerprint       string   `protobuf:"bytes,8,opt,name=TLSFingerprint,proto3" json:"TLSFingerprint,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NodeRecord) Reset()         { *m = NodeRecord{} }
+func (m *NodeRecord) String() string { return proto.CompactTextString(m) }
+func (*NodeRecord) ProtoMessage()    {}
+func (*NodeRecord) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{6}
+}
+
+func (m *NodeRecord) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NodeRecord.Unmarshal(m, b)
+}
+func (m *NodeRecord) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NodeRecord.Marshal(b, m, deterministic)
+}
+func (m *NodeRecord) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeRecord.Merge(m, src)
+}
+func (m *NodeRecord) XXX_Size() int {
+	return xxx_messageInfo_NodeRecord.Size(m)
+}
+func (m *NodeRecord) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeRecord.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeRecord proto.InternalMessageInfo
+
+func (m *NodeRecord) GetID() string {
+	if m != nil {
+		return m.ID
+	}
+	return ""
+}
+
+func (m *NodeRecord) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *NodeRecord) GetServices() []*Service {
+	if m != nil {
+		return m.Services
+	}
+	return nil
+}
+
+func (m *NodeRecord) GetExports() []*Export {
+	if m != nil {
+		return m.Exports
+	}
+	return nil
+}
+
+func (m *NodeRecord) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *NodeRecord) GetLoad() uint64 {
+	if m != nil {
+		return m.Load
+	}
+	return 0
+}
+
+func (m *NodeRecord) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *NodeRecord) GetTLSFingerprint() string {
+	if m != nil {
+		return m.TLSFingerprint
+	}
+	return ""
+}
+
+// Export is a directory served by a node.
+type Export struct {
+	Name string `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	// Free is the space left in bytes, 0 when it is not known
+	Free                 uint64   `protobuf:"varint,2,opt,name=Free,proto3" json:"Free,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Export) Reset()         { *m = Export{} }
+func (m *Export) String() string { return proto.CompactTextString(m) }
+func (*Export) ProtoMessage()    {}
+func (*Export) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{7}
+}
+
+func (m *Export) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Export.Unmarshal(m, b)
+}
+func (m *Export) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Export.Marshal(b, m, deterministic)
+}
+func (m *Export) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Export.Merge(m, src)
+}
+func (m *Export) XXX_Size() int {
+	return xxx_messageInfo_Export.Size(m)
+}
+func (m *Export) XXX_DiscardUnknown() {
+	xxx_messageInfo_Export.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Export proto.InternalMessageInfo
+
+func (m *Export) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Export) GetFree() uint64 {
+	if m != nil {
+		return m.Free
+	}
+	return 0
+}
+
+// Fragment is a part of a marshalled Request too large for one datagram.
+// Fragments sharing an ID are put back together once all Count of them are
+// received.
+type Fragment struct {
+	ID                   uint64   `protobuf:"varint,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Index                uint32   `protobuf:"varint,2,opt,name=Index,proto3" json:"Index,omitempty"`
+	Count                uint32   `protobuf:"varint,3,opt,name=Count,proto3" json:"Count,omitempty"`
+	Data                 []byte   `protobuf:"bytes,4,opt,name=Data,proto3" json:"Data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Fragment) Reset()         { *m = Fragment{} }
+func (m *Fragment) String() string { return proto.CompactTextString(m) }
+func (*Fragment) ProtoMessage()    {}
+func (*Fragment) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{8}
+}
+
+func (m *Fragment) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Fragment.Unmarshal(m, b)
+}
+func (m *Fragment) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Fragment.Marshal(b, m, deterministic)
+}
+func (m *Fragment) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Fragment.Merge(m, src)
+}
+func (m *Fragment) XXX_Size() int {
+	return xxx_messageInfo_Fragment.Size(m)
+}
+func (m *Fragment) XXX_DiscardUnknown() {
+	xxx_messageInfo_Fragment.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Fragment proto.InternalMessageInfo
+
+func (m *Fragment) GetID() uint64 {
+	if m != nil {
+		return m.ID
+	}
+	return 0
+}
+
+func (m *Fragment) GetIndex() uint32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *Fragment) GetCount() uint32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *Fragment) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+// Swim is a message of the gossip membership protocol, see swim.go. Every
+// message carries the latest changes of Members.
+type Swim struct {
+	Kind Swim_Type `protobuf:"varint,1,opt,name=Kind,proto3,enum=resolver.Swim_Type" json:"Kind,omitempty"`
+	Seq  uint64    `protobuf:"varint,2,opt,name=Seq,proto3" json:"Seq,omitempty"`
+	// From is the name of the sender, empty for the clients that are not
+	// members
+	From                 string          `protobuf:"bytes,3,opt,name=From,proto3" json:"From,omitempty"`
+	Target               string          `protobuf:"bytes,4,opt,name=Target,proto3" json:"Target,omitempty"`
+	Members              []*MemberRecord `protobuf:"bytes,5,rep,name=Members,proto3" json:"Members,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *Swim) Reset()         { *m = Swim{} }
+func (m *Swim) String() string { return proto.CompactTextString(m) }
+func (*Swim) ProtoMessage()    {}
+func (*Swim) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{9}
+}
+
+func (m *Swim) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Swim.Unmarshal(m, b)
+}
+func (m *Swim) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Swim.Marshal(b, m, deterministic)
+}
+func (m *Swim) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Swim.Merge(m, src)
+}
+func (m *Swim) XXX_Size() int {
+	return xxx_messageInfo_Swim.Size(m)
+}
+func (m *Swim) XXX_DiscardUnknown() {
+	xxx_messageInfo_Swim.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Swim proto.InternalMessageInfo
+
+func (m *Swim) GetKind() Swim_Type {
+	if m != nil {
+		return m.Kind
+	}
+	return Swim_PING
+}
+
+func (m *Swim) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *Swim) GetFrom() string {
+	if m != nil {
+		return m.From
+	}
+	return ""
+}
+
+func (m *Swim) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
+func (m *Swim) GetMembers() []*MemberRecord {
+	if m != nil {
+		return m.Members
+	}
+	return nil
+}
+
+// MemberRecord is the state of a node in the membership. Updates with a
+// higher Incarnation override the others, only the member itself increments
+// it.
+type MemberRecord struct {
+	Name string `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	// Addr is the host:port the member gossips at
+	Addr                 string              `protobuf:"bytes,2,opt,name=Addr,proto3" json:"Addr,omitempty"`
+	Incarnation          uint64              `protobuf:"varint,3,opt,name=Incarnation,proto3" json:"Incarnation,omitempty"`
+	State                MemberRecord_Status `protobuf:"varint,4,opt,name=State,proto3,enum=resolver.MemberRecord_Status" json:"State,omitempty"`
+	Node                 *NodeRecord         `protobuf:"bytes,5,opt,name=Node,proto3" json:"Node,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
+}
+
+func (m *MemberRecord) Reset()         { *m = MemberRecord{} }
+func (m *MemberRecord) String() string { return proto.CompactTextString(m) }
+func (*MemberRecord) ProtoMessage()    {}
+func (*MemberRecord) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f5838971722c666f, []int{10}
+}
+
+func (m *MemberRecord) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MemberRecord.Unmarshal(m, b)
+}
+func (m *MemberRecord) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MemberRecord.Marshal(b, m, deterministic)
+}
+func (m *MemberRecord) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MemberRecord.Merge(m, src)
+}
+func (m *MemberRecord) XXX_Size() int {
+	return xxx_messageInfo_MemberRecord.Size(m)
+}
+func (m *MemberRecord) XXX_DiscardUnknown() {
+	xxx_messageInfo_MemberRecord.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MemberRecord proto.InternalMessageInfo
+
+func (m *MemberRecord) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *MemberRecord) GetAddr() string {
+	if m != nil {
+		return m.Addr
+	}
+	return ""
+}
+
+func (m *MemberRecord) GetIncarnation() uint64 {
+	if m != nil {
+		return m.Incarnation
+	}
+	return 0
+}
+
+func (m *MemberRecord) GetState() MemberRecord_Status {
+	if m != nil {
+		return m.State
+	}
+	return MemberRecord_ALIVE
+}
+
+func (m *MemberRecord) GetNode() *NodeRecord {
+	if m != nil {
+		return m.Node
+	}
+	return nil
+}
+
 func init() {
+	proto.RegisterEnum("resolver.Request_Route", Request_Route_name, Request_Route_value)
+	proto.RegisterEnum("resolver.Signed_Algorithm", Signed_Algorithm_name, Signed_Algorithm_value)
+	proto.RegisterEnum("resolver.Swim_Type", Swim_Type_name, Swim_Type_value)
+	proto.RegisterEnum("resolver.MemberRecord_Status", MemberRecord_Status_name, MemberRecord_Status_value)
 	proto.RegisterType((*Request)(nil), "resolver.Request")
+	proto.RegisterType((*Hello)(nil), "resolver.Hello")
 	proto.RegisterType((*Service)(nil), "resolver.Service")
 	proto.RegisterType((*DNS)(nil), "resolver.DNS")
+	proto.RegisterMapType((map[string]string)(nil), "resolver.DNS.LabelsEntry")
+	proto.RegisterType((*Goodbye)(nil), "resolver.Goodbye")
+	proto.RegisterType((*Signed)(nil), "resolver.Signed")
+	proto.RegisterType((*NodeRecord)(nil), "resolver.NodeRecord")
+	proto.RegisterMapType((map[string]string)(nil), "resolver.NodeRecord.LabelsEntry")
+	proto.RegisterType((*Export)(nil), "resolver.Export")
+	proto.RegisterType((*Fragment)(nil), "resolver.Fragment")
+	proto.RegisterType((*Swim)(nil), "resolver.Swim")
+	proto.RegisterType((*MemberRecord)(nil), "resolver.MemberRecord")
 }
 
 func init() {
@@ -197,16 +992,65 @@ func init() {
 }
 
 var fileDescriptor_f5838971722c666f = []byte{
-	// 162 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x2b, 0x4a, 0x2d, 0xce,
-	0xcf, 0x29, 0x4b, 0x2d, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x80, 0xf1, 0x95, 0xd2,
-	0xb8, 0xd8, 0x83, 0x52, 0x0b, 0x4b, 0x53, 0x8b, 0x4b, 0x84, 0x74, 0xb9, 0xd8, 0x8b, 0x53, 0x8b,
-	0xca, 0x32, 0x93, 0x53, 0x25, 0x18, 0x15, 0x18, 0x35, 0xb8, 0x8d, 0x04, 0xf5, 0xe0, 0xda, 0x82,
-	0x21, 0x12, 0x1e, 0x0c, 0x41, 0x30, 0x35, 0x42, 0x8a, 0x5c, 0xcc, 0x29, 0x79, 0xc5, 0x12, 0x4c,
-	0x60, 0xa5, 0xbc, 0x08, 0xa5, 0x2e, 0x7e, 0xc1, 0x1e, 0x0c, 0x41, 0x20, 0x39, 0x27, 0x4e, 0x2e,
-	0xf6, 0x22, 0x88, 0xe1, 0x4a, 0x86, 0x5c, 0xec, 0x50, 0x33, 0x84, 0x84, 0xb8, 0x58, 0x1c, 0x53,
-	0x52, 0x8a, 0xc0, 0x96, 0x70, 0x06, 0x81, 0xd9, 0x20, 0x31, 0xbf, 0xc4, 0xdc, 0x54, 0x09, 0x66,
-	0x88, 0x18, 0x88, 0xad, 0x24, 0xc9, 0xc5, 0xec, 0xe2, 0x17, 0x0c, 0x97, 0x62, 0x44, 0x48, 0x25,
-	0xb1, 0x81, 0xbd, 0x61, 0x0c, 0x08, 0x00, 0x00, 0xff, 0xff, 0x42, 0x64, 0x82, 0x29, 0xd8, 0x00,
-	0x00, 0x00,
+	// 959 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x55, 0xdd, 0x6e, 0xe3, 0x44,
+	0x14, 0x8e, 0xff, 0xe2, 0xf8, 0x24, 0xcd, 0x9a, 0xa1, 0x5a, 0xcc, 0x0a, 0xa4, 0x60, 0x21, 0xb6,
+	0xaa, 0x68, 0xd4, 0x4d, 0x29, 0xda, 0xe5, 0xce, 0x24, 0xee, 0x26, 0xda, 0xd4, 0x94, 0x71, 0xba,
+	0x52, 0xb9, 0xa9, 0xdc, 0x7a, 0xc8, 0x5a, 0xc4, 0x9e, 0xec, 0xd8, 0xe9, 0x36, 0x4f, 0xc0, 0xe3,
+	0x71, 0xc7, 0x03, 0x70, 0x8b, 0x78, 0x07, 0x34, 0x33, 0x4e, 0xec, 0x56, 0x81, 0x9b, 0xbd, 0x3b,
+	0x3f, 0x9f, 0xcf, 0x9c, 0x9f, 0xef, 0x1c, 0x43, 0x97, 0x91, 0x9c, 0x2e, 0xee, 0x08, 0xeb, 0x2f,
+	0x19, 0x2d, 0x28, 0x6a, 0x6d, 0x74, 0xf7, 0x0f, 0x0d, 0x4c, 0x4c, 0xde, 0xaf, 0x48, 0x5e, 0xa0,
+	0x23, 0x30, 0x73, 0xc2, 0xee, 0x92, 0x5b, 0xe2, 0x28, 0x3d, 0xe5, 0xa0, 0x3d, 0xf8, 0xa4, 0xbf,
+	0xfd, 0x2e, 0x94, 0x8e, 0x71, 0x03, 0x6f, 0x30, 0xe8, 0x2b, 0xd0, 0xe2, 0x2c, 0x77, 0x54, 0x01,
+	0xdd, 0xab, 0xa0, 0xa3, 0x20, 0x1c, 0x37, 0x30, 0xf7, 0xf1, 0x88, 0x73, 0x4a, 0xe3, 0x9b, 0x35,
+	0x71, 0xb4, 0xc7, 0x11, 0x5f, 0x4b, 0x07, 0x8f, 0x58, 0x62, 0xd0, 0x21, 0x34, 0xf3, 0x64, 0x9e,
+	0x91, 0xd8, 0xd1, 0x05, 0xda, 0xae, 0xbd, 0x2f, 0xec, 0xe3, 0x06, 0x2e, 0x11, 0xe8, 0x10, 0xf4,
+	0x8c, 0xc6, 0xc4, 0x31, 0x04, 0x72, 0xbf, 0x42, 0x06, 0x34, 0x26, 0x98, 0xdc, 0x52, 0xc6, 0xd1,
+	0x02, 0x83, 0x8e, 0xa1, 0xf5, 0x2b, 0x8b, 0xe6, 0x29, 0xc9, 0x0a, 0xa7, 0x29, 0xf0, 0xa8, 0xc2,
+	0x9f, 0x95, 0x9e, 0x71, 0x03, 0x6f, 0x51, 0xe8, 0x39, 0x18, 0xef, 0xc8, 0x62, 0x41, 0x1d, 0x53,
+	0xc0, 0x9f, 0x54, 0xf0, 0x31, 0x37, 0x8f, 0x1b, 0x58, 0xfa, 0xd1, 0xd7, 0xa0, 0xe7, 0x1f, 0x92,
+	0xd4, 0xb1, 0x04, 0xae, 0x5b, 0x4b, 0xf8, 0x43, 0x92, 0xf2, 0x04, 0xb8, 0x17, 0x1d, 0x81, 0xc1,
+	0xe8, 0xaa, 0x20, 0x4e, 0xab, 0xa7, 0x1c, 0x74, 0x07, 0x9f, 0x55, 0xb0, 0xb2, 0xf7, 0x7d, 0xcc,
+	0xdd, 0x58, 0xa2, 0xdc, 0x63, 0x30, 0x84, 0x8e, 0xf6, 0xc0, 0x3a, 0xbf, 0x9c, 0xce, 0x26, 0x43,
+	0x2f, 0x9c, 0xd9, 0x0d, 0xd4, 0x06, 0xf3, 0x32, 0x90, 0x8a, 0xc2, 0x15, 0xec, 0x4f, 0xbd, 0x2b,
+	0x7f, 0x64, 0xab, 0x3f, 0x5a, 0x60, 0x32, 0x19, 0xc9, 0x35, 0xc1, 0x10, 0x39, 0xba, 0x3e, 0x98,
+	0xe5, 0xd4, 0x10, 0x02, 0xdd, 0x8b, 0x63, 0x26, 0xc6, 0x6a, 0x61, 0x21, 0x73, 0x5b, 0x10, 0xa5,
+	0x72, 0x30, 0x16, 0x16, 0xb2, 0xb0, 0xf1, 0xa6, 0xea, 0xa5, 0x8d, 0xc6, 0xc4, 0xfd, 0x5d, 0x01,
+	0x6d, 0x14, 0x84, 0x5b, 0xbc, 0x52, 0xc3, 0xbf, 0x80, 0xe6, 0x34, 0xba, 0x21, 0x0b, 0xce, 0x02,
+	0xed, 0xa0, 0x3d, 0xf8, 0xfc, 0x01, 0x0b, 0xfa, 0xd2, 0xe7, 0x67, 0x05, 0x5b, 0xe3, 0x12, 0xf8,
+	0xec, 0x15, 0xb4, 0x6b, 0x66, 0x64, 0x83, 0xf6, 0x1b, 0x59, 0x97, 0x41, 0xb9, 0x88, 0xf6, 0xc1,
+	0xb8, 0x8b, 0x16, 0x2b, 0x22, 0x88, 0x65, 0x61, 0xa9, 0xfc, 0xa0, 0xbe, 0x54, 0xdc, 0x13, 0x30,
+	0x4b, 0xd2, 0xec, 0x4c, 0x66, 0x1f, 0x0c, 0x5e, 0x98, 0xcc, 0xc5, 0xc2, 0x52, 0x71, 0xff, 0x56,
+	0xa0, 0x29, 0xc9, 0x83, 0x1c, 0x30, 0x2f, 0xa2, 0xf5, 0x82, 0x46, 0xb1, 0xf8, 0xae, 0x83, 0x37,
+	0xea, 0xb6, 0x6e, 0xb5, 0xaa, 0x1b, 0x7d, 0x01, 0xd6, 0x2c, 0x49, 0x49, 0x5e, 0x44, 0xe9, 0x52,
+	0x34, 0x49, 0xc3, 0x95, 0x81, 0x3f, 0x16, 0xd0, 0xec, 0x56, 0xb6, 0x4a, 0xc7, 0x52, 0x41, 0xdf,
+	0x82, 0xe6, 0x2d, 0xe6, 0x82, 0x93, 0xdd, 0xc1, 0xb3, 0xc7, 0xec, 0xed, 0x7b, 0x8b, 0x39, 0x65,
+	0x49, 0xf1, 0x2e, 0xc5, 0x1c, 0xc6, 0x5f, 0xe0, 0x8e, 0xa8, 0x58, 0x31, 0x22, 0x78, 0xd9, 0xc1,
+	0x95, 0xc1, 0x3d, 0x01, 0x6b, 0x8b, 0x47, 0x2d, 0xd0, 0x83, 0x9f, 0x02, 0xdf, 0x6e, 0xa0, 0x27,
+	0xd0, 0x1e, 0x9f, 0x7b, 0xc3, 0xeb, 0x70, 0xec, 0x0d, 0x4e, 0xbf, 0x97, 0x3c, 0xf0, 0x47, 0x83,
+	0xd3, 0xd3, 0x17, 0xaf, 0x6c, 0xd5, 0xfd, 0x53, 0x05, 0xa8, 0x16, 0x00, 0x75, 0x41, 0x9d, 0x8c,
+	0xca, 0x26, 0xa9, 0x93, 0xd1, 0xb6, 0x6d, 0x6a, 0xad, 0x6d, 0x47, 0xd0, 0x2a, 0x69, 0x92, 0x3b,
+	0x5a, 0x4f, 0x7b, 0xb8, 0xa4, 0xa5, 0x07, 0x6f, 0x21, 0xe8, 0x10, 0x4c, 0xff, 0x7e, 0x49, 0x59,
+	0x91, 0x3b, 0x7a, 0x4f, 0x7b, 0xb8, 0xa4, 0xd2, 0x81, 0x37, 0x00, 0xde, 0xf0, 0xb7, 0x84, 0xe5,
+	0x09, 0xcd, 0x44, 0x4b, 0x2c, 0xbc, 0x51, 0x79, 0x22, 0x53, 0x3e, 0x87, 0xa6, 0xe8, 0x9e, 0x90,
+	0xd1, 0xcb, 0x2d, 0x99, 0x4c, 0x11, 0xb8, 0xb7, 0x6b, 0xa7, 0x77, 0x71, 0x0a, 0x7d, 0x03, 0xdd,
+	0xd9, 0x34, 0x3c, 0x4b, 0xb2, 0x39, 0x61, 0x4b, 0x96, 0x64, 0x85, 0xd8, 0x33, 0x0b, 0x3f, 0xb2,
+	0x7e, 0x0c, 0xf7, 0x8e, 0xa1, 0x29, 0xab, 0xda, 0x49, 0x3d, 0x04, 0xfa, 0x19, 0x23, 0xf2, 0x33,
+	0x1d, 0x0b, 0xd9, 0xfd, 0x05, 0x5a, 0x9b, 0xd3, 0x52, 0x9b, 0x83, 0x2e, 0xe6, 0xb0, 0x0f, 0xc6,
+	0x24, 0x8b, 0xc9, 0xbd, 0xf8, 0x60, 0x0f, 0x4b, 0x85, 0x5b, 0x87, 0x74, 0x95, 0x15, 0x82, 0x6d,
+	0x7b, 0x58, 0x2a, 0x3c, 0xf6, 0x28, 0x2a, 0x22, 0x41, 0xb4, 0x0e, 0x16, 0xb2, 0xfb, 0x97, 0x02,
+	0x3a, 0x3f, 0x30, 0xe8, 0x39, 0xe8, 0x6f, 0x92, 0x4c, 0xf2, 0xb9, 0x3b, 0xf8, 0xf4, 0xe1, 0xf9,
+	0xe9, 0xcf, 0xd6, 0x4b, 0x82, 0x05, 0x80, 0xd7, 0x1a, 0x92, 0xf7, 0x65, 0x82, 0x5c, 0x94, 0x39,
+	0xd3, 0x74, 0xb3, 0xff, 0x5c, 0x46, 0x4f, 0xa1, 0x39, 0x8b, 0xd8, 0x9c, 0x14, 0xe5, 0x05, 0x28,
+	0x35, 0x74, 0x0c, 0xe6, 0x39, 0x49, 0x6f, 0x08, 0xcb, 0x1d, 0x43, 0xcc, 0xe6, 0x69, 0xf5, 0x92,
+	0x74, 0xc8, 0xe9, 0xe0, 0x0d, 0xcc, 0xf5, 0x40, 0xe7, 0xaf, 0x73, 0xe2, 0x5e, 0x4c, 0x82, 0xd7,
+	0x76, 0x03, 0x99, 0xa0, 0x79, 0xc3, 0x37, 0xb6, 0x82, 0x3a, 0xd0, 0xe2, 0xa6, 0x6b, 0xec, 0xff,
+	0x6c, 0xab, 0x1c, 0x10, 0x5e, 0x05, 0x43, 0x5b, 0x43, 0x5d, 0x00, 0x2e, 0x5d, 0x63, 0xff, 0x62,
+	0x7a, 0x65, 0xeb, 0xee, 0x3f, 0x0a, 0x74, 0xea, 0xc1, 0xff, 0xab, 0xf3, 0xe2, 0xb2, 0xa9, 0xb5,
+	0xcb, 0xd6, 0x83, 0xf6, 0x24, 0xbb, 0x8d, 0x58, 0x16, 0x15, 0x9c, 0x7a, 0x9a, 0xa8, 0xb9, 0x6e,
+	0x42, 0x27, 0x60, 0x84, 0x45, 0x54, 0xc8, 0xed, 0xed, 0x0e, 0xbe, 0xdc, 0x5d, 0x4d, 0x9f, 0x63,
+	0x56, 0x39, 0x96, 0x58, 0x74, 0x50, 0x1e, 0x89, 0xff, 0xf9, 0xe3, 0x94, 0x27, 0xf3, 0x3b, 0x68,
+	0xca, 0x4f, 0x91, 0x05, 0x86, 0x37, 0x9d, 0xbc, 0xf5, 0xe5, 0xf1, 0x0e, 0x2f, 0xc3, 0x0b, 0x7f,
+	0xc8, 0x8f, 0x77, 0x0b, 0xf4, 0x91, 0xef, 0x8d, 0x64, 0xfd, 0x53, 0xff, 0x6c, 0x66, 0x6b, 0x37,
+	0x4d, 0xf1, 0x6f, 0x3e, 0xf9, 0x77, 0x00, 0xbd, 0xb2, 0x62, 0x2b, 0xad, 0x07, 0x00, 0x00,
 }
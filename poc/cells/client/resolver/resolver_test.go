@@ -0,0 +1,95 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestResolver(t *testing.T) {
+	mem := NewMemory()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := Start(ctx, Options{Backend: mem}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Start(ctx, Options{Backend: mem}); err != ErrStarted {
+		t.Fatalf("expected discovery to be started once, got %v", err)
+	}
+
+	start := time.Now()
+	conn, err := grpc.Dial("cells:///grpc.health.v1.Health", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if d := time.Since(start); d > healthTimeout {
+		t.Fatalf("dial took %s", d)
+	}
+
+	client := healthpb.NewHealthClient(conn)
+
+	// Without nodes, calls fail rather than wait
+	check := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+	if err := check(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the call to fail without nodes, got %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("grpc.health.v1.Health", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	node := &Node{Name: "node1", Addr: lis.Addr().String(), Services: []string{"grpc.health.v1.Health"}}
+	if err := mem.Announce(node); err != nil {
+		t.Fatal(err)
+	}
+
+	var i int
+	for err = check(); err != nil; err = check() {
+		if i++; i == 50 {
+			t.Fatalf("node not resolved: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := mem.Leave(node); err != nil {
+		t.Fatal(err)
+	}
+	for i = 0; check() == nil; i++ {
+		if i == 50 {
+			t.Fatal("node still resolved after it left")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Discovery starts again once stopped
+	cancel()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	if err := Start(ctx, Options{Backend: NewMemory()}); err != nil {
+		t.Fatal(err)
+	}
+}
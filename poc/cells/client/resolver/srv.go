@@ -0,0 +1,114 @@
+package resolver
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srv finds the nodes in the DNS SRV records of name, _cells._tcp.example.com
+// for instance. Each target is a node, named after the first label of its
+// host name, serving services on the port of the record. The records are
+// managed in the DNS, nodes do not announce themselves. The labels of a node
+// are the key=value TXT records of its host name.
+type srv struct {
+	name     string
+	services []string
+	resolver *net.Resolver
+}
+
+// newSRV returns the backend for the records of name. The services of the
+// nodes are given as services=index.FS,etcdserverpb.KV in query.
+func newSRV(name string, query url.Values) *srv {
+	services := defaultServices
+	if s := query.Get("services"); s != "" {
+		services = strings.Split(s, ",")
+	}
+
+	return &srv{name: name, services: services, resolver: net.DefaultResolver}
+}
+
+func (s *srv) Watch(ctx context.Context, r *Registry) error {
+	var failed string
+
+	for {
+		if err := s.lookup(ctx, r); err != nil {
+			if err.Error() != failed {
+				log.Printf("discovery: %v", err)
+			}
+			failed = err.Error()
+		} else {
+			failed = ""
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(refreshInterval):
+		}
+	}
+}
+
+func (s *srv) lookup(ctx context.Context, r *Registry) error {
+	ctx, cancel := context.WithTimeout(ctx, refreshInterval)
+	defer cancel()
+
+	_, records, err := s.resolver.LookupSRV(ctx, "", "", s.name)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		target := strings.TrimSuffix(rec.Target, ".")
+		node := strings.SplitN(target, ".", 2)[0]
+
+		ips, err := s.resolver.LookupHost(ctx, target)
+		if err != nil {
+			log.Printf("discovery: %v", err)
+			continue
+		}
+
+		// Nodes without TXT records have no labels
+		txts, _ := s.resolver.LookupTXT(ctx, target)
+		labels := parseLabels(txts)
+
+		for _, ip := range ips {
+			r.AddNode(&Node{
+				Name:     node,
+				Addr:     net.JoinHostPort(ip, strconv.Itoa(int(rec.Port))),
+				Services: s.services,
+				Labels:   labels,
+			})
+		}
+	}
+
+	return nil
+}
+
+// parseLabels returns the labels of key=value records, ignoring the others.
+func parseLabels(records []string) map[string]string {
+	labels := make(map[string]string)
+	for _, rec := range records {
+		if i := strings.Index(rec, "="); i > 0 {
+			labels[rec[:i]] = rec[i+1:]
+		}
+	}
+
+	return labels
+}
+
+func (*srv) Announce(*Node) error {
+	return nil
+}
+
+func (*srv) Leave(*Node) error {
+	return nil
+}
+
+func (*srv) Close() error {
+	return nil
+}
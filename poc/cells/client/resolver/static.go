@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultServices are the services of the peers that do not list theirs.
+var defaultServices = []string{"index.FS"}
+
+// static reads the peers from a YAML file, read again regularly so that it
+// can be edited while nodes run:
+//
+//	nodes:
+//	  - name: node1
+//	    addr: 10.0.0.1:7000
+//	    labels: {zone: paris}
+//	  - name: config
+//	    addr: 10.0.0.2:7001
+//	    services: [etcdserverpb.KV]
+//
+// Nodes do not announce themselves, they must be listed in the file.
+type static struct {
+	path string
+}
+
+type peersFile struct {
+	Nodes []struct {
+		Name     string            `yaml:"name"`
+		Addr     string            `yaml:"addr"`
+		Services []string          `yaml:"services"`
+		Labels   map[string]string `yaml:"labels"`
+	} `yaml:"nodes"`
+}
+
+func (s *static) Watch(ctx context.Context, r *Registry) error {
+	var failed string
+
+	for {
+		if err := s.load(r); err != nil {
+			// Peers read before are kept until they expire
+			if err.Error() != failed {
+				log.Printf("discovery: %v", err)
+			}
+			failed = err.Error()
+		} else {
+			failed = ""
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(refreshInterval):
+		}
+	}
+}
+
+func (s *static) load(r *Registry) error {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var peers peersFile
+	if err := yaml.UnmarshalStrict(data, &peers); err != nil {
+		return fmt.Errorf("parsing %s: %v", s.path, err)
+	}
+
+	for i, n := range peers.Nodes {
+		if _, _, err := net.SplitHostPort(n.Addr); err != nil || n.Name == "" {
+			return fmt.Errorf("%s: nodes[%d]: a name and a host:port address are required", s.path, i)
+		}
+	}
+
+	for _, n := range peers.Nodes {
+		services := n.Services
+		if len(services) == 0 {
+			services = defaultServices
+		}
+
+		r.AddNode(&Node{Name: n.Name, Addr: n.Addr, Services: services, Labels: n.Labels})
+	}
+
+	return nil
+}
+
+func (*static) Announce(*Node) error {
+	return nil
+}
+
+func (*static) Leave(*Node) error {
+	return nil
+}
+
+func (*static) Close() error {
+	return nil
+}
@@ -0,0 +1,949 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// DefaultSwimPort is the port members gossip at unless the spec tells.
+const DefaultSwimPort = 7946
+
+// swimTiming are the intervals and timeouts of the protocol.
+type swimTiming struct {
+	// probe is how often a member is probed, and ack how long its ACK is
+	// waited for before members are asked to probe it too
+	probe, ack time.Duration
+
+	// suspicion is how long a member stays suspect before it is declared
+	// dead, multiplied by the log of the size of the cluster
+	suspicion time.Duration
+
+	// sync is how often the whole membership is exchanged with a member
+	// or a seed, observe how often clients ask for it
+	sync, observe time.Duration
+
+	// reap is how long dead members are remembered, so that their former
+	// incarnations are not taken for new ones
+	reap time.Duration
+}
+
+var defaultSwimTiming = swimTiming{
+	probe:     time.Second,
+	ack:       400 * time.Millisecond,
+	suspicion: 5 * time.Second,
+	sync:      30 * time.Second,
+	observe:   refreshInterval,
+	reap:      time.Minute,
+}
+
+const (
+	// indirectProbes is how many members are asked to probe a member that
+	// did not answer
+	indirectProbes = 3
+
+	// retransmitMult times the log of the size of the cluster is how many
+	// messages an update is piggybacked on
+	retransmitMult = 4
+
+	// maxPiggyback bounds the updates carried by a message
+	maxPiggyback = 8
+
+	// unsignedSyncInterval is how often a host may ask for the membership
+	// without signing its SYNC when messages are authenticated, and
+	// unsignedSyncOverhead what is left of a datagram for the signature
+	// of the reply
+	unsignedSyncInterval = time.Second
+	unsignedSyncOverhead = 256
+)
+
+// swim maintains the membership of the cluster with the SWIM protocol: every
+// member probes another one at each interval, directly and then through
+// others, and suspects it when it does not answer. Suspected members that do
+// not refute the suspicion within a timeout are declared dead. Updates are
+// spread by piggybacking them on the probes, and ordered by incarnation
+// numbers that only the member concerned increments.
+//
+//	swim://0.0.0.0:7946?join=10.0.0.5,10.0.0.6:7946
+//
+// Nodes join the cluster by exchanging the membership with the seeds listed
+// in join, and regularly afterwards with a random member. Clients do not
+// join, they ask the seeds or the members for the membership. Messages are
+// signed by the members as configured by the query, see auth.
+//
+// With auth, a member is only trusted with its own address and node, which
+// other members relay but cannot change: they only spread its state. A
+// member first heard of from another is asked for its membership, which
+// carries its own record, and is not published until it answers. Clients
+// sign their SYNCs with the secret of the cluster when they have it. Those
+// that cannot are answered at most once per interval per host, with as
+// much of the membership as fits in a datagram, so that the members cannot
+// be used to flood a forged source address. Members sharing a secret can
+// sign for each other: only ed25519 keys tell them apart.
+//
+// The backends opened with the same spec share the member, so that a node
+// announcing itself and its resolver use the same socket.
+type swim struct {
+	spec   string
+	seeds  []string
+	port   int
+	auth   *auth
+	timing swimTiming
+
+	conn *net.UDPConn
+
+	// observer is set when the port is used by another process, the
+	// backend then watches without being able to announce
+	observer bool
+
+	mu         sync.Mutex
+	refs       int
+	done       chan struct{}
+	self       *MemberRecord
+	members    map[string]*swimMember
+	queue      []*update
+	seq        uint64
+	acks       map[uint64]*pendingAck
+	probes     []string
+	registries map[*Registry]int
+	frags      *reassembler
+
+	// syncs tells when the hosts last asked for the membership unsigned
+	syncs map[string]time.Time
+}
+
+type swimMember struct {
+	rec   *MemberRecord
+	since time.Time
+
+	// vouched is set once the address and node of the record are heard
+	// from the member itself, always without auth
+	vouched bool
+}
+
+// update is a change of a member waiting to be piggybacked.
+type update struct {
+	rec       *MemberRecord
+	transmits int
+}
+
+type pendingAck struct {
+	fn       func()
+	deadline time.Time
+}
+
+var (
+	swimsMu sync.Mutex
+	swims   = make(map[string]*swim)
+)
+
+// swimBackend is a reference to a shared swim.
+type swimBackend struct {
+	*swim
+	once sync.Once
+}
+
+func (b *swimBackend) Close() error {
+	b.once.Do(b.swim.release)
+	return nil
+}
+
+// openSwim returns the member of spec, started when it is not yet.
+func openSwim(u *url.URL) (Discovery, error) {
+	spec := u.String()
+
+	swimsMu.Lock()
+	defer swimsMu.Unlock()
+
+	s, ok := swims[spec]
+	if !ok {
+		var err error
+		if s, err = newSwim(u, defaultSwimTiming); err != nil {
+			return nil, err
+		}
+		swims[spec] = s
+	}
+	s.refs++
+
+	return &swimBackend{swim: s}, nil
+}
+
+// swimAddr returns the address of the host:port of a swim spec, with the
+// default port when there is none.
+func swimAddr(host string) (*net.UDPAddr, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, strconv.Itoa(DefaultSwimPort))
+	}
+
+	return net.ResolveUDPAddr("udp", host)
+}
+
+func newSwim(u *url.URL, timing swimTiming) (*swim, error) {
+	addr, err := swimAddr(u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+
+	a, err := newAuth(u.Query())
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+
+	s := &swim{
+		spec:       u.String(),
+		seeds:      splitList(u.Query().Get("join")),
+		port:       addr.Port,
+		auth:       a,
+		timing:     timing,
+		done:       make(chan struct{}),
+		members:    make(map[string]*swimMember),
+		acks:       make(map[uint64]*pendingAck),
+		registries: make(map[*Registry]int),
+		frags:      newReassembler(),
+		syncs:      make(map[string]time.Time),
+	}
+
+	s.conn, err = net.ListenUDP("udp", addr)
+	if err != nil && errors.Is(err, syscall.EADDRINUSE) {
+		// A node of the host has the port, watch from another
+		s.conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: addr.IP, Zone: addr.Zone})
+		s.observer = true
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.conn.SetReadBuffer(maxDatagramSize)
+
+	go s.read()
+	go s.loop()
+
+	return s, nil
+}
+
+// release drops a reference to s, and stops it with the last one.
+func (s *swim) release() {
+	swimsMu.Lock()
+	defer swimsMu.Unlock()
+
+	s.mu.Lock()
+	s.refs--
+	last := s.refs == 0
+	s.mu.Unlock()
+
+	if !last {
+		return
+	}
+
+	if swims[s.spec] == s {
+		delete(swims, s.spec)
+	}
+	close(s.done)
+	s.conn.Close()
+}
+
+// Watch adds the members to r, and keeps them there until ctx is done.
+func (s *swim) Watch(ctx context.Context, r *Registry) error {
+	s.mu.Lock()
+	s.registries[r]++
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if s.registries[r]--; s.registries[r] == 0 {
+			delete(s.registries, r)
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		// Members are added again before they expire, dead ones were
+		// removed already
+		s.mu.Lock()
+		var recs []*MemberRecord
+		for _, m := range s.members {
+			if probed(m.rec) {
+				recs = append(recs, m.rec)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, rec := range recs {
+			publish(r, rec)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.done:
+			return errors.New("discovery closed")
+		case <-time.After(refreshInterval):
+		}
+	}
+}
+
+// publish adds what rec tells of a member to r.
+func publish(r *Registry, rec *MemberRecord) {
+	m := Member{Name: rec.Name, Addr: rec.Addr, State: MemberState(rec.State), Incarnation: rec.Incarnation}
+
+	var n *Node
+	if rec.Node != nil {
+		host, _, _ := net.SplitHostPort(rec.Addr)
+		n, _ = nodeFromRecord(rec.Node, host)
+		m.Node = n
+	}
+
+	r.SetMember(m)
+
+	if n == nil {
+		return
+	}
+
+	switch rec.State {
+	case MemberRecord_ALIVE, MemberRecord_SUSPECT:
+		r.AddNode(n)
+	default:
+		host, _, _ := net.SplitHostPort(n.Addr)
+		r.Remove(n.Name, host, []string{n.Addr})
+	}
+}
+
+// Announce makes n a member, or updates its record.
+func (s *swim) Announce(n *Node) error {
+	if s.observer {
+		return fmt.Errorf("discovery: port %d is used by another process", s.port)
+	}
+
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return err
+	}
+	rec := n.record(host).GetNode()
+
+	// Members gossip at the host of the node unless bound to another
+	local := s.conn.LocalAddr().(*net.UDPAddr)
+	if !local.IP.IsUnspecified() {
+		host = local.IP.String()
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(local.Port))
+
+	s.mu.Lock()
+	joining := s.self == nil || s.self.Name != n.Name
+	if joining {
+		// Incarnations outlive restarts, unless the clock goes back
+		s.self = &MemberRecord{Name: n.Name, Addr: addr, Incarnation: uint64(time.Now().Unix()), Node: rec}
+	} else if s.self.Addr != addr || !proto.Equal(s.self.Node, rec) {
+		s.self = &MemberRecord{Name: n.Name, Addr: addr, Incarnation: s.self.Incarnation + 1, Node: rec}
+	} else {
+		s.mu.Unlock()
+		return nil
+	}
+
+	self := s.self
+	s.members[self.Name] = &swimMember{rec: self, since: time.Now(), vouched: true}
+	s.enqueue(self)
+	s.mu.Unlock()
+
+	s.publish(self)
+
+	if joining {
+		s.sync()
+	}
+
+	return nil
+}
+
+// Leave tells the members that n leaves, and stops probing them.
+func (s *swim) Leave(n *Node) error {
+	s.mu.Lock()
+	if s.self == nil || s.self.Name != n.Name {
+		s.mu.Unlock()
+		return nil
+	}
+
+	left := &MemberRecord{Name: n.Name, Addr: s.self.Addr, Incarnation: s.self.Incarnation + 1, State: MemberRecord_LEFT, Node: s.self.Node}
+	s.members[left.Name] = &swimMember{rec: left, since: time.Now(), vouched: true}
+	s.enqueue(left)
+	targets := s.random(indirectProbes, "")
+	s.mu.Unlock()
+
+	// The members are told right away rather than at the next probes
+	for _, m := range targets {
+		s.send(m.Addr, &Swim{Kind: Swim_PING, Members: []*MemberRecord{left}})
+	}
+
+	s.mu.Lock()
+	s.self = nil
+	s.mu.Unlock()
+
+	s.publish(left)
+
+	return nil
+}
+
+// loop probes the members, ends suspicions and exchanges the membership
+// until s is closed.
+func (s *swim) loop() {
+	var lastSync time.Time
+
+	for {
+		start := time.Now()
+
+		s.mu.Lock()
+		member := s.self != nil
+		s.mu.Unlock()
+
+		if member {
+			s.probe()
+		}
+
+		interval := s.timing.sync
+		if !member {
+			interval = s.timing.observe
+		}
+		if time.Since(lastSync) >= interval {
+			s.sync()
+			lastSync = time.Now()
+		}
+
+		s.expire()
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(s.timing.probe - time.Since(start)):
+		}
+	}
+}
+
+// probe probes the next member, and suspects it unless it answers, directly
+// or through the members asked to probe it.
+func (s *swim) probe() {
+	s.mu.Lock()
+	target := s.nextProbe()
+	if target == nil {
+		s.mu.Unlock()
+		return
+	}
+
+	acked := make(chan struct{})
+	seq := s.await(func() { close(acked) }, s.timing.probe)
+	s.mu.Unlock()
+
+	s.send(target.Addr, &Swim{Kind: Swim_PING, Seq: seq})
+
+	select {
+	case <-acked:
+		return
+	case <-s.done:
+		return
+	case <-time.After(s.timing.ack):
+	}
+
+	s.mu.Lock()
+	helpers := s.random(indirectProbes, target.Name)
+	s.mu.Unlock()
+
+	for _, m := range helpers {
+		s.send(m.Addr, &Swim{Kind: Swim_PING_REQ, Seq: seq, Target: target.Addr})
+	}
+
+	select {
+	case <-acked:
+		return
+	case <-s.done:
+		return
+	case <-time.After(s.timing.probe - s.timing.ack):
+	}
+
+	suspect := copyRecord(target)
+	suspect.State = MemberRecord_SUSPECT
+	s.merge(suspect, "")
+}
+
+// nextProbe returns the next member to probe, going through the members in
+// a random order. s.mu must be held.
+func (s *swim) nextProbe() *MemberRecord {
+	if s.self == nil {
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		for len(s.probes) > 0 {
+			name := s.probes[0]
+			s.probes = s.probes[1:]
+
+			if m, ok := s.members[name]; ok && probed(m.rec) && name != s.self.Name {
+				return m.rec
+			}
+		}
+
+		for name := range s.members {
+			s.probes = append(s.probes, name)
+		}
+		rand.Shuffle(len(s.probes), func(i, j int) { s.probes[i], s.probes[j] = s.probes[j], s.probes[i] })
+	}
+
+	return nil
+}
+
+func probed(rec *MemberRecord) bool {
+	return rec.State == MemberRecord_ALIVE || rec.State == MemberRecord_SUSPECT
+}
+
+// random returns up to k alive members, but the local one and the member
+// named except. s.mu must be held.
+func (s *swim) random(k int, except string) []*MemberRecord {
+	var recs []*MemberRecord
+	for name, m := range s.members {
+		if m.rec.State == MemberRecord_ALIVE && name != except && (s.self == nil || name != s.self.Name) {
+			recs = append(recs, m.rec)
+		}
+	}
+
+	rand.Shuffle(len(recs), func(i, j int) { recs[i], recs[j] = recs[j], recs[i] })
+	if len(recs) > k {
+		recs = recs[:k]
+	}
+
+	return recs
+}
+
+// await registers fn to be called with the ACK of the returned sequence
+// number, until timeout. s.mu must be held.
+func (s *swim) await(fn func(), timeout time.Duration) uint64 {
+	s.seq++
+	s.acks[s.seq] = &pendingAck{fn: fn, deadline: time.Now().Add(timeout)}
+
+	return s.seq
+}
+
+// sync exchanges the membership with a seed, or with a random member once
+// the member knows others. Clients only ask for it.
+func (s *swim) sync() {
+	s.mu.Lock()
+	var recs []*MemberRecord
+	if s.self != nil {
+		for _, m := range s.members {
+			recs = append(recs, m.rec)
+		}
+	}
+
+	var targets []string
+	for _, m := range s.random(1, "") {
+		targets = append(targets, m.Addr)
+	}
+	s.mu.Unlock()
+
+	if len(targets) == 0 {
+		targets = s.seeds
+	}
+
+	for _, t := range targets {
+		s.send(t, &Swim{Kind: Swim_SYNC, Members: recs})
+	}
+}
+
+// expire declares dead the members suspected for too long, forgets those
+// dead for long enough and drops the ACKs not received in time.
+func (s *swim) expire() {
+	now := time.Now()
+
+	s.mu.Lock()
+	timeout := time.Duration(float64(s.timing.suspicion) * math.Max(1, math.Log10(float64(len(s.members)))))
+
+	var dead []*MemberRecord
+	for name, m := range s.members {
+		switch {
+		case m.rec.State == MemberRecord_SUSPECT && now.Sub(m.since) > timeout:
+			rec := copyRecord(m.rec)
+			rec.State = MemberRecord_DEAD
+			dead = append(dead, rec)
+		case !probed(m.rec) && now.Sub(m.since) > s.timing.reap && (s.self == nil || name != s.self.Name):
+			delete(s.members, name)
+		}
+	}
+
+	for seq, a := range s.acks {
+		if now.After(a.deadline) {
+			delete(s.acks, seq)
+		}
+	}
+
+	for host, t := range s.syncs {
+		if now.Sub(t) >= unsignedSyncInterval {
+			delete(s.syncs, host)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, rec := range dead {
+		s.merge(rec, "")
+	}
+}
+
+// supersedes reports whether rec overrides cur, the update known of the
+// same member: alive members must have a newer incarnation, suspicions and
+// deaths override the same incarnation in a lesser state.
+func supersedes(cur, rec *MemberRecord) bool {
+	if cur == nil {
+		return true
+	}
+
+	if rec.Incarnation != cur.Incarnation {
+		return rec.Incarnation > cur.Incarnation
+	}
+
+	return severity(rec.State) > severity(cur.State)
+}
+
+func severity(state MemberRecord_Status) int {
+	switch state {
+	case MemberRecord_ALIVE:
+		return 0
+	case MemberRecord_SUSPECT:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// merge applies an update sent by the member from, spreading it when it is
+// news. Updates about the local member other than its own are refuted with
+// a new incarnation.
+func (s *swim) merge(rec *MemberRecord, from string) {
+	s.mu.Lock()
+
+	if s.self != nil && rec.Name == s.self.Name {
+		if rec.State != MemberRecord_ALIVE && rec.Incarnation >= s.self.Incarnation {
+			refuted := copyRecord(s.self)
+			refuted.Incarnation = rec.Incarnation + 1
+			s.self = refuted
+			s.members[refuted.Name] = &swimMember{rec: refuted, since: time.Now(), vouched: true}
+			s.enqueue(refuted)
+		}
+		s.mu.Unlock()
+		return
+	}
+
+	vouched := s.auth == nil || rec.Name == from
+
+	var cur *MemberRecord
+	m, ok := s.members[rec.Name]
+	if ok {
+		cur = m.rec
+	}
+
+	c := copyRecord(rec)
+	switch {
+	case supersedes(cur, rec):
+	case vouched && !m.vouched && rec.Incarnation <= cur.Incarnation:
+		// The member confirms its address and node, what others told of
+		// its state stands
+		c.Incarnation, c.State = cur.Incarnation, cur.State
+	default:
+		s.mu.Unlock()
+		return
+	}
+
+	switch {
+	case !vouched && ok:
+		c.Addr, c.Node = cur.Addr, cur.Node
+	case !vouched:
+		c.Node = nil
+	case c.Node == nil && cur != nil:
+		c.Node = cur.Node
+	}
+
+	since := time.Now()
+	if ok && cur.State == c.State {
+		since = m.since
+	}
+	s.members[c.Name] = &swimMember{rec: c, since: since, vouched: vouched || ok && m.vouched}
+	s.enqueue(c)
+	s.mu.Unlock()
+
+	s.publish(c)
+
+	if !vouched && !ok && probed(c) {
+		// The member tells its own record in its reply
+		s.send(c.Addr, &Swim{Kind: Swim_SYNC})
+	}
+}
+
+// copyRecord returns a copy of rec to update. Records are shared with the
+// messages being marshalled, which must not be copied as structs.
+func copyRecord(rec *MemberRecord) *MemberRecord {
+	return &MemberRecord{Name: rec.Name, Addr: rec.Addr, Incarnation: rec.Incarnation, State: rec.State, Node: rec.Node}
+}
+
+// enqueue queues rec to be piggybacked, replacing the former updates of the
+// member. s.mu must be held.
+func (s *swim) enqueue(rec *MemberRecord) {
+	for i, u := range s.queue {
+		if u.rec.Name == rec.Name {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			break
+		}
+	}
+
+	s.queue = append(s.queue, &update{rec: rec})
+}
+
+// piggyback returns the updates to carry, those sent the least first, and
+// drops the updates sent enough times. s.mu must be held.
+func (s *swim) piggyback() []*MemberRecord {
+	limit := retransmitMult * int(math.Ceil(math.Log10(float64(len(s.members)+1))))
+
+	var recs []*MemberRecord
+	kept := s.queue[:0]
+	for _, u := range s.queue {
+		if len(recs) < maxPiggyback {
+			recs = append(recs, u.rec)
+			u.transmits++
+		}
+		if u.transmits < limit {
+			kept = append(kept, u)
+		}
+	}
+	s.queue = kept
+
+	return recs
+}
+
+// publish adds rec to the registries watching s.
+func (s *swim) publish(rec *MemberRecord) {
+	s.mu.Lock()
+	var registries []*Registry
+	for r := range s.registries {
+		registries = append(registries, r)
+	}
+	s.mu.Unlock()
+
+	for _, r := range registries {
+		publish(r, rec)
+	}
+}
+
+// send sends msg to addr, with the pending updates unless it carries the
+// membership already.
+func (s *swim) send(addr string, msg *Swim) error {
+	dst, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		if _, _, e := net.SplitHostPort(addr); e != nil {
+			dst, err = swimAddr(net.JoinHostPort(addr, strconv.Itoa(s.port)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	if msg.Kind != Swim_SYNC && msg.Kind != Swim_SYNC_REPLY {
+		msg.Members = append(msg.Members, s.piggyback()...)
+	}
+
+	var node string
+	if s.self != nil {
+		node = s.self.Name
+	}
+	msg.From = node
+	s.mu.Unlock()
+
+	req := &Request{Request: &Request_Swim{Swim: msg}, Route: Request_UNICAST}
+	if s.auth != nil && (node != "" || s.auth.canSign(node)) {
+		if req, err = s.auth.sign(node, req); err != nil {
+			return err
+		}
+		req.Route = Request_UNICAST
+	}
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	parts, err := fragment(data, Request_UNICAST)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		if _, err := s.conn.WriteToUDP(part, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// read handles the messages received until s is closed.
+func (s *swim) read() {
+	var failed string
+
+	b := make([]byte, maxDatagramSize)
+	for {
+		n, src, err := s.conn.ReadFromUDP(b)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+
+			log.Printf("discovery: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if err := s.handle(src, b[:n]); err != nil {
+			// Rejected messages keep coming, only log changes
+			if msg := src.String() + ": " + err.Error(); msg != failed {
+				log.Printf("discovery: %s", msg)
+				failed = msg
+			}
+		}
+	}
+}
+
+func (s *swim) handle(src *net.UDPAddr, data []byte) error {
+	req := &Request{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil
+	}
+
+	if v, ok := req.Request.(*Request_Fragment); ok {
+		data, err := s.frags.add(src.String(), v.Fragment)
+		if err != nil || data == nil {
+			return err
+		}
+
+		req = &Request{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			return err
+		}
+	}
+
+	_, signed := req.Request.(*Request_Signed)
+
+	msg, err := s.open(req)
+	if err != nil || msg == nil {
+		return err
+	}
+
+	// Signed messages were sent by From
+	for _, rec := range msg.Members {
+		s.merge(rec, msg.From)
+	}
+
+	switch msg.Kind {
+	case Swim_PING:
+		s.mu.Lock()
+		member := s.self != nil
+		s.mu.Unlock()
+
+		if member {
+			s.send(src.String(), &Swim{Kind: Swim_ACK, Seq: msg.Seq})
+		}
+	case Swim_ACK:
+		s.mu.Lock()
+		a, ok := s.acks[msg.Seq]
+		delete(s.acks, msg.Seq)
+		s.mu.Unlock()
+
+		if ok {
+			a.fn()
+		}
+	case Swim_PING_REQ:
+		from := src.String()
+
+		s.mu.Lock()
+		seq := s.await(func() {
+			s.send(from, &Swim{Kind: Swim_ACK, Seq: msg.Seq})
+		}, s.timing.probe)
+		s.mu.Unlock()
+
+		s.send(msg.Target, &Swim{Kind: Swim_PING, Seq: seq})
+	case Swim_SYNC:
+		s.mu.Lock()
+		unsigned := s.auth != nil && !signed
+		if unsigned {
+			host := src.IP.String()
+			if t, ok := s.syncs[host]; ok && time.Since(t) < unsignedSyncInterval {
+				s.mu.Unlock()
+				return nil
+			}
+			s.syncs[host] = time.Now()
+		}
+
+		// The local member comes first, as the only record it vouches for
+		var recs []*MemberRecord
+		if s.self != nil {
+			recs = append(recs, s.self)
+		}
+		for _, m := range s.members {
+			if s.self == nil || m.rec.Name != s.self.Name {
+				recs = append(recs, m.rec)
+			}
+		}
+		s.mu.Unlock()
+
+		reply := &Swim{Kind: Swim_SYNC_REPLY, Members: recs}
+		if unsigned {
+			fitDatagram(reply)
+		}
+
+		s.send(src.String(), reply)
+	}
+
+	return nil
+}
+
+// fitDatagram drops the last members of msg until it fits in a single
+// datagram once signed.
+func fitDatagram(msg *Swim) {
+	for len(msg.Members) > 0 && proto.Size(&Request{Request: &Request_Swim{Swim: msg}})+unsignedSyncOverhead > maxFragmentSize {
+		msg.Members = msg.Members[:len(msg.Members)-1]
+	}
+}
+
+// open returns the message carried by req. With auth, only clients asking
+// for the membership may send unsigned messages.
+func (s *swim) open(req *Request) (*Swim, error) {
+	if s.auth != nil {
+		if v, ok := req.Request.(*Request_Swim); ok {
+			if v.Swim.Kind == Swim_SYNC && v.Swim.From == "" && len(v.Swim.Members) == 0 {
+				return v.Swim, nil
+			}
+		}
+
+		r, err := s.auth.verify(req)
+		if err != nil {
+			return nil, err
+		}
+		req = r
+	} else if v, ok := req.Request.(*Request_Signed); ok {
+		r, err := unwrap(v.Signed)
+		if err != nil {
+			return nil, err
+		}
+		req = r
+	}
+
+	v, ok := req.Request.(*Request_Swim)
+	if !ok {
+		return nil, nil
+	}
+
+	return v.Swim, nil
+}
@@ -0,0 +1,332 @@
+package resolver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSwimSupersedes(t *testing.T) {
+	rec := func(inc uint64, state MemberRecord_Status) *MemberRecord {
+		return &MemberRecord{Name: "node1", Incarnation: inc, State: state}
+	}
+
+	tests := []struct {
+		cur, rec *MemberRecord
+		want     bool
+	}{
+		{nil, rec(1, MemberRecord_ALIVE), true},
+		{rec(1, MemberRecord_ALIVE), rec(1, MemberRecord_ALIVE), false},
+		{rec(1, MemberRecord_ALIVE), rec(2, MemberRecord_ALIVE), true},
+		{rec(1, MemberRecord_ALIVE), rec(1, MemberRecord_SUSPECT), true},
+		{rec(1, MemberRecord_SUSPECT), rec(1, MemberRecord_ALIVE), false},
+		{rec(1, MemberRecord_SUSPECT), rec(2, MemberRecord_ALIVE), true},
+		{rec(1, MemberRecord_SUSPECT), rec(1, MemberRecord_DEAD), true},
+		{rec(2, MemberRecord_ALIVE), rec(1, MemberRecord_DEAD), false},
+		{rec(1, MemberRecord_DEAD), rec(1, MemberRecord_LEFT), false},
+		{rec(1, MemberRecord_DEAD), rec(2, MemberRecord_ALIVE), true},
+	}
+
+	for i, test := range tests {
+		if got := supersedes(test.cur, test.rec); got != test.want {
+			t.Errorf("%d: expected %v, got %v", i, test.want, got)
+		}
+	}
+}
+
+func TestSwimMembership(t *testing.T) {
+	timing := swimTiming{
+		probe:     50 * time.Millisecond,
+		ack:       20 * time.Millisecond,
+		suspicion: 200 * time.Millisecond,
+		sync:      time.Second,
+		observe:   100 * time.Millisecond,
+		reap:      time.Minute,
+	}
+
+	var (
+		swims      []*swim
+		registries []*Registry
+		seed       string
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var events []MemberEvent
+
+	for i := 0; i < 3; i++ {
+		u, _ := url.Parse("swim://127.0.0.1:0?join=" + seed)
+		s, err := newSwim(u, timing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.refs++
+		defer s.release()
+
+		if seed == "" {
+			seed = s.conn.LocalAddr().String()
+		}
+
+		r := NewRegistry(time.Minute)
+		go s.Watch(ctx, r)
+
+		if err := s.Announce(&Node{Name: fmt.Sprintf("node%d", i), Addr: fmt.Sprintf("127.0.0.1:%d", 1000+i), Services: []string{"index.FS"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		swims = append(swims, s)
+		registries = append(registries, r)
+	}
+
+	registries[0].WatchMembers(func(ev MemberEvent) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	})
+
+	wait := func(what string, ok func() bool) {
+		t.Helper()
+		for i := 0; !ok(); i++ {
+			if i == 100 {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	states := func(r *Registry) map[string]MemberState {
+		states := make(map[string]MemberState)
+		for _, m := range r.Members() {
+			states[m.Name] = m.State
+		}
+		return states
+	}
+
+	wait("the nodes to join", func() bool {
+		for _, r := range registries {
+			if len(r.Endpoints("index.FS")) != 3 {
+				return false
+			}
+		}
+		return true
+	})
+
+	// A client asks for the membership without joining
+	u, _ := url.Parse("swim://127.0.0.1:0?join=" + seed)
+	client, err := newSwim(u, timing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.refs++
+	defer client.release()
+
+	rc := NewRegistry(time.Minute)
+	go client.Watch(ctx, rc)
+	wait("the client to learn the members", func() bool { return len(rc.Endpoints("index.FS")) == 3 })
+
+	// node2 stops answering
+	swims[2].conn.Close()
+	wait("node2 to be declared dead", func() bool {
+		_, ok := states(registries[0])["node2"]
+		return !ok && len(registries[0].Endpoints("index.FS")) == 2
+	})
+
+	mu.Lock()
+	var suspected, dead bool
+	for _, ev := range events {
+		if ev.Member.Name == "node2" {
+			suspected = suspected || ev.Member.State == Suspect
+			dead = dead || ev.Member.State == Dead
+		}
+	}
+	mu.Unlock()
+	if !suspected || !dead {
+		t.Errorf("expected node2 to be suspected then dead, got events %+v", events)
+	}
+
+	// node1 leaves
+	if err := swims[1].Leave(&Node{Name: "node1"}); err != nil {
+		t.Fatal(err)
+	}
+	wait("node1 to leave", func() bool { return len(registries[0].Endpoints("index.FS")) == 1 })
+
+	if got := states(registries[0]); len(got) != 1 || got["node0"] != Alive {
+		t.Errorf("unexpected members %v", got)
+	}
+}
+
+// swimKeys writes ed25519 keys for the nodes in dir, and the file trusting
+// them, and returns the query of the spec of each node.
+func swimKeys(t *testing.T, dir string, nodes ...string) map[string]string {
+	trusted := make(map[string]string)
+	queries := make(map[string]string)
+	for _, name := range nodes {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, name+".key")
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		trusted[name] = base64.StdEncoding.EncodeToString(pub)
+		queries[name] = "key=" + path
+	}
+
+	data, err := yaml.Marshal(trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "trusted.yaml")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for name := range queries {
+		queries[name] += "&trusted=" + path
+	}
+	queries[""] = "trusted=" + path
+
+	return queries
+}
+
+func TestSwimAuth(t *testing.T) {
+	timing := swimTiming{
+		probe:     50 * time.Millisecond,
+		ack:       20 * time.Millisecond,
+		suspicion: 200 * time.Millisecond,
+		sync:      time.Second,
+		observe:   100 * time.Millisecond,
+		reap:      time.Minute,
+	}
+
+	dir, err := ioutil.TempDir("", "swim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queries := swimKeys(t, dir, "node0", "node1", "node2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	open := func(query string) *swim {
+		u, _ := url.Parse("swim://127.0.0.1:0?" + query)
+		s, err := newSwim(u, timing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.refs++
+		return s
+	}
+
+	seed := open(queries["node0"])
+	defer seed.release()
+	join := "&join=" + seed.conn.LocalAddr().String()
+
+	node1 := open(queries["node1"] + join)
+	defer node1.release()
+
+	for i, s := range []*swim{seed, node1} {
+		if err := s.Announce(&Node{Name: fmt.Sprintf("node%d", i), Addr: fmt.Sprintf("127.0.0.1:%d", 1000+i), Services: []string{"index.FS"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The client cannot sign, it learns node1 from node1 itself
+	client := open(queries[""] + join)
+	defer client.release()
+
+	rc := NewRegistry(time.Minute)
+	go client.Watch(ctx, rc)
+	for i := 0; len(rc.Endpoints("index.FS")) != 2; i++ {
+		if i == 100 {
+			t.Fatalf("timed out waiting for the client to learn the members, got %v", rc.Members())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// node2 cannot change the address or the node of node1
+	seed.mu.Lock()
+	before := copyRecord(seed.members["node1"].rec)
+	seed.mu.Unlock()
+
+	forged := &MemberRecord{Name: "node1", Addr: "127.0.0.1:1", Incarnation: before.Incarnation + 1, Node: &NodeRecord{Name: "node1"}}
+	seed.merge(forged, "node2")
+
+	seed.mu.Lock()
+	after := seed.members["node1"].rec
+	seed.mu.Unlock()
+	if after.Addr != before.Addr || after.Node != before.Node || after.Incarnation != forged.Incarnation {
+		t.Errorf("expected only the incarnation to change, got %+v", after)
+	}
+
+	// Nor tell of an unknown member
+	seed.merge(&MemberRecord{Name: "node3", Addr: "127.0.0.1:1", Node: &NodeRecord{Name: "node3"}}, "node2")
+	seed.mu.Lock()
+	if m := seed.members["node3"]; m == nil || m.rec.Node != nil || m.vouched {
+		t.Errorf("expected node3 to be known without its node, got %+v", m)
+	}
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("fake%d", i)
+		seed.members[name] = &swimMember{rec: &MemberRecord{Name: name, Addr: "127.0.0.1:1", Node: &NodeRecord{Name: name, Version: "1.0.0"}}}
+	}
+	seed.mu.Unlock()
+
+	// Unsigned SYNCs are answered with a single datagram, once per interval
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+
+	syncReq := marshal(t, &Request{Request: &Request_Swim{Swim: &Swim{Kind: Swim_SYNC}}, Route: Request_UNICAST})
+	dst := seed.conn.LocalAddr().(*net.UDPAddr)
+	b := make([]byte, maxDatagramSize)
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteToUDP(syncReq, dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var replies int
+	for {
+		conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		n, _, err := conn.ReadFromUDP(b)
+		if err != nil {
+			break
+		}
+		replies++
+
+		req := &Request{}
+		if err := proto.Unmarshal(b[:n], req); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := req.Request.(*Request_Signed); !ok {
+			t.Fatalf("expected a single signed datagram, got %T", req.Request)
+		}
+	}
+	if replies != 1 {
+		t.Errorf("expected a single reply, got %d", replies)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"compress/zlib"
 	"crypto/sha1"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +15,7 @@ import (
 	"path/filepath"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/ghecquet/tripr/poc/cells/aferofs"
@@ -49,7 +51,12 @@ func main() {
 
 	switch baseurl.Scheme {
 	case "cells":
-		fs = aferofs.NewIndexFs(baseurl.Hostname() + "@" + baseurl.Path)
+		var err error
+		fs, err = aferofs.OpenIndexFs(basefs, append(tlsOptions(), encryptionOptions()...)...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	default:
 		fs = afero.NewBasePathFs(afero.NewOsFs(), baseurl.Path)
 	}
@@ -70,6 +77,34 @@ func main() {
 	}
 }
 
+// encryptionOptions enables end-to-end encryption with the key file named by
+// CELLS_KEY_FILE, or the passphrase in CELLS_PASSPHRASE. Names are encrypted
+// too when CELLS_ENCRYPT_NAMES is set.
+func encryptionOptions() []aferofs.IndexFsOption {
+	names := os.Getenv("CELLS_ENCRYPT_NAMES") != ""
+
+	if name := os.Getenv("CELLS_KEY_FILE"); name != "" {
+		return []aferofs.IndexFsOption{aferofs.WithKeyFile(name, names)}
+	}
+	if passphrase := os.Getenv("CELLS_PASSPHRASE"); passphrase != "" {
+		return []aferofs.IndexFsOption{aferofs.WithPassphrase(passphrase, names)}
+	}
+
+	return nil
+}
+
+// tlsOptions connects to the nodes over TLS when CELLS_TLS_CA is set, to
+// check their certificates against that CA, or when CELLS_TLS_CERT and
+// CELLS_TLS_KEY are, to present a client certificate.
+func tlsOptions() []aferofs.IndexFsOption {
+	ca, cert, key := os.Getenv("CELLS_TLS_CA"), os.Getenv("CELLS_TLS_CERT"), os.Getenv("CELLS_TLS_KEY")
+	if ca == "" && cert == "" && key == "" {
+		return nil
+	}
+
+	return []aferofs.IndexFsOption{aferofs.WithTLS(ca, cert, key)}
+}
+
 func runCommand(commandStr string) error {
 	commandStr = strings.TrimSuffix(commandStr, "\n")
 	arrCommandStr := strings.Fields(commandStr)
@@ -364,6 +399,39 @@ func runCommand(commandStr string) error {
 			log.Fatal("HEEERRRE ", err)
 		}
 
+	case "versions":
+		if len(arrCommandStr) < 2 {
+			return errors.New("usage: versions <file>")
+		}
+
+		vfs, ok := fs.(aferofs.Versioned)
+		if !ok {
+			return errors.New("versions are not supported by this filesystem")
+		}
+
+		versions, err := vfs.Versions(absPath(arrCommandStr[1]))
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+		for _, v := range versions {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", v.GetId(), v.GetSize(), time.Unix(v.GetModTime(), 0).Format(time.RFC3339))
+		}
+		w.Flush()
+
+	case "restore":
+		if len(arrCommandStr) < 3 {
+			return errors.New("usage: restore <file> <version>")
+		}
+
+		vfs, ok := fs.(aferofs.Versioned)
+		if !ok {
+			return errors.New("versions are not supported by this filesystem")
+		}
+
+		return vfs.RestoreVersion(absPath(arrCommandStr[1]), arrCommandStr[2])
+
 	case "exit":
 		os.Exit(0)
 		// add another case here for custom commands.
@@ -371,3 +439,12 @@ func runCommand(commandStr string) error {
 
 	return nil
 }
+
+// absPath resolves fn against the current directory.
+func absPath(fn string) string {
+	if strings.HasPrefix(fn, "/") {
+		return filepath.Clean(fn)
+	}
+
+	return filepath.Clean(cwd + "/" + fn)
+}
@@ -31,6 +31,9 @@ var (
 	Server = grpc.NewServer()
 )
 
+// stopTimeout bounds how long Stop waits for in-flight calls.
+const stopTimeout = 30 * time.Second
+
 type grpcServer struct {
 	s        *grpc.Server
 	opts     server.Options
@@ -129,7 +132,18 @@ func (s *grpcServer) Register() error {
 }
 
 func (s *grpcServer) Deregister() error {
-	return nil
+	node := &registry.Node{
+		Id:      s.opts.Name + "-" + s.opts.Id,
+		Address: s.opts.Address,
+	}
+
+	service := &registry.Service{
+		Name:    s.opts.Name,
+		Version: s.opts.Version,
+		Nodes:   []*registry.Node{node},
+	}
+
+	return s.opts.Registry.Deregister(service)
 }
 
 func (s *grpcServer) Start() error {
@@ -148,6 +162,18 @@ func (s *grpcServer) Start() error {
 }
 
 func (s *grpcServer) Stop() error {
+	done := make(chan struct{})
+	go func() {
+		s.s.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		s.s.Stop()
+	}
+
 	return nil
 }
 
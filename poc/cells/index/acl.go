@@ -0,0 +1,58 @@
+package index
+
+import (
+	context "context"
+	"net"
+)
+
+// Access is what a principal may do on an export.
+type Access int
+
+const (
+	NoAccess Access = iota
+	ReadAccess
+	WriteAccess
+)
+
+// AnyPrincipal in an ACL matches every client.
+const AnyPrincipal = "*"
+
+// ACL lists the principals allowed to read and write an export, see
+// PrincipalFromContext. Principals allowed to write may read as well.
+type ACL struct {
+	Read  []string
+	Write []string
+}
+
+// Access returns what principal may do on the export of the ACL.
+func (a *ACL) Access(principal string) Access {
+	switch {
+	case contains(a.Write, principal):
+		return WriteAccess
+	case contains(a.Read, principal):
+		return ReadAccess
+	}
+
+	return NoAccess
+}
+
+func contains(principals []string, principal string) bool {
+	for _, p := range principals {
+		if p == principal || p == AnyPrincipal {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PrincipalFromContext returns the principal of the client of an incoming
+// call: the common name of its certificate with mutual TLS, or its IP.
+func PrincipalFromContext(ctx context.Context) string {
+	principal, addr := CallerFromContext(ctx)
+	if principal == "" {
+		principal, _, _ = net.SplitHostPort(addr)
+	}
+
+	return principal
+}
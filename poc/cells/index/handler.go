@@ -2,28 +2,374 @@ package index
 
 import (
 	context "context"
+	"errors"
 	"io"
 	"os"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/afero"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 const CHUNKSIZE = 1024
 
+// MaxReadSize is the most a client gets from a single ReadAt request.
+const MaxReadSize = 1 << 20
+
+// ExportKey is the metadata key clients use to select the export a call
+// applies to. Calls without it target the default export "".
+const ExportKey = "export"
+
+// RetryAfterKey is the trailer telling a client rejected with
+// RESOURCE_EXHAUSTED how long to wait before trying again, as a duration
+// string such as "250ms".
+const RetryAfterKey = "retry-after"
+
+// HealthService returns the name under which the health of an export is
+// reported by the grpc.health.v1 service.
+func HealthService(export string) string {
+	return _FS_serviceDesc.ServiceName + "/" + export
+}
+
 type Handler struct {
-	fs afero.Fs
+	mu        sync.RWMutex
+	exports   map[string]afero.Fs
+	trashes   map[string]*Trash
+	versions  map[string]*VersionFs
+	snapshots map[string]*Snapshots
+	acls      map[string]*ACL
+
+	filesMu sync.Mutex
+	files   map[afero.File]struct{}
+	closed  bool
 }
 
+// NewHandler serves fs as the default export.
 func NewHandler(fs afero.Fs) *Handler {
+	return NewExportsHandler(map[string]afero.Fs{"": fs})
+}
+
+// NewExportsHandler serves each filesystem under its export name.
+func NewExportsHandler(exports map[string]afero.Fs) *Handler {
 	return &Handler{
-		fs: fs,
+		exports: exports,
+		files:   make(map[afero.File]struct{}),
+	}
+}
+
+// Close closes every file still opened by a client and refuses new Open
+// calls. Streams using those files fail on their next operation.
+func (h *Handler) Close() error {
+	h.filesMu.Lock()
+	defer h.filesMu.Unlock()
+
+	var err error
+	for fd := range h.files {
+		if e := fd.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	h.files = make(map[afero.File]struct{})
+	h.closed = true
+
+	return err
+}
+
+// OpenFiles returns the number of files currently opened by clients.
+func (h *Handler) OpenFiles() int {
+	h.filesMu.Lock()
+	defer h.filesMu.Unlock()
+
+	return len(h.files)
+}
+
+func (h *Handler) track(fd afero.File) error {
+	h.filesMu.Lock()
+	defer h.filesMu.Unlock()
+
+	if h.closed {
+		fd.Close()
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+
+	h.files[fd] = struct{}{}
+
+	return nil
+}
+
+// release closes fd unless Close already did.
+func (h *Handler) release(fd afero.File) {
+	h.filesMu.Lock()
+	defer h.filesMu.Unlock()
+
+	if _, ok := h.files[fd]; ok {
+		delete(h.files, fd)
+		fd.Close()
+	}
+}
+
+// SetExports replaces the set of exports served by the handler. Files already
+// opened keep working on the filesystem they were opened from.
+func (h *Handler) SetExports(exports map[string]afero.Fs) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.exports = exports
+}
+
+// SetTrashes enables the trash of the exports given, removed entries are
+// moved to it instead of being deleted. The filesystems of those exports
+// should be the ones returned by Trash.Fs.
+func (h *Handler) SetTrashes(trashes map[string]*Trash) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.trashes = trashes
+}
+
+// SetACLs restricts the principals allowed on the exports given, and on
+// their snapshots. Exports without an ACL are open to every client.
+func (h *Handler) SetACLs(acls map[string]*ACL) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.acls = acls
+}
+
+// authorize returns what the client of an incoming call may do on its
+// export, or PERMISSION_DENIED when it may not even read it. h.mu must be
+// held.
+func (h *Handler) authorize(ctx context.Context, export string) (Access, error) {
+	acl := h.acls[export]
+	if acl == nil {
+		return WriteAccess, nil
+	}
+
+	principal := PrincipalFromContext(ctx)
+	access := acl.Access(principal)
+	if access == NoAccess {
+		return NoAccess, status.Errorf(codes.PermissionDenied, "%q may not access export %q", principal, export)
+	}
+
+	return access, nil
+}
+
+// canWrite fails with PERMISSION_DENIED when the client of an incoming call
+// may not change its export.
+func (h *Handler) canWrite(ctx context.Context) error {
+	export, _ := h.Export(ctx)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	access, err := h.authorize(ctx, export)
+	if err == nil && access != WriteAccess {
+		err = status.Errorf(codes.PermissionDenied, "%q may not write to export %q", PrincipalFromContext(ctx), export)
+	}
+
+	return err
+}
+
+// getWritableFs returns the filesystem of the export of an incoming call,
+// when the client may change it.
+func (h *Handler) getWritableFs(ctx context.Context) (afero.Fs, error) {
+	fs, err := h.getFs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs, h.canWrite(ctx)
+}
+
+// getTrash returns the trash of the export of an incoming call, or nil when
+// the export has none.
+func (h *Handler) getTrash(ctx context.Context) *Trash {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.trashes[ExportFromContext(ctx)]
+}
+
+// SetVersions enables the version history of the exports given. The
+// filesystems of those exports should wrap their VersionFs.
+func (h *Handler) SetVersions(versions map[string]*VersionFs) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.versions = versions
+}
+
+func (h *Handler) getVersions(ctx context.Context) (*VersionFs, error) {
+	if _, err := h.getFs(ctx); err != nil {
+		return nil, err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	v := h.versions[ExportFromContext(ctx)]
+	if v == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "export %q has no version history", ExportFromContext(ctx))
+	}
+
+	return v, nil
+}
+
+// SetSnapshots enables the snapshots of the exports given. Each snapshot is
+// served read-only as the export "export@snapshot".
+func (h *Handler) SetSnapshots(snapshots map[string]*Snapshots) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.snapshots = snapshots
+}
+
+func (h *Handler) getSnapshots(ctx context.Context) (*Snapshots, error) {
+	if _, err := h.getFs(ctx); err != nil {
+		return nil, err
 	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s := h.snapshots[ExportFromContext(ctx)]
+	if s == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "export %q has no snapshots", ExportFromContext(ctx))
+	}
+
+	return s, nil
+}
+
+func (h *Handler) mustGetTrash(ctx context.Context) (*Trash, error) {
+	if _, err := h.getFs(ctx); err != nil {
+		return nil, err
+	}
+
+	t := h.getTrash(ctx)
+	if t == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "export %q has no trash", ExportFromContext(ctx))
+	}
+
+	return t, nil
+}
+
+// getFs returns the filesystem of the export of an incoming call, read-only
+// when the client may only read it.
+func (h *Handler) getFs(ctx context.Context) (afero.Fs, error) {
+	name := ExportFromContext(ctx)
+
+	export, snapshot := name, ""
+	i := strings.Index(name, "@")
+	if i >= 0 {
+		export, snapshot = name[:i], name[i+1:]
+	}
+
+	h.mu.RLock()
+	fs, ok := h.exports[export]
+	snapshots := h.snapshots[export]
+	access, err := h.authorize(ctx, export)
+	h.mu.RUnlock()
+
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown export %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if i >= 0 {
+		// Looking the snapshot up reads the disk, h.mu is not held
+		return getSnapshotFs(snapshots, export, snapshot)
+	}
+
+	if access != WriteAccess {
+		fs = afero.NewReadOnlyFs(fs)
+	}
+
+	return fs, nil
+}
+
+// getSnapshotFs returns the filesystem of a snapshot of export, among s.
+func getSnapshotFs(s *Snapshots, export, snapshot string) (afero.Fs, error) {
+	if s == nil {
+		return nil, status.Errorf(codes.NotFound, "unknown export %q", export+"@"+snapshot)
+	}
+
+	fs, err := s.Fs(snapshot)
+	if os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "unknown snapshot %q of export %q", snapshot, export)
+	}
+
+	return fs, err
+}
+
+// CallerFromContext returns the identity of the client of an incoming call,
+// taken from its certificate when the connection uses mutual TLS, and its
+// address.
+func CallerFromContext(ctx context.Context) (principal, addr string) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", ""
+	}
+
+	if p.Addr != nil {
+		addr = p.Addr.String()
+	}
+
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		if certs := info.State.PeerCertificates; len(certs) > 0 {
+			principal = certs[0].Subject.CommonName
+		}
+	}
+
+	return principal, addr
+}
+
+// ExportFromContext returns the export name carried by an incoming call.
+func ExportFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if v := md.Get(ExportKey); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+// Export returns the export a call is made to, without its snapshot, and
+// whether the handler serves it.
+func (h *Handler) Export(ctx context.Context) (string, bool) {
+	name := ExportFromContext(ctx)
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	_, ok := h.exports[name]
+
+	return name, ok
 }
 
 func (h *Handler) Stat(ctx context.Context, in *FileRequest) (*FileInfo, error) {
-	fi, err := h.fs.Stat(in.GetName())
+	fs, err := h.getFs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fs.Stat(in.GetName())
 	if err != nil {
 		return nil, err
 	}
@@ -38,50 +384,312 @@ func (h *Handler) Stat(ctx context.Context, in *FileRequest) (*FileInfo, error)
 }
 
 func (h *Handler) Chtimes(ctx context.Context, in *ChtimesRequest) (*ChtimesResponse, error) {
-	err := h.fs.Chtimes(in.Name, time.Unix(in.Added, 0), time.Unix(in.Modified, 0))
+	fs, err := h.getWritableFs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.Chtimes(in.Name, time.Unix(in.Added, 0), time.Unix(in.Modified, 0))
 
 	return &ChtimesResponse{}, err
 }
 
 func (h *Handler) Chmod(ctx context.Context, in *ChmodRequest) (*ChmodResponse, error) {
-	err := h.fs.Chmod(in.Name, os.FileMode(in.Mode))
+	fs, err := h.getWritableFs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.Chmod(in.Name, os.FileMode(in.Mode))
 
 	return &ChmodResponse{}, err
 }
 
 func (h *Handler) Mkdir(ctx context.Context, in *MkdirRequest) (*MkdirResponse, error) {
-	err := h.fs.Mkdir(in.Name, os.FileMode(in.Perm))
+	fs, err := h.getWritableFs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.Mkdir(in.Name, os.FileMode(in.Perm))
 
 	return &MkdirResponse{}, err
 }
 
 func (h *Handler) MkdirAll(ctx context.Context, in *MkdirAllRequest) (*MkdirAllResponse, error) {
-	err := h.fs.MkdirAll(in.Path, os.FileMode(in.Perm))
+	fs, err := h.getWritableFs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.MkdirAll(in.Path, os.FileMode(in.Perm))
 
 	return &MkdirAllResponse{}, err
 }
 
 func (h *Handler) Rename(ctx context.Context, in *RenameRequest) (*RenameResponse, error) {
-	err := h.fs.Rename(in.OldName, in.NewName)
+	fs, err := h.getWritableFs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.Rename(in.OldName, in.NewName)
 
 	return &RenameResponse{}, err
 }
 
 func (h *Handler) RemoveAll(ctx context.Context, in *RemoveAllRequest) (*RemoveAllResponse, error) {
-	err := h.fs.RemoveAll(in.Path)
+	fs, err := h.getWritableFs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if t := h.getTrash(ctx); t != nil {
+		err = t.Move(in.Path, deleter(ctx), true)
+	} else {
+		err = fs.RemoveAll(in.Path)
+	}
 
 	return &RemoveAllResponse{}, err
 }
 
 func (h *Handler) Remove(ctx context.Context, in *RemoveRequest) (*RemoveResponse, error) {
-	err := h.fs.Remove(in.Name)
+	fs, err := h.getWritableFs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if t := h.getTrash(ctx); t != nil {
+		err = t.Move(in.Name, deleter(ctx), false)
+	} else {
+		err = fs.Remove(in.Name)
+	}
 
 	return &RemoveResponse{}, err
 }
 
+// deleter identifies the client removing an entry in the trash.
+func deleter(ctx context.Context) string {
+	principal, addr := CallerFromContext(ctx)
+	if principal != "" {
+		return principal
+	}
+
+	return addr
+}
+
+func (h *Handler) ListTrash(ctx context.Context, in *ListTrashRequest) (*ListTrashResponse, error) {
+	t, err := h.mustGetTrash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := t.List()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ListTrashResponse{}
+	for _, info := range infos {
+		resp.Entries = append(resp.Entries, &TrashEntry{
+			Id:        info.ID,
+			Path:      info.Path,
+			DeletedBy: info.DeletedBy,
+			DeletedAt: info.DeletedAt.Unix(),
+			IsDir:     info.IsDir,
+			Size:      info.Size,
+		})
+	}
+
+	return resp, nil
+}
+
+func (h *Handler) RestoreTrash(ctx context.Context, in *RestoreTrashRequest) (*RestoreTrashResponse, error) {
+	t, err := h.mustGetTrash(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.canWrite(ctx); err != nil {
+		return nil, err
+	}
+
+	err = t.Restore(in.GetId(), in.GetPath())
+	if os.IsExist(err) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+
+	return &RestoreTrashResponse{}, err
+}
+
+func (h *Handler) PurgeTrash(ctx context.Context, in *PurgeTrashRequest) (*PurgeTrashResponse, error) {
+	t, err := h.mustGetTrash(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.canWrite(ctx); err != nil {
+		return nil, err
+	}
+
+	if in.GetAll() {
+		return &PurgeTrashResponse{}, t.PurgeAll()
+	}
+
+	for _, id := range in.GetIds() {
+		if err := t.Purge(id); err != nil {
+			return nil, err
+		}
+	}
+
+	return &PurgeTrashResponse{}, nil
+}
+
+func (h *Handler) ListVersions(ctx context.Context, in *ListVersionsRequest) (*ListVersionsResponse, error) {
+	v, err := h.getVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := v.Versions(in.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ListVersionsResponse{}
+	for _, ver := range versions {
+		resp.Versions = append(resp.Versions, &Version{
+			Id:      ver.ID,
+			Size:    ver.Size,
+			ModTime: ver.ModTime.Unix(),
+		})
+	}
+
+	return resp, nil
+}
+
+func (h *Handler) OpenVersion(in *OpenVersionRequest, stream FS_OpenVersionServer) error {
+	v, err := h.getVersions(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	fd, err := v.OpenVersion(in.GetName(), in.GetId())
+	if err != nil {
+		return getError(err)
+	}
+
+	if err := h.track(fd); err != nil {
+		return err
+	}
+	defer h.release(fd)
+
+	b := make([]byte, CHUNKSIZE)
+	for {
+		n, err := fd.Read(b)
+		if n > 0 {
+			if err := stream.Send(&ReadResponse{Content: b[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return getError(err)
+		}
+	}
+}
+
+func (h *Handler) RestoreVersion(ctx context.Context, in *RestoreVersionRequest) (*RestoreVersionResponse, error) {
+	v, err := h.getVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.canWrite(ctx); err != nil {
+		return nil, err
+	}
+
+	err = v.RestoreVersion(in.GetName(), in.GetId())
+
+	return &RestoreVersionResponse{}, err
+}
+
+func (h *Handler) CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest) (*CreateSnapshotResponse, error) {
+	s, err := h.getSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.canWrite(ctx); err != nil {
+		return nil, err
+	}
+
+	info, err := s.Create(in.GetName())
+	if os.IsExist(err) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	if errors.Is(err, os.ErrInvalid) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateSnapshotResponse{Snapshot: snapshotProto(info)}, nil
+}
+
+func (h *Handler) ListSnapshots(ctx context.Context, in *ListSnapshotsRequest) (*ListSnapshotsResponse, error) {
+	s, err := h.getSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ListSnapshotsResponse{}
+	for _, info := range infos {
+		resp.Snapshots = append(resp.Snapshots, snapshotProto(info))
+	}
+
+	return resp, nil
+}
+
+func (h *Handler) DeleteSnapshot(ctx context.Context, in *DeleteSnapshotRequest) (*DeleteSnapshotResponse, error) {
+	s, err := h.getSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.canWrite(ctx); err != nil {
+		return nil, err
+	}
+
+	err = s.Delete(in.GetName())
+	if os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "unknown snapshot %q", in.GetName())
+	}
+
+	return &DeleteSnapshotResponse{}, err
+}
+
+func snapshotProto(info *SnapshotInfo) *Snapshot {
+	return &Snapshot{
+		Name:      info.Name,
+		CreatedAt: info.CreatedAt.Unix(),
+		Method:    info.Method,
+		Files:     info.Files,
+		Size:      info.Size,
+	}
+}
+
 func (h *Handler) Open(stream FS_OpenServer) error {
 	var fd afero.File
 
+	fs, err := h.getFs(stream.Context())
+	if err != nil {
+		return err
+	}
+
 	for {
 		r, err := stream.Recv()
 
@@ -92,21 +700,25 @@ func (h *Handler) Open(stream FS_OpenServer) error {
 		switch r.Request.(type) {
 		case *FileRequest_Open:
 			if fd != nil {
-				fd.Close()
+				h.release(fd)
 			}
 
 			in := r.GetOpen()
 
-			fd, err = h.fs.OpenFile(in.GetName(), int(in.GetFlag()), os.FileMode(in.GetFileMode()))
+			fd, err = fs.OpenFile(in.GetName(), int(in.GetFlag()), os.FileMode(in.GetFileMode()))
 			if err != nil {
 				return getError(err)
 			}
 
+			if err := h.track(fd); err != nil {
+				return err
+			}
+
 			if err := stream.Send(&FileResponse{Response: &FileResponse_Open{Open: &OpenResponse{}}}); err != nil {
 				return getError(err)
 			}
 
-			defer fd.Close()
+			defer h.release(fd)
 		case *FileRequest_Stat:
 			fi, err := fd.Stat()
 			if err != nil {
@@ -127,7 +739,9 @@ func (h *Handler) Open(stream FS_OpenServer) error {
 				return getError(err)
 			}
 
-			stream.Send(nil)
+			if err := stream.Send(&FileResponse{Response: &FileResponse_Truncate{Truncate: &TruncateResponse{}}}); err != nil {
+				return getError(err)
+			}
 		case *FileRequest_Read:
 			b := make([]byte, CHUNKSIZE)
 			n, err := fd.Read(b)
@@ -139,7 +753,15 @@ func (h *Handler) Open(stream FS_OpenServer) error {
 				Content: b[:n],
 			}}})
 		case *FileRequest_ReadAt:
-			b := make([]byte, CHUNKSIZE)
+			size := r.GetReadAt().GetSize()
+			if size <= 0 {
+				size = CHUNKSIZE
+			}
+			if size > MaxReadSize {
+				size = MaxReadSize
+			}
+
+			b := make([]byte, size)
 			n, err := fd.ReadAt(b, r.GetReadAt().GetOffset())
 			if err != nil && err != io.EOF {
 				return getError(err)
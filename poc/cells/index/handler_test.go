@@ -0,0 +1,171 @@
+package index
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// openStream is an Open stream of a client sending reqs.
+type openStream struct {
+	grpc.ServerStream
+	reqs chan *FileRequest
+	sent chan *FileResponse
+}
+
+func newOpenStream() *openStream {
+	return &openStream{reqs: make(chan *FileRequest), sent: make(chan *FileResponse, 16)}
+}
+
+func (s *openStream) Context() context.Context { return context.Background() }
+
+func (s *openStream) Recv() (*FileRequest, error) {
+	r, ok := <-s.reqs
+	if !ok {
+		return nil, io.EOF
+	}
+
+	return r, nil
+}
+
+func (s *openStream) Send(r *FileResponse) error {
+	s.sent <- r
+	return nil
+}
+
+// open runs an Open stream on h for name, and returns once the file is
+// opened.
+func open(t *testing.T, h *Handler, name string) (*openStream, <-chan error) {
+	s := newOpenStream()
+	done := make(chan error, 1)
+	go func() { done <- h.Open(s) }()
+
+	s.reqs <- &FileRequest{Request: &FileRequest_Open{Open: &OpenRequest{Name: name, Flag: int64(os.O_RDONLY)}}}
+	select {
+	case <-s.sent:
+	case err := <-done:
+		t.Fatalf("expected %s to be opened, got %v", name, err)
+	}
+
+	return s, done
+}
+
+func TestHandlerClose(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/f.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(fs)
+
+	// Files are released when their stream ends
+	s, done := open(t, h, "/f.txt")
+	if n := h.OpenFiles(); n != 1 {
+		t.Fatalf("expected 1 open file, got %d", n)
+	}
+	close(s.reqs)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := h.OpenFiles(); n != 0 {
+		t.Fatalf("expected the file to be released, got %d open", n)
+	}
+
+	// Closing the handler closes the files of the streams still open
+	s, done = open(t, h, "/f.txt")
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := h.OpenFiles(); n != 0 {
+		t.Fatalf("expected the files to be closed, got %d open", n)
+	}
+
+	s.reqs <- &FileRequest{Request: &FileRequest_Read{Read: &ReadRequest{}}}
+	if err := <-done; err == nil {
+		t.Error("expected reading a closed file to fail")
+	}
+
+	// New files are refused
+	s = newOpenStream()
+	go func() {
+		s.reqs <- &FileRequest{Request: &FileRequest_Open{Open: &OpenRequest{Name: "/f.txt", Flag: int64(os.O_RDONLY)}}}
+	}()
+	if err := h.Open(s); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the handler to refuse new files, got %v", err)
+	}
+}
+
+// clientContext is the context of a call from ip to export.
+func clientContext(ip, export string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}})
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(ExportKey, export))
+}
+
+func TestHandlerACL(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/f.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewExportsHandler(map[string]afero.Fs{"photos": fs, "public": afero.NewMemMapFs()})
+	h.SetACLs(map[string]*ACL{"photos": {Read: []string{"10.0.0.2"}, Write: []string{"10.0.0.1"}}})
+
+	writer, reader, other := clientContext("10.0.0.1", "photos"), clientContext("10.0.0.2", "photos"), clientContext("10.0.0.3", "photos")
+
+	if _, err := h.Stat(reader, &FileRequest{Request: &FileRequest_Name{Name: "/f.txt"}}); err != nil {
+		t.Errorf("expected the reader to stat, got %v", err)
+	}
+	if _, err := h.Stat(other, &FileRequest{Request: &FileRequest_Name{Name: "/f.txt"}}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected other clients to be denied, got %v", err)
+	}
+
+	if _, err := h.Mkdir(reader, &MkdirRequest{Name: "/d", Perm: 0755}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected the reader not to write, got %v", err)
+	}
+	if _, err := h.Remove(reader, &RemoveRequest{Name: "/f.txt"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected the reader not to remove, got %v", err)
+	}
+	if _, err := h.Mkdir(writer, &MkdirRequest{Name: "/d", Perm: 0755}); err != nil {
+		t.Errorf("expected the writer to write, got %v", err)
+	}
+
+	// Files are opened read-only by readers
+	fsReader, err := h.getFs(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsReader.OpenFile("/f.txt", os.O_RDWR, 0); err == nil {
+		t.Error("expected the reader not to open the file for writing")
+	}
+	if f, err := fsReader.Open("/f.txt"); err != nil {
+		t.Errorf("expected the reader to open the file, got %v", err)
+	} else {
+		f.Close()
+	}
+
+	// Snapshots follow the ACL of their export, exports without one are
+	// open to all
+	if _, err := h.getFs(clientContext("10.0.0.3", "photos@daily")); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected the snapshots to be denied, got %v", err)
+	}
+	if _, err := h.Mkdir(clientContext("10.0.0.3", "public"), &MkdirRequest{Name: "/d", Perm: 0755}); err != nil {
+		t.Errorf("expected public to be open, got %v", err)
+	}
+
+	// Every client matches *
+	h.SetACLs(map[string]*ACL{"photos": {Read: []string{AnyPrincipal}}})
+	if _, err := h.Stat(other, &FileRequest{Request: &FileRequest_Name{Name: "/f.txt"}}); err != nil {
+		t.Errorf("expected every client to read, got %v", err)
+	}
+	if _, err := h.Chmod(writer, &ChmodRequest{Name: "/f.txt", Mode: 0600}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected nobody to write, got %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+package index
+
+import "github.com/spf13/afero"
+
+// HealthDir is the directory, at the root of an export, where the server
+// checks that the export still accepts writes. It is hidden from clients.
+const HealthDir = ".cells-health"
+
+// HideHealth returns fs, the view of an export given to clients, without
+// HealthDir.
+func HideHealth(fs afero.Fs) afero.Fs {
+	return &hiddenFs{Fs: fs, dir: HealthDir}
+}
@@ -49,7 +49,7 @@ func (x SeekRequest_Whence) String() string {
 }
 
 func (SeekRequest_Whence) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{15, 0}
+	return fileDescriptor_f750e0f7889345b5, []int{24, 0}
 }
 
 // Requests
@@ -589,6 +589,375 @@ func (m *RemoveRequest) GetName() string {
 	return ""
 }
 
+type ListTrashRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListTrashRequest) Reset()         { *m = ListTrashRequest{} }
+func (m *ListTrashRequest) String() string { return proto.CompactTextString(m) }
+func (*ListTrashRequest) ProtoMessage()    {}
+func (*ListTrashRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{8}
+}
+
+func (m *ListTrashRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListTrashRequest.Unmarshal(m, b)
+}
+func (m *ListTrashRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListTrashRequest.Marshal(b, m, deterministic)
+}
+func (m *ListTrashRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListTrashRequest.Merge(m, src)
+}
+func (m *ListTrashRequest) XXX_Size() int {
+	return xxx_messageInfo_ListTrashRequest.Size(m)
+}
+func (m *ListTrashRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListTrashRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListTrashRequest proto.InternalMessageInfo
+
+type RestoreTrashRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// path to restore the entry to, its original path when empty
+	Path                 string   `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RestoreTrashRequest) Reset()         { *m = RestoreTrashRequest{} }
+func (m *RestoreTrashRequest) String() string { return proto.CompactTextString(m) }
+func (*RestoreTrashRequest) ProtoMessage()    {}
+func (*RestoreTrashRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{9}
+}
+
+func (m *RestoreTrashRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RestoreTrashRequest.Unmarshal(m, b)
+}
+func (m *RestoreTrashRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RestoreTrashRequest.Marshal(b, m, deterministic)
+}
+func (m *RestoreTrashRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RestoreTrashRequest.Merge(m, src)
+}
+func (m *RestoreTrashRequest) XXX_Size() int {
+	return xxx_messageInfo_RestoreTrashRequest.Size(m)
+}
+func (m *RestoreTrashRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RestoreTrashRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RestoreTrashRequest proto.InternalMessageInfo
+
+func (m *RestoreTrashRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *RestoreTrashRequest) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+type PurgeTrashRequest struct {
+	Ids                  []string `protobuf:"bytes,1,rep,name=ids,proto3" json:"ids,omitempty"`
+	All                  bool     `protobuf:"varint,2,opt,name=all,proto3" json:"all,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PurgeTrashRequest) Reset()         { *m = PurgeTrashRequest{} }
+func (m *PurgeTrashRequest) String() string { return proto.CompactTextString(m) }
+func (*PurgeTrashRequest) ProtoMessage()    {}
+func (*PurgeTrashRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{10}
+}
+
+func (m *PurgeTrashRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PurgeTrashRequest.Unmarshal(m, b)
+}
+func (m *PurgeTrashRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PurgeTrashRequest.Marshal(b, m, deterministic)
+}
+func (m *PurgeTrashRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PurgeTrashRequest.Merge(m, src)
+}
+func (m *PurgeTrashRequest) XXX_Size() int {
+	return xxx_messageInfo_PurgeTrashRequest.Size(m)
+}
+func (m *PurgeTrashRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PurgeTrashRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PurgeTrashRequest proto.InternalMessageInfo
+
+func (m *PurgeTrashRequest) GetIds() []string {
+	if m != nil {
+		return m.Ids
+	}
+	return nil
+}
+
+func (m *PurgeTrashRequest) GetAll() bool {
+	if m != nil {
+		return m.All
+	}
+	return false
+}
+
+type ListVersionsRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListVersionsRequest) Reset()         { *m = ListVersionsRequest{} }
+func (m *ListVersionsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListVersionsRequest) ProtoMessage()    {}
+func (*ListVersionsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{11}
+}
+
+func (m *ListVersionsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListVersionsRequest.Unmarshal(m, b)
+}
+func (m *ListVersionsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListVersionsRequest.Marshal(b, m, deterministic)
+}
+func (m *ListVersionsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListVersionsRequest.Merge(m, src)
+}
+func (m *ListVersionsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListVersionsRequest.Size(m)
+}
+func (m *ListVersionsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListVersionsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListVersionsRequest proto.InternalMessageInfo
+
+func (m *ListVersionsRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type OpenVersionRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Id                   string   `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *OpenVersionRequest) Reset()         { *m = OpenVersionRequest{} }
+func (m *OpenVersionRequest) String() string { return proto.CompactTextString(m) }
+func (*OpenVersionRequest) ProtoMessage()    {}
+func (*OpenVersionRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{12}
+}
+
+func (m *OpenVersionRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_OpenVersionRequest.Unmarshal(m, b)
+}
+func (m *OpenVersionRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_OpenVersionRequest.Marshal(b, m, deterministic)
+}
+func (m *OpenVersionRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_OpenVersionRequest.Merge(m, src)
+}
+func (m *OpenVersionRequest) XXX_Size() int {
+	return xxx_messageInfo_OpenVersionRequest.Size(m)
+}
+func (m *OpenVersionRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_OpenVersionRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_OpenVersionRequest proto.InternalMessageInfo
+
+func (m *OpenVersionRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *OpenVersionRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type RestoreVersionRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Id                   string   `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RestoreVersionRequest) Reset()         { *m = RestoreVersionRequest{} }
+func (m *RestoreVersionRequest) String() string { return proto.CompactTextString(m) }
+func (*RestoreVersionRequest) ProtoMessage()    {}
+func (*RestoreVersionRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{13}
+}
+
+func (m *RestoreVersionRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RestoreVersionRequest.Unmarshal(m, b)
+}
+func (m *RestoreVersionRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RestoreVersionRequest.Marshal(b, m, deterministic)
+}
+func (m *RestoreVersionRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RestoreVersionRequest.Merge(m, src)
+}
+func (m *RestoreVersionRequest) XXX_Size() int {
+	return xxx_messageInfo_RestoreVersionRequest.Size(m)
+}
+func (m *RestoreVersionRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RestoreVersionRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RestoreVersionRequest proto.InternalMessageInfo
+
+func (m *RestoreVersionRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *RestoreVersionRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type CreateSnapshotRequest struct {
+	// name of the snapshot, generated from the current time when empty
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CreateSnapshotRequest) Reset()         { *m = CreateSnapshotRequest{} }
+func (m *CreateSnapshotRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateSnapshotRequest) ProtoMessage()    {}
+func (*CreateSnapshotRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{14}
+}
+
+func (m *CreateSnapshotRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateSnapshotRequest.Unmarshal(m, b)
+}
+func (m *CreateSnapshotRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateSnapshotRequest.Marshal(b, m, deterministic)
+}
+func (m *CreateSnapshotRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateSnapshotRequest.Merge(m, src)
+}
+func (m *CreateSnapshotRequest) XXX_Size() int {
+	return xxx_messageInfo_CreateSnapshotRequest.Size(m)
+}
+func (m *CreateSnapshotRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateSnapshotRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateSnapshotRequest proto.InternalMessageInfo
+
+func (m *CreateSnapshotRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type ListSnapshotsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListSnapshotsRequest) Reset()         { *m = ListSnapshotsRequest{} }
+func (m *ListSnapshotsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListSnapshotsRequest) ProtoMessage()    {}
+func (*ListSnapshotsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{15}
+}
+
+func (m *ListSnapshotsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListSnapshotsRequest.Unmarshal(m, b)
+}
+func (m *ListSnapshotsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListSnapshotsRequest.Marshal(b, m, deterministic)
+}
+func (m *ListSnapshotsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListSnapshotsRequest.Merge(m, src)
+}
+func (m *ListSnapshotsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListSnapshotsRequest.Size(m)
+}
+func (m *ListSnapshotsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListSnapshotsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListSnapshotsRequest proto.InternalMessageInfo
+
+type DeleteSnapshotRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteSnapshotRequest) Reset()         { *m = DeleteSnapshotRequest{} }
+func (m *DeleteSnapshotRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteSnapshotRequest) ProtoMessage()    {}
+func (*DeleteSnapshotRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{16}
+}
+
+func (m *DeleteSnapshotRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteSnapshotRequest.Unmarshal(m, b)
+}
+func (m *DeleteSnapshotRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteSnapshotRequest.Marshal(b, m, deterministic)
+}
+func (m *DeleteSnapshotRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteSnapshotRequest.Merge(m, src)
+}
+func (m *DeleteSnapshotRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteSnapshotRequest.Size(m)
+}
+func (m *DeleteSnapshotRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteSnapshotRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteSnapshotRequest proto.InternalMessageInfo
+
+func (m *DeleteSnapshotRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
 type OpenRequest struct {
 	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
 	Flag                 int64    `protobuf:"varint,2,opt,name=flag,proto3" json:"flag,omitempty"`
@@ -602,7 +971,7 @@ func (m *OpenRequest) Reset()         { *m = OpenRequest{} }
 func (m *OpenRequest) String() string { return proto.CompactTextString(m) }
 func (*OpenRequest) ProtoMessage()    {}
 func (*OpenRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{8}
+	return fileDescriptor_f750e0f7889345b5, []int{17}
 }
 
 func (m *OpenRequest) XXX_Unmarshal(b []byte) error {
@@ -654,7 +1023,7 @@ func (m *StatRequest) Reset()         { *m = StatRequest{} }
 func (m *StatRequest) String() string { return proto.CompactTextString(m) }
 func (*StatRequest) ProtoMessage()    {}
 func (*StatRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{9}
+	return fileDescriptor_f750e0f7889345b5, []int{18}
 }
 
 func (m *StatRequest) XXX_Unmarshal(b []byte) error {
@@ -686,7 +1055,7 @@ func (m *TruncateRequest) Reset()         { *m = TruncateRequest{} }
 func (m *TruncateRequest) String() string { return proto.CompactTextString(m) }
 func (*TruncateRequest) ProtoMessage()    {}
 func (*TruncateRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{10}
+	return fileDescriptor_f750e0f7889345b5, []int{19}
 }
 
 func (m *TruncateRequest) XXX_Unmarshal(b []byte) error {
@@ -724,7 +1093,7 @@ func (m *ReadRequest) Reset()         { *m = ReadRequest{} }
 func (m *ReadRequest) String() string { return proto.CompactTextString(m) }
 func (*ReadRequest) ProtoMessage()    {}
 func (*ReadRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{11}
+	return fileDescriptor_f750e0f7889345b5, []int{20}
 }
 
 func (m *ReadRequest) XXX_Unmarshal(b []byte) error {
@@ -746,7 +1115,9 @@ func (m *ReadRequest) XXX_DiscardUnknown() {
 var xxx_messageInfo_ReadRequest proto.InternalMessageInfo
 
 type ReadAtRequest struct {
-	Offset               int64    `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	Offset int64 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	// number of bytes wanted, CHUNKSIZE when zero, at most MaxReadSize
+	Size                 int64    `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -756,7 +1127,7 @@ func (m *ReadAtRequest) Reset()         { *m = ReadAtRequest{} }
 func (m *ReadAtRequest) String() string { return proto.CompactTextString(m) }
 func (*ReadAtRequest) ProtoMessage()    {}
 func (*ReadAtRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{12}
+	return fileDescriptor_f750e0f7889345b5, []int{21}
 }
 
 func (m *ReadAtRequest) XXX_Unmarshal(b []byte) error {
@@ -784,6 +1155,13 @@ func (m *ReadAtRequest) GetOffset() int64 {
 	return 0
 }
 
+func (m *ReadAtRequest) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
 type ReaddirRequest struct {
 	Count                int32    `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -795,7 +1173,7 @@ func (m *ReaddirRequest) Reset()         { *m = ReaddirRequest{} }
 func (m *ReaddirRequest) String() string { return proto.CompactTextString(m) }
 func (*ReaddirRequest) ProtoMessage()    {}
 func (*ReaddirRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{13}
+	return fileDescriptor_f750e0f7889345b5, []int{22}
 }
 
 func (m *ReaddirRequest) XXX_Unmarshal(b []byte) error {
@@ -834,7 +1212,7 @@ func (m *ReaddirnamesRequest) Reset()         { *m = ReaddirnamesRequest{} }
 func (m *ReaddirnamesRequest) String() string { return proto.CompactTextString(m) }
 func (*ReaddirnamesRequest) ProtoMessage()    {}
 func (*ReaddirnamesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{14}
+	return fileDescriptor_f750e0f7889345b5, []int{23}
 }
 
 func (m *ReaddirnamesRequest) XXX_Unmarshal(b []byte) error {
@@ -874,7 +1252,7 @@ func (m *SeekRequest) Reset()         { *m = SeekRequest{} }
 func (m *SeekRequest) String() string { return proto.CompactTextString(m) }
 func (*SeekRequest) ProtoMessage()    {}
 func (*SeekRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{15}
+	return fileDescriptor_f750e0f7889345b5, []int{24}
 }
 
 func (m *SeekRequest) XXX_Unmarshal(b []byte) error {
@@ -920,7 +1298,7 @@ func (m *WriteRequest) Reset()         { *m = WriteRequest{} }
 func (m *WriteRequest) String() string { return proto.CompactTextString(m) }
 func (*WriteRequest) ProtoMessage()    {}
 func (*WriteRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{16}
+	return fileDescriptor_f750e0f7889345b5, []int{25}
 }
 
 func (m *WriteRequest) XXX_Unmarshal(b []byte) error {
@@ -960,7 +1338,7 @@ func (m *WriteAtRequest) Reset()         { *m = WriteAtRequest{} }
 func (m *WriteAtRequest) String() string { return proto.CompactTextString(m) }
 func (*WriteAtRequest) ProtoMessage()    {}
 func (*WriteAtRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{17}
+	return fileDescriptor_f750e0f7889345b5, []int{26}
 }
 
 func (m *WriteAtRequest) XXX_Unmarshal(b []byte) error {
@@ -1011,7 +1389,7 @@ func (m *FileInfo) Reset()         { *m = FileInfo{} }
 func (m *FileInfo) String() string { return proto.CompactTextString(m) }
 func (*FileInfo) ProtoMessage()    {}
 func (*FileInfo) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{18}
+	return fileDescriptor_f750e0f7889345b5, []int{27}
 }
 
 func (m *FileInfo) XXX_Unmarshal(b []byte) error {
@@ -1077,7 +1455,7 @@ func (m *ChtimesResponse) Reset()         { *m = ChtimesResponse{} }
 func (m *ChtimesResponse) String() string { return proto.CompactTextString(m) }
 func (*ChtimesResponse) ProtoMessage()    {}
 func (*ChtimesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{19}
+	return fileDescriptor_f750e0f7889345b5, []int{28}
 }
 
 func (m *ChtimesResponse) XXX_Unmarshal(b []byte) error {
@@ -1108,7 +1486,7 @@ func (m *ChmodResponse) Reset()         { *m = ChmodResponse{} }
 func (m *ChmodResponse) String() string { return proto.CompactTextString(m) }
 func (*ChmodResponse) ProtoMessage()    {}
 func (*ChmodResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{20}
+	return fileDescriptor_f750e0f7889345b5, []int{29}
 }
 
 func (m *ChmodResponse) XXX_Unmarshal(b []byte) error {
@@ -1139,7 +1517,7 @@ func (m *MkdirResponse) Reset()         { *m = MkdirResponse{} }
 func (m *MkdirResponse) String() string { return proto.CompactTextString(m) }
 func (*MkdirResponse) ProtoMessage()    {}
 func (*MkdirResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{21}
+	return fileDescriptor_f750e0f7889345b5, []int{30}
 }
 
 func (m *MkdirResponse) XXX_Unmarshal(b []byte) error {
@@ -1170,7 +1548,7 @@ func (m *MkdirAllResponse) Reset()         { *m = MkdirAllResponse{} }
 func (m *MkdirAllResponse) String() string { return proto.CompactTextString(m) }
 func (*MkdirAllResponse) ProtoMessage()    {}
 func (*MkdirAllResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{22}
+	return fileDescriptor_f750e0f7889345b5, []int{31}
 }
 
 func (m *MkdirAllResponse) XXX_Unmarshal(b []byte) error {
@@ -1201,7 +1579,7 @@ func (m *RenameResponse) Reset()         { *m = RenameResponse{} }
 func (m *RenameResponse) String() string { return proto.CompactTextString(m) }
 func (*RenameResponse) ProtoMessage()    {}
 func (*RenameResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{23}
+	return fileDescriptor_f750e0f7889345b5, []int{32}
 }
 
 func (m *RenameResponse) XXX_Unmarshal(b []byte) error {
@@ -1232,7 +1610,7 @@ func (m *RemoveAllResponse) Reset()         { *m = RemoveAllResponse{} }
 func (m *RemoveAllResponse) String() string { return proto.CompactTextString(m) }
 func (*RemoveAllResponse) ProtoMessage()    {}
 func (*RemoveAllResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{24}
+	return fileDescriptor_f750e0f7889345b5, []int{33}
 }
 
 func (m *RemoveAllResponse) XXX_Unmarshal(b []byte) error {
@@ -1263,26 +1641,512 @@ func (m *RemoveResponse) Reset()         { *m = RemoveResponse{} }
 func (m *RemoveResponse) String() string { return proto.CompactTextString(m) }
 func (*RemoveResponse) ProtoMessage()    {}
 func (*RemoveResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{25}
+	return fileDescriptor_f750e0f7889345b5, []int{34}
+}
+
+func (m *RemoveResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RemoveResponse.Unmarshal(m, b)
+}
+func (m *RemoveResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RemoveResponse.Marshal(b, m, deterministic)
+}
+func (m *RemoveResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RemoveResponse.Merge(m, src)
+}
+func (m *RemoveResponse) XXX_Size() int {
+	return xxx_messageInfo_RemoveResponse.Size(m)
+}
+func (m *RemoveResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_RemoveResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RemoveResponse proto.InternalMessageInfo
+
+type TrashEntry struct {
+	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Path                 string   `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	DeletedBy            string   `protobuf:"bytes,3,opt,name=deletedBy,proto3" json:"deletedBy,omitempty"`
+	DeletedAt            int64    `protobuf:"varint,4,opt,name=deletedAt,proto3" json:"deletedAt,omitempty"`
+	IsDir                bool     `protobuf:"varint,5,opt,name=isDir,proto3" json:"isDir,omitempty"`
+	Size                 int64    `protobuf:"varint,6,opt,name=size,proto3" json:"size,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TrashEntry) Reset()         { *m = TrashEntry{} }
+func (m *TrashEntry) String() string { return proto.CompactTextString(m) }
+func (*TrashEntry) ProtoMessage()    {}
+func (*TrashEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{35}
+}
+
+func (m *TrashEntry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TrashEntry.Unmarshal(m, b)
+}
+func (m *TrashEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TrashEntry.Marshal(b, m, deterministic)
+}
+func (m *TrashEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TrashEntry.Merge(m, src)
+}
+func (m *TrashEntry) XXX_Size() int {
+	return xxx_messageInfo_TrashEntry.Size(m)
+}
+func (m *TrashEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_TrashEntry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TrashEntry proto.InternalMessageInfo
+
+func (m *TrashEntry) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *TrashEntry) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *TrashEntry) GetDeletedBy() string {
+	if m != nil {
+		return m.DeletedBy
+	}
+	return ""
+}
+
+func (m *TrashEntry) GetDeletedAt() int64 {
+	if m != nil {
+		return m.DeletedAt
+	}
+	return 0
+}
+
+func (m *TrashEntry) GetIsDir() bool {
+	if m != nil {
+		return m.IsDir
+	}
+	return false
+}
+
+func (m *TrashEntry) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+type ListTrashResponse struct {
+	Entries              []*TrashEntry `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *ListTrashResponse) Reset()         { *m = ListTrashResponse{} }
+func (m *ListTrashResponse) String() string { return proto.CompactTextString(m) }
+func (*ListTrashResponse) ProtoMessage()    {}
+func (*ListTrashResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{36}
+}
+
+func (m *ListTrashResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListTrashResponse.Unmarshal(m, b)
+}
+func (m *ListTrashResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListTrashResponse.Marshal(b, m, deterministic)
+}
+func (m *ListTrashResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListTrashResponse.Merge(m, src)
+}
+func (m *ListTrashResponse) XXX_Size() int {
+	return xxx_messageInfo_ListTrashResponse.Size(m)
+}
+func (m *ListTrashResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListTrashResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListTrashResponse proto.InternalMessageInfo
+
+func (m *ListTrashResponse) GetEntries() []*TrashEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+type RestoreTrashResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RestoreTrashResponse) Reset()         { *m = RestoreTrashResponse{} }
+func (m *RestoreTrashResponse) String() string { return proto.CompactTextString(m) }
+func (*RestoreTrashResponse) ProtoMessage()    {}
+func (*RestoreTrashResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{37}
+}
+
+func (m *RestoreTrashResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RestoreTrashResponse.Unmarshal(m, b)
+}
+func (m *RestoreTrashResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RestoreTrashResponse.Marshal(b, m, deterministic)
+}
+func (m *RestoreTrashResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RestoreTrashResponse.Merge(m, src)
+}
+func (m *RestoreTrashResponse) XXX_Size() int {
+	return xxx_messageInfo_RestoreTrashResponse.Size(m)
+}
+func (m *RestoreTrashResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_RestoreTrashResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RestoreTrashResponse proto.InternalMessageInfo
+
+type PurgeTrashResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PurgeTrashResponse) Reset()         { *m = PurgeTrashResponse{} }
+func (m *PurgeTrashResponse) String() string { return proto.CompactTextString(m) }
+func (*PurgeTrashResponse) ProtoMessage()    {}
+func (*PurgeTrashResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{38}
+}
+
+func (m *PurgeTrashResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PurgeTrashResponse.Unmarshal(m, b)
+}
+func (m *PurgeTrashResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PurgeTrashResponse.Marshal(b, m, deterministic)
+}
+func (m *PurgeTrashResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PurgeTrashResponse.Merge(m, src)
+}
+func (m *PurgeTrashResponse) XXX_Size() int {
+	return xxx_messageInfo_PurgeTrashResponse.Size(m)
+}
+func (m *PurgeTrashResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PurgeTrashResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PurgeTrashResponse proto.InternalMessageInfo
+
+type Version struct {
+	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Size                 int64    `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	ModTime              int64    `protobuf:"varint,3,opt,name=modTime,proto3" json:"modTime,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Version) Reset()         { *m = Version{} }
+func (m *Version) String() string { return proto.CompactTextString(m) }
+func (*Version) ProtoMessage()    {}
+func (*Version) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{39}
+}
+
+func (m *Version) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Version.Unmarshal(m, b)
+}
+func (m *Version) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Version.Marshal(b, m, deterministic)
+}
+func (m *Version) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Version.Merge(m, src)
+}
+func (m *Version) XXX_Size() int {
+	return xxx_messageInfo_Version.Size(m)
+}
+func (m *Version) XXX_DiscardUnknown() {
+	xxx_messageInfo_Version.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Version proto.InternalMessageInfo
+
+func (m *Version) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *Version) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *Version) GetModTime() int64 {
+	if m != nil {
+		return m.ModTime
+	}
+	return 0
+}
+
+type ListVersionsResponse struct {
+	Versions             []*Version `protobuf:"bytes,1,rep,name=versions,proto3" json:"versions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *ListVersionsResponse) Reset()         { *m = ListVersionsResponse{} }
+func (m *ListVersionsResponse) String() string { return proto.CompactTextString(m) }
+func (*ListVersionsResponse) ProtoMessage()    {}
+func (*ListVersionsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{40}
+}
+
+func (m *ListVersionsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListVersionsResponse.Unmarshal(m, b)
+}
+func (m *ListVersionsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListVersionsResponse.Marshal(b, m, deterministic)
+}
+func (m *ListVersionsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListVersionsResponse.Merge(m, src)
+}
+func (m *ListVersionsResponse) XXX_Size() int {
+	return xxx_messageInfo_ListVersionsResponse.Size(m)
+}
+func (m *ListVersionsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListVersionsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListVersionsResponse proto.InternalMessageInfo
+
+func (m *ListVersionsResponse) GetVersions() []*Version {
+	if m != nil {
+		return m.Versions
+	}
+	return nil
+}
+
+type RestoreVersionResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RestoreVersionResponse) Reset()         { *m = RestoreVersionResponse{} }
+func (m *RestoreVersionResponse) String() string { return proto.CompactTextString(m) }
+func (*RestoreVersionResponse) ProtoMessage()    {}
+func (*RestoreVersionResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{41}
+}
+
+func (m *RestoreVersionResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RestoreVersionResponse.Unmarshal(m, b)
+}
+func (m *RestoreVersionResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RestoreVersionResponse.Marshal(b, m, deterministic)
+}
+func (m *RestoreVersionResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RestoreVersionResponse.Merge(m, src)
+}
+func (m *RestoreVersionResponse) XXX_Size() int {
+	return xxx_messageInfo_RestoreVersionResponse.Size(m)
+}
+func (m *RestoreVersionResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_RestoreVersionResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RestoreVersionResponse proto.InternalMessageInfo
+
+type Snapshot struct {
+	Name      string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	CreatedAt int64  `protobuf:"varint,2,opt,name=createdAt,proto3" json:"createdAt,omitempty"`
+	// how files were captured: reflink, hardlink or copy
+	Method               string   `protobuf:"bytes,3,opt,name=method,proto3" json:"method,omitempty"`
+	Files                int64    `protobuf:"varint,4,opt,name=files,proto3" json:"files,omitempty"`
+	Size                 int64    `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Snapshot) Reset()         { *m = Snapshot{} }
+func (m *Snapshot) String() string { return proto.CompactTextString(m) }
+func (*Snapshot) ProtoMessage()    {}
+func (*Snapshot) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{42}
+}
+
+func (m *Snapshot) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Snapshot.Unmarshal(m, b)
+}
+func (m *Snapshot) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Snapshot.Marshal(b, m, deterministic)
+}
+func (m *Snapshot) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Snapshot.Merge(m, src)
+}
+func (m *Snapshot) XXX_Size() int {
+	return xxx_messageInfo_Snapshot.Size(m)
+}
+func (m *Snapshot) XXX_DiscardUnknown() {
+	xxx_messageInfo_Snapshot.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Snapshot proto.InternalMessageInfo
+
+func (m *Snapshot) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Snapshot) GetCreatedAt() int64 {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return 0
+}
+
+func (m *Snapshot) GetMethod() string {
+	if m != nil {
+		return m.Method
+	}
+	return ""
+}
+
+func (m *Snapshot) GetFiles() int64 {
+	if m != nil {
+		return m.Files
+	}
+	return 0
+}
+
+func (m *Snapshot) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+type CreateSnapshotResponse struct {
+	Snapshot             *Snapshot `protobuf:"bytes,1,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *CreateSnapshotResponse) Reset()         { *m = CreateSnapshotResponse{} }
+func (m *CreateSnapshotResponse) String() string { return proto.CompactTextString(m) }
+func (*CreateSnapshotResponse) ProtoMessage()    {}
+func (*CreateSnapshotResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{43}
+}
+
+func (m *CreateSnapshotResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateSnapshotResponse.Unmarshal(m, b)
+}
+func (m *CreateSnapshotResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateSnapshotResponse.Marshal(b, m, deterministic)
+}
+func (m *CreateSnapshotResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateSnapshotResponse.Merge(m, src)
+}
+func (m *CreateSnapshotResponse) XXX_Size() int {
+	return xxx_messageInfo_CreateSnapshotResponse.Size(m)
+}
+func (m *CreateSnapshotResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateSnapshotResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateSnapshotResponse proto.InternalMessageInfo
+
+func (m *CreateSnapshotResponse) GetSnapshot() *Snapshot {
+	if m != nil {
+		return m.Snapshot
+	}
+	return nil
+}
+
+type ListSnapshotsResponse struct {
+	Snapshots            []*Snapshot `protobuf:"bytes,1,rep,name=snapshots,proto3" json:"snapshots,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *ListSnapshotsResponse) Reset()         { *m = ListSnapshotsResponse{} }
+func (m *ListSnapshotsResponse) String() string { return proto.CompactTextString(m) }
+func (*ListSnapshotsResponse) ProtoMessage()    {}
+func (*ListSnapshotsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{44}
+}
+
+func (m *ListSnapshotsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListSnapshotsResponse.Unmarshal(m, b)
+}
+func (m *ListSnapshotsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListSnapshotsResponse.Marshal(b, m, deterministic)
+}
+func (m *ListSnapshotsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListSnapshotsResponse.Merge(m, src)
+}
+func (m *ListSnapshotsResponse) XXX_Size() int {
+	return xxx_messageInfo_ListSnapshotsResponse.Size(m)
+}
+func (m *ListSnapshotsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListSnapshotsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListSnapshotsResponse proto.InternalMessageInfo
+
+func (m *ListSnapshotsResponse) GetSnapshots() []*Snapshot {
+	if m != nil {
+		return m.Snapshots
+	}
+	return nil
+}
+
+type DeleteSnapshotResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *RemoveResponse) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_RemoveResponse.Unmarshal(m, b)
+func (m *DeleteSnapshotResponse) Reset()         { *m = DeleteSnapshotResponse{} }
+func (m *DeleteSnapshotResponse) String() string { return proto.CompactTextString(m) }
+func (*DeleteSnapshotResponse) ProtoMessage()    {}
+func (*DeleteSnapshotResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{45}
 }
-func (m *RemoveResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_RemoveResponse.Marshal(b, m, deterministic)
+
+func (m *DeleteSnapshotResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteSnapshotResponse.Unmarshal(m, b)
 }
-func (m *RemoveResponse) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_RemoveResponse.Merge(m, src)
+func (m *DeleteSnapshotResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteSnapshotResponse.Marshal(b, m, deterministic)
 }
-func (m *RemoveResponse) XXX_Size() int {
-	return xxx_messageInfo_RemoveResponse.Size(m)
+func (m *DeleteSnapshotResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteSnapshotResponse.Merge(m, src)
 }
-func (m *RemoveResponse) XXX_DiscardUnknown() {
-	xxx_messageInfo_RemoveResponse.DiscardUnknown(m)
+func (m *DeleteSnapshotResponse) XXX_Size() int {
+	return xxx_messageInfo_DeleteSnapshotResponse.Size(m)
+}
+func (m *DeleteSnapshotResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteSnapshotResponse.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_RemoveResponse proto.InternalMessageInfo
+var xxx_messageInfo_DeleteSnapshotResponse proto.InternalMessageInfo
 
 type FileResponse struct {
 	// Types that are valid to be assigned to Response:
@@ -1293,6 +2157,7 @@ type FileResponse struct {
 	//	*FileResponse_Readdirnames
 	//	*FileResponse_Seek
 	//	*FileResponse_Write
+	//	*FileResponse_Truncate
 	Response             isFileResponse_Response `protobuf_oneof:"Response"`
 	XXX_NoUnkeyedLiteral struct{}                `json:"-"`
 	XXX_unrecognized     []byte                  `json:"-"`
@@ -1303,7 +2168,7 @@ func (m *FileResponse) Reset()         { *m = FileResponse{} }
 func (m *FileResponse) String() string { return proto.CompactTextString(m) }
 func (*FileResponse) ProtoMessage()    {}
 func (*FileResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{26}
+	return fileDescriptor_f750e0f7889345b5, []int{46}
 }
 
 func (m *FileResponse) XXX_Unmarshal(b []byte) error {
@@ -1356,6 +2221,10 @@ type FileResponse_Write struct {
 	Write *WriteResponse `protobuf:"bytes,7,opt,name=write,proto3,oneof"`
 }
 
+type FileResponse_Truncate struct {
+	Truncate *TruncateResponse `protobuf:"bytes,8,opt,name=truncate,proto3,oneof"`
+}
+
 func (*FileResponse_Open) isFileResponse_Response() {}
 
 func (*FileResponse_FileInfo) isFileResponse_Response() {}
@@ -1370,6 +2239,8 @@ func (*FileResponse_Seek) isFileResponse_Response() {}
 
 func (*FileResponse_Write) isFileResponse_Response() {}
 
+func (*FileResponse_Truncate) isFileResponse_Response() {}
+
 func (m *FileResponse) GetResponse() isFileResponse_Response {
 	if m != nil {
 		return m.Response
@@ -1426,6 +2297,13 @@ func (m *FileResponse) GetWrite() *WriteResponse {
 	return nil
 }
 
+func (m *FileResponse) GetTruncate() *TruncateResponse {
+	if x, ok := m.GetResponse().(*FileResponse_Truncate); ok {
+		return x.Truncate
+	}
+	return nil
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*FileResponse) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
@@ -1436,6 +2314,7 @@ func (*FileResponse) XXX_OneofWrappers() []interface{} {
 		(*FileResponse_Readdirnames)(nil),
 		(*FileResponse_Seek)(nil),
 		(*FileResponse_Write)(nil),
+		(*FileResponse_Truncate)(nil),
 	}
 }
 
@@ -1449,7 +2328,7 @@ func (m *OpenResponse) Reset()         { *m = OpenResponse{} }
 func (m *OpenResponse) String() string { return proto.CompactTextString(m) }
 func (*OpenResponse) ProtoMessage()    {}
 func (*OpenResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{27}
+	return fileDescriptor_f750e0f7889345b5, []int{47}
 }
 
 func (m *OpenResponse) XXX_Unmarshal(b []byte) error {
@@ -1470,6 +2349,37 @@ func (m *OpenResponse) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_OpenResponse proto.InternalMessageInfo
 
+type TruncateResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TruncateResponse) Reset()         { *m = TruncateResponse{} }
+func (m *TruncateResponse) String() string { return proto.CompactTextString(m) }
+func (*TruncateResponse) ProtoMessage()    {}
+func (*TruncateResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f750e0f7889345b5, []int{48}
+}
+
+func (m *TruncateResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TruncateResponse.Unmarshal(m, b)
+}
+func (m *TruncateResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TruncateResponse.Marshal(b, m, deterministic)
+}
+func (m *TruncateResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TruncateResponse.Merge(m, src)
+}
+func (m *TruncateResponse) XXX_Size() int {
+	return xxx_messageInfo_TruncateResponse.Size(m)
+}
+func (m *TruncateResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_TruncateResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TruncateResponse proto.InternalMessageInfo
+
 type ReadResponse struct {
 	Content              []byte   `protobuf:"bytes,1,opt,name=content,proto3" json:"content,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -1481,7 +2391,7 @@ func (m *ReadResponse) Reset()         { *m = ReadResponse{} }
 func (m *ReadResponse) String() string { return proto.CompactTextString(m) }
 func (*ReadResponse) ProtoMessage()    {}
 func (*ReadResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{28}
+	return fileDescriptor_f750e0f7889345b5, []int{49}
 }
 
 func (m *ReadResponse) XXX_Unmarshal(b []byte) error {
@@ -1520,7 +2430,7 @@ func (m *ReaddirResponse) Reset()         { *m = ReaddirResponse{} }
 func (m *ReaddirResponse) String() string { return proto.CompactTextString(m) }
 func (*ReaddirResponse) ProtoMessage()    {}
 func (*ReaddirResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{29}
+	return fileDescriptor_f750e0f7889345b5, []int{50}
 }
 
 func (m *ReaddirResponse) XXX_Unmarshal(b []byte) error {
@@ -1559,7 +2469,7 @@ func (m *ReaddirnamesResponse) Reset()         { *m = ReaddirnamesResponse{} }
 func (m *ReaddirnamesResponse) String() string { return proto.CompactTextString(m) }
 func (*ReaddirnamesResponse) ProtoMessage()    {}
 func (*ReaddirnamesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{30}
+	return fileDescriptor_f750e0f7889345b5, []int{51}
 }
 
 func (m *ReaddirnamesResponse) XXX_Unmarshal(b []byte) error {
@@ -1598,7 +2508,7 @@ func (m *SeekResponse) Reset()         { *m = SeekResponse{} }
 func (m *SeekResponse) String() string { return proto.CompactTextString(m) }
 func (*SeekResponse) ProtoMessage()    {}
 func (*SeekResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{31}
+	return fileDescriptor_f750e0f7889345b5, []int{52}
 }
 
 func (m *SeekResponse) XXX_Unmarshal(b []byte) error {
@@ -1637,7 +2547,7 @@ func (m *WriteResponse) Reset()         { *m = WriteResponse{} }
 func (m *WriteResponse) String() string { return proto.CompactTextString(m) }
 func (*WriteResponse) ProtoMessage()    {}
 func (*WriteResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f750e0f7889345b5, []int{32}
+	return fileDescriptor_f750e0f7889345b5, []int{53}
 }
 
 func (m *WriteResponse) XXX_Unmarshal(b []byte) error {
@@ -1675,6 +2585,15 @@ func init() {
 	proto.RegisterType((*RenameRequest)(nil), "index.RenameRequest")
 	proto.RegisterType((*RemoveAllRequest)(nil), "index.RemoveAllRequest")
 	proto.RegisterType((*RemoveRequest)(nil), "index.RemoveRequest")
+	proto.RegisterType((*ListTrashRequest)(nil), "index.ListTrashRequest")
+	proto.RegisterType((*RestoreTrashRequest)(nil), "index.RestoreTrashRequest")
+	proto.RegisterType((*PurgeTrashRequest)(nil), "index.PurgeTrashRequest")
+	proto.RegisterType((*ListVersionsRequest)(nil), "index.ListVersionsRequest")
+	proto.RegisterType((*OpenVersionRequest)(nil), "index.OpenVersionRequest")
+	proto.RegisterType((*RestoreVersionRequest)(nil), "index.RestoreVersionRequest")
+	proto.RegisterType((*CreateSnapshotRequest)(nil), "index.CreateSnapshotRequest")
+	proto.RegisterType((*ListSnapshotsRequest)(nil), "index.ListSnapshotsRequest")
+	proto.RegisterType((*DeleteSnapshotRequest)(nil), "index.DeleteSnapshotRequest")
 	proto.RegisterType((*OpenRequest)(nil), "index.OpenRequest")
 	proto.RegisterType((*StatRequest)(nil), "index.StatRequest")
 	proto.RegisterType((*TruncateRequest)(nil), "index.TruncateRequest")
@@ -1693,8 +2612,20 @@ func init() {
 	proto.RegisterType((*RenameResponse)(nil), "index.RenameResponse")
 	proto.RegisterType((*RemoveAllResponse)(nil), "index.RemoveAllResponse")
 	proto.RegisterType((*RemoveResponse)(nil), "index.RemoveResponse")
+	proto.RegisterType((*TrashEntry)(nil), "index.TrashEntry")
+	proto.RegisterType((*ListTrashResponse)(nil), "index.ListTrashResponse")
+	proto.RegisterType((*RestoreTrashResponse)(nil), "index.RestoreTrashResponse")
+	proto.RegisterType((*PurgeTrashResponse)(nil), "index.PurgeTrashResponse")
+	proto.RegisterType((*Version)(nil), "index.Version")
+	proto.RegisterType((*ListVersionsResponse)(nil), "index.ListVersionsResponse")
+	proto.RegisterType((*RestoreVersionResponse)(nil), "index.RestoreVersionResponse")
+	proto.RegisterType((*Snapshot)(nil), "index.Snapshot")
+	proto.RegisterType((*CreateSnapshotResponse)(nil), "index.CreateSnapshotResponse")
+	proto.RegisterType((*ListSnapshotsResponse)(nil), "index.ListSnapshotsResponse")
+	proto.RegisterType((*DeleteSnapshotResponse)(nil), "index.DeleteSnapshotResponse")
 	proto.RegisterType((*FileResponse)(nil), "index.FileResponse")
 	proto.RegisterType((*OpenResponse)(nil), "index.OpenResponse")
+	proto.RegisterType((*TruncateResponse)(nil), "index.TruncateResponse")
 	proto.RegisterType((*ReadResponse)(nil), "index.ReadResponse")
 	proto.RegisterType((*ReaddirResponse)(nil), "index.ReaddirResponse")
 	proto.RegisterType((*ReaddirnamesResponse)(nil), "index.ReaddirnamesResponse")
@@ -1707,71 +2638,104 @@ func init() {
 }
 
 var fileDescriptor_f750e0f7889345b5 = []byte{
-	// 1022 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x56, 0x5d, 0x73, 0x1b, 0x35,
-	0x14, 0xed, 0x7a, 0xed, 0xb5, 0x7d, 0xfd, 0x59, 0xd9, 0x4d, 0x17, 0xf3, 0x92, 0x11, 0x43, 0x70,
-	0x49, 0xc9, 0x50, 0x07, 0x18, 0x18, 0x66, 0x3a, 0x38, 0x81, 0x0e, 0x3c, 0xa4, 0x01, 0xd5, 0x90,
-	0xe7, 0x6d, 0x56, 0x26, 0x3b, 0xb1, 0x77, 0x8d, 0x77, 0xd3, 0x00, 0xcf, 0xfc, 0x01, 0x7e, 0x29,
-	0x0f, 0xfc, 0x81, 0xce, 0x95, 0xb4, 0xfa, 0x70, 0x6c, 0x3f, 0x59, 0xba, 0x7b, 0xce, 0x95, 0x74,
-	0x75, 0x8f, 0x8e, 0xa1, 0x95, 0xa4, 0x31, 0xff, 0xf3, 0x64, 0xb5, 0xce, 0x8a, 0x8c, 0xd4, 0xc4,
-	0x84, 0xfe, 0x5b, 0x85, 0xd6, 0xab, 0x64, 0xc1, 0x19, 0xff, 0xe3, 0x8e, 0xe7, 0x05, 0x19, 0x42,
-	0x35, 0x8d, 0x96, 0x3c, 0xf4, 0x0e, 0xbd, 0x71, 0xf3, 0xc7, 0x47, 0x4c, 0xcc, 0xc8, 0x18, 0xaa,
-	0xd9, 0x8a, 0xa7, 0x61, 0xe5, 0xd0, 0x1b, 0xb7, 0x26, 0xe4, 0x44, 0x26, 0xba, 0x5c, 0xf1, 0x54,
-	0xf1, 0x10, 0x89, 0x08, 0x44, 0xe6, 0x45, 0x54, 0x84, 0xbe, 0x83, 0x7c, 0x53, 0x44, 0x85, 0x85,
-	0x44, 0x04, 0xf9, 0x02, 0x1a, 0xc5, 0xfa, 0x2e, 0xbd, 0x8e, 0x0a, 0x1e, 0x56, 0x05, 0xfa, 0x40,
-	0xa1, 0x67, 0x2a, 0x6c, 0x18, 0x1a, 0x89, 0xf9, 0xd7, 0x3c, 0x8a, 0xc3, 0x9a, 0x93, 0x9f, 0xf1,
-	0x28, 0xb6, 0xf2, 0x23, 0x82, 0x9c, 0x40, 0x80, 0xbf, 0xd3, 0x22, 0x0c, 0x04, 0x76, 0x68, 0x61,
-	0xa7, 0xd6, 0x6e, 0x14, 0x8a, 0xbc, 0x80, 0x3a, 0x8e, 0xe2, 0x64, 0x1d, 0xd6, 0x05, 0xe1, 0x89,
-	0x45, 0x88, 0x93, 0xb5, 0x61, 0x94, 0x38, 0xf2, 0x1d, 0xb4, 0xd5, 0x10, 0xab, 0x94, 0x87, 0x0d,
-	0xc1, 0x1b, 0xb9, 0x3c, 0xf1, 0xc9, 0x90, 0x1d, 0x86, 0x28, 0x17, 0xe7, 0xb7, 0x61, 0xd3, 0x2d,
-	0x17, 0xe7, 0xb7, 0x76, 0xb9, 0x38, 0xbf, 0x25, 0xc7, 0x50, 0xbb, 0x5f, 0x27, 0x05, 0x0f, 0x41,
-	0x40, 0x07, 0x0a, 0x7a, 0x85, 0x31, 0x83, 0x95, 0x18, 0x3c, 0x8b, 0x18, 0x4c, 0x8b, 0xb0, 0xe5,
-	0x9c, 0xe5, 0x4a, 0x46, 0xad, 0xb3, 0x28, 0xdc, 0x59, 0x13, 0xea, 0x2a, 0x4a, 0x7f, 0x83, 0xee,
-	0xf9, 0x4d, 0x91, 0x98, 0x6d, 0x13, 0x62, 0x77, 0x85, 0xea, 0x89, 0x21, 0xd4, 0xa2, 0x38, 0xe6,
-	0xb1, 0x68, 0x0a, 0x9f, 0xc9, 0x09, 0x19, 0x41, 0x63, 0x99, 0xc5, 0xc9, 0x3c, 0xe1, 0xb1, 0xe8,
-	0x01, 0x9f, 0xe9, 0x39, 0xfd, 0x0a, 0xda, 0xe7, 0x37, 0xcb, 0x2c, 0xde, 0x97, 0x95, 0x40, 0x75,
-	0x99, 0xc5, 0x5c, 0x24, 0xed, 0x30, 0x31, 0x46, 0xde, 0xc5, 0xad, 0xb9, 0x81, 0x5d, 0xbc, 0x15,
-	0x5f, 0x2f, 0x4b, 0x1e, 0x8e, 0xe9, 0x37, 0xd0, 0x13, 0xbc, 0xe9, 0x62, 0x61, 0x51, 0x57, 0x51,
-	0x71, 0x53, 0x52, 0x71, 0xbc, 0x95, 0x7a, 0x0e, 0x1d, 0xc6, 0x31, 0x71, 0x49, 0x0c, 0xa1, 0x9e,
-	0x2d, 0xe2, 0xd7, 0x66, 0xd9, 0x72, 0x8a, 0x5f, 0x52, 0x7e, 0x2f, 0xbe, 0x54, 0xe4, 0x17, 0x35,
-	0xa5, 0x47, 0xd0, 0x67, 0x7c, 0x99, 0xbd, 0xe3, 0xfb, 0x37, 0x40, 0x3f, 0xc2, 0xc5, 0x10, 0xb7,
-	0xe7, 0x80, 0xf4, 0x17, 0x68, 0x59, 0x7a, 0xdb, 0x55, 0x83, 0xf9, 0x22, 0xfa, 0x5d, 0x5d, 0x88,
-	0x18, 0xe3, 0x7d, 0xcc, 0x93, 0x05, 0xbf, 0xc0, 0x9a, 0xfa, 0xe2, 0x80, 0x7a, 0x4e, 0x3b, 0xd0,
-	0xb2, 0x84, 0x49, 0x3f, 0x86, 0xde, 0x86, 0xf2, 0x30, 0x63, 0x9e, 0xfc, 0x2d, 0x57, 0xf1, 0x99,
-	0x18, 0x23, 0xcb, 0x92, 0x1b, 0xfd, 0x04, 0x37, 0x6f, 0x29, 0x8a, 0x1c, 0x40, 0x90, 0xcd, 0xe7,
-	0x39, 0x2f, 0x14, 0x4b, 0xcd, 0xe8, 0x11, 0x74, 0x5d, 0x25, 0x61, 0x07, 0x5d, 0x67, 0x77, 0xa9,
-	0x04, 0xd6, 0x98, 0x9c, 0xd0, 0x63, 0x18, 0x6c, 0x51, 0xce, 0x0e, 0xf0, 0x3f, 0x1e, 0xb4, 0x2c,
-	0xb5, 0xec, 0x5a, 0x9c, 0xbc, 0x80, 0xe0, 0xfe, 0x86, 0xa7, 0xd7, 0xf2, 0x8e, 0xba, 0x93, 0x0f,
-	0x1e, 0x2a, 0xed, 0xe4, 0x4a, 0x00, 0x98, 0x02, 0xd2, 0x4f, 0x21, 0x90, 0x11, 0x52, 0x07, 0x7f,
-	0x76, 0xf9, 0x73, 0xff, 0x11, 0x69, 0x41, 0xfd, 0xfc, 0x57, 0xc6, 0x7e, 0x78, 0x3d, 0xeb, 0x7b,
-	0x04, 0x20, 0x38, 0xbb, 0x9c, 0xcd, 0x2e, 0x2f, 0xfa, 0x15, 0x3a, 0x86, 0xb6, 0x2d, 0x44, 0xec,
-	0x89, 0xeb, 0x2c, 0x2d, 0xb8, 0xda, 0x6e, 0x9b, 0x95, 0x53, 0x7a, 0x06, 0x5d, 0x57, 0x83, 0x3b,
-	0xb7, 0x6c, 0xe5, 0xa8, 0xb8, 0x39, 0xde, 0x41, 0x03, 0x9f, 0xec, 0x9f, 0xd2, 0x79, 0xb6, 0xab,
-	0x0f, 0xc4, 0xad, 0x55, 0xcc, 0xad, 0x69, 0x5d, 0xf9, 0x46, 0x57, 0xb8, 0xc2, 0x32, 0x8b, 0x67,
-	0xc9, 0x52, 0x3e, 0xc0, 0x3e, 0x2b, 0xa7, 0x58, 0xec, 0x24, 0xff, 0x3e, 0x59, 0x8b, 0x67, 0xb6,
-	0xc1, 0xe4, 0x84, 0x3e, 0x86, 0x9e, 0x7e, 0x17, 0xf2, 0x55, 0x96, 0xe6, 0x9c, 0xf6, 0xa0, 0xa3,
-	0x24, 0x6d, 0x02, 0x4a, 0xab, 0x2a, 0x40, 0xa0, 0x6f, 0x44, 0xa8, 0x62, 0x7d, 0x6c, 0x05, 0xa9,
-	0x2e, 0x15, 0x19, 0xc0, 0x63, 0x4b, 0x2a, 0x36, 0x4c, 0xea, 0x42, 0x45, 0xfe, 0xab, 0x40, 0x5b,
-	0xba, 0x95, 0x0c, 0x90, 0x67, 0xca, 0x98, 0x3c, 0xe7, 0x51, 0x94, 0x42, 0x91, 0x10, 0xed, 0x4c,
-	0x9f, 0x49, 0x25, 0x60, 0xd5, 0x94, 0x8f, 0xf5, 0x14, 0xbc, 0x2c, 0x26, 0x1a, 0x4d, 0x09, 0xc1,
-	0xcc, 0xc2, 0x68, 0x7c, 0x27, 0xb3, 0xec, 0x7c, 0x93, 0x59, 0x38, 0xcd, 0xc4, 0x38, 0x87, 0x6b,
-	0x64, 0xba, 0xdf, 0x35, 0x41, 0x5b, 0xc7, 0x74, 0xc3, 0x3a, 0xa4, 0x9f, 0x7d, 0xb8, 0xd5, 0x3a,
-	0x34, 0xdb, 0xf5, 0x8e, 0x67, 0xca, 0x3b, 0x02, 0x67, 0x87, 0xb2, 0xa3, 0xcd, 0x0e, 0x85, 0x79,
-	0x3c, 0x2f, 0xcd, 0xa3, 0xee, 0x58, 0xa1, 0xea, 0x59, 0x0d, 0x96, 0xa0, 0x33, 0x80, 0x86, 0xae,
-	0x78, 0x17, 0xda, 0x76, 0x35, 0xb1, 0xd3, 0xed, 0x1a, 0xec, 0xe9, 0xf4, 0x97, 0xd0, 0xdb, 0x38,
-	0x3f, 0x39, 0xb6, 0xae, 0xc0, 0x3b, 0xf4, 0xb7, 0x5c, 0x81, 0xb9, 0x00, 0xfa, 0x1c, 0x86, 0xdb,
-	0xca, 0x80, 0xbd, 0x29, 0x4b, 0x86, 0x19, 0x9a, 0x4c, 0x4e, 0xe8, 0x11, 0xb4, 0xed, 0x93, 0xef,
-	0x7c, 0x85, 0x4e, 0xa1, 0xe3, 0x9c, 0x9a, 0x50, 0x68, 0xbf, 0xfd, 0xab, 0xe0, 0x39, 0x46, 0x0b,
-	0xd5, 0x49, 0x3e, 0x73, 0x62, 0x93, 0xff, 0x7d, 0xa8, 0xbc, 0x7a, 0x43, 0x8e, 0xa1, 0x8a, 0xef,
-	0x25, 0x21, 0xd6, 0xa6, 0x95, 0x8a, 0x47, 0x9b, 0x07, 0x21, 0x5f, 0x43, 0x5d, 0x89, 0x85, 0x94,
-	0xe6, 0xeb, 0x9a, 0xea, 0xe8, 0x60, 0x33, 0xac, 0x76, 0x34, 0x81, 0x9a, 0xd0, 0x14, 0x19, 0x68,
-	0x80, 0x31, 0xcd, 0xd1, 0xd0, 0x0d, 0x1a, 0x8e, 0x50, 0x99, 0xe6, 0xd8, 0x86, 0xa9, 0x39, 0x8e,
-	0x32, 0xc9, 0xb7, 0xd0, 0x28, 0x95, 0x49, 0x0e, 0x6c, 0x84, 0xb1, 0xab, 0xd1, 0xd3, 0x07, 0x71,
-	0x45, 0xfe, 0x12, 0x02, 0x29, 0x61, 0x62, 0xfe, 0x57, 0x59, 0x7e, 0x39, 0x7a, 0xb2, 0x11, 0x55,
-	0xb4, 0x97, 0xd0, 0xd4, 0x3a, 0x27, 0x4f, 0x35, 0xc6, 0x35, 0xc9, 0x51, 0xf8, 0xf0, 0x83, 0xbd,
-	0x2c, 0x06, 0xad, 0x65, 0x2d, 0xe7, 0xb4, 0x96, 0xb5, 0xdf, 0x0d, 0x72, 0x0a, 0x55, 0xec, 0xe2,
-	0xad, 0x37, 0x37, 0x70, 0x62, 0x92, 0x30, 0xf6, 0x3e, 0xf7, 0xde, 0x06, 0xe2, 0x8f, 0xf2, 0xe9,
-	0xfb, 0x00, 0x00, 0x00, 0xff, 0xff, 0x25, 0x3c, 0x6a, 0xbd, 0x37, 0x0b, 0x00, 0x00,
+	// 1539 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x58, 0x5d, 0x73, 0xd3, 0x46,
+	0x17, 0xc6, 0x96, 0x3f, 0x8f, 0x3f, 0xe2, 0xac, 0x1d, 0x23, 0x44, 0x98, 0x61, 0xf4, 0xce, 0xcb,
+	0x04, 0x02, 0x19, 0x30, 0xa5, 0x85, 0x61, 0x86, 0xe2, 0x84, 0x8f, 0xb6, 0xd3, 0x10, 0x2a, 0x5c,
+	0xb8, 0x36, 0xd1, 0x06, 0x6b, 0x62, 0x4b, 0xae, 0xa4, 0x40, 0xe9, 0x45, 0x2f, 0x3a, 0xfd, 0x03,
+	0xbd, 0xe9, 0x4f, 0xe8, 0xef, 0xec, 0xec, 0xee, 0xd9, 0xd5, 0xae, 0x2c, 0x7b, 0x98, 0x5e, 0x65,
+	0xf7, 0xec, 0xf3, 0x1c, 0x9d, 0x3d, 0xbb, 0x7e, 0xce, 0xd9, 0x40, 0x2b, 0x08, 0x7d, 0xfa, 0xeb,
+	0xc1, 0x32, 0x8e, 0xd2, 0x88, 0x54, 0xf9, 0xc4, 0xfd, 0xab, 0x02, 0xad, 0x17, 0xc1, 0x9c, 0x7a,
+	0xf4, 0x97, 0x0b, 0x9a, 0xa4, 0x64, 0x00, 0x95, 0x70, 0xba, 0xa0, 0x76, 0xe9, 0x7a, 0x69, 0xaf,
+	0xf9, 0xdd, 0x25, 0x8f, 0xcf, 0xc8, 0x1e, 0x54, 0xa2, 0x25, 0x0d, 0xed, 0xf2, 0xf5, 0xd2, 0x5e,
+	0x6b, 0x44, 0x0e, 0x84, 0xa3, 0x93, 0x25, 0x0d, 0x91, 0xc7, 0x90, 0x0c, 0xc1, 0x90, 0x49, 0x3a,
+	0x4d, 0x6d, 0xcb, 0x40, 0xbe, 0x49, 0xa7, 0xa9, 0x86, 0x64, 0x08, 0xf2, 0x15, 0x34, 0xd2, 0xf8,
+	0x22, 0x3c, 0x9d, 0xa6, 0xd4, 0xae, 0x70, 0xf4, 0x10, 0xd1, 0x13, 0x34, 0x67, 0x0c, 0x85, 0x64,
+	0xfe, 0x63, 0x3a, 0xf5, 0xed, 0xaa, 0xe1, 0xdf, 0xa3, 0x53, 0x5f, 0xf3, 0xcf, 0x10, 0xe4, 0x00,
+	0x6a, 0xec, 0xef, 0x38, 0xb5, 0x6b, 0x1c, 0x3b, 0xd0, 0xb0, 0x63, 0x2d, 0x1a, 0x44, 0x91, 0x7b,
+	0x50, 0x67, 0x23, 0x3f, 0x88, 0xed, 0x3a, 0x27, 0xec, 0x68, 0x04, 0x3f, 0x88, 0x33, 0x86, 0xc4,
+	0x91, 0xa7, 0xd0, 0xc6, 0x21, 0xcb, 0x52, 0x62, 0x37, 0x38, 0xcf, 0x31, 0x79, 0x7c, 0x29, 0x23,
+	0x1b, 0x0c, 0x9e, 0x2e, 0x4a, 0xcf, 0xed, 0xa6, 0x99, 0x2e, 0x4a, 0xcf, 0xf5, 0x74, 0x51, 0x7a,
+	0x4e, 0xf6, 0xa1, 0xfa, 0x29, 0x0e, 0x52, 0x6a, 0x03, 0x87, 0xf6, 0x11, 0xfa, 0x8e, 0xd9, 0x32,
+	0xac, 0xc0, 0xb0, 0xbd, 0xf0, 0xc1, 0x38, 0xb5, 0x5b, 0xc6, 0x5e, 0xde, 0x09, 0xab, 0xb6, 0x17,
+	0xc4, 0x1d, 0x36, 0xa1, 0x8e, 0x56, 0xf7, 0x2d, 0x74, 0x8f, 0x66, 0x69, 0x90, 0x85, 0x4d, 0x88,
+	0x7e, 0x2b, 0xf0, 0x4e, 0x0c, 0xa0, 0x3a, 0xf5, 0x7d, 0xea, 0xf3, 0x4b, 0x61, 0x79, 0x62, 0x42,
+	0x1c, 0x68, 0x2c, 0x22, 0x3f, 0x38, 0x0b, 0xa8, 0xcf, 0xef, 0x80, 0xe5, 0xa9, 0xb9, 0xfb, 0x35,
+	0xb4, 0x8f, 0x66, 0x8b, 0xc8, 0xdf, 0xe4, 0x95, 0x40, 0x65, 0x11, 0xf9, 0x94, 0x3b, 0xed, 0x78,
+	0x7c, 0xcc, 0x78, 0xc7, 0xe7, 0xd9, 0x09, 0xac, 0xe3, 0x2d, 0x69, 0xbc, 0x90, 0x3c, 0x36, 0x76,
+	0x1f, 0xc1, 0x16, 0xe7, 0x8d, 0xe7, 0x73, 0x8d, 0xba, 0x9c, 0xa6, 0x33, 0x49, 0x65, 0xe3, 0x42,
+	0xea, 0x11, 0x74, 0x3c, 0xca, 0x1c, 0x4b, 0xa2, 0x0d, 0xf5, 0x68, 0xee, 0xbf, 0xca, 0x3e, 0x2b,
+	0xa7, 0x6c, 0x25, 0xa4, 0x9f, 0xf8, 0x4a, 0x59, 0xac, 0xe0, 0xd4, 0xbd, 0x01, 0x3d, 0x8f, 0x2e,
+	0xa2, 0x8f, 0x74, 0x73, 0x00, 0xee, 0xff, 0xa0, 0x23, 0x70, 0x1b, 0x36, 0xe8, 0x12, 0xe8, 0xfd,
+	0x18, 0x24, 0xe9, 0x24, 0x9e, 0x26, 0x33, 0x79, 0x50, 0x8f, 0xa0, 0xef, 0xd1, 0x24, 0x8d, 0x62,
+	0xaa, 0x9b, 0x49, 0x17, 0xca, 0x81, 0x8f, 0xe4, 0x72, 0xe0, 0xab, 0x6f, 0x96, 0xb5, 0x6f, 0x7e,
+	0x03, 0xdb, 0xaf, 0x2f, 0xe2, 0x0f, 0x26, 0xb1, 0x07, 0x56, 0xe0, 0x27, 0x76, 0xe9, 0xba, 0xb5,
+	0xd7, 0xf4, 0xd8, 0x90, 0x59, 0xa6, 0xf3, 0x39, 0x67, 0x36, 0x3c, 0x36, 0x74, 0x6f, 0x42, 0x9f,
+	0xc5, 0xf1, 0x96, 0xc6, 0x49, 0x10, 0x85, 0x9b, 0x6e, 0x88, 0xfb, 0x10, 0x08, 0x93, 0x08, 0x84,
+	0x6e, 0x3a, 0x3d, 0x11, 0x71, 0x59, 0x46, 0xec, 0x3e, 0x86, 0x1d, 0xdc, 0xd8, 0x7f, 0x20, 0xef,
+	0xc3, 0xce, 0x51, 0x4c, 0xa7, 0x29, 0x7d, 0x13, 0x4e, 0x97, 0xc9, 0x2c, 0x4a, 0x37, 0xc5, 0x38,
+	0x84, 0x01, 0xdb, 0x8e, 0x84, 0xca, 0xfd, 0x30, 0x27, 0xcf, 0xe8, 0x9c, 0x7e, 0x99, 0x93, 0x9f,
+	0xa0, 0xa5, 0x69, 0xe1, 0xba, 0xfb, 0x79, 0x36, 0x9f, 0x7e, 0xc0, 0x1f, 0x0b, 0x1f, 0xb3, 0xdf,
+	0xca, 0x59, 0x30, 0xa7, 0xc7, 0xec, 0xbe, 0x5b, 0xfc, 0xf2, 0xa9, 0xb9, 0xdb, 0x81, 0x96, 0x26,
+	0x9a, 0xee, 0xff, 0x61, 0x2b, 0xa7, 0x8a, 0xcc, 0x63, 0x12, 0xfc, 0x26, 0xbe, 0x62, 0x79, 0x7c,
+	0xcc, 0x58, 0x9a, 0x14, 0xba, 0x8f, 0xd9, 0xc5, 0xd2, 0xd4, 0x8e, 0x0c, 0xa1, 0x16, 0x9d, 0x9d,
+	0x25, 0x34, 0x45, 0x16, 0xce, 0x94, 0xaf, 0xb2, 0xe6, 0xeb, 0x06, 0x74, 0x4d, 0xe5, 0x63, 0xbf,
+	0xf8, 0xd3, 0xe8, 0x22, 0x14, 0xe4, 0xaa, 0x27, 0x26, 0xee, 0x3e, 0xf4, 0x11, 0xa7, 0x2b, 0xdd,
+	0x1a, 0xf0, 0x9f, 0x25, 0x68, 0x69, 0xea, 0xb6, 0x36, 0xa0, 0x7b, 0x50, 0xfb, 0x34, 0xa3, 0xe1,
+	0xa9, 0x08, 0xa9, 0x3b, 0xba, 0xb2, 0xaa, 0x8c, 0x07, 0xef, 0x38, 0xc0, 0x43, 0xa0, 0x7b, 0x0b,
+	0x6a, 0xc2, 0x42, 0xea, 0x60, 0x4d, 0x4e, 0x5e, 0xf7, 0x2e, 0x91, 0x16, 0xd4, 0x8f, 0x7e, 0xf6,
+	0xbc, 0xe7, 0xaf, 0x26, 0xbd, 0x12, 0x01, 0xa8, 0x1d, 0x9e, 0x4c, 0x26, 0x27, 0xc7, 0xbd, 0xb2,
+	0xbb, 0x07, 0x6d, 0x5d, 0x38, 0xd9, 0x6f, 0xf8, 0x34, 0x0a, 0x53, 0x8a, 0xe1, 0xb6, 0x3d, 0x39,
+	0x75, 0x0f, 0xa1, 0x6b, 0x6a, 0xe6, 0xda, 0x90, 0x35, 0x1f, 0x65, 0xd3, 0xc7, 0x47, 0x68, 0xb0,
+	0x12, 0xfb, 0x7d, 0x78, 0x16, 0xad, 0xbb, 0x1b, 0xf9, 0xec, 0x2b, 0x1d, 0xb4, 0x32, 0x1d, 0x64,
+	0x5f, 0x58, 0x44, 0xfe, 0x24, 0x58, 0x88, 0x82, 0x69, 0x79, 0x72, 0xca, 0x92, 0x1d, 0x24, 0xcf,
+	0x82, 0x98, 0x97, 0xc5, 0x86, 0x27, 0x26, 0xee, 0x36, 0x6c, 0x29, 0x1d, 0x4f, 0x96, 0x51, 0x98,
+	0x50, 0x77, 0x0b, 0x3a, 0x28, 0xc1, 0x99, 0x01, 0xb5, 0x15, 0x0d, 0x04, 0x7a, 0x99, 0x68, 0xa2,
+	0xad, 0xc7, 0xae, 0x82, 0x50, 0x43, 0xb4, 0xf4, 0x61, 0x5b, 0x93, 0x36, 0x1d, 0x26, 0x74, 0x0c,
+	0x2d, 0x7f, 0x97, 0x00, 0xb8, 0xc2, 0x3c, 0x0f, 0xd3, 0xf8, 0xf3, 0x97, 0x08, 0x13, 0xd9, 0x85,
+	0xa6, 0xcf, 0x7f, 0x78, 0xfe, 0xe1, 0x67, 0xbe, 0xfb, 0xa6, 0x97, 0x19, 0xb4, 0xd5, 0x71, 0x8a,
+	0x49, 0xc8, 0x0c, 0xc5, 0x69, 0x50, 0xe9, 0xad, 0x69, 0x97, 0xfb, 0x29, 0x6c, 0x6b, 0x6a, 0x2a,
+	0xa2, 0x25, 0xfb, 0x50, 0xa7, 0x61, 0x1a, 0x07, 0x54, 0x48, 0x60, 0x6b, 0xb4, 0xad, 0x1a, 0x12,
+	0xb9, 0x05, 0x4f, 0x22, 0x98, 0x70, 0x98, 0xda, 0x8b, 0x5b, 0x1e, 0x00, 0xd1, 0x85, 0x15, 0xad,
+	0x2f, 0xa1, 0x8e, 0x4a, 0x56, 0x94, 0x84, 0x95, 0xd3, 0xd7, 0x4e, 0xda, 0x32, 0x4e, 0xda, 0x3d,
+	0x14, 0x7a, 0x95, 0xc9, 0x2f, 0xc6, 0x7e, 0x0b, 0x1a, 0x1f, 0xd1, 0x86, 0xc1, 0x77, 0x31, 0x78,
+	0x84, 0x7a, 0x6a, 0xdd, 0xb5, 0x61, 0x98, 0x57, 0x57, 0x0c, 0xf3, 0x77, 0x68, 0x48, 0xbd, 0x2b,
+	0xbc, 0xa9, 0xbb, 0xd0, 0x3c, 0xe5, 0xd2, 0xca, 0xd2, 0x2f, 0x02, 0xce, 0x0c, 0xec, 0x97, 0xb1,
+	0xa0, 0xe9, 0x2c, 0xf2, 0xf1, 0xdc, 0x70, 0xc6, 0x8e, 0x85, 0xe9, 0x5a, 0x82, 0x07, 0x26, 0x26,
+	0x6a, 0xdf, 0x55, 0xed, 0x58, 0x9e, 0xc3, 0x30, 0x2f, 0xdd, 0xea, 0x6c, 0x1a, 0x09, 0xda, 0x78,
+	0x44, 0xad, 0xd1, 0x96, 0x94, 0x04, 0x09, 0x55, 0x00, 0xf7, 0x05, 0xec, 0xe4, 0x44, 0x1d, 0xbd,
+	0xdc, 0x81, 0xa6, 0x04, 0xc9, 0x34, 0xad, 0xb8, 0xc9, 0x10, 0x2c, 0x51, 0xf9, 0x22, 0x80, 0x89,
+	0xfa, 0xc7, 0x82, 0xb6, 0x68, 0x9b, 0xd1, 0xf3, 0x4d, 0xec, 0x90, 0x4b, 0x46, 0x77, 0x26, 0xaa,
+	0x82, 0x80, 0xa8, 0x16, 0xf9, 0x8e, 0x90, 0x7d, 0x26, 0x07, 0xd8, 0x50, 0xcb, 0x18, 0xa4, 0x4a,
+	0xb0, 0x8e, 0x57, 0x42, 0x98, 0x67, 0xde, 0xf1, 0x5a, 0x86, 0x67, 0x21, 0xf3, 0x99, 0x67, 0x06,
+	0x21, 0xa3, 0xac, 0x85, 0x35, 0x3b, 0x6a, 0x25, 0xe4, 0x8a, 0x20, 0x81, 0x64, 0x9c, 0xeb, 0x61,
+	0x45, 0x63, 0x7d, 0xb5, 0xb0, 0x87, 0x55, 0x6c, 0x83, 0xc2, 0x22, 0xe4, 0x4d, 0x6c, 0xcd, 0x88,
+	0x50, 0x48, 0x75, 0x16, 0x21, 0x83, 0x90, 0xdb, 0xb2, 0x8b, 0xad, 0x1b, 0x3d, 0x39, 0x8a, 0xb1,
+	0x02, 0x0b, 0x10, 0x79, 0xa0, 0x3d, 0x11, 0x44, 0x6f, 0x7d, 0x79, 0xe5, 0x89, 0xa0, 0x38, 0x0a,
+	0x7a, 0x08, 0xd0, 0x50, 0x07, 0xd5, 0x85, 0xb6, 0x7e, 0x08, 0x4c, 0xde, 0xf2, 0x5c, 0x56, 0x0d,
+	0xf4, 0x74, 0x6e, 0xa8, 0x06, 0x4f, 0x60, 0x2b, 0x97, 0x4a, 0x76, 0x31, 0xd5, 0x69, 0x9a, 0x37,
+	0x4a, 0x9e, 0x66, 0x76, 0x96, 0xee, 0x6d, 0x18, 0x20, 0xdf, 0xc8, 0x28, 0xfb, 0x85, 0x88, 0xec,
+	0x8b, 0xd6, 0x4b, 0x4c, 0xdc, 0x1b, 0xd0, 0xd6, 0x93, 0xb8, 0xae, 0xf2, 0xb8, 0xf7, 0xa1, 0x63,
+	0x24, 0x90, 0xb8, 0xd0, 0x7e, 0xff, 0x39, 0xa5, 0x09, 0xb3, 0xa6, 0x78, 0x29, 0x2d, 0xcf, 0xb0,
+	0x8d, 0xfe, 0x68, 0x42, 0xf9, 0xc5, 0x1b, 0xb2, 0x0f, 0x15, 0xd6, 0x67, 0x10, 0xa2, 0x05, 0x8d,
+	0x95, 0xce, 0xc9, 0x6f, 0x84, 0x3c, 0x84, 0x3a, 0x16, 0x14, 0x22, 0x1f, 0x14, 0xe6, 0x43, 0xc1,
+	0x19, 0xe6, 0xcd, 0x18, 0xd1, 0x08, 0xaa, 0xbc, 0xee, 0x90, 0xbe, 0x02, 0x64, 0x0f, 0x01, 0x67,
+	0x60, 0x1a, 0x33, 0x0e, 0xaf, 0x44, 0x8a, 0xa3, 0x3f, 0x02, 0x9c, 0x81, 0x69, 0x44, 0xce, 0x63,
+	0x68, 0xc8, 0xea, 0x45, 0x86, 0x3a, 0x22, 0x6b, 0xc1, 0x9d, 0xcb, 0x2b, 0x76, 0x24, 0x3f, 0x80,
+	0x9a, 0x28, 0x73, 0x24, 0x7b, 0x2b, 0x6a, 0x6f, 0x00, 0x67, 0x27, 0x67, 0x45, 0xda, 0x13, 0x68,
+	0xaa, 0x5a, 0x48, 0x2e, 0x2b, 0x8c, 0xd9, 0xf8, 0x3b, 0xf6, 0xea, 0x82, 0xfe, 0x59, 0x66, 0xd4,
+	0x3e, 0xab, 0xbd, 0x06, 0x9c, 0x9d, 0x9c, 0x15, 0x69, 0xf7, 0xa1, 0xc2, 0x6e, 0x76, 0xe1, 0xc9,
+	0xf5, 0x0d, 0x9b, 0x20, 0xec, 0x95, 0xee, 0x96, 0x58, 0xac, 0xaa, 0xee, 0xa9, 0x58, 0xf3, 0xef,
+	0x0a, 0xc7, 0x5e, 0x5d, 0xc0, 0x8f, 0xbe, 0x84, 0x36, 0x96, 0x0e, 0xe1, 0x22, 0x7b, 0xeb, 0xae,
+	0x3c, 0x43, 0x9c, 0xab, 0x85, 0x6b, 0xe8, 0x68, 0x0c, 0x90, 0x95, 0x49, 0x22, 0x3f, 0xb8, 0xf2,
+	0x24, 0x71, 0xae, 0x14, 0xac, 0x64, 0xb1, 0xe8, 0xa5, 0x50, 0xc5, 0x52, 0xf0, 0x3c, 0x71, 0xae,
+	0x16, 0xae, 0xa1, 0xa3, 0x6f, 0x45, 0xfb, 0x8e, 0x76, 0x72, 0x45, 0x13, 0x6f, 0xf3, 0xf9, 0xe1,
+	0x14, 0xa9, 0xef, 0xdd, 0x12, 0x39, 0x86, 0x2e, 0x6e, 0x52, 0xfa, 0xd8, 0x35, 0xf7, 0x9e, 0x73,
+	0x73, 0x6d, 0xcd, 0x2a, 0xc6, 0x73, 0x0c, 0x5d, 0xb3, 0x0a, 0x2a, 0x77, 0x85, 0xef, 0x1a, 0xe7,
+	0xda, 0x9a, 0x55, 0x74, 0xf7, 0x03, 0x74, 0x8c, 0x6a, 0x48, 0xf4, 0x64, 0xe4, 0x1f, 0x3e, 0xce,
+	0x6e, 0xf1, 0x62, 0x16, 0x9a, 0x59, 0x11, 0x55, 0x68, 0x85, 0xaf, 0x25, 0xe7, 0xda, 0x9a, 0x55,
+	0xe1, 0xee, 0x7d, 0x8d, 0xff, 0x2f, 0xea, 0xfe, 0xbf, 0x03, 0x00, 0xbf, 0xf3, 0xba, 0xe1, 0x9a,
+	0x12, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -1795,6 +2759,18 @@ type FSClient interface {
 	RemoveAll(ctx context.Context, in *RemoveAllRequest, opts ...grpc.CallOption) (*RemoveAllResponse, error)
 	Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error)
 	Open(ctx context.Context, opts ...grpc.CallOption) (FS_OpenClient, error)
+	// Trash of exports where removed entries are kept
+	ListTrash(ctx context.Context, in *ListTrashRequest, opts ...grpc.CallOption) (*ListTrashResponse, error)
+	RestoreTrash(ctx context.Context, in *RestoreTrashRequest, opts ...grpc.CallOption) (*RestoreTrashResponse, error)
+	PurgeTrash(ctx context.Context, in *PurgeTrashRequest, opts ...grpc.CallOption) (*PurgeTrashResponse, error)
+	// Prior versions of files of exports with versioning
+	ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error)
+	OpenVersion(ctx context.Context, in *OpenVersionRequest, opts ...grpc.CallOption) (FS_OpenVersionClient, error)
+	RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*RestoreVersionResponse, error)
+	// Read-only snapshots of exports, served as the export "export@snapshot"
+	CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest, opts ...grpc.CallOption) (*CreateSnapshotResponse, error)
+	ListSnapshots(ctx context.Context, in *ListSnapshotsRequest, opts ...grpc.CallOption) (*ListSnapshotsResponse, error)
+	DeleteSnapshot(ctx context.Context, in *DeleteSnapshotRequest, opts ...grpc.CallOption) (*DeleteSnapshotResponse, error)
 }
 
 type fSClient struct {
@@ -1908,6 +2884,110 @@ func (x *fSOpenClient) Recv() (*FileResponse, error) {
 	return m, nil
 }
 
+func (c *fSClient) ListTrash(ctx context.Context, in *ListTrashRequest, opts ...grpc.CallOption) (*ListTrashResponse, error) {
+	out := new(ListTrashResponse)
+	err := c.cc.Invoke(ctx, "/index.FS/ListTrash", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fSClient) RestoreTrash(ctx context.Context, in *RestoreTrashRequest, opts ...grpc.CallOption) (*RestoreTrashResponse, error) {
+	out := new(RestoreTrashResponse)
+	err := c.cc.Invoke(ctx, "/index.FS/RestoreTrash", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fSClient) PurgeTrash(ctx context.Context, in *PurgeTrashRequest, opts ...grpc.CallOption) (*PurgeTrashResponse, error) {
+	out := new(PurgeTrashResponse)
+	err := c.cc.Invoke(ctx, "/index.FS/PurgeTrash", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fSClient) ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error) {
+	out := new(ListVersionsResponse)
+	err := c.cc.Invoke(ctx, "/index.FS/ListVersions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fSClient) OpenVersion(ctx context.Context, in *OpenVersionRequest, opts ...grpc.CallOption) (FS_OpenVersionClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_FS_serviceDesc.Streams[1], "/index.FS/OpenVersion", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fSOpenVersionClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FS_OpenVersionClient interface {
+	Recv() (*ReadResponse, error)
+	grpc.ClientStream
+}
+
+type fSOpenVersionClient struct {
+	grpc.ClientStream
+}
+
+func (x *fSOpenVersionClient) Recv() (*ReadResponse, error) {
+	m := new(ReadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fSClient) RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*RestoreVersionResponse, error) {
+	out := new(RestoreVersionResponse)
+	err := c.cc.Invoke(ctx, "/index.FS/RestoreVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fSClient) CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest, opts ...grpc.CallOption) (*CreateSnapshotResponse, error) {
+	out := new(CreateSnapshotResponse)
+	err := c.cc.Invoke(ctx, "/index.FS/CreateSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fSClient) ListSnapshots(ctx context.Context, in *ListSnapshotsRequest, opts ...grpc.CallOption) (*ListSnapshotsResponse, error) {
+	out := new(ListSnapshotsResponse)
+	err := c.cc.Invoke(ctx, "/index.FS/ListSnapshots", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fSClient) DeleteSnapshot(ctx context.Context, in *DeleteSnapshotRequest, opts ...grpc.CallOption) (*DeleteSnapshotResponse, error) {
+	out := new(DeleteSnapshotResponse)
+	err := c.cc.Invoke(ctx, "/index.FS/DeleteSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // FSServer is the server API for FS service.
 type FSServer interface {
 	Stat(context.Context, *FileRequest) (*FileInfo, error)
@@ -1919,6 +2999,18 @@ type FSServer interface {
 	RemoveAll(context.Context, *RemoveAllRequest) (*RemoveAllResponse, error)
 	Remove(context.Context, *RemoveRequest) (*RemoveResponse, error)
 	Open(FS_OpenServer) error
+	// Trash of exports where removed entries are kept
+	ListTrash(context.Context, *ListTrashRequest) (*ListTrashResponse, error)
+	RestoreTrash(context.Context, *RestoreTrashRequest) (*RestoreTrashResponse, error)
+	PurgeTrash(context.Context, *PurgeTrashRequest) (*PurgeTrashResponse, error)
+	// Prior versions of files of exports with versioning
+	ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error)
+	OpenVersion(*OpenVersionRequest, FS_OpenVersionServer) error
+	RestoreVersion(context.Context, *RestoreVersionRequest) (*RestoreVersionResponse, error)
+	// Read-only snapshots of exports, served as the export "export@snapshot"
+	CreateSnapshot(context.Context, *CreateSnapshotRequest) (*CreateSnapshotResponse, error)
+	ListSnapshots(context.Context, *ListSnapshotsRequest) (*ListSnapshotsResponse, error)
+	DeleteSnapshot(context.Context, *DeleteSnapshotRequest) (*DeleteSnapshotResponse, error)
 }
 
 // UnimplementedFSServer can be embedded to have forward compatible implementations.
@@ -1952,6 +3044,33 @@ func (*UnimplementedFSServer) Remove(ctx context.Context, req *RemoveRequest) (*
 func (*UnimplementedFSServer) Open(srv FS_OpenServer) error {
 	return status.Errorf(codes.Unimplemented, "method Open not implemented")
 }
+func (*UnimplementedFSServer) ListTrash(ctx context.Context, req *ListTrashRequest) (*ListTrashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTrash not implemented")
+}
+func (*UnimplementedFSServer) RestoreTrash(ctx context.Context, req *RestoreTrashRequest) (*RestoreTrashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreTrash not implemented")
+}
+func (*UnimplementedFSServer) PurgeTrash(ctx context.Context, req *PurgeTrashRequest) (*PurgeTrashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeTrash not implemented")
+}
+func (*UnimplementedFSServer) ListVersions(ctx context.Context, req *ListVersionsRequest) (*ListVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVersions not implemented")
+}
+func (*UnimplementedFSServer) OpenVersion(req *OpenVersionRequest, srv FS_OpenVersionServer) error {
+	return status.Errorf(codes.Unimplemented, "method OpenVersion not implemented")
+}
+func (*UnimplementedFSServer) RestoreVersion(ctx context.Context, req *RestoreVersionRequest) (*RestoreVersionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreVersion not implemented")
+}
+func (*UnimplementedFSServer) CreateSnapshot(ctx context.Context, req *CreateSnapshotRequest) (*CreateSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSnapshot not implemented")
+}
+func (*UnimplementedFSServer) ListSnapshots(ctx context.Context, req *ListSnapshotsRequest) (*ListSnapshotsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSnapshots not implemented")
+}
+func (*UnimplementedFSServer) DeleteSnapshot(ctx context.Context, req *DeleteSnapshotRequest) (*DeleteSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSnapshot not implemented")
+}
 
 func RegisterFSServer(s *grpc.Server, srv FSServer) {
 	s.RegisterService(&_FS_serviceDesc, srv)
@@ -2127,6 +3246,171 @@ func (x *fSOpenServer) Recv() (*FileRequest, error) {
 	return m, nil
 }
 
+func _FS_ListTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTrashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FSServer).ListTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/index.FS/ListTrash",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FSServer).ListTrash(ctx, req.(*ListTrashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FS_RestoreTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreTrashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FSServer).RestoreTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/index.FS/RestoreTrash",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FSServer).RestoreTrash(ctx, req.(*RestoreTrashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FS_PurgeTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeTrashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FSServer).PurgeTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/index.FS/PurgeTrash",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FSServer).PurgeTrash(ctx, req.(*PurgeTrashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FS_ListVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FSServer).ListVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/index.FS/ListVersions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FSServer).ListVersions(ctx, req.(*ListVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FS_OpenVersion_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(OpenVersionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FSServer).OpenVersion(m, &fSOpenVersionServer{stream})
+}
+
+type FS_OpenVersionServer interface {
+	Send(*ReadResponse) error
+	grpc.ServerStream
+}
+
+type fSOpenVersionServer struct {
+	grpc.ServerStream
+}
+
+func (x *fSOpenVersionServer) Send(m *ReadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FS_RestoreVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreVersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FSServer).RestoreVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/index.FS/RestoreVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FSServer).RestoreVersion(ctx, req.(*RestoreVersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FS_CreateSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FSServer).CreateSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/index.FS/CreateSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FSServer).CreateSnapshot(ctx, req.(*CreateSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FS_ListSnapshots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSnapshotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FSServer).ListSnapshots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/index.FS/ListSnapshots",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FSServer).ListSnapshots(ctx, req.(*ListSnapshotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FS_DeleteSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FSServer).DeleteSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/index.FS/DeleteSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FSServer).DeleteSnapshot(ctx, req.(*DeleteSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _FS_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "index.FS",
 	HandlerType: (*FSServer)(nil),
@@ -2163,6 +3447,38 @@ var _FS_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Remove",
 			Handler:    _FS_Remove_Handler,
 		},
+		{
+			MethodName: "ListTrash",
+			Handler:    _FS_ListTrash_Handler,
+		},
+		{
+			MethodName: "RestoreTrash",
+			Handler:    _FS_RestoreTrash_Handler,
+		},
+		{
+			MethodName: "PurgeTrash",
+			Handler:    _FS_PurgeTrash_Handler,
+		},
+		{
+			MethodName: "ListVersions",
+			Handler:    _FS_ListVersions_Handler,
+		},
+		{
+			MethodName: "RestoreVersion",
+			Handler:    _FS_RestoreVersion_Handler,
+		},
+		{
+			MethodName: "CreateSnapshot",
+			Handler:    _FS_CreateSnapshot_Handler,
+		},
+		{
+			MethodName: "ListSnapshots",
+			Handler:    _FS_ListSnapshots_Handler,
+		},
+		{
+			MethodName: "DeleteSnapshot",
+			Handler:    _FS_DeleteSnapshot_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -2171,6 +3487,11 @@ var _FS_serviceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "OpenVersion",
+			Handler:       _FS_OpenVersion_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "index.proto",
 }
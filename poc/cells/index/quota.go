@@ -0,0 +1,291 @@
+package index
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// quotaRescan is how often the usage of a QuotaFs is measured again.
+const quotaRescan = time.Minute
+
+// QuotaFs bounds the bytes stored in a filesystem, including its trash and
+// versions when it is wrapped by them.
+//
+// The usage is measured by walking the filesystem, at most every
+// quotaRescan, and counted in between from the changes going through
+// QuotaFs. Writes that would exceed the quota fail with ENOSPC. The count is
+// approximate when several handles grow the same file, until the next walk.
+type QuotaFs struct {
+	afero.Fs
+	max int64
+	now func() time.Time
+
+	mu      sync.Mutex
+	used    int64
+	scanned time.Time // zero when the usage must be measured again
+}
+
+// NewQuotaFs returns a filesystem storing at most max bytes in base.
+func NewQuotaFs(base afero.Fs, max int64) *QuotaFs {
+	return &QuotaFs{Fs: base, max: max, now: time.Now}
+}
+
+// Usage returns the bytes stored and the quota.
+func (q *QuotaFs) Usage() (used, max int64, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.scan(); err != nil {
+		return 0, 0, err
+	}
+
+	return q.used, q.max, nil
+}
+
+// scan measures the usage when it is unknown or old. q.mu must be held.
+func (q *QuotaFs) scan() error {
+	now := q.now()
+	if !q.scanned.IsZero() && now.Sub(q.scanned) < quotaRescan {
+		return nil
+	}
+
+	var used int64
+	err := afero.Walk(q.Fs, "/", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// Entries removed during the walk are not counted
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if regular(fi) {
+			used += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	q.used, q.scanned = used, now
+
+	return nil
+}
+
+// reserve counts n more bytes, or frees them when n is negative. It fails
+// with ENOSPC when the bytes do not fit in the quota.
+func (q *QuotaFs) reserve(n int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.scan(); err != nil {
+		return err
+	}
+
+	if n > 0 && q.used+n > q.max {
+		return syscall.ENOSPC
+	}
+
+	q.used += n
+	if q.used < 0 {
+		q.used = 0
+	}
+
+	return nil
+}
+
+// invalidate has the usage measured again, after a change of unknown size.
+func (q *QuotaFs) invalidate() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.scanned = time.Time{}
+}
+
+// size returns the size of name when it is a file, and 0 otherwise.
+func (q *QuotaFs) size(name string) int64 {
+	fi, err := q.Fs.Stat(name)
+	if err != nil || !regular(fi) {
+		return 0
+	}
+
+	return fi.Size()
+}
+
+// regular reports whether fi is a file taking space. Directories of some
+// filesystems have a size, or no directory mode.
+func regular(fi os.FileInfo) bool {
+	return !fi.IsDir() && fi.Mode()&(os.ModeSymlink|os.ModeDevice|os.ModeNamedPipe|os.ModeSocket) == 0
+}
+
+func (q *QuotaFs) Create(name string) (afero.File, error) {
+	return q.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (q *QuotaFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	var truncated int64
+	if flag&os.O_TRUNC != 0 {
+		truncated = q.size(name)
+	}
+
+	f, err := q.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if truncated > 0 {
+		q.reserve(-truncated)
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return f, nil
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &quotaFile{File: f, fs: q, size: fi.Size(), append: flag&os.O_APPEND != 0}, nil
+}
+
+func (q *QuotaFs) Remove(name string) error {
+	size := q.size(name)
+
+	if err := q.Fs.Remove(name); err != nil {
+		return err
+	}
+
+	return q.reserve(-size)
+}
+
+func (q *QuotaFs) RemoveAll(path string) error {
+	defer q.invalidate()
+
+	return q.Fs.RemoveAll(path)
+}
+
+func (q *QuotaFs) Rename(oldname, newname string) error {
+	replaced := q.size(newname)
+
+	if err := q.Fs.Rename(oldname, newname); err != nil {
+		return err
+	}
+
+	return q.reserve(-replaced)
+}
+
+// quotaFile counts the bytes its writes add to the file.
+type quotaFile struct {
+	afero.File
+	fs     *QuotaFs
+	append bool
+
+	// mu guards the size of the file, as seen from this handle, and the
+	// offset
+	mu   sync.Mutex
+	size int64
+	off  int64
+}
+
+// grow reserves the bytes written by write at off, beyond the size of the
+// file, and gives back those that were not written.
+func (f *quotaFile) grow(op string, off int64, n int, write func() (int, error)) (int, error) {
+	reserved := off + int64(n) - f.size
+	if reserved < 0 {
+		reserved = 0
+	}
+	if err := f.fs.reserve(reserved); err != nil {
+		return 0, &os.PathError{Op: op, Path: f.Name(), Err: err}
+	}
+
+	written, err := write()
+
+	end := off + int64(written)
+	grown := end - f.size
+	if grown < 0 {
+		grown = 0
+	}
+	if grown < reserved {
+		f.fs.reserve(grown - reserved)
+	}
+	if end > f.size {
+		f.size = end
+	}
+
+	return written, err
+}
+
+func (f *quotaFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.append {
+		f.off = f.size
+	}
+
+	n, err := f.grow("write", f.off, len(p), func() (int, error) {
+		return f.File.Write(p)
+	})
+	f.off += int64(n)
+
+	return n, err
+}
+
+func (f *quotaFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.grow("writeat", off, len(p), func() (int, error) {
+		return f.File.WriteAt(p, off)
+	})
+}
+
+func (f *quotaFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *quotaFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.File.Read(p)
+	f.off += int64(n)
+
+	return n, err
+}
+
+func (f *quotaFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	off, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.off = off
+	}
+
+	return off, err
+}
+
+func (f *quotaFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delta := size - f.size
+	if err := f.fs.reserve(delta); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.Name(), Err: err}
+	}
+
+	if err := f.File.Truncate(size); err != nil {
+		f.fs.reserve(-delta)
+		return err
+	}
+	f.size = size
+
+	return nil
+}
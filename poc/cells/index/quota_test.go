@@ -0,0 +1,101 @@
+package index
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestQuotaFs(t *testing.T) {
+	base := afero.NewMemMapFs()
+	if err := afero.WriteFile(base, "/a.txt", make([]byte, 40), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewQuotaFs(base, 100)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	usage := func(want int64) {
+		t.Helper()
+		if used, _, err := q.Usage(); err != nil || used != want {
+			t.Fatalf("expected %d bytes used, got %d, %v", want, used, err)
+		}
+	}
+	usage(40)
+
+	f, err := q.OpenFile("/b.txt", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(make([]byte, 50)); err != nil {
+		t.Fatal(err)
+	}
+	usage(90)
+
+	// Writing over existing bytes takes no space
+	if _, err := f.WriteAt(make([]byte, 20), 10); err != nil {
+		t.Fatal(err)
+	}
+	usage(90)
+
+	_, err = f.WriteAt(make([]byte, 20), 45)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.ENOSPC {
+		t.Fatalf("expected ENOSPC, got %v", err)
+	}
+	if err := f.Truncate(61); err == nil {
+		t.Fatal("expected the truncate to exceed the quota")
+	}
+	usage(90)
+
+	if err := f.Truncate(10); err != nil {
+		t.Fatal(err)
+	}
+	usage(50)
+
+	// Removing and truncating files frees their bytes
+	if err := q.Remove("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	usage(10)
+
+	g, err := q.OpenFile("/b.txt", os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	usage(0)
+
+	if _, err := g.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Write([]byte{0}); err == nil {
+		t.Fatal("expected the append to exceed the quota")
+	}
+	usage(100)
+
+	// The usage is measured again once old, and after RemoveAll
+	if err := base.Remove("/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	usage(100)
+	now = now.Add(quotaRescan)
+	usage(0)
+
+	if err := q.MkdirAll("/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(q, "/d/c.txt", make([]byte, 30), 0644); err != nil {
+		t.Fatal(err)
+	}
+	usage(30)
+	if err := q.RemoveAll("/d"); err != nil {
+		t.Fatal(err)
+	}
+	usage(0)
+}
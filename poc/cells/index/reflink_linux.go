@@ -0,0 +1,41 @@
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl, sharing the data of a file with another.
+const ficlone = 0x40049409
+
+// reflink creates dst sharing the data of src.
+func reflink(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	if errno != 0 {
+		out.Close()
+		os.Remove(dst)
+		return &os.LinkError{Op: "reflink", Old: src, New: dst, Err: errno}
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
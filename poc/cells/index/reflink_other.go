@@ -0,0 +1,9 @@
+// +build !linux
+
+package index
+
+import "os"
+
+func reflink(src, dst string, perm os.FileMode) error {
+	return &os.LinkError{Op: "reflink", Old: src, New: dst, Err: errReflinkUnsupported}
+}
@@ -0,0 +1,415 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// SnapshotsDir is the directory, at the root of an export, where snapshots
+// are kept. It is hidden from clients.
+const SnapshotsDir = ".snapshots"
+
+// Ways of capturing the files of a snapshot, from the cheapest.
+//
+// Reflinks share the data of files until either copy is modified, they
+// need a filesystem such as btrfs or xfs. Hard links share the files
+// themselves: a file modified in place rather than replaced also changes in
+// the snapshots linking to it. Copies always work but take time and space.
+const (
+	SnapshotReflink  = "reflink"
+	SnapshotHardlink = "hardlink"
+	SnapshotCopy     = "copy"
+)
+
+const (
+	snapshotData = "data"
+	snapshotInfo = "info.json"
+
+	snapshotNameFormat = "20060102T150405"
+)
+
+// Snapshots takes point-in-time copies of a local directory, each of which
+// can be served as a read-only filesystem. The trash, versions, snapshots
+// and health probes of the directory are not part of its snapshots.
+type Snapshots struct {
+	root     string
+	methods  []string
+	wrap     func(afero.Fs) afero.Fs
+	realPath func(string) string
+
+	// mu serializes the creation and deletion of snapshots
+	mu sync.Mutex
+}
+
+// SnapshotInfo describes a snapshot.
+type SnapshotInfo struct {
+	Name      string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Method    string    `json:"method"`
+	Files     int64     `json:"files"`
+	Size      int64     `json:"size"`
+}
+
+// NewSnapshots returns the snapshots of the directory root. Files are
+// captured with method, or with reflinks when method is empty and the
+// filesystem supports them, copies otherwise. Hard links are never picked
+// by default, as writes to the files of the export would change the
+// snapshots.
+func NewSnapshots(root string, method string) *Snapshots {
+	methods := []string{SnapshotReflink, SnapshotCopy}
+	if method != "" {
+		methods = []string{method}
+	}
+
+	return &Snapshots{root: root, methods: methods}
+}
+
+// Wrap makes Fs serve snapshots through wrap, for instance to decrypt the
+// files of an encrypted export. realPath returns the name wrap stores a name
+// under in root, so that the trash and versions written through wrap are
+// left out of the snapshots when their names are encrypted.
+func (s *Snapshots) Wrap(wrap func(afero.Fs) afero.Fs, realPath func(string) string) {
+	s.wrap = wrap
+	s.realPath = realPath
+}
+
+// Hide returns fs, the view of root given to clients, without the snapshots.
+func (s *Snapshots) Hide(fs afero.Fs) afero.Fs {
+	return &hiddenFs{Fs: fs, dir: SnapshotsDir}
+}
+
+// validSnapshotName rejects names that would escape the snapshots directory,
+// or that could not be told apart from an export in an export@snapshot
+// address.
+func validSnapshotName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\@`)
+}
+
+func (s *Snapshots) dir(name string) string {
+	return filepath.Join(s.root, SnapshotsDir, name)
+}
+
+// Create takes a snapshot called name, or named after the current time when
+// name is empty.
+func (s *Snapshots) Create(name string) (*SnapshotInfo, error) {
+	now := time.Now().UTC()
+	if name == "" {
+		name = "snap-" + now.Format(snapshotNameFormat)
+	}
+	if !validSnapshotName(name) {
+		return nil, &os.PathError{Op: "snapshot", Path: name, Err: os.ErrInvalid}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.dir(name)
+	if _, err := os.Lstat(dir); err == nil {
+		return nil, &os.PathError{Op: "snapshot", Path: name, Err: os.ErrExist}
+	}
+
+	// The snapshot is built aside, so that it is either complete or absent
+	tmp := s.dir("." + name)
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return nil, err
+	}
+
+	info, err := s.capture(filepath.Join(tmp, snapshotData))
+	if err == nil {
+		info.Name = name
+		info.CreatedAt = now
+
+		var data []byte
+		data, err = json.Marshal(info)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(tmp, snapshotInfo), data, 0600)
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, dir)
+	}
+	if err != nil {
+		removeSnapshot(tmp)
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// capture copies the content of root to dest.
+func (s *Snapshots) capture(dest string) (*SnapshotInfo, error) {
+	c := &capturer{methods: s.methods}
+
+	type dirTimes struct {
+		path string
+		fi   os.FileInfo
+	}
+	var dirs []dirTimes
+
+	err := filepath.Walk(s.root, func(src string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(s.root, src)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dest, rel)
+
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			if rel != "." && s.hidden(filepath.ToSlash(rel)) {
+				return filepath.SkipDir
+			}
+
+			// Directories are writable until every entry is in
+			dirs = append(dirs, dirTimes{dst, fi})
+			return os.Mkdir(dst, 0700)
+
+		case mode.IsRegular():
+			return c.file(src, dst, fi)
+
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		}
+
+		// Devices, sockets and pipes are not captured
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.path, d.fi.Mode().Perm()); err != nil {
+			return nil, err
+		}
+		if err := os.Chtimes(d.path, d.fi.ModTime(), d.fi.ModTime()); err != nil {
+			return nil, err
+		}
+	}
+
+	return &SnapshotInfo{Method: c.used, Files: c.files, Size: c.size}, nil
+}
+
+// hidden reports whether rel, relative to the root, is one of the
+// directories kept aside by the index. The snapshots and health probes are
+// stored in root itself, the trash and versions may be stored under another
+// name.
+func (s *Snapshots) hidden(rel string) bool {
+	if rel == SnapshotsDir || rel == HealthDir {
+		return true
+	}
+
+	for _, dir := range []string{TrashDir, VersionsDir} {
+		if rel == dir || s.realPath != nil && rel == s.realPath(dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// capturer captures files with the first of its methods the filesystem
+// supports. Methods found unsupported are not tried again.
+type capturer struct {
+	methods []string
+	used    string
+
+	files, size int64
+}
+
+func (c *capturer) file(src, dst string, fi os.FileInfo) error {
+	for len(c.methods) > 0 {
+		method := c.methods[0]
+
+		var err error
+		switch method {
+		case SnapshotReflink:
+			err = reflink(src, dst, fi.Mode().Perm())
+		case SnapshotHardlink:
+			err = os.Link(src, dst)
+		default:
+			err = copyLocalFile(src, dst, fi)
+		}
+
+		if err == nil {
+			c.used = method
+			c.files++
+			c.size += fi.Size()
+			return nil
+		}
+
+		if method == SnapshotCopy || !unsupported(err) || len(c.methods) == 1 {
+			return err
+		}
+
+		c.methods = c.methods[1:]
+	}
+
+	return fmt.Errorf("snapshot %s: no capture method left", src)
+}
+
+// errReflinkUnsupported is returned by reflink on systems without reflinks.
+var errReflinkUnsupported = errors.New("reflinks are not supported")
+
+// unsupported reports whether err means that the filesystem does not support
+// the way a file was captured, rather than that this file failed.
+func unsupported(err error) bool {
+	if errors.Is(err, errReflinkUnsupported) {
+		return true
+	}
+
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+
+	switch errno {
+	case syscall.EOPNOTSUPP, syscall.EXDEV, syscall.EINVAL, syscall.ENOTTY, syscall.EPERM, syscall.EMLINK:
+		return true
+	}
+
+	return false
+}
+
+func copyLocalFile(src, dst string, fi os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+// List returns the snapshots, most recent first.
+func (s *Snapshots) List() ([]*SnapshotInfo, error) {
+	fis, err := ioutil.ReadDir(filepath.Join(s.root, SnapshotsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*SnapshotInfo
+	for _, fi := range fis {
+		if !validSnapshotName(fi.Name()) {
+			// Left over by an interrupted creation
+			continue
+		}
+
+		info, err := s.info(fi.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.After(infos[j].CreatedAt)
+	})
+
+	return infos, nil
+}
+
+func (s *Snapshots) info(name string) (*SnapshotInfo, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir(name), snapshotInfo))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &SnapshotInfo{Name: name}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %v", name, err)
+	}
+
+	return info, nil
+}
+
+// Delete removes the snapshot name. Files still open in it stay readable
+// until closed.
+func (s *Snapshots) Delete(name string) error {
+	if !validSnapshotName(name) {
+		return notExist("delete", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.dir(name)
+	if _, err := os.Lstat(dir); err != nil {
+		return err
+	}
+
+	return removeSnapshot(dir)
+}
+
+// removeSnapshot deletes dir, including the directories that were captured
+// without write permission.
+func removeSnapshot(dir string) error {
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() && fi.Mode().Perm()&0700 != 0700 {
+			os.Chmod(p, 0700)
+		}
+		return nil
+	})
+
+	return os.RemoveAll(dir)
+}
+
+// Fs returns the content of the snapshot name, read-only.
+func (s *Snapshots) Fs(name string) (afero.Fs, error) {
+	if !validSnapshotName(name) {
+		return nil, notExist("snapshot", name)
+	}
+
+	data := filepath.Join(s.dir(name), snapshotData)
+	if _, err := os.Stat(data); err != nil {
+		return nil, err
+	}
+
+	var fs afero.Fs = afero.NewReadOnlyFs(afero.NewBasePathFs(afero.NewOsFs(), data))
+	if s.wrap != nil {
+		fs = s.wrap(fs)
+	}
+
+	return fs, nil
+}
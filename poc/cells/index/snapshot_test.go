@@ -0,0 +1,187 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghecquet/tripr/poc/cells/aferofs/cryptfs"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSnapshots(t *testing.T) {
+	root, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dir", "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, TrashDir, "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSnapshots(root, SnapshotCopy)
+
+	info, err := s.Create("before")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Method != SnapshotCopy || info.Files != 1 || info.Size != 5 {
+		t.Fatalf("unexpected snapshot %+v", info)
+	}
+
+	if _, err := s.Create("before"); !os.IsExist(err) {
+		t.Fatalf("expected a duplicate name to fail, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(root, "dir", "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := s.Fs("before")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := afero.ReadFile(fs, "/dir/a.txt")
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected snapshot content %q, %v", data, err)
+	}
+	if _, err := fs.Stat("/" + TrashDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the trash to be left out, got %v", err)
+	}
+	if err := afero.WriteFile(fs, "/b.txt", []byte("b"), 0644); err == nil {
+		t.Fatal("expected the snapshot to be read-only")
+	}
+
+	// The cheapest method available is picked by default
+	info, err = NewSnapshots(root, "").Create("auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Method == "" || info.Files != 1 {
+		t.Fatalf("unexpected snapshot %+v", info)
+	}
+
+	// Files written in place do not change the snapshots taken by default
+	f, err := os.OpenFile(filepath.Join(root, "dir", "a.txt"), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("in place"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(root, "dir", "a.txt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = s.Fs("auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = afero.ReadFile(fs, "/dir/a.txt")
+	if err != nil || string(data) != "changed" {
+		t.Fatalf("unexpected snapshot content %q after writing in place, %v", data, err)
+	}
+	if fi, err := fs.Stat("/dir/a.txt"); err != nil || fi.Mode().Perm() != 0644 {
+		t.Fatalf("unexpected snapshot mode after chmod %v, %v", fi, err)
+	}
+
+	infos, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 snapshots, got %+v", infos)
+	}
+
+	hidden := s.Hide(afero.NewBasePathFs(afero.NewOsFs(), root))
+	if _, err := hidden.Stat("/" + SnapshotsDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the snapshots to be hidden, got %v", err)
+	}
+
+	// Handlers serve the snapshots as export@snapshot
+	h := NewExportsHandler(map[string]afero.Fs{"photos": hidden})
+	h.SetSnapshots(map[string]*Snapshots{"photos": s})
+	fs, err = h.getFs(clientContext("10.0.0.1", "photos@before"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := afero.ReadFile(fs, "/dir/a.txt"); err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected content served %q, %v", data, err)
+	}
+	if _, err := h.getFs(clientContext("10.0.0.1", "photos@missing")); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected an unknown snapshot to be reported, got %v", err)
+	}
+
+	if err := s.Delete("before"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Fs("before"); !os.IsNotExist(err) {
+		t.Fatalf("expected the snapshot to be deleted, got %v", err)
+	}
+	if _, err := s.Fs("../dir"); !os.IsNotExist(err) {
+		t.Fatalf("expected an invalid name to be rejected, got %v", err)
+	}
+}
+
+func TestSnapshotsEncryptedNames(t *testing.T) {
+	root, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	key, err := cryptfs.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSnapshots(root, SnapshotCopy)
+	crypt, err := cryptfs.New(s.Hide(afero.NewBasePathFs(afero.NewOsFs(), root)), key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Wrap(func(fs afero.Fs) afero.Fs { return crypt.WithSource(fs) }, crypt.RealPath)
+
+	for _, name := range []string{"/dir/a.txt", "/" + TrashDir + "/x", "/" + VersionsDir + "/y"} {
+		if err := crypt.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := afero.WriteFile(crypt, name, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := s.Create("encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Files != 1 {
+		t.Errorf("expected the trash and versions to be left out, got %d files", info.Files)
+	}
+
+	fs, err := s.Fs("encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := afero.ReadFile(fs, "/dir/a.txt"); err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected snapshot content %q, %v", data, err)
+	}
+	for _, dir := range []string{TrashDir, VersionsDir} {
+		if _, err := fs.Stat("/" + dir); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be left out, got %v", dir, err)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package index
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// TrashDir is the directory, at the root of an export, where removed entries
+// are kept. It is hidden from clients.
+const TrashDir = ".trash"
+
+const (
+	trashData = "data"
+	trashInfo = "info.json"
+)
+
+// Trash moves removed entries aside instead of deleting them. Each entry is
+// kept in its own directory under TrashDir, next to a description of where
+// it came from.
+type Trash struct {
+	fs        afero.Fs
+	retention time.Duration
+}
+
+// TrashInfo describes an entry of the trash.
+type TrashInfo struct {
+	ID        string    `json:"-"`
+	Path      string    `json:"path"`
+	DeletedBy string    `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+	IsDir     bool      `json:"is_dir"`
+	Size      int64     `json:"size"`
+}
+
+// NewTrash returns a trash for fs. Entries older than retention are removed
+// by Expire, a zero retention keeps them until purged.
+func NewTrash(fs afero.Fs, retention time.Duration) *Trash {
+	return &Trash{fs: fs, retention: retention}
+}
+
+// Fs returns the view of the filesystem given to clients, without the trash.
+func (t *Trash) Fs() afero.Fs {
+	return &hiddenFs{Fs: t.fs, dir: TrashDir}
+}
+
+// Move puts name in the trash on behalf of deletedBy. With all, directories
+// are moved along with their content, otherwise only empty ones are, like
+// os.Remove.
+func (t *Trash) Move(name string, deletedBy string, all bool) error {
+	name = cleanPath(name)
+	if name == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	if hidden(name) {
+		if all {
+			return nil
+		}
+		return notExist("remove", name)
+	}
+
+	fi, err := t.fs.Stat(name)
+	if os.IsNotExist(err) && all {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() && !all {
+		names, err := readDirNames(t.fs, name, 1)
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+
+	id, err := newTrashID()
+	if err != nil {
+		return err
+	}
+
+	dir := path.Join("/", TrashDir, id)
+	if err := t.fs.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	info := &TrashInfo{
+		Path:      name,
+		DeletedBy: deletedBy,
+		DeletedAt: time.Now().UTC(),
+		IsDir:     fi.IsDir(),
+	}
+	if !fi.IsDir() {
+		info.Size = fi.Size()
+	}
+
+	data, err := json.Marshal(info)
+	if err == nil {
+		err = afero.WriteFile(t.fs, path.Join(dir, trashInfo), data, 0600)
+	}
+	if err == nil {
+		err = t.fs.Rename(name, path.Join(dir, trashData))
+	}
+	if err != nil {
+		t.fs.RemoveAll(dir)
+		return err
+	}
+
+	return nil
+}
+
+// List returns the entries of the trash, most recently deleted first.
+func (t *Trash) List() ([]*TrashInfo, error) {
+	ids, err := readDirNames(t.fs, path.Join("/", TrashDir), -1)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*TrashInfo
+	for _, id := range ids {
+		info, err := t.info(id)
+		if err != nil {
+			// Left over by an interrupted move, Expire cleans it up
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].DeletedAt.After(infos[j].DeletedAt)
+	})
+
+	return infos, nil
+}
+
+func (t *Trash) info(id string) (*TrashInfo, error) {
+	if !validTrashID(id) {
+		return nil, &os.PathError{Op: "trash", Path: id, Err: os.ErrNotExist}
+	}
+
+	data, err := afero.ReadFile(t.fs, path.Join("/", TrashDir, id, trashInfo))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &TrashInfo{ID: id}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("trash entry %s: %v", id, err)
+	}
+
+	return info, nil
+}
+
+// Restore moves the entry id back to to, or to its original path when to is
+// empty. It fails if something already exists there.
+func (t *Trash) Restore(id string, to string) error {
+	info, err := t.info(id)
+	if err != nil {
+		return err
+	}
+
+	if to == "" {
+		to = info.Path
+	}
+	to = cleanPath(to)
+
+	if hidden(to) {
+		return &os.PathError{Op: "restore", Path: to, Err: os.ErrPermission}
+	}
+
+	if _, err := t.fs.Stat(to); err == nil {
+		return &os.PathError{Op: "restore", Path: to, Err: os.ErrExist}
+	}
+
+	if err := t.fs.MkdirAll(path.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	dir := path.Join("/", TrashDir, id)
+	if err := t.fs.Rename(path.Join(dir, trashData), to); err != nil {
+		return err
+	}
+
+	return t.fs.RemoveAll(dir)
+}
+
+// Purge deletes the entry id for good.
+func (t *Trash) Purge(id string) error {
+	if !validTrashID(id) {
+		return &os.PathError{Op: "purge", Path: id, Err: os.ErrNotExist}
+	}
+
+	dir := path.Join("/", TrashDir, id)
+	if _, err := t.fs.Stat(dir); err != nil {
+		return err
+	}
+
+	return t.fs.RemoveAll(dir)
+}
+
+// PurgeAll empties the trash.
+func (t *Trash) PurgeAll() error {
+	return t.fs.RemoveAll(path.Join("/", TrashDir))
+}
+
+// Expire deletes the entries removed more than the retention period ago,
+// and the ones left incomplete by an interrupted move.
+func (t *Trash) Expire() error {
+	if t.retention == 0 {
+		return nil
+	}
+
+	ids, err := readDirNames(t.fs, path.Join("/", TrashDir), -1)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(-t.retention)
+
+	for _, id := range ids {
+		info, err := t.info(id)
+		if err == nil && info.DeletedAt.After(deadline) {
+			continue
+		}
+
+		if err != nil {
+			// Entries being moved have no info yet, give them time
+			fi, err := t.fs.Stat(path.Join("/", TrashDir, id))
+			if err != nil || fi.ModTime().After(deadline) {
+				continue
+			}
+		}
+
+		if err := t.fs.RemoveAll(path.Join("/", TrashDir, id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newTrashID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(b)), nil
+}
+
+// validTrashID rejects ids that would escape the trash directory.
+func validTrashID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+func readDirNames(fs afero.Fs, name string, n int) ([]string, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(n)
+	if err == io.EOF {
+		return nil, nil
+	}
+
+	return names, err
+}
+
+func cleanPath(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// hidden reports whether name is inside the trash directory.
+func hidden(name string) bool {
+	return under(TrashDir, name)
+}
+
+// under reports whether name is dir, at the root of the filesystem, or is
+// inside it.
+func under(dir, name string) bool {
+	name = cleanPath(name)
+	return name == "/"+dir || strings.HasPrefix(name, "/"+dir+"/")
+}
+
+// hiddenFs hides a directory at the root of the filesystem from clients.
+type hiddenFs struct {
+	afero.Fs
+	dir string
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+func (fs *hiddenFs) Create(name string) (afero.File, error) {
+	if under(fs.dir, name) {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Fs.Create(name)
+}
+
+func (fs *hiddenFs) Mkdir(name string, perm os.FileMode) error {
+	if under(fs.dir, name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Fs.Mkdir(name, perm)
+}
+
+func (fs *hiddenFs) MkdirAll(name string, perm os.FileMode) error {
+	if under(fs.dir, name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Fs.MkdirAll(name, perm)
+}
+
+func (fs *hiddenFs) Open(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fs *hiddenFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if under(fs.dir, name) {
+		if flag&os.O_CREATE != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+		}
+		return nil, notExist("open", name)
+	}
+
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if cleanPath(name) == "/" {
+		return &hiddenRoot{File: f, name: fs.dir}, nil
+	}
+
+	return f, nil
+}
+
+func (fs *hiddenFs) Remove(name string) error {
+	if under(fs.dir, name) {
+		return notExist("remove", name)
+	}
+	return fs.Fs.Remove(name)
+}
+
+func (fs *hiddenFs) RemoveAll(name string) error {
+	if under(fs.dir, name) {
+		return nil
+	}
+	return fs.Fs.RemoveAll(name)
+}
+
+func (fs *hiddenFs) Rename(oldname, newname string) error {
+	if under(fs.dir, oldname) {
+		return notExist("rename", oldname)
+	}
+	if under(fs.dir, newname) {
+		return &os.PathError{Op: "rename", Path: newname, Err: os.ErrPermission}
+	}
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func (fs *hiddenFs) Stat(name string) (os.FileInfo, error) {
+	if under(fs.dir, name) {
+		return nil, notExist("stat", name)
+	}
+	return fs.Fs.Stat(name)
+}
+
+func (fs *hiddenFs) Chmod(name string, mode os.FileMode) error {
+	if under(fs.dir, name) {
+		return notExist("chmod", name)
+	}
+	return fs.Fs.Chmod(name, mode)
+}
+
+func (fs *hiddenFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if under(fs.dir, name) {
+		return notExist("chtimes", name)
+	}
+	return fs.Fs.Chtimes(name, atime, mtime)
+}
+
+// hiddenRoot lists the root of an export without the directory name.
+type hiddenRoot struct {
+	afero.File
+	name string
+}
+
+func (f *hiddenRoot) Readdir(count int) ([]os.FileInfo, error) {
+	var kept []os.FileInfo
+	for {
+		n := count
+		if n > 0 {
+			n -= len(kept)
+		}
+
+		fis, err := f.File.Readdir(n)
+		for _, fi := range fis {
+			if fi.Name() != f.name {
+				kept = append(kept, fi)
+			}
+		}
+
+		if done, err := paged(count, len(kept), err); done {
+			return kept, err
+		}
+	}
+}
+
+func (f *hiddenRoot) Readdirnames(n int) ([]string, error) {
+	var kept []string
+	for {
+		count := n
+		if count > 0 {
+			count -= len(kept)
+		}
+
+		names, err := f.File.Readdirnames(count)
+		for _, name := range names {
+			if name != f.name {
+				kept = append(kept, name)
+			}
+		}
+
+		if done, err := paged(n, len(kept), err); done {
+			return kept, err
+		}
+	}
+}
+
+// paged reports whether a page of count entries, kept so far, is complete
+// after a read failing with err. A page missing the hidden entry is filled
+// from the next entries, and only an empty page ends the listing with
+// io.EOF, like os.File.
+func paged(count, kept int, err error) (bool, error) {
+	switch {
+	case count <= 0:
+		return true, err
+	case err == io.EOF && kept > 0:
+		return true, nil
+	case err != nil, kept == count:
+		return true, err
+	}
+
+	return false, nil
+}
@@ -0,0 +1,140 @@
+package index
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestTrash(t *testing.T) {
+	base := afero.NewMemMapFs()
+	trash := NewTrash(base, 0)
+	fs := trash.Fs()
+
+	if err := afero.WriteFile(fs, "/a.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := trash.Move("/a.txt", "alice", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Stat("/a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected /a.txt to be removed, got %v", err)
+	}
+
+	// The trash itself is not visible to clients
+	if _, err := fs.Stat("/" + TrashDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the trash to be hidden, got %v", err)
+	}
+	names, err := readDirNames(fs, "/", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("expected an empty root, got %v", names)
+	}
+
+	infos, err := trash.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Path != "/a.txt" || infos[0].DeletedBy != "alice" || infos[0].Size != 5 {
+		t.Fatalf("unexpected trash content %+v", infos)
+	}
+
+	if err := afero.WriteFile(fs, "/a.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := trash.Restore(infos[0].ID, ""); !os.IsExist(err) {
+		t.Fatalf("expected restoring over an existing file to fail, got %v", err)
+	}
+
+	if err := trash.Restore(infos[0].ID, "/restored/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := afero.ReadFile(fs, "/restored/a.txt")
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected restored content %q, %v", data, err)
+	}
+
+	if err := trash.Move("/a.txt", "bob", false); err != nil {
+		t.Fatal(err)
+	}
+	infos, _ = trash.List()
+	if len(infos) != 1 {
+		t.Fatalf("expected one entry, got %+v", infos)
+	}
+	if err := trash.Purge(infos[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ = trash.List(); len(infos) != 0 {
+		t.Fatalf("expected an empty trash, got %+v", infos)
+	}
+
+	if err := trash.Purge("../a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected an invalid id to be rejected, got %v", err)
+	}
+}
+
+func TestTrashPaging(t *testing.T) {
+	trash := NewTrash(afero.NewMemMapFs(), 0)
+	fs := trash.Fs()
+
+	for _, name := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		if err := afero.WriteFile(fs, name, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := trash.Move("/c.txt", "alice", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The trash sorts first, pages leaving it out are filled all the same
+	for _, count := range []int{1, 2} {
+		f, err := fs.Open("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for {
+			fis, err := f.Readdir(count)
+			if err == io.EOF {
+				if len(fis) != 0 {
+					t.Errorf("count %d: expected an empty last page, got %d entries", count, len(fis))
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fis) == 0 || len(fis) > count {
+				t.Fatalf("count %d: unexpected page of %d entries", count, len(fis))
+			}
+			for _, fi := range fis {
+				names = append(names, fi.Name())
+			}
+		}
+		f.Close()
+
+		if len(names) != 2 || names[0] != "a.txt" || names[1] != "b.txt" {
+			t.Errorf("count %d: unexpected listing %v", count, names)
+		}
+	}
+
+	f, err := fs.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if names, err := f.Readdirnames(1); err != nil || len(names) != 1 || names[0] != "a.txt" {
+		t.Errorf("unexpected first name %v, %v", names, err)
+	}
+	if names, err := f.Readdirnames(-1); err != nil || len(names) != 1 || names[0] != "b.txt" {
+		t.Errorf("unexpected remaining names %v, %v", names, err)
+	}
+}
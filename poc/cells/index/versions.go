@@ -0,0 +1,501 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// VersionsDir is the directory, at the root of an export, where prior
+// versions of files are kept. It is hidden from clients.
+const VersionsDir = ".versions"
+
+const (
+	versionIDFormat = "20060102T150405.000000000Z"
+
+	// versionPath is the file holding the path of the versioned file, next
+	// to its versions.
+	versionPath = "path"
+)
+
+// VersionOptions bounds the versions kept for each file. Zero values mean no
+// limit.
+type VersionOptions struct {
+	MaxCount int
+	MaxAge   time.Duration
+	MaxSize  int64
+}
+
+// FileVersion describes a prior version of a file.
+type FileVersion struct {
+	ID      string
+	Size    int64
+	ModTime time.Time
+}
+
+// VersionFs keeps the prior content of files that are truncated, written
+// over or replaced by a rename. Appending to a file does not create a new
+// version.
+//
+// The versions of a file are stored under VersionsDir, in a directory named
+// after the hash of its path, so that any path can be versioned.
+type VersionFs struct {
+	afero.Fs
+	opts VersionOptions
+
+	// mu serializes the changes to the versions
+	mu sync.Mutex
+}
+
+// NewVersionFs returns a filesystem keeping the versions of the files of
+// base.
+func NewVersionFs(base afero.Fs, opts VersionOptions) *VersionFs {
+	return &VersionFs{Fs: base, opts: opts}
+}
+
+func versionsHidden(name string) bool {
+	return under(VersionsDir, name)
+}
+
+func versionDir(name string) string {
+	sum := sha256.Sum256([]byte(cleanPath(name)))
+	return path.Join("/", VersionsDir, hex.EncodeToString(sum[:]))
+}
+
+func validVersionID(id string) bool {
+	_, err := time.Parse(versionIDFormat, id)
+	return err == nil
+}
+
+// save keeps the current content of name as a new version and returns
+// where it was stored. With move, the file itself is moved to the versions,
+// otherwise it is copied. Files that do not exist, are empty or are not
+// regular files are not versioned.
+//
+// Retention limits are not applied, so that the caller can move the version
+// back if the operation replacing the file fails. It calls done afterwards.
+func (v *VersionFs) save(name string, move bool) (string, error) {
+	fi, err := v.Fs.Stat(name)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !fi.Mode().IsRegular() || fi.Size() == 0 {
+		return "", nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	dir := versionDir(name)
+	if err := v.Fs.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	if err := afero.WriteFile(v.Fs, path.Join(dir, versionPath), []byte(cleanPath(name)), 0600); err != nil {
+		return "", err
+	}
+
+	dest := path.Join(dir, time.Now().UTC().Format(versionIDFormat))
+
+	if move {
+		err = v.Fs.Rename(name, dest)
+	} else {
+		err = copyFile(v.Fs, name, dest)
+		if err == nil {
+			err = v.Fs.Chtimes(dest, fi.ModTime(), fi.ModTime())
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return dest, nil
+}
+
+// done applies the retention limits to the versions of name.
+func (v *VersionFs) done(name string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.prune(versionDir(name))
+}
+
+func copyFile(fs afero.Fs, src, dest string) error {
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := fs.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// versions returns the versions stored in dir, oldest first.
+func (v *VersionFs) versions(dir string) ([]FileVersion, error) {
+	f, err := v.Fs.Open(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fis, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []FileVersion
+	for _, fi := range fis {
+		if !validVersionID(fi.Name()) {
+			continue
+		}
+
+		versions = append(versions, FileVersion{
+			ID:      fi.Name(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+
+	// IDs sort in time order
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ID < versions[j].ID
+	})
+
+	return versions, nil
+}
+
+// prune removes the oldest versions in dir exceeding the retention limits.
+func (v *VersionFs) prune(dir string) error {
+	versions, err := v.versions(dir)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, ver := range versions {
+		total += ver.Size
+	}
+
+	deadline := time.Now().Add(-v.opts.MaxAge)
+
+	for len(versions) > 0 {
+		oldest := versions[0]
+		created, _ := time.Parse(versionIDFormat, oldest.ID)
+
+		expired := (v.opts.MaxCount > 0 && len(versions) > v.opts.MaxCount) ||
+			(v.opts.MaxAge > 0 && created.Before(deadline)) ||
+			(v.opts.MaxSize > 0 && total > v.opts.MaxSize)
+		if !expired {
+			break
+		}
+
+		if err := v.Fs.Remove(path.Join(dir, oldest.ID)); err != nil {
+			return err
+		}
+
+		total -= oldest.Size
+		versions = versions[1:]
+	}
+
+	if len(versions) == 0 {
+		return v.Fs.RemoveAll(dir)
+	}
+
+	return nil
+}
+
+// Prune applies the retention limits to the versions of every file. Limits
+// are otherwise only applied to a file when it gets a new version.
+func (v *VersionFs) Prune() error {
+	names, err := readDirNames(v.Fs, path.Join("/", VersionsDir), -1)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, name := range names {
+		if err := v.prune(path.Join("/", VersionsDir, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Versions returns the prior versions of name, most recent first.
+func (v *VersionFs) Versions(name string) ([]FileVersion, error) {
+	if versionsHidden(name) {
+		return nil, notExist("versions", name)
+	}
+
+	versions, err := v.versions(versionDir(name))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+
+	return versions, nil
+}
+
+// OpenVersion opens the version id of name for reading.
+func (v *VersionFs) OpenVersion(name, id string) (afero.File, error) {
+	if versionsHidden(name) || !validVersionID(id) {
+		return nil, notExist("open", name+"@"+id)
+	}
+
+	return v.Fs.Open(path.Join(versionDir(name), id))
+}
+
+// RestoreVersion replaces the content of name with its version id. The
+// content replaced is kept as a new version.
+func (v *VersionFs) RestoreVersion(name, id string) error {
+	src, err := v.OpenVersion(name, id)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	perm := os.FileMode(0644)
+	if fi, err := v.Fs.Stat(name); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	if _, err := v.save(name, false); err != nil {
+		return err
+	}
+
+	dest, err := v.Fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dest, src); err != nil {
+		dest.Close()
+		return err
+	}
+
+	if err := dest.Close(); err != nil {
+		return err
+	}
+
+	return v.done(name)
+}
+
+func (v *VersionFs) Create(name string) (afero.File, error) {
+	return v.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (v *VersionFs) Open(name string) (afero.File, error) {
+	return v.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (v *VersionFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if versionsHidden(name) {
+		if flag&os.O_CREATE != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+		}
+		return nil, notExist("open", name)
+	}
+
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+
+	var saved string
+	if writing && flag&os.O_TRUNC != 0 {
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			if _, err := v.Fs.Stat(name); err == nil {
+				return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+			}
+		}
+
+		// The file is copied, not moved away, so that it is truncated in
+		// place: the handles already open, its hard links and its owner are
+		// kept
+		var err error
+		if saved, err = v.save(name, false); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := v.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		if saved != "" {
+			v.Fs.Remove(saved)
+			v.done(name)
+		}
+		return nil, err
+	}
+
+	if saved != "" {
+		if err := v.done(name); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	if cleanPath(name) == "/" {
+		return &hiddenRoot{File: f, name: VersionsDir}, nil
+	}
+
+	if writing && flag&(os.O_TRUNC|os.O_APPEND) == 0 {
+		return &versionFile{File: f, fs: v, name: name}, nil
+	}
+
+	return f, nil
+}
+
+func (v *VersionFs) Rename(oldname, newname string) error {
+	if versionsHidden(oldname) {
+		return notExist("rename", oldname)
+	}
+	if versionsHidden(newname) {
+		return &os.PathError{Op: "rename", Path: newname, Err: os.ErrPermission}
+	}
+
+	saved, err := v.save(newname, true)
+	if err != nil {
+		return err
+	}
+
+	if err := v.Fs.Rename(oldname, newname); err != nil {
+		if saved != "" {
+			v.Fs.Rename(saved, newname)
+		}
+		return err
+	}
+
+	if saved != "" {
+		return v.done(newname)
+	}
+
+	return nil
+}
+
+func (v *VersionFs) Mkdir(name string, perm os.FileMode) error {
+	if versionsHidden(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+	}
+	return v.Fs.Mkdir(name, perm)
+}
+
+func (v *VersionFs) MkdirAll(name string, perm os.FileMode) error {
+	if versionsHidden(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+	}
+	return v.Fs.MkdirAll(name, perm)
+}
+
+func (v *VersionFs) Remove(name string) error {
+	if versionsHidden(name) {
+		return notExist("remove", name)
+	}
+	return v.Fs.Remove(name)
+}
+
+func (v *VersionFs) RemoveAll(name string) error {
+	if versionsHidden(name) {
+		return nil
+	}
+	return v.Fs.RemoveAll(name)
+}
+
+func (v *VersionFs) Stat(name string) (os.FileInfo, error) {
+	if versionsHidden(name) {
+		return nil, notExist("stat", name)
+	}
+	return v.Fs.Stat(name)
+}
+
+func (v *VersionFs) Chmod(name string, mode os.FileMode) error {
+	if versionsHidden(name) {
+		return notExist("chmod", name)
+	}
+	return v.Fs.Chmod(name, mode)
+}
+
+func (v *VersionFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if versionsHidden(name) {
+		return notExist("chtimes", name)
+	}
+	return v.Fs.Chtimes(name, atime, mtime)
+}
+
+// versionFile saves the content of a file opened for writing before it is
+// first modified.
+type versionFile struct {
+	afero.File
+	fs   *VersionFs
+	name string
+
+	once sync.Once
+	err  error
+}
+
+func (f *versionFile) saveOnce() error {
+	f.once.Do(func() {
+		var saved string
+		if saved, f.err = f.fs.save(f.name, false); saved != "" && f.err == nil {
+			f.err = f.fs.done(f.name)
+		}
+	})
+
+	return f.err
+}
+
+func (f *versionFile) Write(b []byte) (int, error) {
+	if err := f.saveOnce(); err != nil {
+		return 0, err
+	}
+	return f.File.Write(b)
+}
+
+func (f *versionFile) WriteAt(b []byte, off int64) (int, error) {
+	if err := f.saveOnce(); err != nil {
+		return 0, err
+	}
+	return f.File.WriteAt(b, off)
+}
+
+func (f *versionFile) WriteString(s string) (int, error) {
+	if err := f.saveOnce(); err != nil {
+		return 0, err
+	}
+	return f.File.WriteString(s)
+}
+
+func (f *versionFile) Truncate(size int64) error {
+	if err := f.saveOnce(); err != nil {
+		return err
+	}
+	return f.File.Truncate(size)
+}
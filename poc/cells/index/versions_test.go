@@ -0,0 +1,130 @@
+package index
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestVersionFs(t *testing.T) {
+	fs := NewVersionFs(afero.NewMemMapFs(), VersionOptions{MaxCount: 2})
+
+	for _, content := range []string{"one", "two", "three", "four"} {
+		if err := afero.WriteFile(fs, "/a.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := fs.Versions("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %+v", versions)
+	}
+
+	f, err := fs.OpenVersion("/a.txt", versions[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := afero.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "two" {
+		t.Fatalf("unexpected oldest version %q, %v", data, err)
+	}
+
+	if err := fs.RestoreVersion("/a.txt", versions[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	data, err = afero.ReadFile(fs, "/a.txt")
+	if err != nil || string(data) != "two" {
+		t.Fatalf("unexpected restored content %q, %v", data, err)
+	}
+
+	// The content replaced by the restore is a version too
+	versions, _ = fs.Versions("/a.txt")
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %+v", versions)
+	}
+	f, _ = fs.OpenVersion("/a.txt", versions[0].ID)
+	data, _ = afero.ReadAll(f)
+	f.Close()
+	if string(data) != "four" {
+		t.Fatalf("unexpected latest version %q", data)
+	}
+
+	// Renaming over a file keeps it
+	if err := afero.WriteFile(fs, "/b.txt", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/b.txt", "/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	versions, _ = fs.Versions("/a.txt")
+	f, _ = fs.OpenVersion("/a.txt", versions[0].ID)
+	data, _ = afero.ReadAll(f)
+	f.Close()
+	if string(data) != "two" {
+		t.Fatalf("unexpected version of the replaced file %q", data)
+	}
+
+	if _, err := fs.Stat("/" + VersionsDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the versions to be hidden, got %v", err)
+	}
+	names, err := readDirNames(fs, "/", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "a.txt" {
+		t.Fatalf("unexpected root listing %v", names)
+	}
+
+	if _, err := fs.OpenVersion("/a.txt", "../../a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected an invalid id to be rejected, got %v", err)
+	}
+}
+
+func TestVersionFsTruncate(t *testing.T) {
+	fs := NewVersionFs(afero.NewMemMapFs(), VersionOptions{})
+
+	if err := afero.WriteFile(fs, "/a.txt", []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exclusive creation fails without versioning the file
+	_, err := fs.OpenFile("/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0644)
+	if !os.IsExist(err) {
+		t.Fatalf("expected the file to exist, got %v", err)
+	}
+	if versions, _ := fs.Versions("/a.txt"); len(versions) != 0 {
+		t.Fatalf("expected no versions, got %+v", versions)
+	}
+
+	// The file is truncated in place, under the handles already open
+	f, err := fs.Open("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := afero.WriteFile(fs, "/a.txt", []byte("two"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := afero.ReadAll(f)
+	if err != nil || string(data) != "two" {
+		t.Fatalf("unexpected content read by the open handle %q, %v", data, err)
+	}
+
+	versions, err := fs.Versions("/a.txt")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("expected 1 version, got %+v, %v", versions, err)
+	}
+	v, _ := fs.OpenVersion("/a.txt", versions[0].ID)
+	data, _ = afero.ReadAll(v)
+	v.Close()
+	if string(data) != "one" {
+		t.Fatalf("unexpected version %q", data)
+	}
+}
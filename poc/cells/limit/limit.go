@@ -0,0 +1,400 @@
+// Package limit throttles the clients of an index server with token buckets
+// kept per principal and per export.
+package limit
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/index"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// streamRetry is the delay suggested to clients exceeding their number
+	// of concurrent streams.
+	streamRetry = time.Second
+
+	// Buckets unused for idleTimeout are forgotten.
+	idleTimeout   = 10 * time.Minute
+	pruneInterval = time.Minute
+
+	// healthService is never throttled, a loaded node must not be taken
+	// for an unhealthy one.
+	healthService = "/grpc.health.v1.Health/"
+)
+
+// Limits are the rates allowed to a principal or an export. Zero values mean
+// no limit.
+type Limits struct {
+	// Ops is the number of calls, or of requests sent on an Open stream,
+	// per second.
+	Ops float64
+
+	// ReadBytes and WriteBytes are the file contents transferred per
+	// second.
+	ReadBytes  int64
+	WriteBytes int64
+
+	// Streams is the number of Open streams held at the same time.
+	Streams int
+}
+
+// Config holds the default limits applied to every principal and every
+// export, and the overrides for some of them by name.
+type Config struct {
+	Principal Limits
+	Export    Limits
+
+	Principals map[string]Limits
+	Exports    map[string]Limits
+}
+
+func (c *Config) principal(name string) Limits {
+	if l, ok := c.Principals[name]; ok {
+		return l
+	}
+	return c.Principal
+}
+
+func (c *Config) export(name string) Limits {
+	if l, ok := c.Exports[name]; ok {
+		return l
+	}
+	return c.Export
+}
+
+// Exports tells the exports served by the node apart from the names sent by
+// clients, index.Handler implements it.
+type Exports interface {
+	// Export returns the export a call is made to, without its snapshot,
+	// and whether it is served.
+	Export(ctx context.Context) (string, bool)
+}
+
+// Limiter enforces a Config through gRPC interceptors.
+//
+// Buckets are kept per export served, snapshots share the bucket of their
+// export and calls to other exports are rejected with NOT_FOUND.
+//
+// Unary calls and new Open streams over the limits are rejected with
+// RESOURCE_EXHAUSTED and a retry-after trailer. Requests already inside an
+// Open stream are delayed instead, so that a file in use is never broken.
+// Health checks are not limited.
+type Limiter struct {
+	served Exports
+
+	mu         sync.Mutex
+	cfg        Config
+	principals map[string]*bucket
+	exports    map[string]*bucket
+	pruned     time.Time
+}
+
+type bucket struct {
+	ops, read, write *rate.Limiter
+
+	maxStreams int
+	streams    int
+	seen       time.Time
+}
+
+// newBucket returns a bucket enforcing l, full so that the first calls of a
+// client are not delayed.
+func newBucket(l Limits) *bucket {
+	return &bucket{
+		ops:        newLimiter(l.Ops),
+		read:       newLimiter(float64(l.ReadBytes)),
+		write:      newLimiter(float64(l.WriteBytes)),
+		maxStreams: l.Streams,
+	}
+}
+
+// set applies l, keeping the tokens already consumed.
+func (b *bucket) set(l Limits) {
+	setRate(b.ops, l.Ops)
+	setRate(b.read, float64(l.ReadBytes))
+	setRate(b.write, float64(l.WriteBytes))
+
+	b.maxStreams = l.Streams
+}
+
+func newLimiter(r float64) *rate.Limiter {
+	return rate.NewLimiter(limitOf(r))
+}
+
+// setRate allows r events per second with bursts of one second worth of
+// events.
+func setRate(lim *rate.Limiter, r float64) {
+	limit, burst := limitOf(r)
+	lim.SetLimit(limit)
+	lim.SetBurst(burst)
+}
+
+// limitOf returns the limit and the burst allowing r events per second,
+// with no limit when r is zero.
+func limitOf(r float64) (rate.Limit, int) {
+	if r <= 0 {
+		return rate.Inf, 0
+	}
+
+	burst := int(r)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return rate.Limit(r), burst
+}
+
+// New returns a Limiter enforcing cfg on the exports served.
+func New(cfg Config, exports Exports) *Limiter {
+	return &Limiter{
+		served:     exports,
+		cfg:        cfg,
+		principals: make(map[string]*bucket),
+		exports:    make(map[string]*bucket),
+		pruned:     time.Now(),
+	}
+}
+
+// SetConfig replaces the limits. Clients already known get the new limits
+// right away.
+func (l *Limiter) SetConfig(cfg Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cfg = cfg
+
+	for name, b := range l.principals {
+		b.set(cfg.principal(name))
+	}
+	for name, b := range l.exports {
+		b.set(cfg.export(name))
+	}
+}
+
+// buckets returns the buckets of the principal and the export of an
+// incoming call. Calls to exports that are not served are rejected, their
+// names are chosen by the clients.
+func (l *Limiter) buckets(ctx context.Context) (*bucket, *bucket, error) {
+	export, ok := l.served.Export(ctx)
+	if !ok {
+		return nil, nil, status.Errorf(codes.NotFound, "unknown export %q", index.ExportFromContext(ctx))
+	}
+
+	principal := index.PrincipalFromContext(ctx)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.pruned) > pruneInterval {
+		prune(l.principals, now)
+		prune(l.exports, now)
+		l.pruned = now
+	}
+
+	p, ok := l.principals[principal]
+	if !ok {
+		p = newBucket(l.cfg.principal(principal))
+		l.principals[principal] = p
+	}
+
+	e, ok := l.exports[export]
+	if !ok {
+		e = newBucket(l.cfg.export(export))
+		l.exports[export] = e
+	}
+
+	p.seen, e.seen = now, now
+
+	return p, e, nil
+}
+
+func prune(buckets map[string]*bucket, now time.Time) {
+	for name, b := range buckets {
+		if b.streams == 0 && now.Sub(b.seen) > idleTimeout {
+			delete(buckets, name)
+		}
+	}
+}
+
+// UnaryServerInterceptor rejects calls exceeding the operations rate.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if exempt(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		p, e, err := l.buckets(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if d := reserve(p.ops, e.ops); d > 0 {
+			grpc.SetTrailer(ctx, retryAfter(d))
+			return nil, status.Errorf(codes.ResourceExhausted, "operation rate exceeded, retry after %s", d)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams over the concurrent streams limit
+// and throttles the requests and the bytes going through the others.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if exempt(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		p, e, err := l.buckets(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		if !l.acquire(p, e) {
+			ss.SetTrailer(retryAfter(streamRetry))
+			return status.Errorf(codes.ResourceExhausted, "too many open streams, retry after %s", streamRetry)
+		}
+		defer l.release(p, e)
+
+		return handler(srv, &stream{ServerStream: ss, p: p, e: e})
+	}
+}
+
+// exempt reports whether calls to method are not limited.
+func exempt(method string) bool {
+	return strings.HasPrefix(method, healthService)
+}
+
+func (l *Limiter) acquire(buckets ...*bucket) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, b := range buckets {
+		if b.maxStreams > 0 && b.streams >= b.maxStreams {
+			return false
+		}
+	}
+
+	for _, b := range buckets {
+		b.streams++
+	}
+
+	return true
+}
+
+func (l *Limiter) release(buckets ...*bucket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, b := range buckets {
+		b.streams--
+	}
+}
+
+// stream delays the messages of an Open stream to keep within the limits.
+type stream struct {
+	grpc.ServerStream
+	p, e *bucket
+}
+
+func (s *stream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	ctx := s.Context()
+
+	if err := wait(ctx, 1, s.p.ops, s.e.ops); err != nil {
+		return err
+	}
+
+	if req, ok := m.(*index.FileRequest); ok {
+		n := len(req.GetWrite().GetContent()) + len(req.GetWriteAt().GetContent())
+		if err := wait(ctx, n, s.p.write, s.e.write); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *stream) SendMsg(m interface{}) error {
+	var n int
+	switch resp := m.(type) {
+	case *index.FileResponse:
+		n = len(resp.GetRead().GetContent())
+	case *index.ReadResponse:
+		n = len(resp.GetContent())
+	}
+
+	if err := wait(s.Context(), n, s.p.read, s.e.read); err != nil {
+		return err
+	}
+
+	return s.ServerStream.SendMsg(m)
+}
+
+// reserve takes a token from every limiter, or none if one of them would
+// have to wait. It returns how long to wait before trying again.
+func reserve(lims ...*rate.Limiter) time.Duration {
+	now := time.Now()
+
+	var (
+		rs    []*rate.Reservation
+		delay time.Duration
+	)
+	for _, lim := range lims {
+		r := lim.ReserveN(now, 1)
+		rs = append(rs, r)
+
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+
+	if delay > 0 {
+		for _, r := range rs {
+			r.CancelAt(now)
+		}
+	}
+
+	return delay
+}
+
+// wait blocks until n tokens are available in every limiter.
+func wait(ctx context.Context, n int, lims ...*rate.Limiter) error {
+	for _, lim := range lims {
+		if lim.Limit() == rate.Inf {
+			continue
+		}
+
+		// WaitN refuses more tokens than the burst, take them in steps
+		for left := n; left > 0; {
+			k := left
+			if b := lim.Burst(); k > b {
+				k = b
+			}
+
+			if err := lim.WaitN(ctx, k); err != nil {
+				return status.FromContextError(err).Err()
+			}
+
+			left -= k
+		}
+	}
+
+	return nil
+}
+
+func retryAfter(d time.Duration) metadata.MD {
+	return metadata.Pairs(index.RetryAfterKey, d.String())
+}
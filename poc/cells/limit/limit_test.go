@@ -0,0 +1,228 @@
+package limit
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/index"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// transportStream records the trailer set by the interceptors.
+type transportStream struct {
+	method  string
+	trailer metadata.MD
+}
+
+func (s *transportStream) Method() string                  { return s.method }
+func (s *transportStream) SetHeader(md metadata.MD) error  { return nil }
+func (s *transportStream) SendHeader(md metadata.MD) error { return nil }
+func (s *transportStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+// serverStream is an Open stream of a client, recording its trailer.
+type serverStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	trailer metadata.MD
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+func (s *serverStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+// callContext is the context of a call from ip to export.
+func callContext(ip, export string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}})
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(index.ExportKey, export))
+}
+
+// exports is a set of served exports.
+type exports map[string]bool
+
+func (e exports) Export(ctx context.Context) (string, bool) {
+	name := index.ExportFromContext(ctx)
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+
+	return name, e[name]
+}
+
+func TestReserve(t *testing.T) {
+	a := rate.NewLimiter(10, 1)
+	b := rate.NewLimiter(10, 2)
+
+	if d := reserve(a, b); d != 0 {
+		t.Fatalf("expected a token right away, got a delay of %s", d)
+	}
+
+	// a is empty, the token taken from b is given back
+	if d := reserve(a, b); d <= 0 {
+		t.Fatal("expected a delay")
+	}
+	if !b.Allow() {
+		t.Error("expected the token of b to be given back")
+	}
+}
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+
+	// More tokens than the burst are taken in steps
+	lim := rate.NewLimiter(1000, 10)
+	start := time.Now()
+	if err := wait(ctx, 50, lim, rate.NewLimiter(rate.Inf, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("expected to wait for the tokens, waited %s", d)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := wait(ctx, 2, rate.NewLimiter(0.1, 1)); err == nil {
+		t.Fatal("expected the wait to be interrupted")
+	}
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	l := New(Config{Principal: Limits{Ops: 1}}, exports{"photos": true})
+	intercept := l.UnaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	call := func(ip, method string) (*transportStream, error) {
+		ts := &transportStream{method: method}
+		ctx := grpc.NewContextWithServerTransportStream(callContext(ip, "photos"), ts)
+		_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return ts, err
+	}
+
+	if _, err := call("10.0.0.1", "/index.FS/Stat"); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := call("10.0.0.1", "/index.FS/Stat")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the rate to be exceeded, got %v", err)
+	}
+	if v := ts.trailer.Get(index.RetryAfterKey); len(v) != 1 {
+		t.Errorf("expected a retry-after trailer, got %v", ts.trailer)
+	} else if d, err := time.ParseDuration(v[0]); err != nil || d <= 0 {
+		t.Errorf("unexpected retry-after %q, %v", v[0], err)
+	}
+
+	// Other principals have their own bucket
+	if _, err := call("10.0.0.2", "/index.FS/Stat"); err != nil {
+		t.Errorf("expected another principal to be allowed, got %v", err)
+	}
+
+	// Health checks are never throttled
+	if _, err := call("10.0.0.1", "/grpc.health.v1.Health/Check"); err != nil {
+		t.Errorf("expected health checks to be allowed, got %v", err)
+	}
+
+	// Reloading applies to the principals already known
+	l.SetConfig(Config{})
+	if _, err := call("10.0.0.1", "/index.FS/Stat"); err != nil {
+		t.Errorf("expected the limit to be lifted, got %v", err)
+	}
+
+	l.SetConfig(Config{Principals: map[string]Limits{"10.0.0.2": {Ops: 0.001}}})
+	if _, err := call("10.0.0.2", "/index.FS/Stat"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected the override to apply, got %v", err)
+	}
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	l := New(Config{Export: Limits{Streams: 1}}, exports{"photos": true, "archive": true})
+	intercept := l.StreamServerInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: "/index.FS/Open"}
+
+	opened, release := make(chan struct{}), make(chan struct{})
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		opened <- struct{}{}
+		<-release
+		return nil
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- intercept(nil, &serverStream{ctx: callContext("10.0.0.1", "photos")}, info, handler)
+	}()
+	<-opened
+
+	ss := &serverStream{ctx: callContext("10.0.0.2", "photos")}
+	if err := intercept(nil, ss, info, handler); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected too many streams, got %v", err)
+	}
+	if v := ss.trailer.Get(index.RetryAfterKey); len(v) != 1 || v[0] != streamRetry.String() {
+		t.Errorf("unexpected retry-after trailer %v", ss.trailer)
+	}
+
+	// Other exports have their own limit
+	go func() {
+		done <- intercept(nil, &serverStream{ctx: callContext("10.0.0.2", "archive")}, info, handler)
+	}()
+	<-opened
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Streams closed are given back
+	release = make(chan struct{})
+	close(release)
+	go func() { <-opened }()
+	if err := intercept(nil, &serverStream{ctx: callContext("10.0.0.2", "photos")}, info, handler); err != nil {
+		t.Errorf("expected the stream to be released, got %v", err)
+	}
+}
+
+func TestExportBuckets(t *testing.T) {
+	l := New(Config{Exports: map[string]Limits{"photos": {Ops: 1}}}, exports{"photos": true})
+	intercept := l.UnaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	call := func(ip, export string) error {
+		ctx := grpc.NewContextWithServerTransportStream(callContext(ip, export), &transportStream{method: "/index.FS/Stat"})
+		_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/index.FS/Stat"}, handler)
+		return err
+	}
+
+	if err := call("10.0.0.1", "photos"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Snapshots are limited along with their export
+	if err := call("10.0.0.2", "photos@daily"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected the limit of photos to apply to its snapshots, got %v", err)
+	}
+
+	// Names that are not exports get no bucket of their own
+	for _, export := range []string{"music", "music@daily"} {
+		if err := call("10.0.0.3", export); status.Code(err) != codes.NotFound {
+			t.Errorf("expected %s to be unknown, got %v", export, err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.exports) != 1 || l.exports["photos"] == nil {
+		t.Errorf("expected a single bucket for photos, got %v", l.exports)
+	}
+}
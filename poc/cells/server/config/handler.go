@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+// maxTxnOps is the maximum number of operations in a txn, as in etcd.
+const maxTxnOps = 128
+
+// Handler serves the etcd KV, Watch and Lease APIs from the store.
+type Handler struct {
+	store *store
+
+	// progressInterval overrides the interval of the progress
+	// notifications of the watchers
+	progressInterval time.Duration
+}
+
+func header(rev int64) *etcdserverpb.ResponseHeader {
+	return &etcdserverpb.ResponseHeader{Revision: rev}
+}
+
+// Range gets the keys in the range from the key-value store.
+func (h *Handler) Range(ctx context.Context, r *etcdserverpb.RangeRequest) (*etcdserverpb.RangeResponse, error) {
+	if len(r.Key) == 0 {
+		return nil, rpctypes.ErrGRPCEmptyKey
+	}
+
+	var resp *etcdserverpb.RangeResponse
+	err := h.store.view(func(t *txn) error {
+		var err error
+		resp, err = rangeOp(t, r)
+		if err == nil {
+			resp.Header = header(t.Rev())
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Put puts the given key into the key-value store.
+// A put request increments the revision of the key-value store
+// and generates one event in the event history.
+func (h *Handler) Put(ctx context.Context, r *etcdserverpb.PutRequest) (*etcdserverpb.PutResponse, error) {
+	if err := checkPut(r); err != nil {
+		return nil, err
+	}
+
+	var resp *etcdserverpb.PutResponse
+	rev, _, err := h.store.update(func(t *txn) error {
+		var err error
+		resp, err = putOp(t, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Header = header(rev)
+
+	return resp, nil
+}
+
+// DeleteRange deletes the given range from the key-value store.
+// A delete request increments the revision of the key-value store
+// and generates a delete event in the event history for every deleted key.
+func (h *Handler) DeleteRange(ctx context.Context, r *etcdserverpb.DeleteRangeRequest) (*etcdserverpb.DeleteRangeResponse, error) {
+	if len(r.Key) == 0 {
+		return nil, rpctypes.ErrGRPCEmptyKey
+	}
+
+	var resp *etcdserverpb.DeleteRangeResponse
+	rev, _, err := h.store.update(func(t *txn) error {
+		var err error
+		resp, err = deleteOp(t, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Header = header(rev)
+
+	return resp, nil
+}
+
+// Txn processes multiple requests in a single transaction.
+// A txn request increments the revision of the key-value store
+// and generates events with the same revision for every completed request.
+// It is not allowed to modify the same key several times within one txn.
+func (h *Handler) Txn(ctx context.Context, r *etcdserverpb.TxnRequest) (*etcdserverpb.TxnResponse, error) {
+	if err := checkTxn(r); err != nil {
+		return nil, err
+	}
+
+	var resp *etcdserverpb.TxnResponse
+	rev, _, err := h.store.update(func(t *txn) error {
+		var err error
+		resp, err = txnOp(t, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	setHeaders(resp, header(rev))
+
+	return resp, nil
+}
+
+// Compact compacts the event history in the etcd key-value store. The key-value
+// store should be periodically compacted or the event history will continue to grow
+// indefinitely.
+func (h *Handler) Compact(ctx context.Context, r *etcdserverpb.CompactionRequest) (*etcdserverpb.CompactionResponse, error) {
+	if err := h.store.Compact(r.Revision); err != nil {
+		return nil, err
+	}
+
+	return &etcdserverpb.CompactionResponse{Header: header(h.store.Rev())}, nil
+}
+
+// rangeOp reads the keys of r, filtering and sorting them before the limit
+// is applied as etcd does.
+func rangeOp(t *txn, r *etcdserverpb.RangeRequest) (*etcdserverpb.RangeResponse, error) {
+	filtered := r.MinModRevision != 0 || r.MaxModRevision != 0 || r.MinCreateRevision != 0 || r.MaxCreateRevision != 0
+
+	limit := r.Limit
+	if r.SortOrder != etcdserverpb.RangeRequest_NONE || filtered {
+		limit = 0
+	}
+	if limit > 0 {
+		// One more tells whether there are more keys
+		limit++
+	}
+
+	kvs, count, err := t.Range(r.Key, r.RangeEnd, r.Revision, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &etcdserverpb.RangeResponse{Count: int64(count)}
+	if r.CountOnly {
+		return resp, nil
+	}
+
+	if filtered {
+		kvs = filter(kvs, r)
+	}
+
+	order := r.SortOrder
+	if r.SortTarget != etcdserverpb.RangeRequest_KEY && order == etcdserverpb.RangeRequest_NONE {
+		order = etcdserverpb.RangeRequest_ASCEND
+	}
+	if order != etcdserverpb.RangeRequest_NONE {
+		less := sortLess(r.SortTarget, kvs)
+		if order == etcdserverpb.RangeRequest_DESCEND {
+			sort.SliceStable(kvs, func(i, j int) bool { return less(j, i) })
+		} else {
+			sort.SliceStable(kvs, less)
+		}
+	}
+
+	if r.Limit > 0 && len(kvs) > int(r.Limit) {
+		kvs = kvs[:r.Limit]
+		resp.More = true
+	}
+
+	if r.KeysOnly {
+		for _, kv := range kvs {
+			kv.Value = nil
+		}
+	}
+	resp.Kvs = kvs
+
+	return resp, nil
+}
+
+// filter keeps the key-values within the revisions bounds of r.
+func filter(kvs []*mvccpb.KeyValue, r *etcdserverpb.RangeRequest) []*mvccpb.KeyValue {
+	var kept []*mvccpb.KeyValue
+	for _, kv := range kvs {
+		switch {
+		case r.MinModRevision != 0 && kv.ModRevision < r.MinModRevision:
+		case r.MaxModRevision != 0 && kv.ModRevision > r.MaxModRevision:
+		case r.MinCreateRevision != 0 && kv.CreateRevision < r.MinCreateRevision:
+		case r.MaxCreateRevision != 0 && kv.CreateRevision > r.MaxCreateRevision:
+		default:
+			kept = append(kept, kv)
+		}
+	}
+
+	return kept
+}
+
+func sortLess(target etcdserverpb.RangeRequest_SortTarget, kvs []*mvccpb.KeyValue) func(i, j int) bool {
+	switch target {
+	case etcdserverpb.RangeRequest_VERSION:
+		return func(i, j int) bool { return kvs[i].Version < kvs[j].Version }
+	case etcdserverpb.RangeRequest_CREATE:
+		return func(i, j int) bool { return kvs[i].CreateRevision < kvs[j].CreateRevision }
+	case etcdserverpb.RangeRequest_MOD:
+		return func(i, j int) bool { return kvs[i].ModRevision < kvs[j].ModRevision }
+	case etcdserverpb.RangeRequest_VALUE:
+		return func(i, j int) bool { return bytes.Compare(kvs[i].Value, kvs[j].Value) < 0 }
+	default:
+		return func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 }
+	}
+}
+
+func checkPut(r *etcdserverpb.PutRequest) error {
+	switch {
+	case len(r.Key) == 0:
+		return rpctypes.ErrGRPCEmptyKey
+	case r.IgnoreValue && len(r.Value) != 0:
+		return rpctypes.ErrGRPCValueProvided
+	case r.IgnoreLease && r.Lease != 0:
+		return rpctypes.ErrGRPCLeaseProvided
+	}
+
+	return nil
+}
+
+func putOp(t *txn, r *etcdserverpb.PutRequest) (*etcdserverpb.PutResponse, error) {
+	if r.Lease != 0 && !t.s.leases.exists(r.Lease) {
+		return nil, rpctypes.ErrGRPCLeaseNotFound
+	}
+
+	value, lease := r.Value, r.Lease
+	if r.IgnoreValue || r.IgnoreLease {
+		cur, err := t.current(r.Key)
+		if err != nil {
+			return nil, err
+		}
+		if cur == nil {
+			return nil, rpctypes.ErrGRPCKeyNotFound
+		}
+
+		if r.IgnoreValue {
+			value = cur.Value
+		}
+		if r.IgnoreLease {
+			lease = cur.Lease
+		}
+	}
+
+	prev, err := t.Put(r.Key, value, lease)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &etcdserverpb.PutResponse{}
+	if r.PrevKv {
+		resp.PrevKv = prev
+	}
+
+	return resp, nil
+}
+
+func deleteOp(t *txn, r *etcdserverpb.DeleteRangeRequest) (*etcdserverpb.DeleteRangeResponse, error) {
+	prevs, err := t.DeleteRange(r.Key, r.RangeEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &etcdserverpb.DeleteRangeResponse{Deleted: int64(len(prevs))}
+	if r.PrevKv {
+		resp.PrevKvs = prevs
+	}
+
+	return resp, nil
+}
+
+// txnOp runs the success or the failure operations of r depending on its
+// comparisons. Later operations see the changes of the earlier ones.
+func txnOp(t *txn, r *etcdserverpb.TxnRequest) (*etcdserverpb.TxnResponse, error) {
+	succeeded := true
+	for _, c := range r.Compare {
+		ok, err := compare(t, c)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			succeeded = false
+			break
+		}
+	}
+
+	ops := r.Success
+	if !succeeded {
+		ops = r.Failure
+	}
+
+	resp := &etcdserverpb.TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		var rop etcdserverpb.ResponseOp
+		switch v := op.Request.(type) {
+		case *etcdserverpb.RequestOp_RequestRange:
+			res, err := rangeOp(t, v.RequestRange)
+			if err != nil {
+				return nil, err
+			}
+			rop.Response = &etcdserverpb.ResponseOp_ResponseRange{ResponseRange: res}
+		case *etcdserverpb.RequestOp_RequestPut:
+			res, err := putOp(t, v.RequestPut)
+			if err != nil {
+				return nil, err
+			}
+			rop.Response = &etcdserverpb.ResponseOp_ResponsePut{ResponsePut: res}
+		case *etcdserverpb.RequestOp_RequestDeleteRange:
+			res, err := deleteOp(t, v.RequestDeleteRange)
+			if err != nil {
+				return nil, err
+			}
+			rop.Response = &etcdserverpb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: res}
+		case *etcdserverpb.RequestOp_RequestTxn:
+			res, err := txnOp(t, v.RequestTxn)
+			if err != nil {
+				return nil, err
+			}
+			rop.Response = &etcdserverpb.ResponseOp_ResponseTxn{ResponseTxn: res}
+		}
+		resp.Responses = append(resp.Responses, &rop)
+	}
+
+	return resp, nil
+}
+
+// compare evaluates c against every key of its range. Missing keys compare
+// as zero, but never match on their value.
+func compare(t *txn, c *etcdserverpb.Compare) (bool, error) {
+	kvs, _, err := t.Range(c.Key, c.RangeEnd, 0, 0)
+	if err != nil {
+		return false, err
+	}
+
+	if len(kvs) == 0 {
+		if c.Target == etcdserverpb.Compare_VALUE {
+			return false, nil
+		}
+		kvs = []*mvccpb.KeyValue{{}}
+	}
+
+	for _, kv := range kvs {
+		if !compareKV(c, kv) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func compareKV(c *etcdserverpb.Compare, kv *mvccpb.KeyValue) bool {
+	var result int
+	switch c.Target {
+	case etcdserverpb.Compare_VALUE:
+		result = bytes.Compare(kv.Value, c.GetValue())
+	case etcdserverpb.Compare_CREATE:
+		result = compareInt64(kv.CreateRevision, c.GetCreateRevision())
+	case etcdserverpb.Compare_MOD:
+		result = compareInt64(kv.ModRevision, c.GetModRevision())
+	case etcdserverpb.Compare_VERSION:
+		result = compareInt64(kv.Version, c.GetVersion())
+	case etcdserverpb.Compare_LEASE:
+		result = compareInt64(kv.Lease, c.GetLease())
+	}
+
+	switch c.Result {
+	case etcdserverpb.Compare_EQUAL:
+		return result == 0
+	case etcdserverpb.Compare_NOT_EQUAL:
+		return result != 0
+	case etcdserverpb.Compare_GREATER:
+		return result > 0
+	case etcdserverpb.Compare_LESS:
+		return result < 0
+	}
+
+	return false
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// checkTxn validates r as etcd does: keys must be given, and the operations
+// of a branch must not modify the same key twice.
+func checkTxn(r *etcdserverpb.TxnRequest) error {
+	if len(r.Compare) > maxTxnOps || len(r.Success) > maxTxnOps || len(r.Failure) > maxTxnOps {
+		return rpctypes.ErrGRPCTooManyOps
+	}
+
+	for _, c := range r.Compare {
+		if len(c.Key) == 0 {
+			return rpctypes.ErrGRPCEmptyKey
+		}
+	}
+
+	for _, ops := range [][]*etcdserverpb.RequestOp{r.Success, r.Failure} {
+		if _, _, err := checkOps(ops); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyRange is the range [key, end) of a delete, a single key when end is
+// empty.
+type keyRange struct {
+	key, end []byte
+}
+
+func (kr keyRange) contains(key []byte) bool {
+	switch {
+	case len(kr.end) == 0:
+		return bytes.Equal(key, kr.key)
+	case len(kr.end) == 1 && kr.end[0] == 0:
+		return bytes.Compare(key, kr.key) >= 0
+	default:
+		return bytes.Compare(key, kr.key) >= 0 && bytes.Compare(key, kr.end) < 0
+	}
+}
+
+// checkOps checks the operations of a branch, and returns the keys they
+// put and the ranges they delete. Both branches of nested txns count.
+func checkOps(ops []*etcdserverpb.RequestOp) (map[string]bool, []keyRange, error) {
+	puts := make(map[string]bool)
+	var dels []keyRange
+
+	addPut := func(key []byte) error {
+		if puts[string(key)] {
+			return rpctypes.ErrGRPCDuplicateKey
+		}
+		puts[string(key)] = true
+		return nil
+	}
+
+	for _, op := range ops {
+		switch v := op.Request.(type) {
+		case *etcdserverpb.RequestOp_RequestRange:
+			if len(v.RequestRange.GetKey()) == 0 {
+				return nil, nil, rpctypes.ErrGRPCEmptyKey
+			}
+		case *etcdserverpb.RequestOp_RequestPut:
+			if err := checkPut(v.RequestPut); err != nil {
+				return nil, nil, err
+			}
+			if err := addPut(v.RequestPut.Key); err != nil {
+				return nil, nil, err
+			}
+		case *etcdserverpb.RequestOp_RequestDeleteRange:
+			if len(v.RequestDeleteRange.GetKey()) == 0 {
+				return nil, nil, rpctypes.ErrGRPCEmptyKey
+			}
+			dels = append(dels, keyRange{v.RequestDeleteRange.Key, v.RequestDeleteRange.RangeEnd})
+		case *etcdserverpb.RequestOp_RequestTxn:
+			if err := checkTxn(v.RequestTxn); err != nil {
+				return nil, nil, err
+			}
+			// Only one branch runs, they may modify the same keys
+			nested := make(map[string]bool)
+			for _, branch := range [][]*etcdserverpb.RequestOp{v.RequestTxn.Success, v.RequestTxn.Failure} {
+				p, d, err := checkOps(branch)
+				if err != nil {
+					return nil, nil, err
+				}
+				for key := range p {
+					nested[key] = true
+				}
+				dels = append(dels, d...)
+			}
+			for key := range nested {
+				if err := addPut([]byte(key)); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	for key := range puts {
+		for _, d := range dels {
+			if d.contains([]byte(key)) {
+				return nil, nil, rpctypes.ErrGRPCDuplicateKey
+			}
+		}
+	}
+
+	return puts, dels, nil
+}
+
+// setHeaders sets h on resp and the responses of its operations.
+func setHeaders(resp *etcdserverpb.TxnResponse, h *etcdserverpb.ResponseHeader) {
+	resp.Header = h
+	for _, op := range resp.Responses {
+		switch v := op.Response.(type) {
+		case *etcdserverpb.ResponseOp_ResponseRange:
+			v.ResponseRange.Header = h
+		case *etcdserverpb.ResponseOp_ResponsePut:
+			v.ResponsePut.Header = h
+		case *etcdserverpb.ResponseOp_ResponseDeleteRange:
+			v.ResponseDeleteRange.Header = h
+		case *etcdserverpb.ResponseOp_ResponseTxn:
+			setHeaders(v.ResponseTxn, h)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	bolt "go.etcd.io/bbolt"
+)
+
+var leaseBucket = []byte("lease")
+
+// errLeaseKept is returned when an expired lease was kept alive before it
+// was revoked.
+var errLeaseKept = errors.New("lease kept alive")
+
+const (
+	// minLeaseTTL and maxLeaseTTL bound the TTL of the leases in seconds,
+	// shorter TTLs are raised to minLeaseTTL as in etcd
+	minLeaseTTL = 2
+	maxLeaseTTL = 9000000000
+
+	// expireInterval is how often the expired leases are revoked
+	expireInterval = 500 * time.Millisecond
+)
+
+// lease holds keys until it is revoked or is not kept alive within its TTL.
+type lease struct {
+	id     int64
+	ttl    int64
+	expiry time.Time
+	keys   map[string]bool
+}
+
+func (l *lease) remaining(now time.Time) int64 {
+	d := l.expiry.Sub(now)
+	if d <= 0 {
+		return 0
+	}
+
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// lessor tracks the leases of the store and the keys attached to them.
+// Leases are persisted with their TTL, and given a whole TTL again when the
+// store is opened.
+type lessor struct {
+	s *store
+
+	mu     sync.Mutex
+	leases map[int64]*lease
+}
+
+func newLessor(s *store) *lessor {
+	return &lessor{s: s, leases: make(map[int64]*lease)}
+}
+
+// load reads the leases back from the store, and the keys attached to
+// them at rev. The store must not be in use yet.
+func (l *lessor) load(tx *bolt.Tx, rev int64) error {
+	now := time.Now()
+
+	err := tx.Bucket(leaseBucket).ForEach(func(k, v []byte) error {
+		id := int64(binary.BigEndian.Uint64(k))
+		ttl := int64(binary.BigEndian.Uint64(v))
+		l.leases[id] = &lease{id: id, ttl: ttl, expiry: now.Add(time.Duration(ttl) * time.Second), keys: make(map[string]bool)}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	kvs, _, err := (&txn{s: l.s, tx: tx, rev: rev}).Range([]byte{0}, []byte{0}, rev, 0)
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		if le, ok := l.leases[kv.Lease]; ok {
+			le.keys[string(kv.Key)] = true
+		}
+	}
+
+	return nil
+}
+
+// exists reports whether the lease id can hold keys.
+func (l *lessor) exists(id int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.leases[id]
+	return ok
+}
+
+// apply attaches the keys put with a lease to it, and detaches them from
+// the lease they had.
+func (l *lessor) apply(events []*mvccpb.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, ev := range events {
+		key := string(ev.Kv.Key)
+		if ev.PrevKv != nil {
+			if le, ok := l.leases[ev.PrevKv.Lease]; ok {
+				delete(le.keys, key)
+			}
+		}
+		if ev.Type == mvccpb.PUT {
+			if le, ok := l.leases[ev.Kv.Lease]; ok {
+				le.keys[key] = true
+			}
+		}
+	}
+}
+
+// Grant creates a lease of ttl seconds, at least minLeaseTTL, with a random
+// ID if id is 0.
+func (l *lessor) Grant(id, ttl int64) (*lease, error) {
+	if ttl > maxLeaseTTL {
+		return nil, rpctypes.ErrGRPCLeaseTTLTooLarge
+	}
+	if ttl < minLeaseTTL {
+		ttl = minLeaseTTL
+	}
+
+	l.mu.Lock()
+	for id == 0 {
+		if id = rand.Int63(); l.leases[id] != nil {
+			id = 0
+		}
+	}
+	if _, ok := l.leases[id]; ok {
+		l.mu.Unlock()
+		return nil, rpctypes.ErrGRPCLeaseExist
+	}
+
+	le := &lease{id: id, ttl: ttl, expiry: time.Now().Add(time.Duration(ttl) * time.Second), keys: make(map[string]bool)}
+	l.leases[id] = le
+	l.mu.Unlock()
+
+	// Writes of the store check leases within bolt transactions, l.mu
+	// must not be held here
+	err := l.s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(leaseBucket).Put(int64Bytes(id), int64Bytes(ttl))
+	})
+	if err != nil {
+		l.mu.Lock()
+		delete(l.leases, id)
+		l.mu.Unlock()
+
+		return nil, err
+	}
+
+	return le, nil
+}
+
+// Renew gives the lease id its whole TTL again, and returns it.
+func (l *lessor) Renew(id int64) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	le, ok := l.leases[id]
+	if !ok {
+		return 0, rpctypes.ErrGRPCLeaseNotFound
+	}
+	le.expiry = time.Now().Add(time.Duration(le.ttl) * time.Second)
+
+	return le.ttl, nil
+}
+
+// Lookup returns the TTL of the lease id, the seconds it has left, and the
+// keys attached to it.
+func (l *lessor) Lookup(id int64) (ttl, remaining int64, keys [][]byte, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	le, ok := l.leases[id]
+	if !ok {
+		return 0, 0, nil, rpctypes.ErrGRPCLeaseNotFound
+	}
+
+	for key := range le.keys {
+		keys = append(keys, []byte(key))
+	}
+
+	return le.ttl, le.remaining(time.Now()), keys, nil
+}
+
+// Leases returns the IDs of the leases.
+func (l *lessor) Leases() []int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var ids []int64
+	for id := range l.leases {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Revoke deletes the lease id and the keys attached to it, in a single
+// revision, and returns the revision of the store.
+func (l *lessor) Revoke(id int64) (int64, error) {
+	return l.revoke(id, func(*lease) bool { return true })
+}
+
+// revoke revokes the lease id if it is found and ok. The lease is kept if
+// the keys cannot be deleted.
+func (l *lessor) revoke(id int64, ok func(*lease) bool) (int64, error) {
+	var le *lease
+	rev, _, err := l.s.update(func(t *txn) error {
+		// No key can be put with the lease once the store is locked
+		l.mu.Lock()
+		var found bool
+		le, found = l.leases[id]
+		if found && !ok(le) {
+			l.mu.Unlock()
+			return errLeaseKept
+		}
+		delete(l.leases, id)
+		l.mu.Unlock()
+
+		if !found {
+			return rpctypes.ErrGRPCLeaseNotFound
+		}
+
+		for key := range le.keys {
+			cur, err := t.current([]byte(key))
+			if err != nil {
+				return err
+			}
+
+			// Keys put since without the lease are kept
+			if cur != nil && cur.Lease == id {
+				if _, err := t.DeleteRange(cur.Key, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		return t.tx.Bucket(leaseBucket).Delete(int64Bytes(id))
+	})
+	if err != nil && err != errLeaseKept && le != nil {
+		// Nothing was written, the lease still holds its keys
+		l.mu.Lock()
+		if _, ok := l.leases[id]; !ok {
+			l.leases[id] = le
+		}
+		l.mu.Unlock()
+	}
+
+	return rev, err
+}
+
+// run revokes the leases expired every interval until done is closed.
+func (l *lessor) run(interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		now := time.Now()
+
+		var expired []int64
+		l.mu.Lock()
+		for id, le := range l.leases {
+			if now.After(le.expiry) {
+				expired = append(expired, id)
+			}
+		}
+		l.mu.Unlock()
+
+		// Leases kept alive or revoked meanwhile are left alone
+		for _, id := range expired {
+			_, err := l.revoke(id, func(le *lease) bool { return time.Now().After(le.expiry) })
+			if err != nil && err != errLeaseKept && err != rpctypes.ErrGRPCLeaseNotFound {
+				log.Printf("revoking lease %x: %v", id, err)
+			}
+		}
+	}
+}
+
+// LeaseGrant creates a lease which expires if the server does not receive a keepAlive
+// within a given time to live period. All keys attached to the lease will be expired and
+// deleted if the lease expires. Each expired key generates a delete event in the event history.
+func (h *Handler) LeaseGrant(ctx context.Context, r *etcdserverpb.LeaseGrantRequest) (*etcdserverpb.LeaseGrantResponse, error) {
+	le, err := h.store.leases.Grant(r.ID, r.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &etcdserverpb.LeaseGrantResponse{Header: header(h.store.Rev()), ID: le.id, TTL: le.ttl}, nil
+}
+
+// LeaseRevoke revokes a lease. All keys attached to the lease will expire and be deleted.
+func (h *Handler) LeaseRevoke(ctx context.Context, r *etcdserverpb.LeaseRevokeRequest) (*etcdserverpb.LeaseRevokeResponse, error) {
+	rev, err := h.store.leases.Revoke(r.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &etcdserverpb.LeaseRevokeResponse{Header: header(rev)}, nil
+}
+
+// LeaseKeepAlive keeps the lease alive by streaming keep alive requests from the client
+// to the server and streaming keep alive responses from the server to the client.
+// Leases not found are told with a TTL of 0.
+func (h *Handler) LeaseKeepAlive(stream etcdserverpb.Lease_LeaseKeepAliveServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		ttl, err := h.store.leases.Renew(req.ID)
+		if err != nil && err != rpctypes.ErrGRPCLeaseNotFound {
+			return err
+		}
+
+		if err := stream.Send(&etcdserverpb.LeaseKeepAliveResponse{Header: header(h.store.Rev()), ID: req.ID, TTL: ttl}); err != nil {
+			return err
+		}
+	}
+}
+
+// LeaseTimeToLive retrieves lease information. Leases not found have a TTL
+// of -1.
+func (h *Handler) LeaseTimeToLive(ctx context.Context, r *etcdserverpb.LeaseTimeToLiveRequest) (*etcdserverpb.LeaseTimeToLiveResponse, error) {
+	ttl, remaining, keys, err := h.store.leases.Lookup(r.ID)
+	if err == rpctypes.ErrGRPCLeaseNotFound {
+		return &etcdserverpb.LeaseTimeToLiveResponse{Header: header(h.store.Rev()), ID: r.ID, TTL: -1}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &etcdserverpb.LeaseTimeToLiveResponse{Header: header(h.store.Rev()), ID: r.ID, TTL: remaining, GrantedTTL: ttl}
+	if r.Keys {
+		resp.Keys = keys
+	}
+
+	return resp, nil
+}
+
+// LeaseLeases lists all existing leases.
+func (h *Handler) LeaseLeases(ctx context.Context, r *etcdserverpb.LeaseLeasesRequest) (*etcdserverpb.LeaseLeasesResponse, error) {
+	resp := &etcdserverpb.LeaseLeasesResponse{Header: header(h.store.Rev())}
+	for _, id := range h.store.leases.Leases() {
+		resp.Leases = append(resp.Leases, &etcdserverpb.LeaseStatus{ID: id})
+	}
+
+	return resp, nil
+}
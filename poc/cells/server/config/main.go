@@ -1,21 +1,39 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/ghecquet/tripr/poc/cells/client/resolver"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 const (
-	srvAddr       = ":0"
-	discoveryAddr = "224.0.0.1:9999"
+	srvAddr         = ":0"
+	nodeName        = "config"
+	shutdownTimeout = 30 * time.Second
 )
 
+var dataFile = flag.String("data", "config.db", "path of the file the keys are stored in")
+
 func main() {
+	flag.Parse()
+
+	st, err := openStore(*dataFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer st.Close()
+
 	s := grpc.NewServer()
 
 	lis, err := net.Listen("tcp", srvAddr)
@@ -25,26 +43,85 @@ func main() {
 
 	fmt.Println(lis.Addr())
 
-	etcdserverpb.RegisterKVServer(s, &Handler{})
+	hs := health.NewServer()
+	hs.SetServingStatus("etcdserverpb.KV", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("etcdserverpb.Watch", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("etcdserverpb.Lease", healthpb.HealthCheckResponse_SERVING)
+
+	h := &Handler{store: st}
+	etcdserverpb.RegisterKVServer(s, h)
+	etcdserverpb.RegisterWatchServer(s, h)
+	etcdserverpb.RegisterLeaseServer(s, h)
+	healthpb.RegisterHealthServer(s, hs)
+
+	stop := make(chan struct{})
+	left := make(chan struct{})
+
+	go ping(lis.Addr(), s, stop, left)
+
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Fatal(err)
+		}
+	}()
 
-	go ping(lis.Addr(), s)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
 
-	s.Serve(lis)
+	sig := <-c
+	log.Printf("received %s, shutting down", sig)
+
+	hs.Shutdown()
+	close(stop)
+	<-left
+
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		log.Printf("streams still open after %s, closing them", shutdownTimeout)
+		s.Stop()
+	}
 
 	// Checkout hashicorp plugin ??
 }
 
-func ping(a net.Addr, s *grpc.Server) {
-	addr, err := net.ResolveUDPAddr("udp", discoveryAddr)
+// ping announces the node through the discovery backend chosen by the
+// environment until stop is closed, then tells its peers it leaves.
+func ping(a net.Addr, s *grpc.Server, stop <-chan struct{}, left chan<- struct{}) {
+	defer close(left)
+
+	d, err := resolver.Open(os.Getenv(resolver.EnvDiscovery))
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer d.Close()
+
+	self := &resolver.Node{
+		Name: nodeName,
+		Addr: resolver.AdvertiseAddr(a, ""),
+	}
+	for service := range s.GetServiceInfo() {
+		self.Services = append(self.Services, service)
+	}
 
-	c, err := net.DialUDP("udp", nil, addr)
 	for {
-		for service := range s.GetServiceInfo() {
-			c.Write([]byte(a.String() + "," + service))
+		if err := d.Announce(self); err != nil {
+			log.Printf("discovery: %v", err)
+		}
+
+		select {
+		case <-stop:
+			if err := d.Leave(self); err != nil {
+				log.Printf("discovery: %v", err)
+			}
+			return
+		case <-time.After(1 * time.Second):
 		}
-		time.Sleep(1 * time.Second)
 	}
 }
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The store keeps every change of the keys in the key bucket, under its
+// revision, like etcd does: 8 bytes for the main revision and 8 for the
+// sub revision, big endian, followed by a 't' for deletions. The index of
+// the keys to their revisions is rebuilt from it when the store is opened.
+var (
+	keyBucket  = []byte("key")
+	metaBucket = []byte("meta")
+
+	currentRevKey = []byte("current_rev")
+	compactRevKey = []byte("compact_rev")
+)
+
+const (
+	revBytesLen = 16
+	tombstone   = 't'
+)
+
+// revision orders the changes of the store: every write request gets a
+// new main revision, the changes it makes are ordered by sub.
+type revision struct {
+	main, sub int64
+}
+
+func (r revision) bytes(deleted bool) []byte {
+	b := make([]byte, revBytesLen, revBytesLen+1)
+	binary.BigEndian.PutUint64(b, uint64(r.main))
+	binary.BigEndian.PutUint64(b[8:], uint64(r.sub))
+	if deleted {
+		b = append(b, tombstone)
+	}
+
+	return b
+}
+
+func parseRevision(b []byte) (revision, bool, error) {
+	if len(b) != revBytesLen && (len(b) != revBytesLen+1 || b[revBytesLen] != tombstone) {
+		return revision{}, false, fmt.Errorf("invalid revision %x", b)
+	}
+
+	r := revision{
+		main: int64(binary.BigEndian.Uint64(b)),
+		sub:  int64(binary.BigEndian.Uint64(b[8:])),
+	}
+
+	return r, len(b) > revBytesLen, nil
+}
+
+// change is a revision of a key, deleted when it is a tombstone.
+type change struct {
+	rev     revision
+	deleted bool
+}
+
+// visible returns the last of changes at or before rev, false when the key
+// did not exist then.
+func visible(changes []change, rev int64) (revision, bool) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].rev.main <= rev {
+			return changes[i].rev, !changes[i].deleted
+		}
+	}
+
+	return revision{}, false
+}
+
+// store is a multi-version key-value store persisted in a bolt database.
+// Reads at past revisions are served until they are compacted. It is safe
+// for concurrent use.
+type store struct {
+	db *bolt.DB
+
+	mu         sync.RWMutex
+	keys       []string // sorted
+	index      map[string][]change
+	rev        int64
+	compactRev int64
+	watchers   map[*watcher]bool
+
+	leases *lessor
+	done   chan struct{}
+}
+
+// openStore opens the store in the file at path, creating it if needed.
+func openStore(path string) (*store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %v", path, err)
+	}
+
+	s := &store{
+		db:       db,
+		index:    make(map[string][]change),
+		rev:      1,
+		watchers: make(map[*watcher]bool),
+		done:     make(chan struct{}),
+	}
+	s.leases = newLessor(s)
+
+	if err := db.Update(s.load); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("loading %s: %v", path, err)
+	}
+
+	go s.leases.run(expireInterval, s.done)
+
+	return s, nil
+}
+
+// load creates the buckets, rebuilds the index and loads the leases.
+func (s *store) load(tx *bolt.Tx) error {
+	keys, err := tx.CreateBucketIfNotExists(keyBucket)
+	if err != nil {
+		return err
+	}
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.CreateBucketIfNotExists(leaseBucket); err != nil {
+		return err
+	}
+
+	if b := meta.Get(currentRevKey); len(b) == 8 {
+		s.rev = int64(binary.BigEndian.Uint64(b))
+	}
+	if b := meta.Get(compactRevKey); len(b) == 8 {
+		s.compactRev = int64(binary.BigEndian.Uint64(b))
+	}
+
+	err = keys.ForEach(func(k, v []byte) error {
+		rev, deleted, err := parseRevision(k)
+		if err != nil {
+			return err
+		}
+
+		var kv mvccpb.KeyValue
+		if err := kv.Unmarshal(v); err != nil {
+			return fmt.Errorf("revision %d: %v", rev.main, err)
+		}
+
+		s.add(string(kv.Key), change{rev: rev, deleted: deleted})
+		if rev.main > s.rev {
+			s.rev = rev.main
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.leases.load(tx, s.rev)
+}
+
+// add appends c to the changes of key. s.mu must be held.
+func (s *store) add(key string, c change) {
+	if _, ok := s.index[key]; !ok {
+		i := sort.SearchStrings(s.keys, key)
+		s.keys = append(s.keys, "")
+		copy(s.keys[i+1:], s.keys[i:])
+		s.keys[i] = key
+	}
+
+	s.index[key] = append(s.index[key], c)
+}
+
+// remove forgets key, which has no changes left. s.mu must be held.
+func (s *store) remove(key string) {
+	delete(s.index, key)
+
+	i := sort.SearchStrings(s.keys, key)
+	if i < len(s.keys) && s.keys[i] == key {
+		s.keys = append(s.keys[:i], s.keys[i+1:]...)
+	}
+}
+
+// Close stops expiring the leases and closes the database.
+func (s *store) Close() error {
+	close(s.done)
+
+	return s.db.Close()
+}
+
+// Rev returns the current revision of the store.
+func (s *store) Rev() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.rev
+}
+
+// CompactRev returns the revision the store was last compacted at.
+func (s *store) CompactRev() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.compactRev
+}
+
+// view calls fn with a read-only transaction.
+func (s *store) view(fn func(t *txn) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&txn{s: s, tx: tx, rev: s.rev})
+	})
+}
+
+// update calls fn with a transaction whose changes are committed if it
+// returns nil. The changes made get the next revision, and are returned as
+// events with the revision of the store after the txn. The events are
+// queued to the watchers before the store is unlocked, in order.
+func (s *store) update(fn func(t *txn) error) (int64, []*mvccpb.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var t *txn
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		t = &txn{s: s, tx: tx, rev: s.rev, pending: make(map[string][]change)}
+		if err := fn(t); err != nil {
+			return err
+		}
+
+		if t.sub == 0 {
+			return nil
+		}
+
+		return tx.Bucket(metaBucket).Put(currentRevKey, int64Bytes(t.rev+1))
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if t.sub > 0 {
+		for key, changes := range t.pending {
+			for _, c := range changes {
+				s.add(key, c)
+			}
+		}
+		s.rev++
+
+		s.leases.apply(t.events)
+		for w := range s.watchers {
+			w.queue(s.rev, t.events)
+		}
+	}
+
+	return s.rev, t.events, nil
+}
+
+// Compact drops the changes older than rev, but the latest of every key
+// still existing at rev.
+func (s *store) Compact(rev int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case rev > s.rev:
+		return rpctypes.ErrGRPCFutureRev
+	case rev <= s.compactRev:
+		return rpctypes.ErrGRPCCompacted
+	}
+
+	compacted := make(map[string][]change)
+	var dropped [][]byte
+	for key, changes := range s.index {
+		i := 0
+		for i < len(changes) && changes[i].rev.main <= rev {
+			i++
+		}
+
+		// The last change at or before rev is kept, unless it deletes the key
+		if i > 0 && !changes[i-1].deleted {
+			i--
+		}
+		if i == 0 {
+			continue
+		}
+
+		for _, c := range changes[:i] {
+			dropped = append(dropped, c.rev.bytes(c.deleted))
+		}
+		compacted[key] = changes[i:]
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(keyBucket)
+		for _, k := range dropped {
+			if err := keys.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket(metaBucket).Put(compactRevKey, int64Bytes(rev))
+	})
+	if err != nil {
+		return err
+	}
+
+	for key, changes := range compacted {
+		if len(changes) == 0 {
+			s.remove(key)
+		} else {
+			s.index[key] = changes
+		}
+	}
+	s.compactRev = rev
+
+	return nil
+}
+
+func int64Bytes(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+
+	return b
+}
+
+// txn reads and writes the store within a bolt transaction. The changes of
+// a txn share the revision following the one it started at, and are only
+// added to the index of the store once committed.
+type txn struct {
+	s  *store
+	tx *bolt.Tx
+
+	rev     int64
+	sub     int64
+	pending map[string][]change
+	events  []*mvccpb.Event
+}
+
+// Rev returns the revision the txn reads at, which includes its changes.
+func (t *txn) Rev() int64 {
+	if t.sub > 0 {
+		return t.rev + 1
+	}
+
+	return t.rev
+}
+
+// changes returns every change of key, those of the txn last.
+func (t *txn) changes(key string) []change {
+	committed := t.s.index[key]
+	if len(t.pending[key]) == 0 {
+		return committed
+	}
+
+	return append(append([]change(nil), committed...), t.pending[key]...)
+}
+
+// keys returns the keys in the range [key, end) existing at rev, sorted,
+// with their revision. An empty end is the single key, and an end of "\x00"
+// is every key from key on.
+func (t *txn) keys(key, end []byte, rev int64) ([]string, []revision) {
+	inRange := func(k string) bool {
+		switch {
+		case len(end) == 0:
+			return k == string(key)
+		case len(end) == 1 && end[0] == 0:
+			return k >= string(key)
+		default:
+			return k >= string(key) && k < string(end)
+		}
+	}
+
+	var candidates []string
+	for i := sort.SearchStrings(t.s.keys, string(key)); i < len(t.s.keys) && inRange(t.s.keys[i]); i++ {
+		candidates = append(candidates, t.s.keys[i])
+	}
+	for k := range t.pending {
+		if _, ok := t.s.index[k]; !ok && inRange(k) {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(t.pending) > 0 {
+		sort.Strings(candidates)
+	}
+
+	var (
+		keys []string
+		revs []revision
+	)
+	for _, k := range candidates {
+		if r, ok := visible(t.changes(k), rev); ok {
+			keys = append(keys, k)
+			revs = append(revs, r)
+		}
+	}
+
+	return keys, revs
+}
+
+// get reads the key-value at rev.
+func (t *txn) get(rev revision) (*mvccpb.KeyValue, error) {
+	v := t.tx.Bucket(keyBucket).Get(rev.bytes(false))
+	if v == nil {
+		return nil, fmt.Errorf("revision %d.%d is missing", rev.main, rev.sub)
+	}
+
+	kv := &mvccpb.KeyValue{}
+	if err := kv.Unmarshal(v); err != nil {
+		return nil, fmt.Errorf("revision %d.%d: %v", rev.main, rev.sub, err)
+	}
+
+	return kv, nil
+}
+
+// Range returns the key-values in the range [key, end) at rev, the
+// current revision when 0, up to limit if not 0, and the number of keys in
+// the range.
+func (t *txn) Range(key, end []byte, rev, limit int64) ([]*mvccpb.KeyValue, int, error) {
+	if rev <= 0 {
+		rev = t.Rev()
+	}
+	switch {
+	case rev > t.Rev():
+		return nil, 0, rpctypes.ErrGRPCFutureRev
+	case rev < t.s.compactRev:
+		return nil, 0, rpctypes.ErrGRPCCompacted
+	}
+
+	_, revs := t.keys(key, end, rev)
+	count := len(revs)
+	if limit > 0 && len(revs) > int(limit) {
+		revs = revs[:limit]
+	}
+
+	kvs := make([]*mvccpb.KeyValue, 0, len(revs))
+	for _, r := range revs {
+		kv, err := t.get(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		kvs = append(kvs, kv)
+	}
+
+	return kvs, count, nil
+}
+
+// Put sets the value of key, and returns its previous key-value if any.
+func (t *txn) Put(key, value []byte, lease int64) (*mvccpb.KeyValue, error) {
+	prev, err := t.current(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rev := revision{main: t.rev + 1, sub: t.sub}
+	kv := &mvccpb.KeyValue{
+		Key:            key,
+		Value:          value,
+		CreateRevision: rev.main,
+		ModRevision:    rev.main,
+		Version:        1,
+		Lease:          lease,
+	}
+	if prev != nil {
+		kv.CreateRevision = prev.CreateRevision
+		kv.Version = prev.Version + 1
+	}
+
+	if err := t.write(kv, rev, false); err != nil {
+		return nil, err
+	}
+	t.events = append(t.events, &mvccpb.Event{Type: mvccpb.PUT, Kv: kv, PrevKv: prev})
+
+	return prev, nil
+}
+
+// DeleteRange deletes the keys in the range [key, end), and returns their
+// last key-values.
+func (t *txn) DeleteRange(key, end []byte) ([]*mvccpb.KeyValue, error) {
+	prevs, _, err := t.Range(key, end, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, prev := range prevs {
+		rev := revision{main: t.rev + 1, sub: t.sub}
+		kv := &mvccpb.KeyValue{Key: prev.Key, ModRevision: rev.main}
+		if err := t.write(kv, rev, true); err != nil {
+			return nil, err
+		}
+		t.events = append(t.events, &mvccpb.Event{Type: mvccpb.DELETE, Kv: kv, PrevKv: prev})
+	}
+
+	return prevs, nil
+}
+
+// current returns the key-value of key at the revision of the txn, nil if
+// it does not exist.
+func (t *txn) current(key []byte) (*mvccpb.KeyValue, error) {
+	kvs, _, err := t.Range(key, nil, 0, 0)
+	if err != nil || len(kvs) == 0 {
+		return nil, err
+	}
+
+	return kvs[0], nil
+}
+
+func (t *txn) write(kv *mvccpb.KeyValue, rev revision, deleted bool) error {
+	data, err := kv.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if err := t.tx.Bucket(keyBucket).Put(rev.bytes(deleted), data); err != nil {
+		return err
+	}
+
+	key := string(kv.Key)
+	t.pending[key] = append(t.pending[key], change{rev: rev, deleted: deleted})
+	t.sub++
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"google.golang.org/grpc"
+)
+
+// serve serves the store in the file at path to an etcd client, with the
+// handler configured by opts.
+func serve(t *testing.T, path string, opts ...func(*Handler)) (*clientv3.Client, func()) {
+	st, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	h := &Handler{store: st}
+	for _, opt := range opts {
+		opt(h)
+	}
+	etcdserverpb.RegisterKVServer(s, h)
+	etcdserverpb.RegisterWatchServer(s, h)
+	etcdserverpb.RegisterLeaseServer(s, h)
+	go s.Serve(lis)
+
+	c, err := clientv3.New(clientv3.Config{Endpoints: []string{lis.Addr().String()}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, func() {
+		c.Close()
+		s.Stop()
+		st.Close()
+	}
+}
+
+func keys(resp *clientv3.GetResponse) []string {
+	var keys []string
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+
+	return keys
+}
+
+func equalKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.db")
+
+	c, stop := serve(t, path)
+	ctx := context.Background()
+
+	var first int64
+	for i, key := range []string{"/nodes/b", "/nodes/a", "/nodes/c", "/other"} {
+		resp, err := c.Put(ctx, key, key+"-v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = resp.Header.Revision
+		}
+	}
+
+	put, err := c.Put(ctx, "/nodes/a", "a-v2", clientv3.WithPrevKV())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if put.PrevKv == nil || string(put.PrevKv.Value) != "/nodes/a-v1" {
+		t.Fatalf("unexpected previous value %v", put.PrevKv)
+	}
+	if put.Header.Revision != first+4 {
+		t.Fatalf("expected revision %d, got %d", first+4, put.Header.Revision)
+	}
+
+	get, err := c.Get(ctx, "/nodes/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(get); !equalKeys(got, []string{"/nodes/a", "/nodes/b", "/nodes/c"}) {
+		t.Errorf("unexpected keys %v", got)
+	}
+	if kv := get.Kvs[0]; string(kv.Value) != "a-v2" || kv.Version != 2 || kv.CreateRevision != first+1 || kv.ModRevision != first+4 {
+		t.Errorf("unexpected key-value %v", kv)
+	}
+
+	get, err = c.Get(ctx, "/nodes/", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortDescend), clientv3.WithLimit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(get); !equalKeys(got, []string{"/nodes/a", "/nodes/c"}) || !get.More || get.Count != 3 {
+		t.Errorf("unexpected sorted keys %v, more %v, count %d", got, get.More, get.Count)
+	}
+
+	get, err = c.Get(ctx, "/nodes/a", clientv3.WithRev(first+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(get.Kvs) != 1 || string(get.Kvs[0].Value) != "/nodes/a-v1" {
+		t.Errorf("unexpected value at revision %d: %v", first+1, get.Kvs)
+	}
+
+	// Compare and swap
+	txn, err := c.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value("/nodes/a"), "=", "a-v2")).
+		Then(clientv3.OpPut("/nodes/a", "a-v3"), clientv3.OpGet("/nodes/a")).
+		Else(clientv3.OpGet("/nodes/a")).
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !txn.Succeeded {
+		t.Fatal("expected the comparison to succeed")
+	}
+	if kvs := txn.Responses[1].GetResponseRange().Kvs; len(kvs) != 1 || string(kvs[0].Value) != "a-v3" {
+		t.Errorf("expected the txn to read its own write, got %v", kvs)
+	}
+
+	txn, err = c.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision("/missing"), ">", 0)).
+		Then(clientv3.OpDelete("/nodes/", clientv3.WithPrefix())).
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txn.Succeeded || len(txn.Responses) != 0 {
+		t.Errorf("expected the comparison to fail, got %v", txn)
+	}
+
+	if _, err := c.Txn(ctx).Then(clientv3.OpPut("/x", "1"), clientv3.OpPut("/x", "2")).Commit(); err != rpctypes.ErrDuplicateKey {
+		t.Errorf("expected a duplicate key error, got %v", err)
+	}
+
+	del, err := c.Delete(ctx, "/nodes/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if del.Deleted != 3 {
+		t.Errorf("expected 3 keys deleted, got %d", del.Deleted)
+	}
+	rev := del.Header.Revision
+
+	if _, err := c.Compact(ctx, rev); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "/other", clientv3.WithRev(first)); err != rpctypes.ErrCompacted {
+		t.Errorf("expected a compacted revision error, got %v", err)
+	}
+
+	stop()
+
+	// Everything is read back from the file
+	c, stop = serve(t, path)
+	defer stop()
+
+	get, err = c.Get(ctx, "/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(get); !equalKeys(got, []string{"/other"}) || get.Header.Revision != rev {
+		t.Errorf("unexpected keys %v at revision %d after reopening", got, get.Header.Revision)
+	}
+
+	put, err = c.Put(ctx, "/other", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if put.Header.Revision != rev+1 {
+		t.Errorf("expected revision %d, got %d", rev+1, put.Header.Revision)
+	}
+}
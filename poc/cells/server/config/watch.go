@@ -0,0 +1,377 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	bolt "go.etcd.io/bbolt"
+)
+
+// progressInterval is how often the watchers asking for it are told the
+// revision of the store when they got no event, as in etcd.
+const progressInterval = 10 * time.Minute
+
+// watcher queues the events of the keys in a range, from its start
+// revision on, until they are sent.
+type watcher struct {
+	id    int64
+	kr    keyRange
+	start int64
+
+	prevKV   bool
+	noPut    bool
+	noDelete bool
+	progress bool
+
+	mu     sync.Mutex
+	events []*mvccpb.Event
+	rev    int64
+	ready  chan struct{}
+
+	// stop is closed when the watcher is canceled
+	stop chan struct{}
+}
+
+func newWatcher(id int64, r *etcdserverpb.WatchCreateRequest) *watcher {
+	key := r.Key
+	if len(key) == 0 {
+		// \x00 is the smallest key
+		key = []byte{0}
+	}
+
+	w := &watcher{
+		id:       id,
+		kr:       keyRange{key, r.RangeEnd},
+		start:    r.StartRevision,
+		prevKV:   r.PrevKv,
+		progress: r.ProgressNotify,
+		ready:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+
+	for _, f := range r.Filters {
+		switch f {
+		case etcdserverpb.WatchCreateRequest_NOPUT:
+			w.noPut = true
+		case etcdserverpb.WatchCreateRequest_NODELETE:
+			w.noDelete = true
+		}
+	}
+
+	return w
+}
+
+// queue adds the events watched among events, that brought the store to
+// rev. Events are shared between watchers and must not be modified.
+func (w *watcher) queue(rev int64, events []*mvccpb.Event) {
+	var kept []*mvccpb.Event
+	for _, ev := range events {
+		switch {
+		case ev.Kv.ModRevision < w.start:
+		case !w.kr.contains(ev.Kv.Key):
+		case ev.Type == mvccpb.PUT && w.noPut:
+		case ev.Type == mvccpb.DELETE && w.noDelete:
+		case !w.prevKV && ev.PrevKv != nil:
+			kept = append(kept, &mvccpb.Event{Type: ev.Type, Kv: ev.Kv})
+		default:
+			kept = append(kept, ev)
+		}
+	}
+
+	if len(kept) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	w.events = append(w.events, kept...)
+	w.rev = rev
+	w.mu.Unlock()
+
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the events queued and the revision they brought the store
+// to.
+func (w *watcher) take() ([]*mvccpb.Event, int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	events := w.events
+	w.events = nil
+
+	return events, w.rev
+}
+
+// watch starts queuing the events of w, starting with those of the history
+// from its start revision if it is past. It fails if the history was
+// compacted.
+func (s *store) watch(w *watcher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w.start < s.compactRev {
+		return rpctypes.ErrGRPCCompacted
+	}
+
+	if w.start <= s.rev {
+		var events []*mvccpb.Event
+		err := s.db.View(func(tx *bolt.Tx) error {
+			var err error
+			events, err = (&txn{s: s, tx: tx, rev: s.rev}).history(w.start)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		w.queue(s.rev, events)
+	}
+
+	s.watchers[w] = true
+
+	return nil
+}
+
+// unwatch stops queuing the events of w.
+func (s *store) unwatch(w *watcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.watchers, w)
+}
+
+// history returns the events from rev on, with their previous key-values.
+func (t *txn) history(rev int64) ([]*mvccpb.Event, error) {
+	var events []*mvccpb.Event
+
+	c := t.tx.Bucket(keyBucket).Cursor()
+	for k, v := c.Seek(revision{main: rev}.bytes(false)); k != nil; k, v = c.Next() {
+		r, deleted, err := parseRevision(k)
+		if err != nil {
+			return nil, err
+		}
+
+		kv := &mvccpb.KeyValue{}
+		if err := kv.Unmarshal(v); err != nil {
+			return nil, err
+		}
+
+		ev := &mvccpb.Event{Type: mvccpb.PUT, Kv: kv}
+		if deleted {
+			ev.Type = mvccpb.DELETE
+		}
+
+		if prev, ok := visible(t.s.index[string(kv.Key)], r.main-1); ok {
+			if ev.PrevKv, err = t.get(prev); err != nil {
+				return nil, err
+			}
+		}
+
+		events = append(events, ev)
+	}
+
+	return events, nil
+}
+
+// Watch watches for events happening or that have happened. Both input and output
+// are streams; the input stream is for creating and canceling watchers and the output
+// stream sends events. One watch RPC can watch on multiple key ranges, streaming events
+// for several watches at once. The entire event history can be watched starting from the
+// last compaction revision.
+func (h *Handler) Watch(stream etcdserverpb.Watch_WatchServer) error {
+	ws := &watchStream{
+		store:    h.store,
+		stream:   stream,
+		watchers: make(map[int64]*watcher),
+		sent:     make(map[int64]bool),
+		done:     make(chan struct{}),
+	}
+	defer ws.close()
+
+	interval := h.progressInterval
+	if interval == 0 {
+		interval = progressInterval
+	}
+	go ws.notifyProgress(interval)
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch v := req.RequestUnion.(type) {
+		case *etcdserverpb.WatchRequest_CreateRequest:
+			if v.CreateRequest != nil {
+				err = ws.create(v.CreateRequest)
+			}
+		case *etcdserverpb.WatchRequest_CancelRequest:
+			if v.CancelRequest != nil {
+				err = ws.cancel(v.CancelRequest.WatchId)
+			}
+		case *etcdserverpb.WatchRequest_ProgressRequest:
+			// Not associated with any watcher, the client tells all of them
+			err = ws.send(&etcdserverpb.WatchResponse{Header: header(h.store.Rev()), WatchId: -1})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// watchStream sends the events of the watchers of a Watch stream.
+type watchStream struct {
+	store  *store
+	stream etcdserverpb.Watch_WatchServer
+
+	// mu guards the watchers and the stream, which must not be sent to
+	// concurrently. sent tells the watchers that got events since the last
+	// progress notification.
+	mu       sync.Mutex
+	watchers map[int64]*watcher
+	nextID   int64
+	sent     map[int64]bool
+	done     chan struct{}
+}
+
+func (ws *watchStream) send(resp *etcdserverpb.WatchResponse) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.stream.Send(resp)
+}
+
+// create starts a watcher, which is canceled right away if its history was
+// compacted.
+func (ws *watchStream) create(r *etcdserverpb.WatchCreateRequest) error {
+	ws.mu.Lock()
+	id := ws.nextID
+	ws.nextID++
+	w := newWatcher(id, r)
+	ws.mu.Unlock()
+
+	rev := ws.store.Rev()
+	if w.start == 0 {
+		w.start = rev + 1
+	}
+
+	// The watcher is created before its events are sent
+	if err := ws.send(&etcdserverpb.WatchResponse{Header: header(rev), WatchId: id, Created: true}); err != nil {
+		return err
+	}
+
+	if err := ws.store.watch(w); err != nil {
+		if err != rpctypes.ErrGRPCCompacted {
+			return err
+		}
+
+		return ws.send(&etcdserverpb.WatchResponse{
+			Header:          header(ws.store.Rev()),
+			WatchId:         id,
+			Canceled:        true,
+			CompactRevision: ws.store.CompactRev(),
+		})
+	}
+
+	ws.mu.Lock()
+	ws.watchers[id] = w
+	ws.mu.Unlock()
+
+	go ws.forward(w)
+
+	return nil
+}
+
+// forward sends the events of w until it is canceled.
+func (ws *watchStream) forward(w *watcher) {
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-w.stop:
+			return
+		case <-w.ready:
+		}
+
+		events, rev := w.take()
+
+		ws.mu.Lock()
+		if _, ok := ws.watchers[w.id]; !ok {
+			ws.mu.Unlock()
+			return
+		}
+		ws.sent[w.id] = true
+		err := ws.stream.Send(&etcdserverpb.WatchResponse{Header: header(rev), WatchId: w.id, Events: events})
+		ws.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (ws *watchStream) cancel(id int64) error {
+	ws.mu.Lock()
+	w, ok := ws.watchers[id]
+	delete(ws.watchers, id)
+	ws.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	ws.store.unwatch(w)
+	close(w.stop)
+
+	return ws.send(&etcdserverpb.WatchResponse{Header: header(ws.store.Rev()), WatchId: id, Canceled: true})
+}
+
+// notifyProgress tells the revision of the store to the watchers asking
+// for it that got no event during the last interval.
+func (ws *watchStream) notifyProgress(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-t.C:
+		}
+
+		rev := ws.store.Rev()
+
+		ws.mu.Lock()
+		for id, w := range ws.watchers {
+			if w.progress && !ws.sent[id] {
+				if err := ws.stream.Send(&etcdserverpb.WatchResponse{Header: header(rev), WatchId: id}); err != nil {
+					break
+				}
+			}
+		}
+		ws.sent = make(map[int64]bool)
+		ws.mu.Unlock()
+	}
+}
+
+// close stops the watchers of the stream.
+func (ws *watchStream) close() {
+	close(ws.done)
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for id, w := range ws.watchers {
+		ws.store.unwatch(w)
+		delete(ws.watchers, id)
+	}
+}
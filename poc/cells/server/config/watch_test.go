@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	bolt "go.etcd.io/bbolt"
+)
+
+func tempStore(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "config.db"), func() { os.RemoveAll(dir) }
+}
+
+// next returns the next response of wc with events, or fails after a while.
+func next(t *testing.T, wc clientv3.WatchChan) clientv3.WatchResponse {
+	t.Helper()
+
+	for {
+		select {
+		case resp, ok := <-wc:
+			if !ok {
+				t.Fatal("watch closed")
+			}
+			if err := resp.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Events) > 0 {
+				return resp
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	c, stop := serve(t, path)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	put, err := c.Put(ctx, "/svc/a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wc := c.Watch(ctx, "/svc/", clientv3.WithPrefix(), clientv3.WithPrevKV())
+
+	if _, err := c.Put(ctx, "/other", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put(ctx, "/svc/a", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := next(t, wc)
+	ev := resp.Events[0]
+	if len(resp.Events) != 1 || ev.Type != mvccpb.PUT || string(ev.Kv.Value) != "2" || ev.PrevKv == nil || string(ev.PrevKv.Value) != "1" {
+		t.Fatalf("unexpected events %v", resp.Events)
+	}
+
+	if _, err := c.Delete(ctx, "/svc/", clientv3.WithPrefix()); err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(t, wc).Events[0]; ev.Type != mvccpb.DELETE || string(ev.Kv.Key) != "/svc/a" {
+		t.Fatalf("unexpected event %v", ev)
+	}
+
+	// The history is replayed from the start revision
+	history := c.Watch(ctx, "/svc/a", clientv3.WithRev(put.Header.Revision))
+	var types []mvccpb.Event_EventType
+	for len(types) < 3 {
+		for _, ev := range next(t, history).Events {
+			types = append(types, ev.Type)
+		}
+	}
+	if types[0] != mvccpb.PUT || types[1] != mvccpb.PUT || types[2] != mvccpb.DELETE {
+		t.Errorf("unexpected history %v", types)
+	}
+
+	// Watchers of compacted revisions are canceled
+	if _, err := c.Compact(ctx, put.Header.Revision+2); err != nil {
+		t.Fatal(err)
+	}
+	compacted := c.Watch(ctx, "/svc/a", clientv3.WithRev(put.Header.Revision))
+	select {
+	case resp := <-compacted:
+		if resp.CompactRevision != put.Header.Revision+2 {
+			t.Errorf("expected the watcher to be compacted, got %+v", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the compaction")
+	}
+}
+
+func TestWatchCancel(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	c, stop := serve(t, path)
+	defer stop()
+
+	// The stream of the client is open before counting
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wc := c.Watch(ctx, "/base")
+	if _, err := c.Put(ctx, "/base", "1"); err != nil {
+		t.Fatal(err)
+	}
+	next(t, wc)
+	base := runtime.NumGoroutine()
+
+	// Canceled watchers stop forwarding while the stream stays open
+	for i := 0; i < 20; i++ {
+		wctx, wcancel := context.WithCancel(ctx)
+		wc := c.Watch(wctx, "/svc/", clientv3.WithPrefix())
+		if _, err := c.Put(ctx, "/svc/a", "1"); err != nil {
+			t.Fatal(err)
+		}
+		next(t, wc)
+		wcancel()
+	}
+
+	for i := 0; runtime.NumGoroutine() > base+5; i++ {
+		if i == 100 {
+			t.Fatalf("expected the goroutines of the canceled watchers to stop, %d left of %d", runtime.NumGoroutine(), base)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestWatchProgress(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	c, stop := serve(t, path, func(h *Handler) { h.progressInterval = 100 * time.Millisecond })
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wc := c.Watch(ctx, "/svc/", clientv3.WithPrefix(), clientv3.WithProgressNotify())
+	select {
+	case resp := <-wc:
+		if !resp.IsProgressNotify() {
+			t.Errorf("expected a progress notification, got %+v", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a progress notification")
+	}
+}
+
+func TestLease(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	c, stop := serve(t, path)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	short, err := c.Grant(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if short.TTL != minLeaseTTL {
+		t.Errorf("expected the TTL to be raised to %d, got %d", minLeaseTTL, short.TTL)
+	}
+	if none, err := c.Grant(ctx, -5); err != nil || none.TTL != minLeaseTTL {
+		t.Errorf("expected a negative TTL to be raised, got %+v, %v", none, err)
+	}
+	long, err := c.Grant(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Put(ctx, "/nodes/a", "1", clientv3.WithLease(short.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put(ctx, "/nodes/b", "1", clientv3.WithLease(long.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put(ctx, "/nodes/c", "1", clientv3.WithLease(12345)); err == nil {
+		t.Error("expected keys to need an existing lease")
+	}
+
+	ttl, err := c.TimeToLive(ctx, long.ID, clientv3.WithAttachedKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl.GrantedTTL != 60 || ttl.TTL <= 0 || len(ttl.Keys) != 1 || string(ttl.Keys[0]) != "/nodes/b" {
+		t.Errorf("unexpected time to live %+v", ttl)
+	}
+
+	if ka, err := c.KeepAliveOnce(ctx, long.ID); err != nil || ka.TTL != 60 {
+		t.Errorf("unexpected keep alive %+v, %v", ka, err)
+	}
+
+	// The key of the short lease is deleted once it expires
+	wc := c.Watch(ctx, "/nodes/a")
+	if ev := next(t, wc).Events[0]; ev.Type != mvccpb.DELETE {
+		t.Fatalf("unexpected event %v", ev)
+	}
+	if ttl, err := c.TimeToLive(ctx, short.ID); err != nil || ttl.TTL != -1 {
+		t.Errorf("expected the lease to be gone, got %+v, %v", ttl, err)
+	}
+
+	if _, err := c.Revoke(ctx, long.ID); err != nil {
+		t.Fatal(err)
+	}
+	get, err := c.Get(ctx, "/nodes/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(get.Kvs) != 0 {
+		t.Errorf("expected the keys of the leases to be deleted, got %v", get.Kvs)
+	}
+}
+
+func TestLeaseRevokeFailure(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	st, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	le, err := st.leases.Grant(0, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, _, err := st.update(func(t *txn) error {
+		_, err := t.Put([]byte("/nodes/a"), []byte("1"), le.id)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key cannot be read back to be deleted
+	err = st.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(keyBucket).Put(revision{main: rev}.bytes(false), []byte("garbage"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.leases.Revoke(le.id); err == nil {
+		t.Fatal("expected the revocation to fail")
+	}
+	if !st.leases.exists(le.id) {
+		t.Error("expected the lease to be kept")
+	}
+	if _, _, keys, err := st.leases.Lookup(le.id); err != nil || len(keys) != 1 {
+		t.Errorf("expected the lease to keep its key, got %q, %v", keys, err)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultCentralPrefix = "/cells-config"
+	centralTimeout       = 5 * time.Second
+	centralRetry         = 5 * time.Second
+)
+
+// CentralConfig reads the configuration of the node from a central store,
+// etcd or the config server, at Address:
+//
+//	etcd://10.0.0.1:2379,10.0.0.2:2379/cells-config
+//
+// The store holds a key per section of the configuration under the prefix
+// of the node, as YAML. The node writes back the configuration it runs with
+// and the status of the last change it read:
+//
+//	<prefix>/<name>/config/exports = - name: photos …
+//	<prefix>/<name>/config/rate_limits = principal: …
+//	<prefix>/<name>/effective = name: node1 …
+//	<prefix>/<name>/status = {"revision": 12, "error": "…", …}
+//
+// The exports carry their ACLs and quotas, and rate_limits the limits per
+// principal and per export.
+//
+// Changes are validated and applied live, as with SIGHUP, and the settings
+// of the configuration file override them. The node keeps the prefix of the
+// name it started with.
+//
+// Whoever can write the keys of a node chooses what it exports, so the store
+// must be trusted. Restrict access to them with client certificates or a
+// user of etcd, the config server authenticates neither.
+type CentralConfig struct {
+	Address string           `yaml:"address"`
+	TLS     CentralTLSConfig `yaml:"tls"`
+
+	// Username authenticates the node to etcd, with the password read from
+	// PasswordFile so that it is not written back with the effective
+	// configuration.
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// CentralTLSConfig enables TLS to the store when CA or Cert is set. The
+// store is verified against CA, or the system roots without it, and Cert
+// and Key are presented as the client certificate.
+type CentralTLSConfig struct {
+	CA   string `yaml:"ca"`
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+// parseCentral returns the endpoints of the store at address, and the
+// prefix of the keys.
+func parseCentral(address string) (endpoints []string, prefix string, err error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "etcd" {
+		return nil, "", fmt.Errorf("%q: unknown scheme %q, expected etcd", address, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("%q: missing host", address)
+	}
+
+	prefix = strings.TrimSuffix(u.Path, "/")
+	if prefix == "" {
+		prefix = defaultCentralPrefix
+	}
+
+	return strings.Split(u.Host, ","), prefix, nil
+}
+
+// clientConfig returns the configuration of the client of the store at
+// endpoints.
+func (cfg CentralConfig) clientConfig(endpoints []string) (clientv3.Config, error) {
+	cc := clientv3.Config{Endpoints: endpoints, DialTimeout: centralTimeout, Username: cfg.Username}
+
+	if cfg.PasswordFile != "" {
+		password, err := ioutil.ReadFile(cfg.PasswordFile)
+		if err != nil {
+			return cc, fmt.Errorf("reading password: %v", err)
+		}
+		cc.Password = strings.TrimSpace(string(password))
+	}
+
+	if cfg.TLS.CA == "" && cfg.TLS.Cert == "" {
+		return cc, nil
+	}
+
+	cc.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.TLS.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			return cc, fmt.Errorf("loading tls key pair: %v", err)
+		}
+		cc.TLS.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.TLS.CA != "" {
+		pem, err := ioutil.ReadFile(cfg.TLS.CA)
+		if err != nil {
+			return cc, fmt.Errorf("reading ca: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return cc, fmt.Errorf("no certificate found in %s", cfg.TLS.CA)
+		}
+		cc.TLS.RootCAs = pool
+	}
+
+	return cc, nil
+}
+
+// localSections can only be set by the configuration file, as they tell
+// where the configuration of the node is.
+var localSections = map[string]bool{"id": true, "name": true, "central": true}
+
+// centralStatus is written back to the store every time the node reads its
+// configuration.
+type centralStatus struct {
+	// Revision of the store the configuration was read at
+	Revision int64     `json:"revision"`
+	Version  string    `json:"version"`
+	Updated  time.Time `json:"updated"`
+	Error    string    `json:"error,omitempty"`
+
+	// RestartRequired lists the changes not applied until the next restart
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// central reads the configuration of a node from the central store.
+type central struct {
+	client *clientv3.Client
+	prefix string // of the keys of the node, with a trailing slash
+
+	mu  sync.Mutex
+	rev int64 // the configuration was last read at
+}
+
+func openCentral(cfg CentralConfig, name string) (*central, error) {
+	endpoints, prefix, err := parseCentral(cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("central: %v", err)
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return nil, errors.New("central: a node name without '/' is required")
+	}
+
+	cc, err := cfg.clientConfig(endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("central: %v", err)
+	}
+
+	// The configuration is needed to start, wait for the store
+	c, err := clientv3.New(cc)
+	if err != nil {
+		return nil, fmt.Errorf("central: %v", err)
+	}
+
+	return &central{client: c, prefix: path.Join(prefix, name) + "/"}, nil
+}
+
+// load reads the sections of the configuration of the node, and returns
+// them as a single YAML document.
+func (c *central) load() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), centralTimeout)
+	defer cancel()
+
+	resp, err := c.client.Get(ctx, c.prefix+"config/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("central: %v", err)
+	}
+
+	c.mu.Lock()
+	c.rev = resp.Header.Revision
+	c.mu.Unlock()
+
+	// Unknown sections are reported when the document is parsed
+	doc := make(map[string]interface{})
+	for _, kv := range resp.Kvs {
+		section := strings.TrimPrefix(string(kv.Key), c.prefix+"config/")
+		if localSections[section] {
+			return nil, fmt.Errorf("central: %s: %s can only be set by the configuration file", kv.Key, section)
+		}
+
+		var v interface{}
+		if err := yaml.Unmarshal(kv.Value, &v); err != nil {
+			return nil, fmt.Errorf("central: %s: %v", kv.Key, err)
+		}
+		doc[section] = v
+	}
+
+	if len(doc) == 0 {
+		return nil, nil
+	}
+
+	return yaml.Marshal(doc)
+}
+
+// watch calls changed every time the configuration of the node changes in
+// the store, until ctx is done.
+func (c *central) watch(ctx context.Context, changed func()) {
+	for ctx.Err() == nil {
+		c.mu.Lock()
+		rev := c.rev
+		c.mu.Unlock()
+
+		wctx, cancel := context.WithCancel(ctx)
+		wc := c.client.Watch(wctx, c.prefix+"config/", clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+		for resp := range wc {
+			if err := resp.Err(); err != nil {
+				// The changes since rev may have been compacted, read the
+				// whole configuration again
+				log.Printf("central: %v", err)
+				changed()
+				break
+			}
+
+			if len(resp.Events) > 0 {
+				changed()
+			}
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(centralRetry):
+		}
+	}
+}
+
+// report writes back the configuration the node runs with, and the outcome
+// of the last time it was read.
+func (c *central) report(cfg *Config, restart []string, err error) {
+	effective, merr := yaml.Marshal(cfg)
+	if merr != nil {
+		log.Printf("central: %v", merr)
+		return
+	}
+
+	c.mu.Lock()
+	st := centralStatus{Revision: c.rev, Version: version, Updated: time.Now(), RestartRequired: restart}
+	c.mu.Unlock()
+	if err != nil {
+		st.Error = err.Error()
+	}
+
+	status, merr := json.Marshal(st)
+	if merr != nil {
+		log.Printf("central: %v", merr)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), centralTimeout)
+	defer cancel()
+
+	_, err = c.client.Txn(ctx).Then(
+		clientv3.OpPut(c.prefix+"effective", string(effective)),
+		clientv3.OpPut(c.prefix+"status", string(status)),
+	).Commit()
+	if err != nil {
+		log.Printf("central: writing status: %v", err)
+	}
+}
+
+func (c *central) Close() error {
+	return c.client.Close()
+}
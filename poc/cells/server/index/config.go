@@ -0,0 +1,723 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/aferofs/cryptfs"
+	"github.com/ghecquet/tripr/poc/cells/client/resolver"
+	"github.com/ghecquet/tripr/poc/cells/index"
+	"github.com/ghecquet/tripr/poc/cells/limit"
+	"github.com/ghecquet/tripr/poc/cells/target"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultListen            = "0.0.0.0"
+	defaultDiscoveryAddr     = "224.0.0.1:9999"
+	defaultDiscoveryInterval = time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+)
+
+// Config is the configuration of an index server, as read from the central
+// store when one is configured, overridden by the YAML file given with
+// -config and by command line flags.
+type Config struct {
+	// ID identifies the node across restarts, see NodeID
+	ID        string          `yaml:"id"`
+	Name      string          `yaml:"name"`
+	Listen    string          `yaml:"listen"`
+	Port      int             `yaml:"port"`
+	Exports   []ExportConfig  `yaml:"exports"`
+	Discovery DiscoveryConfig `yaml:"discovery"`
+	TLS       TLSConfig       `yaml:"tls"`
+	Limits    LimitsConfig    `yaml:"limits"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Audit     AuditConfig     `yaml:"audit"`
+	Rclone    RcloneConfig    `yaml:"rclone"`
+	Central   CentralConfig   `yaml:"central"`
+
+	// RateLimits throttle clients and can be changed with a reload, unlike
+	// Limits.
+	RateLimits RateLimitsConfig `yaml:"rate_limits"`
+
+	// ShutdownTimeout is how long open streams are given to finish after
+	// SIGTERM before their files are closed.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// ExportConfig describes a directory served under a name. The directory is
+// either a local Path, or a Remote of rclone such as "s3:bucket/photos".
+type ExportConfig struct {
+	Name       string           `yaml:"name"`
+	Path       string           `yaml:"path"`
+	Remote     string           `yaml:"remote"`
+	ReadOnly   bool             `yaml:"readonly"`
+	Trash      TrashConfig      `yaml:"trash"`
+	Versions   VersionsConfig   `yaml:"versions"`
+	Snapshots  SnapshotsConfig  `yaml:"snapshots"`
+	Encryption EncryptionConfig `yaml:"encryption"`
+	ACL        ACLConfig        `yaml:"acl"`
+	Quota      QuotaConfig      `yaml:"quota"`
+}
+
+// ACLConfig restricts an export, and its snapshots, to the principals
+// listed, see RateLimitsConfig. Principals allowed to write may read as
+// well, and "*" stands for every client. Without any principal, the export
+// is open to all.
+type ACLConfig struct {
+	Read  []string `yaml:"read"`
+	Write []string `yaml:"write"`
+}
+
+func (a ACLConfig) acl() *index.ACL {
+	if len(a.Read) == 0 && len(a.Write) == 0 {
+		return nil
+	}
+
+	return &index.ACL{Read: a.Read, Write: a.Write}
+}
+
+// QuotaConfig bounds the bytes stored in an export, its trash and versions
+// included. Zero means no quota.
+type QuotaConfig struct {
+	MaxBytes int64 `yaml:"max_bytes"`
+}
+
+// TrashConfig makes removals move entries to the trash of the export, where
+// they are kept for Retention, or until purged when Retention is zero.
+type TrashConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+// VersionsConfig keeps the prior content of the files of the export when
+// they are overwritten. Each file keeps at most MaxCount versions, none older
+// than MaxAge and MaxSize bytes in total. Zero values mean no limit.
+type VersionsConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	MaxCount int           `yaml:"max_count"`
+	MaxAge   time.Duration `yaml:"max_age"`
+	MaxSize  int64         `yaml:"max_size"`
+}
+
+func (v *VersionsConfig) options() index.VersionOptions {
+	return index.VersionOptions{
+		MaxCount: v.MaxCount,
+		MaxAge:   v.MaxAge,
+		MaxSize:  v.MaxSize,
+	}
+}
+
+// SnapshotsConfig allows clients to take read-only snapshots of the export.
+// Method is how files are captured: reflink, hardlink or copy. By default
+// reflinks are used where the filesystem supports them, copies otherwise.
+// Hard links share the files with the export, so files modified in place
+// rather than replaced change in the snapshots as well.
+type SnapshotsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Method  string `yaml:"method"`
+}
+
+// EncryptionConfig encrypts the files of the export on disk with the key
+// read from KeyFile, and their names as well with Names. Files already in
+// the export are not encrypted: enable it on an empty directory.
+type EncryptionConfig struct {
+	KeyFile string `yaml:"key_file"`
+	Names   bool   `yaml:"names"`
+}
+
+func (e *EncryptionConfig) fs(source afero.Fs) (*cryptfs.Fs, error) {
+	key, err := cryptfs.ReadKeyFile(e.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return cryptfs.New(source, key, e.Names)
+}
+
+// DiscoveryConfig controls how the node announces itself to its peers.
+// Address selects the discovery backend, as described by resolver.Open, and
+// defaults to the CELLS_DISCOVERY environment variable, then to multicast.
+// Advertise is the host peers reach the node at, when it cannot be told from
+// the listening address. Labels are announced with the node, for clients to
+// select it, along with the labels derived from its record such as export
+// and version. Interfaces, ExcludeInterfaces and Seeds configure multicast,
+// see resolver.MulticastOptions.
+type DiscoveryConfig struct {
+	Disabled  bool              `yaml:"disabled"`
+	Address   string            `yaml:"address"`
+	Advertise string            `yaml:"advertise"`
+	Interval  time.Duration     `yaml:"interval"`
+	Labels    map[string]string `yaml:"labels"`
+
+	Interfaces        []string `yaml:"interfaces"`
+	ExcludeInterfaces []string `yaml:"exclude_interfaces"`
+	Seeds             []string `yaml:"seeds"`
+}
+
+// Spec returns the discovery spec with the multicast options, shared by the
+// announcements of the node and by its resolver.
+func (d DiscoveryConfig) Spec() (string, error) {
+	return resolver.MulticastOptions{
+		Interfaces: d.Interfaces,
+		Exclude:    d.ExcludeInterfaces,
+		Seeds:      d.Seeds,
+	}.Spec(d.Address)
+}
+
+// TLSConfig enables TLS on the gRPC listener. When ClientCA is set, clients
+// must present a certificate signed by it.
+type TLSConfig struct {
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+	ClientCA string `yaml:"client_ca"`
+}
+
+// LimitsConfig bounds the resources a single connection can use.
+type LimitsConfig struct {
+	MaxConcurrentStreams uint32 `yaml:"max_concurrent_streams"`
+	MaxRecvMsgSize       int    `yaml:"max_recv_msg_size"`
+	MaxSendMsgSize       int    `yaml:"max_send_msg_size"`
+}
+
+// MetricsConfig exposes Prometheus metrics over HTTP when Address is set.
+type MetricsConfig struct {
+	Address string `yaml:"address"`
+}
+
+// RateLimitsConfig holds the limits applied to each principal and to each
+// export, and overrides by name. Principals are the common name of client
+// certificates, or the client IP without TLS. The snapshots of an export
+// share its limits.
+type RateLimitsConfig struct {
+	Principal  RateLimitConfig            `yaml:"principal"`
+	Export     RateLimitConfig            `yaml:"export"`
+	Principals map[string]RateLimitConfig `yaml:"principals"`
+	Exports    map[string]RateLimitConfig `yaml:"exports"`
+}
+
+// RateLimitConfig are per second rates, or the maximum number of concurrent
+// Open streams. Zero means unlimited.
+type RateLimitConfig struct {
+	Ops        float64 `yaml:"ops"`
+	ReadBytes  int64   `yaml:"read_bytes"`
+	WriteBytes int64   `yaml:"write_bytes"`
+	Streams    int     `yaml:"streams"`
+}
+
+func (c RateLimitConfig) validate(field string) []string {
+	var errs []string
+
+	if c.Ops < 0 {
+		errs = append(errs, field+".ops: must not be negative")
+	}
+	if c.ReadBytes < 0 {
+		errs = append(errs, field+".read_bytes: must not be negative")
+	}
+	if c.WriteBytes < 0 {
+		errs = append(errs, field+".write_bytes: must not be negative")
+	}
+	if c.Streams < 0 {
+		errs = append(errs, field+".streams: must not be negative")
+	}
+
+	return errs
+}
+
+func (c RateLimitConfig) limits() limit.Limits {
+	return limit.Limits{
+		Ops:        c.Ops,
+		ReadBytes:  c.ReadBytes,
+		WriteBytes: c.WriteBytes,
+		Streams:    c.Streams,
+	}
+}
+
+// Limiter returns the rate limits in the form used by the limit package.
+func (c *RateLimitsConfig) Limiter() limit.Config {
+	cfg := limit.Config{
+		Principal:  c.Principal.limits(),
+		Export:     c.Export.limits(),
+		Principals: make(map[string]limit.Limits),
+		Exports:    make(map[string]limit.Limits),
+	}
+
+	for name, l := range c.Principals {
+		cfg.Principals[name] = l.limits()
+	}
+	for name, l := range c.Exports {
+		cfg.Exports[name] = l.limits()
+	}
+
+	return cfg
+}
+
+// AuditConfig enables the audit log of mutating operations when Path is set.
+type AuditConfig struct {
+	Path       string   `yaml:"path"`
+	MaxSize    int64    `yaml:"max_size"`
+	MaxBackups int      `yaml:"max_backups"`
+	HashChain  bool     `yaml:"hash_chain"`
+	Exclude    []string `yaml:"exclude"`
+}
+
+// LoadConfig reads the YAML file at path on top of central, the YAML of the
+// configuration read from the central store. The settings of the file
+// override those of central one by one, lists replacing each other whole.
+// An empty path and central return the defaults.
+func LoadConfig(path string, central []byte) (*Config, error) {
+	c := &Config{}
+
+	if len(central) > 0 {
+		if err := yaml.UnmarshalStrict(central, c); err != nil {
+			return nil, fmt.Errorf("parsing central config: %v", err)
+		}
+	}
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config: %v", err)
+		}
+
+		// Strict parsing rejects keys of maps already set by central, the
+		// file is checked on its own first
+		if err := yaml.UnmarshalStrict(data, &Config{}); err != nil {
+			return nil, fmt.Errorf("parsing config %s: %v", path, err)
+		}
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("parsing config %s: %v", path, err)
+		}
+	}
+
+	c.setDefaults()
+
+	return c, nil
+}
+
+func (c *Config) setDefaults() {
+	if c.Listen == "" {
+		c.Listen = defaultListen
+	}
+	if c.Discovery.Address == "" {
+		c.Discovery.Address = os.Getenv(resolver.EnvDiscovery)
+	}
+	if c.Discovery.Address == "" {
+		c.Discovery.Address = defaultDiscoveryAddr
+	}
+	if c.Discovery.Interval == 0 {
+		c.Discovery.Interval = defaultDiscoveryInterval
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if c.Rclone.ListCacheTTL == 0 {
+		c.Rclone.ListCacheTTL = defaultRemoteListCacheTTL
+	}
+}
+
+// Addr returns the address the gRPC server listens on.
+func (c *Config) Addr() string {
+	return net.JoinHostPort(c.Listen, strconv.Itoa(c.Port))
+}
+
+// NodeID returns the ID announced by the node: the configured one, or one
+// derived from the host name and the node name, which is stable as long as
+// neither changes.
+func (c *Config) NodeID() string {
+	if c.ID != "" {
+		return c.ID
+	}
+
+	host, _ := os.Hostname()
+	sum := sha256.Sum256([]byte(host + "/" + c.Name))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// TLSFingerprint returns the hex SHA-256 of the certificate of the node, or
+// an empty string without TLS.
+func (c *Config) TLSFingerprint() (string, error) {
+	if c.TLS.Cert == "" {
+		return "", nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.TLS.Cert, c.TLS.Key)
+	if err != nil {
+		return "", fmt.Errorf("loading tls key pair: %v", err)
+	}
+
+	sum := sha256.Sum256(cert.Certificate[0])
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Validate checks the configuration and returns every problem found.
+func (c *Config) Validate() error {
+	var errs []string
+
+	if c.Name == "" {
+		errs = append(errs, "name: a node name is required")
+	} else if strings.ContainsAny(c.Name, "@/") {
+		errs = append(errs, fmt.Sprintf("name: %q must not contain '@' or '/'", c.Name))
+	}
+
+	if net.ParseIP(c.Listen) == nil {
+		if _, err := net.LookupHost(c.Listen); err != nil {
+			errs = append(errs, fmt.Sprintf("listen: %q is neither an IP nor a resolvable host", c.Listen))
+		}
+	}
+
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("port: %d is out of range", c.Port))
+	}
+
+	seen := make(map[string]bool)
+	for i, e := range c.Exports {
+		switch {
+		case e.Name == "":
+			errs = append(errs, fmt.Sprintf("exports[%d]: a name is required", i))
+		case strings.ContainsAny(e.Name, "@/"):
+			errs = append(errs, fmt.Sprintf("exports[%d]: name %q must not contain '@' or '/'", i, e.Name))
+		case seen[e.Name]:
+			errs = append(errs, fmt.Sprintf("exports[%d]: duplicate name %q", i, e.Name))
+		}
+		seen[e.Name] = true
+
+		switch {
+		case e.Remote != "" && e.Path != "":
+			errs = append(errs, fmt.Sprintf("exports[%d]: path and remote are mutually exclusive", i))
+			continue
+		case e.Remote != "":
+			if err := c.validRemote(e.Remote); err != nil {
+				errs = append(errs, fmt.Sprintf("exports[%d]: remote %q: %v", i, e.Remote, err))
+			}
+			if e.Snapshots.Enabled {
+				errs = append(errs, fmt.Sprintf("exports[%d]: snapshots cannot be enabled on a remote export", i))
+			}
+		case !filepath.IsAbs(e.Path):
+			errs = append(errs, fmt.Sprintf("exports[%d]: path %q must be absolute", i, e.Path))
+			continue
+		}
+
+		if e.Trash.Enabled && e.ReadOnly {
+			errs = append(errs, fmt.Sprintf("exports[%d]: trash cannot be enabled on a read-only export", i))
+		}
+		if e.Trash.Retention < 0 {
+			errs = append(errs, fmt.Sprintf("exports[%d]: trash.retention must not be negative", i))
+		}
+		if e.Versions.Enabled && e.ReadOnly {
+			errs = append(errs, fmt.Sprintf("exports[%d]: versions cannot be enabled on a read-only export", i))
+		}
+		if e.Versions.MaxCount < 0 || e.Versions.MaxAge < 0 || e.Versions.MaxSize < 0 {
+			errs = append(errs, fmt.Sprintf("exports[%d]: versions limits must not be negative", i))
+		}
+		if e.Encryption.KeyFile != "" {
+			if _, err := cryptfs.ReadKeyFile(e.Encryption.KeyFile); err != nil {
+				errs = append(errs, fmt.Sprintf("exports[%d]: encryption: %v", i, err))
+			}
+		} else if e.Encryption.Names {
+			errs = append(errs, fmt.Sprintf("exports[%d]: encryption.names requires encryption.key_file", i))
+		}
+		for _, p := range append(e.ACL.Read, e.ACL.Write...) {
+			if strings.TrimSpace(p) == "" {
+				errs = append(errs, fmt.Sprintf("exports[%d]: acl: empty principal", i))
+			}
+		}
+		if e.Quota.MaxBytes < 0 {
+			errs = append(errs, fmt.Sprintf("exports[%d]: quota.max_bytes must not be negative", i))
+		}
+		if e.Quota.MaxBytes > 0 && e.ReadOnly {
+			errs = append(errs, fmt.Sprintf("exports[%d]: quota cannot be enabled on a read-only export", i))
+		}
+		switch e.Snapshots.Method {
+		case "", index.SnapshotReflink, index.SnapshotHardlink, index.SnapshotCopy:
+		default:
+			errs = append(errs, fmt.Sprintf("exports[%d]: unknown snapshots.method %q", i, e.Snapshots.Method))
+		}
+
+		if e.Remote != "" {
+			continue
+		}
+
+		fi, err := os.Stat(e.Path)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("exports[%d]: %v", i, err))
+		} else if !fi.IsDir() {
+			errs = append(errs, fmt.Sprintf("exports[%d]: %s is not a directory", i, e.Path))
+		}
+	}
+
+	if c.Rclone.ListCacheTTL < 0 {
+		errs = append(errs, "rclone.list_cache_ttl: must not be negative")
+	}
+	if c.Rclone.TempDir != "" && !filepath.IsAbs(c.Rclone.TempDir) {
+		errs = append(errs, fmt.Sprintf("rclone.temp_dir: %q must be absolute", c.Rclone.TempDir))
+	}
+
+	if !c.Discovery.Disabled {
+		if err := resolver.ValidateDiscovery(c.Discovery.Address); err != nil {
+			errs = append(errs, fmt.Sprintf("discovery.address: %v", err))
+		} else if _, err := c.Discovery.Spec(); err != nil {
+			errs = append(errs, fmt.Sprintf("discovery: %v", err))
+		}
+		if c.Discovery.Interval < 100*time.Millisecond {
+			errs = append(errs, fmt.Sprintf("discovery.interval: %s is too short (minimum 100ms)", c.Discovery.Interval))
+		}
+		for k := range c.Discovery.Labels {
+			if !target.ValidLabel(k) {
+				errs = append(errs, fmt.Sprintf("discovery.labels: invalid or reserved label %q", k))
+			}
+		}
+	}
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		errs = append(errs, "tls: cert and key must be set together")
+	}
+	if c.TLS.ClientCA != "" && c.TLS.Cert == "" {
+		errs = append(errs, "tls.client_ca: requires cert and key")
+	}
+
+	if c.Metrics.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Address); err != nil {
+			errs = append(errs, fmt.Sprintf("metrics.address: %v", err))
+		}
+	}
+
+	if c.Audit.Path != "" && !filepath.IsAbs(c.Audit.Path) {
+		errs = append(errs, fmt.Sprintf("audit.path: %q must be absolute", c.Audit.Path))
+	}
+	if c.Audit.MaxSize < 0 {
+		errs = append(errs, "audit.max_size: must not be negative")
+	}
+	if c.Audit.MaxBackups < 0 {
+		errs = append(errs, "audit.max_backups: must not be negative")
+	}
+	for i, pattern := range c.Audit.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("audit.exclude[%d]: %q: %v", i, pattern, err))
+		}
+	}
+
+	errs = append(errs, c.RateLimits.Principal.validate("rate_limits.principal")...)
+	errs = append(errs, c.RateLimits.Export.validate("rate_limits.export")...)
+	for name, l := range c.RateLimits.Principals {
+		errs = append(errs, l.validate(fmt.Sprintf("rate_limits.principals[%q]", name))...)
+	}
+	for name, l := range c.RateLimits.Exports {
+		errs = append(errs, l.validate(fmt.Sprintf("rate_limits.exports[%q]", name))...)
+	}
+
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, "shutdown_timeout: must not be negative")
+	}
+
+	if c.Central.Address != "" {
+		if _, _, err := parseCentral(c.Central.Address); err != nil {
+			errs = append(errs, fmt.Sprintf("central.address: %v", err))
+		}
+	}
+	if (c.Central.TLS.Cert == "") != (c.Central.TLS.Key == "") {
+		errs = append(errs, "central.tls: cert and key must be set together")
+	}
+	if (c.Central.Username == "") != (c.Central.PasswordFile == "") {
+		errs = append(errs, "central: username and password_file must be set together")
+	}
+
+	if c.Limits.MaxRecvMsgSize < 0 {
+		errs = append(errs, "limits.max_recv_msg_size: must not be negative")
+	}
+	if c.Limits.MaxSendMsgSize < 0 {
+		errs = append(errs, "limits.max_send_msg_size: must not be negative")
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+// exports holds the filesystems serving the exports of a configuration, and
+// the trash, version history, snapshots and ACLs of the exports that have
+// them. Writable local exports are probed for health in probes, the
+// filesystems of their directory. The exports that could not be opened are
+// not served, failed holds why.
+type exports struct {
+	fss       map[string]afero.Fs
+	probes    map[string]afero.Fs
+	failed    map[string]error
+	trashes   map[string]*index.Trash
+	versions  map[string]*index.VersionFs
+	snapshots map[string]*index.Snapshots
+	acls      map[string]*index.ACL
+}
+
+// apply makes h serve the exports.
+func (e *exports) apply(h *index.Handler) {
+	h.SetExports(e.fss)
+	h.SetTrashes(e.trashes)
+	h.SetVersions(e.versions)
+	h.SetSnapshots(e.snapshots)
+	h.SetACLs(e.acls)
+}
+
+// Filesystems returns the exports of the configuration. Without any export,
+// the whole local filesystem is served under the default (empty) export
+// name. Exports that cannot be opened, such as remotes out of reach, are
+// left out and reported NOT_SERVING by the health checks, until a reload
+// opens them.
+func (c *Config) Filesystems() *exports {
+	base := afero.NewOsFs()
+
+	if len(c.Exports) == 0 {
+		return &exports{fss: map[string]afero.Fs{"": base}}
+	}
+
+	exp := &exports{
+		fss:       make(map[string]afero.Fs),
+		probes:    make(map[string]afero.Fs),
+		failed:    make(map[string]error),
+		trashes:   make(map[string]*index.Trash),
+		versions:  make(map[string]*index.VersionFs),
+		snapshots: make(map[string]*index.Snapshots),
+		acls:      make(map[string]*index.ACL),
+	}
+	for _, e := range c.Exports {
+		var fs afero.Fs
+		if e.Remote != "" {
+			r, err := c.remoteFs(e.Remote)
+			if err != nil {
+				exp.failed[e.Name] = err
+				continue
+			}
+			fs = r
+		} else {
+			fs = afero.NewBasePathFs(base, e.Path)
+			if !e.ReadOnly {
+				exp.probes[e.Name] = fs
+				fs = index.HideHealth(fs)
+			}
+		}
+		if e.Snapshots.Enabled {
+			s := index.NewSnapshots(e.Path, e.Snapshots.Method)
+			exp.snapshots[e.Name] = s
+			fs = s.Hide(fs)
+		}
+		if e.Quota.MaxBytes > 0 {
+			fs = index.NewQuotaFs(fs, e.Quota.MaxBytes)
+		}
+		if e.ReadOnly {
+			fs = afero.NewReadOnlyFs(fs)
+		}
+		if e.Encryption.KeyFile != "" {
+			crypt, err := e.Encryption.fs(fs)
+			if err != nil {
+				// The key file changed since the configuration was
+				// validated, do not serve the files encrypted
+				exp.failed[e.Name] = err
+				continue
+			}
+			if s := exp.snapshots[e.Name]; s != nil {
+				s.Wrap(func(fs afero.Fs) afero.Fs { return crypt.WithSource(fs) }, crypt.RealPath)
+			}
+			fs = crypt
+		}
+		if e.Versions.Enabled {
+			v := index.NewVersionFs(fs, e.Versions.options())
+			exp.versions[e.Name] = v
+			fs = v
+		}
+		if e.Trash.Enabled {
+			t := index.NewTrash(fs, e.Trash.Retention)
+			exp.trashes[e.Name] = t
+			fs = t.Fs()
+		}
+		if acl := e.ACL.acl(); acl != nil {
+			exp.acls[e.Name] = acl
+		}
+		exp.fss[e.Name] = fs
+	}
+
+	return exp
+}
+
+// ServerTLS builds the server TLS configuration, or returns nil when TLS is
+// not enabled.
+func (c *Config) ServerTLS() (*tls.Config, error) {
+	if c.TLS.Cert == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.TLS.Cert, c.TLS.Key)
+	if err != nil {
+		return nil, fmt.Errorf("loading tls key pair: %v", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.TLS.ClientCA != "" {
+		pem, err := ioutil.ReadFile(c.TLS.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading client ca: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.TLS.ClientCA)
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// restartRequired lists the settings that differ between c and next and that
+// cannot be changed without restarting the server.
+func (c *Config) restartRequired(next *Config) []string {
+	var fields []string
+
+	if c.Listen != next.Listen || c.Port != next.Port {
+		fields = append(fields, "listen/port")
+	}
+	if c.TLS != next.TLS {
+		fields = append(fields, "tls")
+	}
+	if c.Limits != next.Limits {
+		fields = append(fields, "limits")
+	}
+	if c.Metrics != next.Metrics {
+		fields = append(fields, "metrics")
+	}
+	if !reflect.DeepEqual(c.Audit, next.Audit) {
+		fields = append(fields, "audit")
+	}
+	if c.Rclone.Config != next.Rclone.Config {
+		fields = append(fields, "rclone.config")
+	}
+	if c.Central != next.Central {
+		fields = append(fields, "central")
+	}
+
+	return fields
+}
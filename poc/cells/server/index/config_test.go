@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/client/resolver"
+	"github.com/ghecquet/tripr/poc/cells/index"
+	"github.com/spf13/afero"
+)
+
+func TestParseCentral(t *testing.T) {
+	tests := []struct {
+		address   string
+		endpoints []string
+		prefix    string
+		ok        bool
+	}{
+		{"etcd://10.0.0.1:2379", []string{"10.0.0.1:2379"}, defaultCentralPrefix, true},
+		{"etcd://10.0.0.1:2379/", []string{"10.0.0.1:2379"}, defaultCentralPrefix, true},
+		{"etcd://10.0.0.1:2379,10.0.0.2:2379/cells/", []string{"10.0.0.1:2379", "10.0.0.2:2379"}, "/cells", true},
+		{"http://10.0.0.1:2379", nil, "", false},
+		{"etcd:///cells", nil, "", false},
+		{"etcd://%zz", nil, "", false},
+	}
+
+	for _, test := range tests {
+		endpoints, prefix, err := parseCentral(test.address)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%s: expected ok %v, got %v", test.address, test.ok, err)
+			continue
+		}
+		if !reflect.DeepEqual(endpoints, test.endpoints) || prefix != test.prefix {
+			t.Errorf("%s: expected %v %q, got %v %q", test.address, test.endpoints, test.prefix, endpoints, prefix)
+		}
+	}
+}
+
+func TestCentralClientConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "central")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	password := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(password, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := CentralConfig{Username: "node1", PasswordFile: password}.clientConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.Username != "node1" || cc.Password != "secret" {
+		t.Errorf("unexpected credentials %q %q", cc.Username, cc.Password)
+	}
+	if cc.TLS != nil {
+		t.Error("expected no TLS")
+	}
+
+	if _, err := (CentralConfig{TLS: CentralTLSConfig{CA: password}}).clientConfig(nil); err == nil {
+		t.Error("expected an error for a CA without certificate")
+	}
+	if _, err := (CentralConfig{TLS: CentralTLSConfig{Cert: password, Key: password}}).clientConfig(nil); err == nil {
+		t.Error("expected an error for an invalid key pair")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	central := []byte(`
+listen: 10.0.0.1
+port: 7000
+exports:
+  - name: photos
+    path: /srv/photos
+  - name: archive
+    path: /srv/archive
+discovery:
+  address: 224.0.0.2:9999
+  labels:
+    zone: paris
+    version: "2"
+rate_limits:
+  principal:
+    ops: 100
+    streams: 4
+`)
+
+	path := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(path, []byte(`
+name: node1
+port: 8000
+exports:
+  - name: backups
+    path: /srv/backups
+discovery:
+  labels:
+    zone: lyon
+    rack: "1"
+rate_limits:
+  principal:
+    streams: 8
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path, central)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Scalars are overridden one by one
+	if cfg.Name != "node1" || cfg.Listen != "10.0.0.1" || cfg.Port != 8000 {
+		t.Errorf("unexpected name %q, listen %q, port %d", cfg.Name, cfg.Listen, cfg.Port)
+	}
+	if cfg.Discovery.Address != "224.0.0.2:9999" {
+		t.Errorf("unexpected discovery address %q", cfg.Discovery.Address)
+	}
+	if p := cfg.RateLimits.Principal; p.Ops != 100 || p.Streams != 8 {
+		t.Errorf("unexpected principal limits %+v", p)
+	}
+
+	// Lists are replaced whole
+	if len(cfg.Exports) != 1 || cfg.Exports[0].Name != "backups" {
+		t.Errorf("expected the exports of the file, got %+v", cfg.Exports)
+	}
+
+	// Maps are merged
+	labels := map[string]string{"zone": "lyon", "version": "2", "rack": "1"}
+	if !reflect.DeepEqual(cfg.Discovery.Labels, labels) {
+		t.Errorf("expected labels %v, got %v", labels, cfg.Discovery.Labels)
+	}
+
+	// Defaults fill what neither sets
+	if cfg.Discovery.Interval != defaultDiscoveryInterval || cfg.ShutdownTimeout != defaultShutdownTimeout {
+		t.Errorf("expected the defaults, got %s %s", cfg.Discovery.Interval, cfg.ShutdownTimeout)
+	}
+
+	if _, err := LoadConfig("", []byte("unknown: 1")); err == nil {
+		t.Error("expected an error for an unknown section")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		set  func(c *Config)
+		err  string // expected in the error, valid when empty
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"no name", func(c *Config) { c.Name = "" }, "name: a node name is required"},
+		{"name with a slash", func(c *Config) { c.Name = "a/b" }, "must not contain"},
+		{"port", func(c *Config) { c.Port = 70000 }, "port: 70000 is out of range"},
+		{"duplicate export", func(c *Config) { c.Exports = append(c.Exports, c.Exports[0]) }, "duplicate name"},
+		{"relative path", func(c *Config) { c.Exports[0].Path = "photos" }, "must be absolute"},
+		{"missing path", func(c *Config) { c.Exports[0].Path = filepath.Join(dir, "missing") }, "no such file"},
+		{"path and remote", func(c *Config) { c.Exports[0].Remote = "s3:bucket" }, "mutually exclusive"},
+		{"read-only trash", func(c *Config) {
+			c.Exports[0].ReadOnly = true
+			c.Exports[0].Trash.Enabled = true
+		}, "trash cannot be enabled on a read-only export"},
+		{"versions limits", func(c *Config) { c.Exports[0].Versions.MaxCount = -1 }, "versions limits must not be negative"},
+		{"snapshots method", func(c *Config) { c.Exports[0].Snapshots.Method = "zfs" }, "unknown snapshots.method"},
+		{"acl", func(c *Config) { c.Exports[0].ACL.Write = []string{"alice", " "} }, "acl: empty principal"},
+		{"quota", func(c *Config) { c.Exports[0].Quota.MaxBytes = -1 }, "quota.max_bytes must not be negative"},
+		{"read-only quota", func(c *Config) {
+			c.Exports[0].ReadOnly = true
+			c.Exports[0].Quota.MaxBytes = 1 << 30
+		}, "quota cannot be enabled on a read-only export"},
+		{"encryption names", func(c *Config) { c.Exports[0].Encryption.Names = true }, "encryption.names requires encryption.key_file"},
+		{"rclone temp dir", func(c *Config) { c.Rclone.TempDir = "tmp" }, "rclone.temp_dir"},
+		{"discovery interval", func(c *Config) { c.Discovery.Interval = time.Millisecond }, "discovery.interval"},
+		{"discovery labels", func(c *Config) { c.Discovery.Labels = map[string]string{"node": "node2"} }, "invalid or reserved label"},
+		{"discovery seeds", func(c *Config) { c.Discovery.Seeds = []string{"10.0.0.2:99999"} }, "invalid seed"},
+		{"discovery backend", func(c *Config) { c.Discovery.Address = "static://" }, "missing the path of the peers file"},
+		{"tls", func(c *Config) { c.TLS.Cert = "/etc/cells/node1.crt" }, "tls: cert and key must be set together"},
+		{"client ca", func(c *Config) { c.TLS.ClientCA = "/etc/cells/ca.crt" }, "tls.client_ca: requires cert and key"},
+		{"metrics", func(c *Config) { c.Metrics.Address = "127.0.0.1" }, "metrics.address"},
+		{"audit path", func(c *Config) { c.Audit.Path = "audit.log" }, "audit.path"},
+		{"limits", func(c *Config) { c.Limits.MaxRecvMsgSize = -1 }, "limits.max_recv_msg_size"},
+		{"rate limits", func(c *Config) { c.RateLimits.Principal.Ops = -1 }, "rate_limits.principal.ops"},
+		{"shutdown timeout", func(c *Config) { c.ShutdownTimeout = -time.Second }, "shutdown_timeout"},
+		{"central", func(c *Config) { c.Central.Address = "http://10.0.0.1:2379" }, "central.address"},
+		{"central credentials", func(c *Config) { c.Central.Username = "node1" }, "username and password_file"},
+	}
+
+	for _, test := range tests {
+		cfg := &Config{Name: "node1", Exports: []ExportConfig{{Name: "photos", Path: dir}}}
+		cfg.setDefaults()
+		test.set(cfg)
+
+		err = cfg.Validate()
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	tests := []struct {
+		set    func(c *Config)
+		fields []string
+	}{
+		{func(c *Config) { c.Exports = []ExportConfig{{Name: "photos", Path: "/srv/photos"}} }, nil},
+		{func(c *Config) { c.Discovery.Interval = time.Minute }, nil},
+		{func(c *Config) { c.Port = 4000 }, []string{"listen/port"}},
+		{func(c *Config) {
+			c.TLS.ClientCA = "/etc/cells/ca.crt"
+			c.Limits.MaxConcurrentStreams = 10
+		}, []string{"tls", "limits"}},
+		{func(c *Config) { c.Metrics.Address = "127.0.0.1:9100" }, []string{"metrics"}},
+		{func(c *Config) { c.Audit.Exclude = []string{"/tmp"} }, []string{"audit"}},
+		{func(c *Config) { c.Central.Address = "etcd://10.0.0.1:2379" }, []string{"central"}},
+		{func(c *Config) { c.Central.TLS.CA = "/etc/cells/etcd-ca.crt" }, []string{"central"}},
+	}
+
+	for i, test := range tests {
+		cur, next := &Config{}, &Config{}
+		cur.setDefaults()
+		next.setDefaults()
+		test.set(next)
+
+		if fields := cur.restartRequired(next); !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("%d: expected %v, got %v", i, test.fields, fields)
+		}
+	}
+}
+
+// configServer runs the config server with an empty store, and returns its
+// address.
+func configServer(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "central")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bin := filepath.Join(dir, "config")
+	if out, err := exec.Command("go", "build", "-o", bin, "../config").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("building the config server: %v\n%s", err, out)
+	}
+
+	cmd := exec.Command(bin, "-data", filepath.Join(dir, "config.db"))
+	cmd.Env = append(os.Environ(), resolver.EnvDiscovery+"=static://"+filepath.Join(dir, "peers.yaml"))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.RemoveAll(dir)
+	}
+
+	// The server prints the address it listens on first
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	_, port, err := net.SplitHostPort(strings.TrimSpace(line))
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+
+	return net.JoinHostPort("127.0.0.1", port), stop
+}
+
+func TestCentral(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the config server")
+	}
+
+	addr, stop := configServer(t)
+	defer stop()
+
+	c, err := openCentral(CentralConfig{Address: "etcd://" + addr + "/test"}, "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if doc, err := c.load(); err != nil || doc != nil {
+		t.Fatalf("expected no configuration, got %q, %v", doc, err)
+	}
+
+	put := func(key, value string) {
+		if _, err := c.client.Put(ctx, "/test/node1/"+key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("config/exports", "- name: photos\n  path: /srv/photos\n  acl:\n    read: ['*']\n    write: [alice]\n  quota:\n    max_bytes: 1024\n")
+	put("config/rate_limits", "principal:\n  ops: 100\n")
+
+	// Sections of other nodes are not read
+	if _, err := c.client.Put(ctx, "/test/node2/config/port", "9000"); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := c.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig("", doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Exports) != 1 || cfg.Exports[0].Name != "photos" || cfg.RateLimits.Principal.Ops != 100 || cfg.Port != 0 {
+		t.Errorf("unexpected configuration %+v", cfg)
+	}
+	acl := ACLConfig{Read: []string{"*"}, Write: []string{"alice"}}
+	if e := cfg.Exports[0]; !reflect.DeepEqual(e.ACL, acl) || e.Quota.MaxBytes != 1024 {
+		t.Errorf("unexpected acl %+v and quota %+v", e.ACL, e.Quota)
+	}
+
+	// The sections telling where the configuration is are rejected
+	for section := range localSections {
+		put("config/"+section, "x")
+		if _, err := c.load(); err == nil {
+			t.Errorf("expected %s to be rejected", section)
+		}
+		if _, err := c.client.Delete(ctx, "/test/node1/config/"+section); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan struct{}, 1)
+	go c.watch(ctx, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	put("config/port", "8000")
+	select {
+	case <-changed:
+	case <-ctx.Done():
+		t.Fatal("expected the change to be seen")
+	}
+
+	// Keys out of the configuration do not count as changes
+	c.report(cfg, []string{"tls"}, nil)
+	select {
+	case <-changed:
+		t.Error("expected the report not to be seen as a change")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	resp, err := c.client.Get(ctx, "/test/node1/status")
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("expected a status, got %v", err)
+	}
+	var st centralStatus
+	if err := json.Unmarshal(resp.Kvs[0].Value, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Revision == 0 || st.Error != "" || !reflect.DeepEqual(st.RestartRequired, []string{"tls"}) {
+		t.Errorf("unexpected status %+v", st)
+	}
+}
+
+func TestFilesystemsACLQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{Exports: []ExportConfig{
+		{Name: "photos", Path: dir, ACL: ACLConfig{Write: []string{"alice"}}, Quota: QuotaConfig{MaxBytes: 10}},
+		{Name: "public", Path: dir},
+	}}
+	exp := cfg.Filesystems()
+
+	if acl := exp.acls["photos"]; acl == nil || acl.Access("alice") != index.WriteAccess || acl.Access("bob") != index.NoAccess {
+		t.Errorf("unexpected acl of photos %+v", acl)
+	}
+	if _, ok := exp.acls["public"]; ok {
+		t.Error("expected public to have no acl")
+	}
+
+	if err := afero.WriteFile(exp.fss["photos"], "/a.txt", make([]byte, 11), 0644); err == nil {
+		t.Error("expected the quota of photos to be exceeded")
+	}
+	if err := afero.WriteFile(exp.fss["public"], "/b.txt", make([]byte, 11), 0644); err != nil {
+		t.Errorf("expected public to have no quota, got %v", err)
+	}
+}
+
+func TestFilesystemsFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{Exports: []ExportConfig{
+		{Name: "photos", Path: dir},
+		{Name: "secret", Path: dir, Encryption: EncryptionConfig{KeyFile: filepath.Join(dir, "missing.key")}},
+	}}
+	exp := cfg.Filesystems()
+
+	if _, ok := exp.fss["secret"]; ok || exp.failed["secret"] == nil {
+		t.Errorf("expected secret to fail, got %v", exp.failed)
+	}
+	if _, ok := exp.fss["photos"]; !ok || exp.failed["photos"] != nil {
+		t.Errorf("expected photos to be served, got %v", exp.failed["photos"])
+	}
+}
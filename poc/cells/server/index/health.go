@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/index"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const healthInterval = 5 * time.Second
+
+// healthProbe is written in index.HealthDir to check that an export still
+// accepts writes.
+var healthProbe = path.Join("/", index.HealthDir, "probe")
+
+// checkHealth reports the status of every export on hs until the node
+// stops. An export is NOT_SERVING when it could not be opened, when its root
+// cannot be read, or when its directory cannot be written to although the
+// export is writable. Remotes are not written to, that would upload a file
+// every interval, nor the whole filesystem served without exports.
+func (n *node) checkHealth(hs *health.Server) {
+	statuses := make(map[string]healthpb.HealthCheckResponse_ServingStatus)
+
+	for {
+		fss, probes, failed := n.exports()
+
+		errs := make(map[string]error)
+		for name, fs := range fss {
+			errs[name] = checkExport(fs, probes[name])
+		}
+		for name, err := range failed {
+			errs[name] = err
+		}
+
+		next := make(map[string]healthpb.HealthCheckResponse_ServingStatus)
+		for name, err := range errs {
+			status := healthpb.HealthCheckResponse_SERVING
+			if err != nil {
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+
+				if statuses[name] != status {
+					log.Printf("export %q is not serving: %v", name, err)
+				}
+			} else if s, ok := statuses[name]; ok && s != status {
+				log.Printf("export %q is serving again", name)
+			}
+
+			next[name] = status
+		}
+
+		// Exports removed by a reload are no longer served
+		for name := range statuses {
+			if _, ok := next[name]; !ok {
+				next[name] = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+		}
+
+		for name, status := range next {
+			hs.SetServingStatus(index.HealthService(name), status)
+		}
+
+		statuses = next
+
+		if !n.sleep(healthInterval) {
+			return
+		}
+	}
+}
+
+// checkExport checks that the root of fs is a directory, and that a file
+// can be written to probe, the directory of the export without its trash,
+// encryption or ACLs, unless probe is nil. The probe is written in
+// index.HealthDir, hidden from clients.
+func checkExport(fs, probe afero.Fs) error {
+	fi, err := fs.Stat("/")
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("root is not a directory")
+	}
+
+	if probe == nil {
+		return nil
+	}
+
+	if err := probe.MkdirAll(path.Dir(healthProbe), 0700); err != nil {
+		return err
+	}
+
+	f, err := probe.OpenFile(healthProbe, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	// Writing a byte catches full disks, creating the file alone may not
+	_, err = f.Write([]byte{0})
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if e := probe.Remove(healthProbe); err == nil {
+		err = e
+	}
+
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghecquet/tripr/poc/cells/index"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestCheckExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	local := func(root string) afero.Fs { return afero.NewBasePathFs(afero.NewOsFs(), root) }
+
+	tests := []struct {
+		name  string
+		fs    afero.Fs
+		probe afero.Fs
+		ok    bool
+	}{
+		{"writable", index.HideHealth(local(dir)), local(dir), true},
+		{"read-only export", afero.NewReadOnlyFs(local(dir)), nil, true},
+		{"unwritable", local(dir), afero.NewReadOnlyFs(local(dir)), false},
+		{"missing root", local(filepath.Join(dir, "missing")), nil, false},
+		{"root is a file", local(file), nil, false},
+	}
+
+	for _, test := range tests {
+		err := checkExport(test.fs, test.probe)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%s: expected ok %v, got %v", test.name, test.ok, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, healthProbe)); !os.IsNotExist(err) {
+		t.Errorf("expected the probe to be removed, got %v", err)
+	}
+
+	// Clients do not see where the probe is written
+	names, err := afero.ReadDir(index.HideHealth(local(dir)), "/")
+	if err != nil || len(names) != 1 || names[0].Name() != "file" {
+		t.Errorf("expected the probes to be hidden, got %v, %v", names, err)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := &node{
+		fss:    map[string]afero.Fs{"photos": afero.NewBasePathFs(afero.NewOsFs(), dir)},
+		failed: map[string]error{"backups": errors.New("remote unreachable")},
+		stop:   make(chan struct{}),
+	}
+
+	// A single round of checks
+	close(n.stop)
+	hs := health.NewServer()
+	n.checkHealth(hs)
+
+	for export, want := range map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"photos":  healthpb.HealthCheckResponse_SERVING,
+		"backups": healthpb.HealthCheckResponse_NOT_SERVING,
+	} {
+		resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: index.HealthService(export)})
+		if err != nil || resp.GetStatus() != want {
+			t.Errorf("%s: expected %v, got %v, %v", export, want, resp.GetStatus(), err)
+		}
+	}
+}
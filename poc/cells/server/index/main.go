@@ -1,60 +1,559 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/ghecquet/tripr/poc/cells/audit"
 	"github.com/ghecquet/tripr/poc/cells/client/resolver"
 	"github.com/ghecquet/tripr/poc/cells/index"
-	"github.com/golang/protobuf/proto"
+	"github.com/ghecquet/tripr/poc/cells/limit"
 	"github.com/spf13/afero"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-const (
-	srvAddr       = ":0"
-	discoveryAddr = "224.0.0.1:9999"
+var (
+	configFile = flag.String("config", "", "path to the YAML configuration file")
+	name       = flag.String("name", "", "node name announced to peers")
+	listen     = flag.String("listen", "", "address to listen on")
+	port       = flag.Int("port", 0, "port to listen on (0 picks a free port)")
+	discovery  = flag.String("discovery", "", "discovery backend used to announce the node, a multicast address by default")
+	metricsAt  = flag.String("metrics", "", "address serving Prometheus metrics on /metrics")
+	centralAt  = flag.String("central", "", "central store the configuration is read from, etcd://host:port/prefix")
+	verify     = flag.Bool("verify-audit", false, "verify the hash chain of the audit log and exit")
 )
 
+// version is announced to the peers, set at build time with
+// -ldflags "-X main.version=1.2.0".
+var version = "dev"
+
 func main() {
-	// TODO - make that a mandatory argument
-	args := os.Args[1:]
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [name]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Flags override the values read from the configuration file.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	local, err := readConfig(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var cs *central
+	if local.Central.Address != "" {
+		if cs, err = openCentral(local.Central, local.Name); err != nil {
+			log.Fatal(err)
+		}
+		defer cs.Close()
+	}
+
+	cfg, err := loadConfig(cs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *verify {
+		verifyAudit(cfg)
+		return
+	}
+
+	opts, err := serverOptions(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fingerprint, err := cfg.TLSFingerprint()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auditLog, err := openAudit(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	name := args[0]
+	exp := cfg.Filesystems()
 
-	base := afero.NewOsFs()
+	h := index.NewExportsHandler(exp.fss)
+	exp.apply(h)
+	m := newMetrics(h)
+	l := limit.New(cfg.RateLimits.Limiter(), h)
 
-	s := grpc.NewServer()
+	opts = append(opts, m.serverOptions()...)
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(l.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(l.StreamServerInterceptor()),
+	)
 
-	lis, err := net.Listen("tcp", srvAddr)
+	s := grpc.NewServer(opts...)
+
+	lis, err := net.Listen("tcp", cfg.Addr())
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	index.RegisterFSServer(s, index.NewHandler(base))
+	var srv index.FSServer = h
+	if auditLog != nil {
+		srv = audit.NewServer(h, auditLog, cfg.Audit.Exclude)
+	}
+
+	hs := health.NewServer()
+	hs.SetServingStatus("index.FS", healthpb.HealthCheckResponse_SERVING)
+
+	index.RegisterFSServer(s, srv)
+	healthpb.RegisterHealthServer(s, hs)
+	m.grpc.InitializeMetrics(s)
+
+	if cfg.Metrics.Address != "" {
+		go m.serve(cfg.Metrics.Address)
+	}
+
+	n := &node{
+		cfg:      cfg,
+		fss:      exp.fss,
+		probes:   exp.probes,
+		failed:   exp.failed,
+		versions: exp.versions,
+		handler:  h,
+		limiter:  l,
+		health:   hs,
+		central:  cs,
+		stop:     make(chan struct{}),
+		left:     make(chan struct{}),
+
+		fingerprint: fingerprint,
+	}
+
+	go n.ping(lis.Addr(), s)
+	go n.watchReload()
+	go n.checkHealth(hs)
+	go n.expireTrash()
+	go n.pruneVersions()
+
+	if cs != nil {
+		cs.report(cfg, nil, nil)
+		go n.watchCentral()
+	}
+
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	log.Printf("node %s listening on %s", cfg.Name, lis.Addr())
 
-	go ping(name, lis.Addr(), s)
+	n.waitShutdown(s)
 
-	s.Serve(lis)
+	if auditLog != nil {
+		auditLog.Close()
+	}
 }
 
-func ping(name string, a net.Addr, s *grpc.Server) {
-	addr, err := net.ResolveUDPAddr("udp", discoveryAddr)
+// loadConfig reads the configuration of the node, from the central store c
+// unless it is nil, then from the configuration file, and checks it.
+func loadConfig(c *central) (*Config, error) {
+	cfg, err := readConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// readConfig reads the configuration from the central store c unless it is
+// nil, then the configuration file and the command line overrides on top
+// of it.
+func readConfig(c *central) (*Config, error) {
+	var layer []byte
+	if c != nil {
+		var err error
+		if layer, err = c.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg, err := LoadConfig(*configFile, layer)
+	if err != nil {
+		return nil, err
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			cfg.Name = *name
+		case "listen":
+			cfg.Listen = *listen
+		case "port":
+			cfg.Port = *port
+		case "discovery":
+			cfg.Discovery.Address = *discovery
+		case "metrics":
+			cfg.Metrics.Address = *metricsAt
+		case "central":
+			cfg.Central.Address = *centralAt
+		}
+	})
+
+	// Kept for compatibility with the former `index <name>` invocation
+	if cfg.Name == "" && flag.NArg() > 0 {
+		cfg.Name = flag.Arg(0)
+	}
+
+	return cfg, nil
+}
+
+func serverOptions(cfg *Config) ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+
+	tlsConfig, err := cfg.ServerTLS()
 	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	if l := cfg.Limits.MaxConcurrentStreams; l > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(l))
+	}
+	if l := cfg.Limits.MaxRecvMsgSize; l > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(l))
+	}
+	if l := cfg.Limits.MaxSendMsgSize; l > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(l))
+	}
+
+	return opts, nil
+}
+
+// openAudit opens the audit log, or returns nil when auditing is disabled.
+func openAudit(cfg *Config) (*audit.Log, error) {
+	if cfg.Audit.Path == "" {
+		return nil, nil
+	}
+
+	return audit.Open(cfg.Audit.Path, audit.Options{
+		MaxSize:    cfg.Audit.MaxSize,
+		MaxBackups: cfg.Audit.MaxBackups,
+		HashChain:  cfg.Audit.HashChain,
+	})
+}
+
+func verifyAudit(cfg *Config) {
+	if cfg.Audit.Path == "" {
+		log.Fatal("no audit log configured")
+	}
+	if !cfg.Audit.HashChain {
+		log.Fatal("audit.hash_chain is off, the audit log has no hash chain to verify")
+	}
+
+	files, err := audit.Files(cfg.Audit.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := audit.VerifyFiles(files...); err != nil {
 		log.Fatal(err)
 	}
 
-	c, err := net.DialUDP("udp", nil, addr)
+	// The first entry is trusted, lines removed before it go unnoticed
+	log.Printf("%d audit files verified", len(files))
+}
+
+// node holds the live configuration of the running server.
+type node struct {
+	mu       sync.RWMutex
+	cfg      *Config
+	fss      map[string]afero.Fs
+	probes   map[string]afero.Fs
+	failed   map[string]error
+	versions map[string]*index.VersionFs
+	handler  *index.Handler
+	limiter  *limit.Limiter
+	health   *health.Server
+	central  *central // nil unless configured
+
+	// reloading serializes the reloads on SIGHUP and on central changes
+	reloading sync.Mutex
+
+	stop chan struct{} // closed to stop announcing the node
+	left chan struct{} // closed once peers have been told the node leaves
+
+	fingerprint string // of the TLS certificate, which needs a restart to change
+}
+
+func (n *node) config() *Config {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.cfg
+}
+
+// exports returns the filesystems of the exports served, those to probe for
+// their health, and the errors of the exports that could not be opened.
+func (n *node) exports() (fss, probes map[string]afero.Fs, failed map[string]error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.fss, n.probes, n.failed
+}
+
+// watchReload reloads the configuration every time the process receives
+// SIGHUP. Exports, discovery settings and rate limits are applied in place,
+// so open streams are not interrupted.
+func (n *node) watchReload() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	for range c {
+		n.applyReload()
+	}
+}
+
+// watchCentral reloads the configuration every time it changes in the
+// central store, until the node stops.
+func (n *node) watchCentral() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-n.stop
+		cancel()
+	}()
+
+	n.central.watch(ctx, n.applyReload)
+}
+
+// applyReload reloads the configuration, and reports the outcome to the
+// central store.
+func (n *node) applyReload() {
+	n.reloading.Lock()
+	defer n.reloading.Unlock()
+
+	restart, err := n.reload()
+	if err != nil {
+		log.Printf("reload failed, keeping current configuration: %v", err)
+	} else {
+		log.Printf("configuration reloaded")
+	}
+
+	if n.central != nil {
+		n.central.report(n.config(), restart, err)
+	}
+}
+
+// reload applies the configuration read again, and returns the settings
+// changed that require a restart.
+func (n *node) reload() ([]string, error) {
+	next, err := loadConfig(n.central)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	fields := n.cfg.restartRequired(next)
+	if len(fields) > 0 {
+		log.Printf("ignoring changes to %v until the next restart", fields)
+
+		next.Listen, next.Port = n.cfg.Listen, n.cfg.Port
+		next.TLS = n.cfg.TLS
+		next.Limits = n.cfg.Limits
+		next.Metrics = n.cfg.Metrics
+		next.Audit = n.cfg.Audit
+		next.Central = n.cfg.Central
+	}
+
+	exp := next.Filesystems()
+	exp.apply(n.handler)
+	n.limiter.SetConfig(next.RateLimits.Limiter())
+	n.cfg = next
+	n.fss = exp.fss
+	n.probes = exp.probes
+	n.failed = exp.failed
+	n.versions = exp.versions
+
+	return fields, nil
+}
+
+// waitShutdown blocks until SIGTERM or SIGINT, then stops announcing the
+// node, lets in-flight streams finish within the configured timeout and
+// closes every file still open.
+func (n *node) waitShutdown(s *grpc.Server) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-c
+	log.Printf("received %s, shutting down", sig)
+
+	// Peers checking the node's health stop using it right away
+	n.health.Shutdown()
+
+	close(n.stop)
+	<-n.left
+
+	timeout := n.config().ShutdownTimeout
+
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("streams still open after %s, closing them", timeout)
+	}
+
+	if err := n.handler.Close(); err != nil {
+		log.Printf("closing files: %v", err)
+	}
+
+	s.Stop()
+
+	log.Printf("node %s stopped", n.config().Name)
+}
+
+// ping announces the node through the configured discovery backend until the
+// node stops, then tells its peers it leaves.
+func (n *node) ping(a net.Addr, s *grpc.Server) {
+	var (
+		d      resolver.Discovery
+		spec   string
+		self   *resolver.Node
+		failed string
+
+		stopResolver = func() {}
+	)
+
+	defer close(n.left)
+	defer func() { stopResolver() }()
+
+	leave := func() {
+		if d == nil {
+			return
+		}
+		if self != nil {
+			if err := d.Leave(self); err != nil {
+				log.Printf("discovery: %v", err)
+			}
+		}
+		d.Close()
+		d = nil
+	}
+	defer leave()
+
 	for {
-		data, _ := proto.Marshal(resolver.NewDNS(name))
-		c.Write(data)
+		cfg := n.config()
 
-		for service := range s.GetServiceInfo() {
-			data, _ := proto.Marshal(resolver.NewService(a.String(), service))
-			c.Write(data)
+		if cfg.Discovery.Disabled {
+			leave()
+
+			if !n.sleep(cfg.Discovery.Interval) {
+				return
+			}
+			continue
 		}
-		time.Sleep(1 * time.Second)
+
+		// Validated with the config
+		address, _ := cfg.Discovery.Spec()
+
+		if d == nil || spec != address {
+			leave()
+
+			var err error
+			d, err = resolver.Open(address)
+			if err != nil {
+				log.Printf("discovery: %v", err)
+				d = nil
+
+				if !n.sleep(cfg.Discovery.Interval) {
+					return
+				}
+				continue
+			}
+
+			// The resolver of the node finds its peers the same way
+			stopResolver()
+			ctx, cancel := context.WithCancel(context.Background())
+			if err := resolver.Start(ctx, resolver.Options{Discovery: address}); err != nil {
+				log.Printf("discovery: %v", err)
+			}
+			stopResolver = cancel
+
+			spec = address
+		}
+
+		self = n.record(cfg, a, s)
+
+		// Announcements are repeated every interval, only log changes
+		if err := d.Announce(self); err != nil && err.Error() != failed {
+			log.Printf("discovery: %v", err)
+			failed = err.Error()
+		} else if err == nil {
+			failed = ""
+		}
+
+		if !n.sleep(cfg.Discovery.Interval) {
+			return
+		}
+	}
+}
+
+// record returns what the node announces of itself.
+func (n *node) record(cfg *Config, a net.Addr, s *grpc.Server) *resolver.Node {
+	self := &resolver.Node{
+		ID:             cfg.NodeID(),
+		Name:           cfg.Name,
+		Addr:           resolver.AdvertiseAddr(a, cfg.Discovery.Advertise),
+		Advertised:     cfg.Discovery.Advertise != "",
+		Labels:         cfg.Discovery.Labels,
+		Version:        version,
+		Load:           uint64(n.handler.OpenFiles()),
+		TLSFingerprint: n.fingerprint,
+	}
+
+	for service := range s.GetServiceInfo() {
+		self.Services = append(self.Services, service)
+	}
+	sort.Strings(self.Services)
+
+	for _, e := range cfg.Exports {
+		export := &resolver.Export{Name: e.Name}
+		if e.Path != "" {
+			// Remote exports do not tell their free space
+			export.Free = freeSpace(e.Path)
+		}
+		self.Exports = append(self.Exports, export)
+	}
+
+	return self
+}
+
+// sleep waits for d and reports whether the node is still running.
+func (n *node) sleep(d time.Duration) bool {
+	select {
+	case <-n.stop:
+		return false
+	case <-time.After(d):
+		return true
 	}
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/ghecquet/tripr/poc/cells/client/resolver"
+	"github.com/ghecquet/tripr/poc/cells/index"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+)
+
+const metricsNamespace = "cells_index"
+
+// unknownExport labels the calls made to exports that are not served.
+const unknownExport = "unknown"
+
+// metrics collects the activity of the index server. Per-RPC counts and
+// latencies come from the go-grpc-prometheus interceptors, the rest is
+// gathered by the stream interceptor and the stats handler below.
+type metrics struct {
+	registry *prometheus.Registry
+	grpc     *grpc_prometheus.ServerMetrics
+	handler  *index.Handler
+
+	bytes   *prometheus.CounterVec
+	streams prometheus.Gauge
+	clients prometheus.Gauge
+}
+
+func newMetrics(h *index.Handler) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		grpc:     grpc_prometheus.NewServerMetrics(),
+		handler:  h,
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bytes_total",
+			Help:      "Bytes read from or written to files, per export.",
+		}, []string{"export", "direction"}),
+		streams: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "open_streams",
+			Help:      "Number of Open streams in progress.",
+		}),
+		clients: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_clients",
+			Help:      "Number of connected clients.",
+		}),
+	}
+
+	m.grpc.EnableHandlingTimeHistogram()
+
+	m.registry.MustRegister(
+		m.grpc,
+		m.bytes,
+		m.streams,
+		m.clients,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "open_files",
+			Help:      "Number of file handles held for clients.",
+		}, func() float64 {
+			return float64(h.OpenFiles())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "discovered_peers",
+			Help:      "Number of nodes discovered through the resolver.",
+		}, func() float64 {
+			return float64(len(resolver.Nodes()))
+		}),
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+func (m *metrics) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(m.grpc.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(m.grpc.StreamServerInterceptor(), m.streamInterceptor),
+		grpc.StatsHandler(&connCounter{m.clients}),
+	}
+}
+
+// serve exposes the metrics on addr under /metrics.
+func (m *metrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("metrics: %v", err)
+	}
+}
+
+func (m *metrics) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	m.streams.Inc()
+	defer m.streams.Dec()
+
+	// Labels are bounded to the exports served, whatever clients send
+	export, ok := m.handler.Export(ss.Context())
+	if !ok {
+		export = unknownExport
+	}
+
+	return handler(srv, &countingStream{
+		ServerStream: ss,
+		read:         m.bytes.WithLabelValues(export, "read"),
+		written:      m.bytes.WithLabelValues(export, "write"),
+	})
+}
+
+// countingStream counts the file contents going through Open and
+// OpenVersion streams.
+type countingStream struct {
+	grpc.ServerStream
+	read    prometheus.Counter
+	written prometheus.Counter
+}
+
+func (s *countingStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err != nil {
+		return err
+	}
+
+	switch resp := m.(type) {
+	case *index.FileResponse:
+		s.read.Add(float64(len(resp.GetRead().GetContent())))
+		s.written.Add(float64(resp.GetWrite().GetBytesWritten()))
+	case *index.ReadResponse:
+		s.read.Add(float64(len(resp.GetContent())))
+	}
+
+	return err
+}
+
+// connCounter tracks the number of open client connections.
+type connCounter struct {
+	clients prometheus.Gauge
+}
+
+func (c *connCounter) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (c *connCounter) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (c *connCounter) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (c *connCounter) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		c.clients.Inc()
+	case *stats.ConnEnd:
+		c.clients.Dec()
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ghecquet/tripr/poc/cells/index"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+)
+
+// sendStream is a server stream whose sends fail with err.
+type sendStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	err error
+}
+
+func (s *sendStream) Context() context.Context    { return s.ctx }
+func (s *sendStream) SendMsg(m interface{}) error { return s.err }
+
+// exportContext returns the context of a call made to export.
+func exportContext(export string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(index.ExportKey, export))
+}
+
+func TestStreamMetrics(t *testing.T) {
+	h := index.NewHandler(afero.NewMemMapFs())
+	h.SetExports(map[string]afero.Fs{"photos": afero.NewMemMapFs()})
+	m := newMetrics(h)
+	ctx := exportContext("photos")
+	info := &grpc.StreamServerInfo{FullMethod: "/index.FS/Open"}
+
+	err := m.streamInterceptor(nil, &sendStream{ctx: ctx}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		if n := testutil.ToFloat64(m.streams); n != 1 {
+			t.Errorf("expected 1 open stream, got %v", n)
+		}
+
+		ss.SendMsg(&index.FileResponse{Response: &index.FileResponse_Read{Read: &index.ReadResponse{Content: []byte("hello")}}})
+		ss.SendMsg(&index.FileResponse{Response: &index.FileResponse_Write{Write: &index.WriteResponse{BytesWritten: 3}}})
+		ss.SendMsg(&index.ReadResponse{Content: []byte("!!")})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Contents that could not be sent are not counted
+	m.streamInterceptor(nil, &sendStream{ctx: ctx, err: errors.New("closed")}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		return ss.SendMsg(&index.ReadResponse{Content: []byte("lost")})
+	})
+
+	if n := testutil.ToFloat64(m.bytes.WithLabelValues("photos", "read")); n != 7 {
+		t.Errorf("expected 7 bytes read, got %v", n)
+	}
+	if n := testutil.ToFloat64(m.bytes.WithLabelValues("photos", "write")); n != 3 {
+		t.Errorf("expected 3 bytes written, got %v", n)
+	}
+	if n := testutil.ToFloat64(m.streams); n != 0 {
+		t.Errorf("expected the streams to be closed, got %v", n)
+	}
+}
+
+func TestStreamMetricsExport(t *testing.T) {
+	h := index.NewHandler(afero.NewMemMapFs())
+	h.SetExports(map[string]afero.Fs{"photos": afero.NewMemMapFs()})
+	m := newMetrics(h)
+	info := &grpc.StreamServerInfo{FullMethod: "/index.FS/Open"}
+
+	// Snapshots count towards their export, names that are not exports
+	// share a single label
+	for _, export := range []string{"photos@daily", "music", "music@daily", "x"} {
+		m.streamInterceptor(nil, &sendStream{ctx: exportContext(export)}, info, func(srv interface{}, ss grpc.ServerStream) error {
+			return ss.SendMsg(&index.ReadResponse{Content: []byte("ab")})
+		})
+	}
+
+	if n := testutil.ToFloat64(m.bytes.WithLabelValues("photos", "read")); n != 2 {
+		t.Errorf("expected 2 bytes read from photos, got %v", n)
+	}
+	if n := testutil.ToFloat64(m.bytes.WithLabelValues(unknownExport, "read")); n != 6 {
+		t.Errorf("expected 6 bytes read from unknown exports, got %v", n)
+	}
+
+	families, err := m.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports := make(map[string]bool)
+	for _, f := range families {
+		if f.GetName() != "cells_index_bytes_total" {
+			continue
+		}
+		for _, metric := range f.GetMetric() {
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "export" {
+					exports[l.GetValue()] = true
+				}
+			}
+		}
+	}
+	if len(exports) != 2 {
+		t.Errorf("expected the photos and unknown exports only, got %v", exports)
+	}
+}
+
+func TestConnCounter(t *testing.T) {
+	m := newMetrics(index.NewHandler(afero.NewMemMapFs()))
+	c := &connCounter{m.clients}
+
+	ctx := context.Background()
+	c.HandleConn(ctx, &stats.ConnBegin{})
+	c.HandleConn(ctx, &stats.ConnBegin{})
+	c.HandleConn(ctx, &stats.ConnEnd{})
+
+	if n := testutil.ToFloat64(m.clients); n != 1 {
+		t.Errorf("expected 1 client, got %v", n)
+	}
+
+	families, err := m.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, f := range families {
+		found[f.GetName()] = true
+	}
+	for _, name := range []string{"cells_index_active_clients", "cells_index_open_files", "cells_index_discovered_peers"} {
+		if !found[name] {
+			t.Errorf("expected %s to be registered", name)
+		}
+	}
+}
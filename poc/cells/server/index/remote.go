@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ghecquet/tripr/poc/cells/aferofs/rclonefs"
+	_ "github.com/rclone/rclone/backend/all" // import all backends
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+)
+
+const defaultRemoteListCacheTTL = 10 * time.Second
+
+// RcloneConfig sets up the exports served from rclone remotes. Config is
+// the rclone configuration file defining the remotes, rclone's own by
+// default. It is read once, changing it requires a restart.
+type RcloneConfig struct {
+	Config       string        `yaml:"config"`
+	ListCacheTTL time.Duration `yaml:"list_cache_ttl"`
+	TempDir      string        `yaml:"temp_dir"`
+}
+
+func (r *RcloneConfig) options() rclonefs.Options {
+	return rclonefs.Options{
+		ListCacheTTL: r.ListCacheTTL,
+		TempDir:      r.TempDir,
+	}
+}
+
+var (
+	rcloneOnce sync.Once
+
+	// remotes are kept across reloads, so that remotes are not connected
+	// to again and their listing caches survive
+	remotesMu sync.Mutex
+	remotes   = make(map[remoteKey]*rclonefs.Fs)
+)
+
+type remoteKey struct {
+	remote string
+	opts   rclonefs.Options
+}
+
+// loadRcloneConfig reads the rclone configuration file, the first time only.
+func loadRcloneConfig(path string) {
+	rcloneOnce.Do(func() {
+		if path != "" {
+			config.ConfigPath = path
+		}
+		config.LoadConfig()
+	})
+}
+
+// remoteFs returns the filesystem serving remote, connecting to it the first
+// time.
+func (c *Config) remoteFs(remote string) (*rclonefs.Fs, error) {
+	loadRcloneConfig(c.Rclone.Config)
+
+	key := remoteKey{remote: remote, opts: c.Rclone.options()}
+
+	remotesMu.Lock()
+	defer remotesMu.Unlock()
+
+	if f, ok := remotes[key]; ok {
+		return f, nil
+	}
+
+	f, err := fs.NewFs(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	remotes[key] = rclonefs.New(f, key.opts)
+
+	return remotes[key], nil
+}
+
+// validRemote checks that remote names a backend or a configured remote,
+// without connecting to it.
+func (c *Config) validRemote(remote string) error {
+	loadRcloneConfig(c.Rclone.Config)
+
+	_, _, _, err := fs.ParseRemote(remote)
+
+	return err
+}
@@ -0,0 +1,8 @@
+// +build !linux,!darwin
+
+package main
+
+// freeSpace is not implemented on this platform.
+func freeSpace(path string) uint64 {
+	return 0
+}
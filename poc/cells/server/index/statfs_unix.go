@@ -0,0 +1,16 @@
+// +build linux darwin
+
+package main
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem of path, or 0 when it cannot be told.
+func freeSpace(path string) uint64 {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize)
+}
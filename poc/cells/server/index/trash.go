@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const trashInterval = time.Hour
+
+// expireTrash removes the entries kept in the trash of exports past their
+// retention period, until the node stops.
+func (n *node) expireTrash() {
+	for {
+		trashes := n.config().Filesystems().trashes
+
+		for name, t := range trashes {
+			if err := t.Expire(); err != nil {
+				log.Printf("expiring trash of %q: %v", name, err)
+			}
+		}
+
+		if !n.sleep(trashInterval) {
+			return
+		}
+	}
+}
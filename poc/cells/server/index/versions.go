@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const versionsInterval = time.Hour
+
+// pruneVersions applies the retention limits of the exports to the versions
+// of files that have not been written to lately, until the node stops.
+func (n *node) pruneVersions() {
+	for {
+		n.mu.RLock()
+		versions := n.versions
+		n.mu.RUnlock()
+
+		for name, v := range versions {
+			if err := v.Prune(); err != nil {
+				log.Printf("pruning versions of %q: %v", name, err)
+			}
+		}
+
+		if !n.sleep(versionsInterval) {
+			return
+		}
+	}
+}
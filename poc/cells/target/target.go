@@ -0,0 +1,500 @@
+// Package target parses the addresses of cells services, and matches them
+// against the labels announced by nodes.
+//
+// A target names a service, optionally on a given node, and selects the
+// nodes by their labels:
+//
+//	cells:///index.FS?export=photos&zone=paris&version>=2
+//	cells:///node1@index.FS/photos
+//	cells:///index.FS?export=photos&balance=least_outstanding
+//
+// A location is the address of a directory in an export, as given to the
+// filesystem clients:
+//
+//	cells://node1/path/in/export?export=photos&zone=paris
+//	node1@photos/path/in/export
+//	node1@photos@snapshot/path/in/snapshot
+package target
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Scheme is the scheme of the targets resolved by cells.
+const Scheme = "cells"
+
+// DefaultService is the service of locations.
+const DefaultService = "index.FS"
+
+// Keys of the selectors that are not labels.
+const (
+	NodeKey    = "node"
+	ExportKey  = "export"
+	BalanceKey = "balance"
+)
+
+// Op compares a label with the value of a selector.
+type Op string
+
+const (
+	Equal        Op = "="
+	NotEqual     Op = "!="
+	Greater      Op = ">"
+	GreaterEqual Op = ">="
+	Less         Op = "<"
+	LessEqual    Op = "<="
+)
+
+// ops are tried in order, two-character operators first.
+var ops = []Op{NotEqual, GreaterEqual, LessEqual, Equal, Greater, Less}
+
+// Selector is a condition on a label of the nodes.
+type Selector struct {
+	Key   string
+	Op    Op
+	Value string
+}
+
+func (s Selector) String() string {
+	return s.Key + string(s.Op) + url.QueryEscape(s.Value)
+}
+
+// Target is a parsed target or location.
+type Target struct {
+	// Node restricts the target to the node of that name
+	Node string
+
+	Service string
+
+	// Export and Snapshot are the export served, and its snapshot if any
+	Export   string
+	Snapshot string
+
+	// Path is the directory of a location in its export
+	Path string
+
+	// Balance is the policy spreading calls among the nodes selected,
+	// see the resolver package
+	Balance string
+
+	Selectors []Selector
+}
+
+// Error is returned for malformed targets.
+type Error struct {
+	Target string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid target %q: %s", e.Target, e.Reason)
+}
+
+func invalid(s string, format string, args ...interface{}) error {
+	return &Error{Target: s, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Parse parses a target, given either as a cells:// URL or as the endpoint
+// of a URL, [node@]service[/export[@snapshot]][?selectors].
+func Parse(s string) (*Target, error) {
+	endpoint := s
+	if strings.HasPrefix(s, Scheme+":") {
+		rest := strings.TrimPrefix(s, Scheme+":")
+		if !strings.HasPrefix(rest, "//") {
+			return nil, invalid(s, "expected %s://[authority]/service", Scheme)
+		}
+
+		// The authority is not used to resolve targets
+		rest = rest[2:]
+		i := strings.Index(rest, "/")
+		if i < 0 {
+			return nil, invalid(s, "missing service")
+		}
+		endpoint = rest[i+1:]
+	}
+
+	endpoint, query := split(endpoint, "?")
+
+	t := &Target{}
+	if err := t.parseSelectors(s, query); err != nil {
+		return nil, err
+	}
+
+	if i := strings.Index(endpoint, "@"); i >= 0 && (strings.Index(endpoint, "/") < 0 || i < strings.Index(endpoint, "/")) {
+		node := endpoint[:i]
+		if node == "" {
+			return nil, invalid(s, "empty node name before '@'")
+		}
+		if err := t.setNode(s, node); err != nil {
+			return nil, err
+		}
+		endpoint = endpoint[i+1:]
+	}
+
+	service, export := split(endpoint, "/")
+	if service == "" {
+		return nil, invalid(s, "missing service")
+	}
+	if !validKey(service) {
+		return nil, invalid(s, "invalid service %q", service)
+	}
+	t.Service = service
+
+	if export != "" {
+		if err := t.setExport(s, export); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// ParseLocation parses a location, either a cells:// URL whose host is the
+// node and whose path is the directory, or [node@][export[@snapshot]]/path.
+// The export is served by the default service.
+func ParseLocation(s string) (*Target, error) {
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, invalid(s, "%v", err)
+		}
+		if u.Scheme != Scheme {
+			return nil, invalid(s, "expected the %s scheme", Scheme)
+		}
+
+		t := &Target{Service: DefaultService, Path: cleanPath(u.Path)}
+		if err := t.parseSelectors(s, u.RawQuery); err != nil {
+			return nil, err
+		}
+		if u.Hostname() != "" {
+			if err := t.setNode(s, u.Hostname()); err != nil {
+				return nil, err
+			}
+		}
+
+		return t, nil
+	}
+
+	t := &Target{Service: DefaultService}
+
+	rest := s
+	if i := strings.Index(s, "@"); i >= 0 && (strings.Index(s, "/") < 0 || i < strings.Index(s, "/")) {
+		if i == 0 {
+			return nil, invalid(s, "empty node name before '@'")
+		}
+		t.Node, rest = s[:i], s[i+1:]
+		if !validName(t.Node) {
+			return nil, invalid(s, "invalid node name %q", t.Node)
+		}
+	}
+
+	export, p := rest, "/"
+	if i := strings.Index(rest, "/"); i >= 0 {
+		export, p = rest[:i], rest[i:]
+	}
+
+	if export != "" {
+		if err := t.setExport(s, export); err != nil {
+			return nil, err
+		}
+	}
+	t.Path = cleanPath(p)
+
+	return t, nil
+}
+
+func split(s, sep string) (string, string) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):]
+	}
+
+	return s, ""
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// validKey accepts the names of services and labels.
+func validKey(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.' || c == '-' || c == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidLabel reports whether key can name a label, which is not one of the
+// keys reserved for the node, the export and the policy.
+func ValidLabel(key string) bool {
+	return validKey(key) && key != NodeKey && key != ExportKey && key != BalanceKey
+}
+
+// validName accepts the names of nodes, exports and snapshots, which only
+// exclude the characters delimiting the parts of a target.
+func validName(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "/@?&#=!<>")
+}
+
+func (t *Target) setNode(s, node string) error {
+	if !validName(node) {
+		return invalid(s, "invalid node name %q", node)
+	}
+	if t.Node != "" && t.Node != node {
+		return invalid(s, "node given twice, %q and %q", t.Node, node)
+	}
+	t.Node = node
+
+	return nil
+}
+
+func (t *Target) setExport(s, name string) error {
+	export, snapshot := split(name, "@")
+	if !validName(export) {
+		return invalid(s, "invalid export %q", export)
+	}
+	if strings.Contains(name, "@") && (!validName(snapshot) || strings.HasPrefix(snapshot, ".")) {
+		return invalid(s, "invalid snapshot %q", snapshot)
+	}
+	if t.Export != "" && (t.Export != export || t.Snapshot != snapshot) {
+		return invalid(s, "export given twice, %q and %q", t.ExportName(), name)
+	}
+
+	t.Export, t.Snapshot = export, snapshot
+
+	return nil
+}
+
+func (t *Target) setBalance(s, policy string) error {
+	if !validKey(policy) {
+		return invalid(s, "invalid balancing policy %q", policy)
+	}
+	if t.Balance != "" && t.Balance != policy {
+		return invalid(s, "balancing policy given twice, %q and %q", t.Balance, policy)
+	}
+	t.Balance = policy
+
+	return nil
+}
+
+// parseSelectors parses key op value terms separated by '&'.
+func (t *Target) parseSelectors(s, query string) error {
+	if query == "" {
+		return nil
+	}
+
+	for _, term := range strings.Split(query, "&") {
+		if term == "" {
+			continue
+		}
+
+		sel, err := parseSelector(term)
+		if err != nil {
+			return invalid(s, "%v", err)
+		}
+
+		switch sel.Key {
+		case NodeKey, ExportKey, BalanceKey:
+			if sel.Op != Equal {
+				return invalid(s, "%s only supports '='", sel.Key)
+			}
+
+			switch sel.Key {
+			case NodeKey:
+				err = t.setNode(s, sel.Value)
+			case ExportKey:
+				err = t.setExport(s, sel.Value)
+			default:
+				err = t.setBalance(s, sel.Value)
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			t.Selectors = append(t.Selectors, sel)
+		}
+	}
+
+	return nil
+}
+
+func parseSelector(term string) (Selector, error) {
+	i := strings.IndexAny(term, "!=<>")
+	if i < 0 {
+		return Selector{}, fmt.Errorf("%q has no operator", term)
+	}
+
+	key := term[:i]
+	if !validKey(key) {
+		return Selector{}, fmt.Errorf("invalid label %q in %q", key, term)
+	}
+
+	for _, op := range ops {
+		if !strings.HasPrefix(term[i:], string(op)) {
+			continue
+		}
+
+		value, err := url.QueryUnescape(term[i+len(op):])
+		if err != nil {
+			return Selector{}, fmt.Errorf("%q: %v", term, err)
+		}
+
+		return Selector{Key: key, Op: op, Value: value}, nil
+	}
+
+	return Selector{}, fmt.Errorf("%q has no valid operator", term)
+}
+
+// HealthService is the health service checked for the target: the service
+// itself, followed by the export if any, index.FS/photos for instance.
+func (t *Target) HealthService() string {
+	if t.Export == "" {
+		return t.Service
+	}
+
+	return t.Service + "/" + t.Export
+}
+
+// ExportName is the export as addressed by clients, export@snapshot for
+// snapshots.
+func (t *Target) ExportName() string {
+	if t.Snapshot == "" {
+		return t.Export
+	}
+
+	return t.Export + "@" + t.Snapshot
+}
+
+// String returns the target as a cells:// URL, without the path of
+// locations.
+func (t *Target) String() string {
+	var terms []string
+	if t.Node != "" {
+		terms = append(terms, NodeKey+"="+t.Node)
+	}
+	if t.Export != "" {
+		terms = append(terms, ExportKey+"="+url.QueryEscape(t.ExportName()))
+	}
+	for _, sel := range t.Selectors {
+		terms = append(terms, sel.String())
+	}
+	if t.Balance != "" {
+		terms = append(terms, BalanceKey+"="+t.Balance)
+	}
+
+	s := Scheme + ":///" + t.Service
+	if len(terms) > 0 {
+		s += "?" + strings.Join(terms, "&")
+	}
+
+	return s
+}
+
+// Match reports whether a node with labels is selected by the target. The
+// export must be one of the comma-separated values of the export label, if
+// the node announces it. Labels compared with '=' and '!=' may also hold
+// comma-separated values, any of which matches.
+func (t *Target) Match(labels map[string]string) bool {
+	if exports, ok := labels[ExportKey]; ok && t.Export != "" {
+		if !contains(exports, t.Export) {
+			return false
+		}
+	}
+
+	for _, sel := range t.Selectors {
+		if !sel.Match(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Match reports whether labels satisfy the selector. A missing label only
+// satisfies '!='.
+func (s Selector) Match(labels map[string]string) bool {
+	v, ok := labels[s.Key]
+	if !ok {
+		return s.Op == NotEqual
+	}
+
+	switch s.Op {
+	case Equal:
+		return contains(v, s.Value)
+	case NotEqual:
+		return !contains(v, s.Value)
+	}
+
+	c, err := compare(v, s.Value)
+	if err != nil {
+		return false
+	}
+
+	switch s.Op {
+	case Greater:
+		return c > 0
+	case GreaterEqual:
+		return c >= 0
+	case Less:
+		return c < 0
+	default:
+		return c <= 0
+	}
+}
+
+func contains(list, value string) bool {
+	for _, v := range strings.Split(list, ",") {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+var errNotComparable = errors.New("not comparable")
+
+// compare compares dotted numbers such as versions, 2 < 2.1 < 10.
+func compare(a, b string) (int, error) {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int64
+		var err error
+
+		if i < len(as) {
+			if x, err = strconv.ParseInt(as[i], 10, 64); err != nil {
+				return 0, errNotComparable
+			}
+		}
+		if i < len(bs) {
+			if y, err = strconv.ParseInt(bs[i], 10, 64); err != nil {
+				return 0, errNotComparable
+			}
+		}
+
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+}
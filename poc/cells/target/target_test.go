@@ -0,0 +1,185 @@
+package target
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected Target
+	}{
+		{"index.FS", Target{Service: "index.FS"}},
+		{"cells:///index.FS", Target{Service: "index.FS"}},
+		{"cells://authority/index.FS", Target{Service: "index.FS"}},
+		{"node1@index.FS/photos", Target{Node: "node1", Service: "index.FS", Export: "photos"}},
+		{"node1@index.FS/photos@snap-1", Target{Node: "node1", Service: "index.FS", Export: "photos", Snapshot: "snap-1"}},
+		{"index.FS/photos@snap-1", Target{Service: "index.FS", Export: "photos", Snapshot: "snap-1"}},
+		{"etcdserverpb.KV", Target{Service: "etcdserverpb.KV"}},
+		{
+			"cells:///index.FS?export=photos&zone=paris&version>=2",
+			Target{Service: "index.FS", Export: "photos", Selectors: []Selector{
+				{Key: "zone", Op: Equal, Value: "paris"},
+				{Key: "version", Op: GreaterEqual, Value: "2"},
+			}},
+		},
+		{
+			"index.FS?node=node1&tier!=cold&load<0.5&disk>100&rack<=3&name=a%20b",
+			Target{Node: "node1", Service: "index.FS", Selectors: []Selector{
+				{Key: "tier", Op: NotEqual, Value: "cold"},
+				{Key: "load", Op: Less, Value: "0.5"},
+				{Key: "disk", Op: Greater, Value: "100"},
+				{Key: "rack", Op: LessEqual, Value: "3"},
+				{Key: "name", Op: Equal, Value: "a b"},
+			}},
+		},
+		{"node1@index.FS?node=node1", Target{Node: "node1", Service: "index.FS"}},
+		{"index.FS?balance=zone&zone!=london", Target{Service: "index.FS", Balance: "zone", Selectors: []Selector{
+			{Key: "zone", Op: NotEqual, Value: "london"},
+		}}},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(*got, test.expected) {
+			t.Errorf("%q: expected %+v, got %+v", test.in, test.expected, *got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"cells:",
+		"cells:index.FS",
+		"cells://authority",
+		"@index.FS",
+		"node1@",
+		"node1@/photos",
+		"index FS",
+		"index.FS/photos@",
+		"index.FS/photos@.hidden",
+		"index.FS?zone",
+		"index.FS?=paris",
+		"index.FS?export>=photos",
+		"node1@index.FS?node=node2",
+		"index.FS/photos?export=archive",
+		"index.FS?zone=%zz",
+		"index.FS?balance!=zone",
+		"index.FS?balance=zone&balance=round_robin",
+	}
+
+	for _, in := range tests {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		} else if _, ok := err.(*Error); !ok {
+			t.Errorf("%q: unexpected error type %T", in, err)
+		}
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected Target
+	}{
+		{"node1@photos/a/b", Target{Node: "node1", Service: DefaultService, Export: "photos", Path: "/a/b"}},
+		{"node1@/a/b", Target{Node: "node1", Service: DefaultService, Path: "/a/b"}},
+		{"node1@photos", Target{Node: "node1", Service: DefaultService, Export: "photos", Path: "/"}},
+		{"node1@photos@snap/a", Target{Node: "node1", Service: DefaultService, Export: "photos", Snapshot: "snap", Path: "/a"}},
+		{"/a/b", Target{Service: DefaultService, Path: "/a/b"}},
+		{"cells://node1/a/b", Target{Node: "node1", Service: DefaultService, Path: "/a/b"}},
+		{
+			"cells:///a?export=photos&zone=paris",
+			Target{Service: DefaultService, Export: "photos", Path: "/a", Selectors: []Selector{
+				{Key: "zone", Op: Equal, Value: "paris"},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := ParseLocation(test.in)
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(*got, test.expected) {
+			t.Errorf("%q: expected %+v, got %+v", test.in, test.expected, *got)
+		}
+	}
+
+	for _, in := range []string{"@photos/a", "http://node1/a", "cells://node1/a?zone", "node1@photos@/a"} {
+		if _, err := ParseLocation(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	for _, in := range []string{
+		"cells:///index.FS",
+		"cells:///index.FS?node=node1&export=photos%40snap&zone=paris&version>=2",
+		"cells:///index.FS?zone=paris&balance=least_outstanding",
+	} {
+		target, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := target.String(); s != in {
+			t.Errorf("expected %q, got %q", in, s)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	labels := map[string]string{
+		"export":  "photos,archive",
+		"zone":    "paris",
+		"version": "2.1",
+	}
+
+	tests := []struct {
+		target  string
+		matches bool
+	}{
+		{"index.FS", true},
+		{"index.FS/photos", true},
+		{"index.FS/music", false},
+		{"index.FS?zone=paris", true},
+		{"index.FS?zone=london", false},
+		{"index.FS?zone!=london", true},
+		{"index.FS?rack=1", false},
+		{"index.FS?rack!=1", true},
+		{"index.FS?version>=2", true},
+		{"index.FS?version>2.0.5", true},
+		{"index.FS?version<2.1", false},
+		{"index.FS?version<=2.1", true},
+		{"index.FS?version>=10", false},
+		{"index.FS?zone>=2", false},
+	}
+
+	for _, test := range tests {
+		target, err := Parse(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := target.Match(labels); got != test.matches {
+			t.Errorf("%q: expected %v, got %v", test.target, test.matches, got)
+		}
+	}
+
+	// Nodes not announcing their exports are not filtered on them
+	target, _ := Parse("index.FS/photos")
+	if !target.Match(nil) {
+		t.Errorf("expected a node without labels to match")
+	}
+}